@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxDndMinutes caps a single do-not-disturb so nobody vanishes from routing
+// for days by accident.
+const maxDndMinutes = 12 * 60
+
+// Agent presences. Agents set online, away and offline themselves; busy is
+// set and cleared by their calls. Only online agents are routed to.
+const (
+	presenceOnline  = "online"
+	presenceBusy    = "busy"
+	presenceAway    = "away"
+	presenceOffline = "offline"
+)
+
+var presences = []string{presenceOnline, presenceBusy, presenceAway, presenceOffline}
+
+type AgentDndRequest struct {
+	Minutes int `json:"minutes"`
+}
+
+type AgentPresenceRequest struct {
+	Status string `json:"status"`
+}
+
+// AgentStatusInfo is where one agent stands with routing.
+type AgentStatusInfo struct {
+	AgentID       string     `json:"agent_id"`
+	Status        string     `json:"status"`
+	Presence      string     `json:"presence"`
+	LastSeenAt    *time.Time `json:"last_seen_at,omitempty"`
+	DndUntil      *time.Time `json:"dnd_until,omitempty"`
+	WrapUpCallSid string     `json:"wrap_up_call_sid,omitempty"`
+	WrapUpUntil   *time.Time `json:"wrap_up_until,omitempty"`
+}
+
+type AgentStatusResponse struct {
+	Success bool `json:"success"`
+	AgentStatusInfo
+}
+
+type AgentStatusesResponse struct {
+	Success bool              `json:"success"`
+	Agents  []AgentStatusInfo `json:"agents"`
+}
+
+// presenceTimeoutFromEnv reads how long an agent stays online without a
+// heartbeat, AGENT_HEARTBEAT_TIMEOUT, which defaults to two minutes.
+func presenceTimeoutFromEnv() (time.Duration, error) {
+	value := os.Getenv("AGENT_HEARTBEAT_TIMEOUT")
+	if value == "" {
+		return 2 * time.Minute, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 10*time.Second {
+		return 0, errors.New("AGENT_HEARTBEAT_TIMEOUT must be a duration of at least 10s")
+	}
+	return timeout, nil
+}
+
+// agentStatusInfo reports an online agent as "available", and any other as
+// their presence. "dnd" wins while dnd_until is still in the future, and
+// "wrap_up" while a disposition is owed. Once those pass the agent is back
+// to their presence; routing makes the same checks, so nothing has to flip
+// them back.
+func agentStatusInfo(agentID string, status db.AgentStatus, now time.Time) AgentStatusInfo {
+	info := AgentStatusInfo{AgentID: agentID, Status: status.Presence, Presence: status.Presence}
+	if info.Presence == "" {
+		info.Presence = presenceOffline
+		info.Status = presenceOffline
+	}
+	if info.Presence == presenceOnline {
+		info.Status = "available"
+	}
+	if status.LastSeenAt.Valid {
+		info.LastSeenAt = &status.LastSeenAt.Time
+	}
+	if status.DndUntil.Valid && status.DndUntil.Time.After(now) {
+		info.Status = "dnd"
+		info.DndUntil = &status.DndUntil.Time
+	}
+	if status.WrapUpUntil.Valid && status.WrapUpUntil.Time.After(now) {
+		info.Status = "wrap_up"
+		info.WrapUpCallSid = status.WrapUpCallSid.String
+		info.WrapUpUntil = &status.WrapUpUntil.Time
+	}
+	return info
+}
+
+func agentStatusResponse(agentID string, status db.AgentStatus, now time.Time) AgentStatusResponse {
+	return AgentStatusResponse{Success: true, AgentStatusInfo: agentStatusInfo(agentID, status, now)}
+}
+
+// companyAgent looks up the agent named in the URL, which must belong to the
+// caller's company.
+func (s *Server) companyAgent(w http.ResponseWriter, r *http.Request, user *db.User) (*db.User, bool) {
+	agent, err := s.queries.GetUserByAgentID(r.Context(), chi.URLParam(r, "agentId"))
+	if err != nil || agent.DeletedAt.Valid || agent.CompanyID != user.CompanyID {
+		respondError(w, http.StatusNotFound, "Agent not found")
+		return nil, false
+	}
+	return &agent, true
+}
+
+func (s *Server) getAgentStatus(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	agent, ok := s.companyAgent(w, r, user)
+	if !ok {
+		return
+	}
+	s.writeAgentStatus(w, r, agent)
+}
+
+func (s *Server) writeAgentStatus(w http.ResponseWriter, r *http.Request, agent *db.User) {
+	s.expirePresence(r.Context())
+
+	status, err := s.queries.GetAgentStatus(r.Context(), agent.AgentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusInternalServerError, "Failed to get agent status")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentStatusResponse(agent.AgentID, status, time.Now()))
+}
+
+// setAgentDnd takes an agent out of routing for the given number of
+// minutes. Zero minutes ends DND straight away.
+func (s *Server) setAgentDnd(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	agent, ok := s.companyAgent(w, r, user)
+	if !ok {
+		return
+	}
+	s.updateAgentDnd(w, r, user, agent)
+}
+
+// updateAgentDnd applies a DND request from user to agent, who may be the
+// same person.
+func (s *Server) updateAgentDnd(w http.ResponseWriter, r *http.Request, user, agent *db.User) {
+	var req AgentDndRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Minutes < 0 || req.Minutes > maxDndMinutes {
+		respondError(w, http.StatusBadRequest, "Minutes must be between 0 and "+strconv.Itoa(maxDndMinutes))
+		return
+	}
+
+	now := time.Now()
+	var until sql.NullTime
+	if req.Minutes > 0 {
+		until = sql.NullTime{Time: now.Add(time.Duration(req.Minutes) * time.Minute).UTC(), Valid: true}
+	}
+
+	status, err := s.queries.SetAgentDnd(r.Context(), db.SetAgentDndParams{
+		AgentID:  agent.AgentID,
+		DndUntil: until,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to set do not disturb")
+		return
+	}
+
+	if until.Valid {
+		logInfof(r.Context(), "🔕 Agent %s on do not disturb until %s (set by %s)", agent.AgentID, until.Time.Format(time.RFC3339), user.AgentID)
+	} else {
+		logInfof(r.Context(), "🔔 Agent %s off do not disturb (set by %s)", agent.AgentID, user.AgentID)
+	}
+	s.audit(r.Context(), user, auditAgentDnd, agent.AgentID, strconv.Itoa(req.Minutes))
+	s.notifyAgentStatus(r.Context(), agent.AgentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentStatusResponse(agent.AgentID, status, now))
+}
+
+// getAgentStatuses lists every agent in the caller's company with their
+// presence, so supervisors can see who is taking calls.
+func (s *Server) getAgentStatuses(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	s.expirePresence(r.Context())
+
+	rows, err := s.queries.ListAgentStatusesByCompany(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get agent statuses")
+		return
+	}
+
+	now := time.Now()
+	agents := make([]AgentStatusInfo, 0, len(rows))
+	for _, row := range rows {
+		agents = append(agents, agentStatusInfo(row.AgentID, db.AgentStatus{
+			AgentID:       row.AgentID,
+			DndUntil:      row.DndUntil,
+			UpdatedAt:     row.UpdatedAt,
+			WrapUpCallSid: row.WrapUpCallSid,
+			WrapUpUntil:   row.WrapUpUntil,
+			Presence:      row.Presence.String,
+			LastSeenAt:    row.LastSeenAt,
+		}, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentStatusesResponse{Success: true, Agents: agents})
+}
+
+// setAgentPresence sets the caller's own presence, which also counts as a
+// heartbeat.
+func (s *Server) setAgentPresence(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req AgentPresenceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !slices.Contains(presences, req.Status) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Status must be one of: %s", strings.Join(presences, ", ")))
+		return
+	}
+
+	now := time.Now()
+	status, err := s.queries.SetAgentPresence(r.Context(), db.SetAgentPresenceParams{
+		AgentID:    user.AgentID,
+		Presence:   req.Status,
+		LastSeenAt: sql.NullTime{Time: now.UTC(), Valid: true},
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to set status")
+		return
+	}
+
+	logInfof(r.Context(), "👤 Agent %s is %s", user.AgentID, req.Status)
+	s.notifyAgentStatus(r.Context(), user.AgentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentStatusResponse(user.AgentID, status, now))
+}
+
+// agentHeartbeat keeps the caller's presence from expiring. Browsers send
+// one well inside AGENT_HEARTBEAT_TIMEOUT while the softphone is open.
+func (s *Server) agentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	if _, err := s.queries.TouchAgentPresence(r.Context(), db.TouchAgentPresenceParams{
+		LastSeenAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		AgentID:    user.AgentID,
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to record heartbeat")
+		return
+	}
+	s.writeAgentStatus(w, r, user)
+}
+
+// expirePresence takes offline the agents whose heartbeats stopped, such as
+// those who closed the browser without signing out. It runs before presence
+// is read, so there is no sweeper to schedule.
+func (s *Server) expirePresence(ctx context.Context) {
+	cutoff := time.Now().Add(-s.presenceTimeout).UTC()
+	expired, err := s.queries.ExpireAgentPresence(ctx, sql.NullTime{Time: cutoff, Valid: true})
+	if err != nil {
+		logErrorf(ctx, "Error expiring agent presence: %v", err)
+		return
+	}
+	if expired > 0 {
+		logInfof(ctx, "👤 %d agent(s) went offline after missing heartbeats", expired)
+	}
+}
+
+// trackAgentCall makes an online agent busy when their call is answered and
+// puts them back online when it ends.
+func (s *Server) trackAgentCall(ctx context.Context, agentID, callStatus string) {
+	if agentID == "" {
+		return
+	}
+
+	params := db.SwitchAgentPresenceParams{AgentID: agentID}
+	switch {
+	case callStatus == "in-progress":
+		params.CurrentPresence, params.Presence = presenceOnline, presenceBusy
+	case callStatusRank[callStatus] == callStatusRank["completed"]:
+		params.CurrentPresence, params.Presence = presenceBusy, presenceOnline
+	default:
+		return
+	}
+	rows, err := s.queries.SwitchAgentPresence(ctx, params)
+	if err != nil {
+		logErrorf(ctx, "Error updating presence for agent %s: %v", agentID, err)
+		return
+	}
+	if rows > 0 {
+		s.notifyAgentStatus(ctx, agentID)
+	}
+}
+
+// notifyAgentStatus tells the agent's company where they now stand with
+// routing.
+func (s *Server) notifyAgentStatus(ctx context.Context, agentID string) {
+	agent, err := s.queries.GetUserByAgentID(ctx, agentID)
+	if err != nil {
+		return
+	}
+	status, err := s.queries.GetAgentStatus(ctx, agentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logErrorf(ctx, "Error getting status of agent %s: %v", agentID, err)
+		return
+	}
+	s.notify(realtimeEvent{
+		Type:      "agent_status",
+		CompanyID: agent.CompanyID,
+		AgentID:   agentID,
+		Data:      agentStatusInfo(agentID, status, time.Now()),
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimedDnd(t *testing.T) {
+	s := newTestServer(t)
+	acmeID := addCompany(t, s, "Acme")
+	agent := addAgent(t, s, acmeID, "acme1", roleAgent)
+	acme, _ := s.queries.GetCompany(t.Context(), acmeID)
+	router := newRouters(s.queries)[routingFirstAvailable]
+
+	setDnd := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.setMyDnd(w, asUser(httptest.NewRequest(http.MethodPut, "/api/me/dnd", strings.NewReader(body)), agent))
+		return w
+	}
+	status := func() string {
+		w := httptest.NewRecorder()
+		s.getMyStatus(w, asUser(httptest.NewRequest(http.MethodGet, "/api/me/status", nil), agent))
+		var resp AgentStatusResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Status
+	}
+	routed := func() bool {
+		_, err := router.SelectAgent(t.Context(), &acme, CallContext{})
+		if err != nil && !errors.Is(err, errNoAgentAvailable) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	for _, body := range []string{`{"minutes":-1}`, `{"minutes":721}`} {
+		if w := setDnd(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	w := setDnd(`{"minutes":30}`)
+	var resp AgentStatusResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.DndUntil == nil {
+		t.Fatalf("status = %d, want %d with dnd_until: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if until := time.Until(*resp.DndUntil); until < 29*time.Minute || until > 30*time.Minute {
+		t.Errorf("dnd_until is %s away, want 30m", until)
+	}
+	if got := status(); got != "dnd" || routed() {
+		t.Errorf("on do not disturb: status %q, routed %v; want dnd and not routed", got, routed())
+	}
+
+	// Once the time passes the agent is back without anyone clearing it
+	exec(t, s, "UPDATE agent_status SET dnd_until = ? WHERE agent_id = ?", time.Now().Add(-time.Second).UTC(), agent.AgentID)
+	if got := status(); got != "available" || !routed() {
+		t.Errorf("after do not disturb: status %q, routed %v; want available and routed", got, routed())
+	}
+
+	// Zero minutes ends it early
+	setDnd(`{"minutes":60}`)
+	if w := setDnd(`{"minutes":0}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := status(); got != "available" || !routed() {
+		t.Errorf("after ending do not disturb: status %q, routed %v; want available and routed", got, routed())
+	}
+}
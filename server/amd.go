@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"strings"
+
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// What happened to an outbound leg once answering machine detection reported
+const (
+	amdBridged = "bridged"
+	amdDropped = "dropped"
+	amdFailed  = "failed"
+)
+
+// amdVoicemailDrop reports whether machine-answered outbound calls get the
+// company's message instead of the agent. It is off unless AMD_VOICEMAIL_DROP
+// or the company's amd_voicemail_drop setting turns it on.
+func (s *Server) amdVoicemailDrop(ctx context.Context, companyID int64) bool {
+	enabled := s.companySetting(ctx, companyID, settingAmdVoicemailDrop, os.Getenv("AMD_VOICEMAIL_DROP"))
+	on, _ := strconv.ParseBool(enabled)
+	return on
+}
+
+// amdMessage is what the answering machine is told: the company's
+// amd_voicemail_message setting, else AMD_VOICEMAIL_MESSAGE, else a short
+// call-back request in the company's brand name.
+func (s *Server) amdMessage(ctx context.Context, companyID int64) string {
+	message := os.Getenv("AMD_VOICEMAIL_MESSAGE")
+	if message == "" {
+		message = fmt.Sprintf("Hello, this is %s. Sorry we missed you. Please call us back when you can. Goodbye.", s.productName(ctx, companyID))
+	}
+	return s.companySetting(ctx, companyID, settingAmdMessage, message)
+}
+
+func validateAmdMessage(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 500 {
+		return errors.New("Message must be 1 to 500 characters")
+	}
+	return nil
+}
+
+// amdAttributes turns on asynchronous answering machine detection for a
+// <Number> the agent dials, when their company drops messages on machines.
+// The agent is bridged straight away and only taken off the call if a
+// machine is detected.
+func (s *Server) amdAttributes(ctx context.Context, agentID string) string {
+	agent, err := s.queries.GetUserByAgentID(ctx, agentID)
+	if err != nil || !s.amdVoicemailDrop(ctx, agent.CompanyID) {
+		return ""
+	}
+	return fmt.Sprintf(` machineDetection="DetectMessageEnd" amdStatusCallback="/twilio/amd?agent_id=%s"`, url.QueryEscape(agentID))
+}
+
+// handleAmdResult is the amdStatusCallback for dialed legs. Machines that
+// have finished their greeting hear the company's message and are hung up
+// on; anything else stays bridged to the agent.
+func (s *Server) handleAmdResult(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	answeredBy := r.FormValue("AnsweredBy")
+	agentID := r.URL.Query().Get("agent_id")
+
+	outcome := amdBridged
+	if strings.HasPrefix(answeredBy, "machine_end") {
+		outcome = amdDropped
+		if err := s.dropAmdMessage(r.Context(), callSID, agentID); err != nil {
+			logErrorf(r.Context(), "Error dropping message on %s: %v", callSID, err)
+			outcome = amdFailed
+		}
+	}
+
+	logInfof(r.Context(), "🤖 Call %s answered by %s: %s", callSID, answeredBy, outcome)
+
+	if _, err := s.queries.UpdateCallAmd(r.Context(), db.UpdateCallAmdParams{
+		AnsweredBy: nullString(answeredBy),
+		AmdOutcome: sql.NullString{String: outcome, Valid: true},
+		CallSid:    callSID,
+	}); err != nil {
+		logErrorf(r.Context(), "Error recording AMD result for %s: %v", callSID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dropAmdMessage redirects the machine-answered leg to play the message of
+// agentID's company and hang up, which also ends the agent's side.
+func (s *Server) dropAmdMessage(ctx context.Context, callSID, agentID string) error {
+	agent, err := s.queries.GetUserByAgentID(ctx, agentID)
+	if err != nil {
+		return err
+	}
+	if s.twilio == nil {
+		return errTwilioNotConfigured
+	}
+	return playAmdMessage(s.twilio.Api, callSID, s.amdMessage(ctx, agent.CompanyID))
+}
+
+func playAmdMessage(api callController, callSID, message string) error {
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(amdMessageTwiML(message))
+	_, err := api.UpdateCall(callSID, update)
+	return err
+}
+
+func amdMessageTwiML(message string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>%s</Say>
+	<Hangup/>
+</Response>`, html.EscapeString(message))
+}
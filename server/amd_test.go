@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOutboundAmdAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string // amd_voicemail_drop, empty for none
+		skip    string // SkipVoicemailDrop sent with the call
+		amd     bool
+	}{
+		{"on", "true", "", true},
+		{"skipped for this call", "true", "true", false},
+		{"off", "false", "", false},
+		{"off by default", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+			if tt.setting != "" {
+				exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingAmdVoicemailDrop, tt.setting)
+			}
+
+			w := httptest.NewRecorder()
+			s.handleOutboundVoice(w, twilioWebhook("/twilio/voice", url.Values{
+				"CallSid": {"CA-agent"}, "From": {"client:acme1"}, "To": {"+27825550001"}, "SkipVoicemailDrop": {tt.skip},
+			}))
+			body := w.Body.String()
+			if amd := strings.Contains(body, `machineDetection="DetectMessageEnd" amdStatusCallback="/twilio/amd?agent_id=acme1"`); amd != tt.amd {
+				t.Errorf("answering machine detection = %v, want %v: %s", amd, tt.amd, body)
+			}
+		})
+	}
+}
+
+func TestAmdResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		answeredBy string
+		message    string // amd_voicemail_message, empty for none
+		fail       bool   // Twilio refuses the redirect
+		outcome    string
+		played     string // in the redirect, empty for none
+	}{
+		{"machine", "machine_end_beep", "Please call Acme back.", false, amdDropped, "<Say>Please call Acme back.</Say>\n\t<Hangup/>"},
+		{"machine, default message", "machine_end_silence", "", false, amdDropped, "Hello, this is OmniCall."},
+		{"human", "human", "Please call Acme back.", false, amdBridged, ""},
+		{"unsure", "unknown", "Please call Acme back.", false, amdBridged, ""},
+		{"machine, Twilio failing", "machine_end_other", "", true, amdFailed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			fake := withFakeTwilio(s)
+			fake.fail = tt.fail
+			fake.responses = map[string]string{"/Calls/CA-customer.json": `{"sid":"CA-customer"}`}
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			if tt.message != "" {
+				exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingAmdMessage, tt.message)
+			}
+			exec(t, s, `INSERT INTO calls (call_sid, parent_call_sid, direction, company_id, agent_id, status, started_at)
+				VALUES ('CA-customer', 'CA-agent', 'outbound', ?, 'acme1', 'in-progress', CURRENT_TIMESTAMP)`, acme)
+
+			w := httptest.NewRecorder()
+			s.handleAmdResult(w, twilioWebhook("/twilio/amd?agent_id=acme1", url.Values{
+				"CallSid": {"CA-customer"}, "AnsweredBy": {tt.answeredBy},
+			}))
+			if w.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+			}
+
+			// A human stays bridged to the agent: Twilio isn't asked anything
+			got := redirectedTo(fake, "CA-customer")
+			switch {
+			case tt.played != "" && (!strings.Contains(got, tt.played) || !strings.HasSuffix(got, "<Hangup/>\n</Response>")):
+				t.Errorf("redirected to %q, want the message %q then a hang-up", got, tt.played)
+			case tt.outcome == amdBridged && len(fake.requests) != 0:
+				t.Errorf("redirected a bridged call: %v", fake.paths)
+			}
+
+			call, err := s.queries.GetCallBySid(t.Context(), "CA-customer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.AnsweredBy.String != tt.answeredBy || call.AmdOutcome.String != tt.outcome {
+				t.Errorf("recorded %q, %q; want %q, %q", call.AnsweredBy.String, call.AmdOutcome.String, tt.answeredBy, tt.outcome)
+			}
+		})
+	}
+}
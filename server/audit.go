@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+	"time"
+)
+
+// Audit actions
+const (
+	auditCallBundleDownload = "call_bundle.download"
+	auditVoicemailReplay    = "voicemail.replay"
+	auditVoicemailPlay      = "voicemail.play"
+	auditCustomerVip        = "customer.vip"
+	auditCustomerCreate     = "customer.create"
+	auditCustomerUpdate     = "customer.update"
+	auditCustomerExport     = "customer.export"
+	auditBlockedNumberAdd   = "blocked_number.create"
+	auditBlockedNumberDel   = "blocked_number.delete"
+	auditSettingUpdate      = "setting.update"
+	auditVoicemailCleanup   = "voicemail.bulk_delete"
+	auditAgentDnd           = "agent.dnd"
+	auditPhoneNumberAdd     = "phone_number.create"
+	auditPhoneNumberUpdate  = "phone_number.update"
+	auditPhoneNumberDel     = "phone_number.delete"
+	auditVoicemailDrop      = "voicemail_drop.play"
+	auditCallPark           = "call.park"
+	auditCallRetrieve       = "call.retrieve"
+	auditPasswordReset      = "user.password_reset"
+	auditPasswordChange     = "user.password_change"
+	auditSessionRevoke      = "session.revoke"
+	auditSessionRevokeAll   = "session.revoke_all"
+	auditSMSSend            = "sms.send"
+	auditCallRecordingPlay  = "call.recording_play"
+	auditCallTransfer       = "call.transfer"
+	auditCallConference     = "call.conference"
+	auditCallHold           = "call.hold"
+	auditCallResume         = "call.resume"
+	auditCallDTMF           = "call.dtmf"
+	auditCallDial           = "call.dial"
+	auditCompanyUpdate      = "company.update"
+	auditUserCallerNumber   = "user.caller_number"
+	auditUserRole           = "user.role"
+	auditBusinessHours      = "business_hours.update"
+)
+
+type AuditLogResponse struct {
+	Success    bool          `json:"success"`
+	Entries    []db.AuditLog `json:"entries"`
+	Pagination Pagination    `json:"pagination"`
+}
+
+// audit records an action in the company's audit log. Failing to write the
+// entry is logged but never fails the request that triggered it.
+func (s *Server) audit(ctx context.Context, user *db.User, action, target, details string) {
+	err := s.queries.CreateAuditEntry(ctx, db.CreateAuditEntryParams{
+		CompanyID: user.CompanyID,
+		Actor:     user.AgentID,
+		Action:    action,
+		Target:    sql.NullString{String: target, Valid: target != ""},
+		Details:   sql.NullString{String: details, Valid: details != ""},
+	})
+	if err != nil {
+		logErrorf(ctx, "Error writing audit entry %s by %s: %v", action, user.AgentID, err)
+	}
+}
+
+// getAuditLog lists the caller's company audit trail, newest first. It can be
+// narrowed by actor, action and a from/to date range (RFC 3339 or
+// YYYY-MM-DD; a bare "to" date includes that whole day).
+func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	query := r.URL.Query()
+	since, err := parseTimeFilter(query.Get("from"), false)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid from date")
+		return
+	}
+	until, err := parseTimeFilter(query.Get("to"), true)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid to date")
+		return
+	}
+
+	actor := sql.NullString{String: query.Get("actor"), Valid: query.Get("actor") != ""}
+	action := sql.NullString{String: query.Get("action"), Valid: query.Get("action") != ""}
+	page := parsePagination(r)
+
+	total, err := s.queries.CountAuditEntries(r.Context(), db.CountAuditEntriesParams{
+		CompanyID: user.CompanyID,
+		Actor:     actor,
+		Action:    action,
+		Since:     since,
+		Until:     until,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get audit log")
+		return
+	}
+	page.Total = total
+
+	entries, err := s.queries.ListAuditEntries(r.Context(), db.ListAuditEntriesParams{
+		CompanyID: user.CompanyID,
+		Actor:     actor,
+		Action:    action,
+		Since:     since,
+		Until:     until,
+		Limit:     page.PageSize,
+		Offset:    page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get audit log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditLogResponse{
+		Success:    true,
+		Entries:    entries,
+		Pagination: page,
+	})
+}
+
+// parseTimeFilter parses a date filter bound. Date-only upper bounds move to
+// the start of the next day so the range is inclusive of that day.
+func parseTimeFilter(value string, upper bool) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestGetAuditLog(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	for _, e := range []struct {
+		company int64
+		actor   string
+		action  string
+		target  string
+		at      string
+	}{
+		{acme, "acmeadmin", auditCustomerCreate, "1", "2025-03-01 09:00:00"},
+		{acme, "acme1", auditCallDial, "2", "2025-03-01 23:59:59"},
+		{acme, "acmeadmin", auditCustomerUpdate, "3", "2025-03-02 08:00:00"},
+		{acme, "acme1", auditCustomerCreate, "4", "2025-03-03 12:00:00"},
+		{acme, "acme1", auditCallDial, "5", "2025-03-03 12:00:00"}, // same second as 4
+		{globex, "globexadmin", auditCustomerCreate, "6", "2025-03-02 10:00:00"},
+	} {
+		exec(t, s, "INSERT INTO audit_log (company_id, actor, action, target, created_at) VALUES (?, ?, ?, ?, ?)",
+			e.company, e.actor, e.action, e.target, e.at)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		targets []string // in the order listed
+		total   int64
+	}{
+		{"newest first, ties by id", "", []string{"5", "4", "3", "2", "1"}, 5},
+		{"by actor", "?actor=acme1", []string{"5", "4", "2"}, 3},
+		{"by action", "?action=" + auditCustomerCreate, []string{"4", "1"}, 2},
+		{"from a day", "?from=2025-03-02", []string{"5", "4", "3"}, 3},
+		{"to a day, inclusive", "?to=2025-03-01", []string{"2", "1"}, 2},
+		{"between instants", "?from=2025-03-01T12:00:00Z&to=2025-03-03T00:00:00Z", []string{"3", "2"}, 2},
+		{"filters combine", "?actor=acme1&action=" + auditCallDial + "&from=2025-03-02", []string{"5"}, 1},
+		{"another company's actor", "?actor=globexadmin", nil, 0},
+		{"second page", "?page=2&page_size=2", []string{"3", "2"}, 5},
+		{"page size clamped", "?page_size=0", []string{"5", "4", "3", "2", "1"}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.getAuditLog(w, asUser(httptest.NewRequest(http.MethodGet, "/api/audit-log"+tt.query, nil), admin))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var resp AuditLogResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			var targets []string
+			for _, e := range resp.Entries {
+				if e.CompanyID != acme {
+					t.Errorf("listed company %d's entry %s", e.CompanyID, e.Target.String)
+				}
+				targets = append(targets, e.Target.String)
+			}
+			if !slices.Equal(targets, tt.targets) || resp.Pagination.Total != tt.total {
+				t.Errorf("listed %v of %d, want %v of %d", targets, resp.Pagination.Total, tt.targets, tt.total)
+			}
+		})
+	}
+
+	t.Run("page size capped", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.getAuditLog(w, asUser(httptest.NewRequest(http.MethodGet, "/api/audit-log?page_size=1000", nil), admin))
+		var resp AuditLogResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Pagination.PageSize != maxPageSize {
+			t.Errorf("page size = %d, want %d", resp.Pagination.PageSize, maxPageSize)
+		}
+	})
+
+	for _, query := range []string{"?from=yesterday", "?to=2025-13-01"} {
+		t.Run("bad date "+query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.getAuditLog(w, asUser(httptest.NewRequest(http.MethodGet, "/api/audit-log"+query, nil), admin))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const backupPrefix = "omnicall-"
+
+// backupMu keeps the scheduled job and admin requests from writing
+// snapshots at the same time.
+var backupMu sync.Mutex
+
+// backupConfig says where snapshots go, how often the scheduled job takes
+// one (never when zero) and how many are kept.
+type backupConfig struct {
+	dir      string
+	interval time.Duration
+	keep     int
+}
+
+// BackupResponse names the snapshot within BACKUP_DIR, without saying where
+// that is on the server.
+type BackupResponse struct {
+	Success   bool      `json:"success"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupConfigFromEnv reads BACKUP_DIR (default ./backups), BACKUP_INTERVAL
+// (a duration such as 6h, unset for no scheduled backups) and BACKUP_KEEP
+// (default 7).
+func backupConfigFromEnv() (backupConfig, error) {
+	c := backupConfig{dir: os.Getenv("BACKUP_DIR"), keep: 7}
+	if c.dir == "" {
+		c.dir = "./backups"
+	}
+	if value := os.Getenv("BACKUP_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return c, errors.New("BACKUP_INTERVAL must be a duration such as 6h")
+		}
+		if interval > 0 && interval < time.Minute {
+			return c, errors.New("BACKUP_INTERVAL must be at least 1m")
+		}
+		c.interval = interval
+	}
+	if value := os.Getenv("BACKUP_KEEP"); value != "" {
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			return c, errors.New("BACKUP_KEEP must be at least 1")
+		}
+		c.keep = keep
+	}
+	return c, nil
+}
+
+// backupDatabase writes a snapshot of the live database with VACUUM INTO,
+// which reads one consistent view without blocking writers (WAL or not),
+// then prunes old snapshots.
+func (s *Server) backupDatabase(ctx context.Context) (string, os.FileInfo, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if err := os.MkdirAll(s.backups.dir, 0o700); err != nil {
+		return "", nil, err
+	}
+
+	name := backupPrefix + time.Now().UTC().Format("20060102-150405.000") + ".db"
+	path := filepath.Join(s.backups.dir, name)
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return "", nil, fmt.Errorf("vacuum into %s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := pruneBackups(s.backups.dir, s.backups.keep); err != nil {
+		logErrorf(ctx, "Error pruning backups: %v", err)
+	}
+	return path, info, nil
+}
+
+// pruneBackups deletes all but the newest keep snapshots. Their names sort
+// by the time they were taken.
+func pruneBackups(dir string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*.db"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	for len(names) > keep {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// runScheduledBackups snapshots the database every backup interval until
+// ctx is done.
+func (s *Server) runScheduledBackups(ctx context.Context) {
+	ticker := time.NewTicker(s.backups.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, info, err := s.backupDatabase(ctx)
+			if err != nil {
+				logErrorf(ctx, "Error backing up database: %v", err)
+				continue
+			}
+			logInfof(ctx, "💾 Database backed up to %s (%d bytes)", path, info.Size())
+		}
+	}
+}
+
+// createBackup takes a snapshot on demand. It copies every company's data,
+// so only the operator can.
+func (s *Server) createBackup(w http.ResponseWriter, r *http.Request) {
+	path, info, err := s.backupDatabase(r.Context())
+	if err != nil {
+		logErrorf(r.Context(), "Error backing up database: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to back up database")
+		return
+	}
+
+	logInfof(r.Context(), "💾 Database backed up to %s by the operator from %s (%d bytes)", path, s.clientIP(r), info.Size())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BackupResponse{
+		Success:   true,
+		Name:      filepath.Base(path),
+		Size:      info.Size(),
+		CreatedAt: info.ModTime().UTC(),
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateBackup(t *testing.T) {
+	s := newTestServer(t)
+	s.backups = backupConfig{dir: t.TempDir(), keep: 2}
+	addCompany(t, s, "Acme")
+
+	w := httptest.NewRecorder()
+	s.createBackup(w, httptest.NewRequest(http.MethodPost, "/api/admin/backup", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if strings.Contains(w.Body.String(), s.backups.dir) {
+		t.Errorf("response gives the server's backup directory: %s", w.Body)
+	}
+
+	var resp BackupResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != filepath.Base(resp.Name) || !strings.HasPrefix(resp.Name, backupPrefix) {
+		t.Errorf("name = %q, want a bare snapshot file name", resp.Name)
+	}
+	info, err := os.Stat(filepath.Join(s.backups.dir, resp.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != resp.Size {
+		t.Errorf("size = %d, want %d", resp.Size, info.Size())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type BlockedNumberCreate struct {
+	Phone  string `json:"phone"`
+	Reason string `json:"reason"`
+}
+
+type BlockedNumbersResponse struct {
+	Success        bool               `json:"success"`
+	BlockedNumbers []db.BlockedNumber `json:"blocked_numbers"`
+}
+
+type BlockedNumberResponse struct {
+	Success       bool              `json:"success"`
+	BlockedNumber *db.BlockedNumber `json:"blocked_number,omitempty"`
+}
+
+func (s *Server) getBlockedNumbers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	numbers, err := s.queries.ListBlockedNumbersByCompany(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get blocked numbers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BlockedNumbersResponse{
+		Success:        true,
+		BlockedNumbers: numbers,
+	})
+}
+
+func (s *Server) createBlockedNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req BlockedNumberCreate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	phone := normalizePhoneNumber(req.Phone)
+	if phone == "" {
+		respondError(w, http.StatusBadRequest, "Phone number is required")
+		return
+	}
+
+	// Twilio only understands "rejected" and "busy"
+	if req.Reason == "" {
+		req.Reason = "rejected"
+	}
+	if req.Reason != "rejected" && req.Reason != "busy" {
+		respondError(w, http.StatusBadRequest, "Reason must be 'rejected' or 'busy'")
+		return
+	}
+
+	blocked, err := s.queries.CreateBlockedNumber(r.Context(), db.CreateBlockedNumberParams{
+		CompanyID: user.CompanyID,
+		Phone:     phone,
+		Reason:    req.Reason,
+	})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Number is already blocked")
+		return
+	}
+
+	logInfof(r.Context(), "🚫 Blocked number %s for company %d", phone, user.CompanyID)
+	s.audit(r.Context(), user, auditBlockedNumberAdd, phone, req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BlockedNumberResponse{
+		Success:       true,
+		BlockedNumber: &blocked,
+	})
+}
+
+func (s *Server) deleteBlockedNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid blocked number id")
+		return
+	}
+
+	rows, err := s.queries.DeleteBlockedNumber(r.Context(), db.DeleteBlockedNumberParams{
+		ID:        id,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to unblock number")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusNotFound, "Blocked number not found")
+		return
+	}
+
+	s.audit(r.Context(), user, auditBlockedNumberDel, strconv.FormatInt(id, 10), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// rejectTwiML refuses a call without answering it, so the caller is never billed.
+func rejectTwiML(reason string) string {
+	if reason != "busy" {
+		reason = "rejected"
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Reject reason="%s"/>
+</Response>`, reason)
+}
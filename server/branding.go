@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"strings"
+)
+
+const (
+	defaultProductName = "OmniCall"
+	apiVersion         = "1.0.0"
+)
+
+type VersionResponse struct {
+	Product string `json:"product"`
+	Version string `json:"version"`
+}
+
+type ConfigResponse struct {
+	Success     bool   `json:"success"`
+	ProductName string `json:"product_name"`
+	Version     string `json:"version"`
+}
+
+// productName is what the API calls itself: the company's product_name
+// setting, else PRODUCT_NAME, else OmniCall. companyID is 0 when the request
+// isn't tied to a company.
+func (s *Server) productName(ctx context.Context, companyID int64) string {
+	name := os.Getenv("PRODUCT_NAME")
+	if name == "" {
+		name = defaultProductName
+	}
+	if companyID != 0 {
+		name = s.companySetting(ctx, companyID, settingProductName, name)
+	}
+	return name
+}
+
+// requestProductName brands a response for the signed-in user's company,
+// falling back to the global name for anonymous requests.
+func (s *Server) requestProductName(r *http.Request) string {
+	var id int64
+	if user, err := s.sessionUser(r); err == nil {
+		id = user.CompanyID
+	}
+	return s.productName(r.Context(), id)
+}
+
+// companyID is the id of company, or 0 when there isn't one.
+func companyID(company *db.Company) int64 {
+	if company == nil {
+		return 0
+	}
+	return company.ID
+}
+
+func validateProductName(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 50 {
+		return errors.New("Product name must be 1 to 50 characters")
+	}
+	return nil
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionResponse{
+		Product: s.requestProductName(r),
+		Version: apiVersion,
+	})
+}
+
+// getConfig is what the frontend needs to know about this deployment before
+// it renders anything.
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfigResponse{
+		Success:     true,
+		ProductName: s.requestProductName(r),
+		Version:     apiVersion,
+	})
+}
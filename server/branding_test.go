@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProductName(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		company string // who is signed in, empty for nobody
+		want    string
+	}{
+		{"default", "", "", defaultProductName},
+		{"deployment", "CallCo", "", "CallCo"},
+		{"company's own", "CallCo", "Acme", "Acme Voice"},
+		{"company without one", "CallCo", "Globex", "CallCo"},
+		{"company without one, default", "", "Globex", defaultProductName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PRODUCT_NAME", tt.env)
+			s := newTestServer(t)
+			users := map[string]*http.Cookie{}
+			for _, name := range []string{"Acme", "Globex"} {
+				company := addCompany(t, s, name)
+				users[name] = signIn(t, s, addAgent(t, s, company, name+"1", roleAgent))
+				if name == "Acme" {
+					exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, 'Acme Voice')", company, settingProductName)
+				}
+			}
+
+			get := func(handler http.HandlerFunc, path string, v any) {
+				t.Helper()
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				if tt.company != "" {
+					r.AddCookie(users[tt.company])
+				}
+				w := httptest.NewRecorder()
+				handler(w, r)
+				if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+					t.Fatalf("%s: %v", path, err)
+				}
+			}
+
+			var root struct {
+				Message string `json:"message"`
+			}
+			get(s.root, "/", &root)
+			if root.Message != tt.want+" API Server" {
+				t.Errorf("/ message = %q, want %q", root.Message, tt.want+" API Server")
+			}
+			var version VersionResponse
+			get(s.version, "/version", &version)
+			if version.Product != tt.want || version.Version != apiVersion {
+				t.Errorf("/version = %+v, want %s %s", version, tt.want, apiVersion)
+			}
+			var config ConfigResponse
+			get(s.getConfig, "/api/config", &config)
+			if config.ProductName != tt.want {
+				t.Errorf("/api/config product_name = %q, want %q", config.ProductName, tt.want)
+			}
+		})
+	}
+}
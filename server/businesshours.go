@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"strings"
+	"time"
+
+	// The runtime image has no zoneinfo of its own
+	_ "time/tzdata"
+)
+
+// Company opening hours: a timezone, weekly windows and holidays. A company
+// without a schedule is open around the clock.
+
+type BusinessHoursWindow struct {
+	Day    int64  `json:"day"`    // 0 is Sunday
+	Opens  string `json:"opens"`  // HH:MM
+	Closes string `json:"closes"` // HH:MM, at or before opens to run past midnight
+}
+
+type BusinessHoursHoliday struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	Name string `json:"name"`
+}
+
+type BusinessHours struct {
+	Timezone string                 `json:"timezone"`
+	Windows  []BusinessHoursWindow  `json:"windows"`
+	Holidays []BusinessHoursHoliday `json:"holidays"`
+
+	location *time.Location
+}
+
+type BusinessHoursResponse struct {
+	Success       bool           `json:"success"`
+	BusinessHours *BusinessHours `json:"business_hours"` // null when always open
+	Open          bool           `json:"open"`
+}
+
+// isOpen reports whether hours has the company open at now. Windows are
+// compared on the local wall clock, so 09:00 stays 09:00 across daylight
+// saving changes. Holidays close the whole local date, including the tail
+// of a window that opened the evening before.
+func isOpen(hours *BusinessHours, now time.Time) bool {
+	if hours == nil {
+		return true
+	}
+
+	local := now.In(hours.location)
+	date := local.Format(time.DateOnly)
+	for _, holiday := range hours.Holidays {
+		if holiday.Date == date {
+			return false
+		}
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	today := int64(local.Weekday())
+	yesterday := (today + 6) % 7
+	for _, window := range hours.Windows {
+		opens, _ := parseClock(window.Opens)
+		closes, _ := parseClock(window.Closes)
+		overnight := closes <= opens
+		switch {
+		case window.Day == today && minute >= opens && (overnight || minute < closes):
+			return true
+		case window.Day == yesterday && overnight && minute < closes:
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock turns HH:MM into minutes past midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateBusinessHours checks a schedule and loads its timezone, returning
+// the problems with it by field.
+func validateBusinessHours(hours *BusinessHours) map[string]string {
+	fields := map[string]string{}
+
+	hours.Timezone = strings.TrimSpace(hours.Timezone)
+	location, err := time.LoadLocation(hours.Timezone)
+	if hours.Timezone == "" || err != nil {
+		fields["timezone"] = "Must be an IANA timezone such as America/New_York"
+	}
+	hours.location = location
+
+	for i, window := range hours.Windows {
+		if window.Day < 0 || window.Day > 6 {
+			fields[fmt.Sprintf("windows[%d].day", i)] = "Must be 0 (Sunday) to 6 (Saturday)"
+		}
+		if _, err := parseClock(window.Opens); err != nil {
+			fields[fmt.Sprintf("windows[%d].opens", i)] = "Must be a time such as 09:00"
+		}
+		if _, err := parseClock(window.Closes); err != nil {
+			fields[fmt.Sprintf("windows[%d].closes", i)] = "Must be a time such as 17:30"
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, holiday := range hours.Holidays {
+		hours.Holidays[i].Name = strings.TrimSpace(holiday.Name)
+		switch _, err := time.Parse(time.DateOnly, holiday.Date); {
+		case err != nil:
+			fields[fmt.Sprintf("holidays[%d].date", i)] = "Must be a date such as 2025-12-25"
+		case seen[holiday.Date]:
+			fields[fmt.Sprintf("holidays[%d].date", i)] = "Listed more than once"
+		}
+		seen[holiday.Date] = true
+		if len(hours.Holidays[i].Name) > 100 {
+			fields[fmt.Sprintf("holidays[%d].name", i)] = "Must be at most 100 characters"
+		}
+	}
+	return fields
+}
+
+// businessHours loads a company's schedule, or nil when it has none.
+func (s *Server) businessHours(ctx context.Context, companyID int64) (*BusinessHours, error) {
+	schedule, err := s.queries.GetBusinessSchedule(ctx, companyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := s.queries.ListBusinessScheduleWindows(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	holidays, err := s.queries.ListBusinessHolidays(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := &BusinessHours{
+		Timezone: schedule.Timezone,
+		Windows:  []BusinessHoursWindow{},
+		Holidays: []BusinessHoursHoliday{},
+		location: location,
+	}
+	for _, window := range windows {
+		hours.Windows = append(hours.Windows, BusinessHoursWindow{Day: window.Day, Opens: window.Opens, Closes: window.Closes})
+	}
+	for _, holiday := range holidays {
+		hours.Holidays = append(hours.Holidays, BusinessHoursHoliday{Date: holiday.Date, Name: holiday.Name})
+	}
+	return hours, nil
+}
+
+// companyOpen reports whether a company is taking calls at now. A schedule
+// that can't be read leaves the company open rather than turning callers
+// away.
+func (s *Server) companyOpen(ctx context.Context, companyID int64, now time.Time) bool {
+	hours, err := s.businessHours(ctx, companyID)
+	if err != nil {
+		logErrorf(ctx, "Error getting business hours for company %d: %v", companyID, err)
+		return true
+	}
+	return isOpen(hours, now)
+}
+
+// afterHoursTwiML answers a call the company is closed for: its after-hours
+// message when it has one, otherwise the voicemail prompt.
+func (s *Server) afterHoursTwiML(ctx context.Context, company *db.Company, from string) string {
+	message := s.companySetting(ctx, company.ID, settingAfterHoursMessage, "")
+	if message == "" {
+		return s.voicemailPromptTwiML(ctx, company, from)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	%s
+	<Hangup/>
+</Response>`, s.sayTwiML(ctx, company.ID, message))
+}
+
+func (s *Server) getBusinessHours(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	hours, err := s.businessHours(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get business hours")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BusinessHoursResponse{
+		Success:       true,
+		BusinessHours: hours,
+		Open:          isOpen(hours, time.Now()),
+	})
+}
+
+// updateBusinessHours replaces the company's whole schedule.
+func (s *Server) updateBusinessHours(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var hours BusinessHours
+	if !decodeJSON(w, r, &hours) {
+		return
+	}
+	if fields := validateBusinessHours(&hours); len(fields) > 0 {
+		respondValidationError(w, errCodeInvalidSchedule, "Invalid business hours", fields)
+		return
+	}
+	if hours.Windows == nil {
+		hours.Windows = []BusinessHoursWindow{}
+	}
+	if hours.Holidays == nil {
+		hours.Holidays = []BusinessHoursHoliday{}
+	}
+
+	err := s.withTx(r.Context(), func(q *db.Queries) error {
+		if _, err := q.UpsertBusinessSchedule(r.Context(), db.UpsertBusinessScheduleParams{
+			CompanyID: user.CompanyID,
+			Timezone:  hours.Timezone,
+		}); err != nil {
+			return err
+		}
+		if err := q.DeleteBusinessScheduleWindows(r.Context(), user.CompanyID); err != nil {
+			return err
+		}
+		for _, window := range hours.Windows {
+			if err := q.CreateBusinessScheduleWindow(r.Context(), db.CreateBusinessScheduleWindowParams{
+				CompanyID: user.CompanyID,
+				Day:       window.Day,
+				Opens:     window.Opens,
+				Closes:    window.Closes,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := q.DeleteBusinessHolidays(r.Context(), user.CompanyID); err != nil {
+			return err
+		}
+		for _, holiday := range hours.Holidays {
+			if err := q.CreateBusinessHoliday(r.Context(), db.CreateBusinessHolidayParams{
+				CompanyID: user.CompanyID,
+				Date:      holiday.Date,
+				Name:      holiday.Name,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error saving business hours for company %d: %v", user.CompanyID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to update business hours")
+		return
+	}
+
+	s.audit(r.Context(), user, auditBusinessHours, hours.Timezone, fmt.Sprintf("%d windows, %d holidays", len(hours.Windows), len(hours.Holidays)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BusinessHoursResponse{
+		Success:       true,
+		BusinessHours: &hours,
+		Open:          isOpen(&hours, time.Now()),
+	})
+}
+
+// deleteBusinessHours removes the company's schedule, leaving it open
+// around the clock.
+func (s *Server) deleteBusinessHours(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	err := s.withTx(r.Context(), func(q *db.Queries) error {
+		if err := q.DeleteBusinessScheduleWindows(r.Context(), user.CompanyID); err != nil {
+			return err
+		}
+		if err := q.DeleteBusinessHolidays(r.Context(), user.CompanyID); err != nil {
+			return err
+		}
+		return q.DeleteBusinessSchedule(r.Context(), user.CompanyID)
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete business hours")
+		return
+	}
+
+	s.audit(r.Context(), user, auditBusinessHours, "", "always open")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// schedule builds validated business hours, as the handlers load them.
+func schedule(t *testing.T, timezone string, windows []BusinessHoursWindow, holidays ...BusinessHoursHoliday) *BusinessHours {
+	t.Helper()
+	hours := &BusinessHours{Timezone: timezone, Windows: windows, Holidays: holidays}
+	if fields := validateBusinessHours(hours); len(fields) > 0 {
+		t.Fatalf("invalid schedule: %v", fields)
+	}
+	return hours
+}
+
+func TestIsOpen(t *testing.T) {
+	weekdays := schedule(t, "America/New_York", []BusinessHoursWindow{
+		{Day: 0, Opens: "09:00", Closes: "17:00"},
+		{Day: 1, Opens: "09:00", Closes: "17:00"},
+	})
+	overnight := schedule(t, "UTC", []BusinessHoursWindow{
+		{Day: 5, Opens: "22:00", Closes: "02:00"}, // Friday night
+		{Day: 6, Opens: "22:00", Closes: "02:00"}, // Saturday night, into Sunday
+		{Day: 2, Opens: "00:00", Closes: "00:00"}, // all of Tuesday
+	})
+	// Saturday night into Sunday 9 March 2025, when New York springs forward
+	springForward := schedule(t, "America/New_York", []BusinessHoursWindow{{Day: 6, Opens: "22:00", Closes: "06:00"}})
+	holiday := schedule(t, "Africa/Johannesburg",
+		[]BusinessHoursWindow{{Day: 3, Opens: "20:00", Closes: "04:00"}}, // Wednesday night
+		BusinessHoursHoliday{Date: "2025-12-25", Name: "Christmas"})
+
+	tests := []struct {
+		name  string
+		hours *BusinessHours
+		now   time.Time
+		want  bool
+	}{
+		{"no schedule", nil, time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC), true},
+
+		{"opening minute", weekdays, time.Date(2025, 1, 6, 14, 0, 0, 0, time.UTC), true},          // Mon 09:00 EST
+		{"minute before opening", weekdays, time.Date(2025, 1, 6, 13, 59, 0, 0, time.UTC), false}, // Mon 08:59 EST
+		{"closing minute", weekdays, time.Date(2025, 1, 6, 22, 0, 0, 0, time.UTC), false},         // Mon 17:00 EST
+		{"day without a window", weekdays, time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), false},   // Tue 10:00 EST
+
+		{"overnight before midnight", overnight, time.Date(2025, 1, 3, 23, 0, 0, 0, time.UTC), true},    // Fri 23:00
+		{"overnight after midnight", overnight, time.Date(2025, 1, 4, 1, 59, 0, 0, time.UTC), true},     // Sat 01:59
+		{"overnight at closing", overnight, time.Date(2025, 1, 4, 2, 0, 0, 0, time.UTC), false},         // Sat 02:00
+		{"before an overnight window", overnight, time.Date(2025, 1, 3, 21, 59, 0, 0, time.UTC), false}, // Fri 21:59
+		{"overnight into Sunday", overnight, time.Date(2025, 1, 5, 1, 0, 0, 0, time.UTC), true},         // Sun 01:00
+		{"night without a window", overnight, time.Date(2025, 1, 2, 23, 0, 0, 0, time.UTC), false},      // Thu 23:00
+		{"whole-day window", overnight, time.Date(2025, 1, 7, 12, 0, 0, 0, time.UTC), true},             // Tue 12:00
+		{"whole-day window runs to midnight", overnight, time.Date(2025, 1, 7, 23, 59, 0, 0, time.UTC), true},
+		{"whole-day window ends at midnight", overnight, time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC), false},
+
+		// 09:00 stays 09:00 local time on either side of a change
+		{"09:30 EST", weekdays, time.Date(2025, 3, 2, 14, 30, 0, 0, time.UTC), true},
+		{"08:30 EST", weekdays, time.Date(2025, 3, 2, 13, 30, 0, 0, time.UTC), false},
+		{"09:30 EDT, the day clocks go forward", weekdays, time.Date(2025, 3, 9, 13, 30, 0, 0, time.UTC), true},
+		{"09:30 EST, the day clocks go back", weekdays, time.Date(2025, 11, 2, 14, 30, 0, 0, time.UTC), true},
+		{"08:30 EST, the day clocks go back", weekdays, time.Date(2025, 11, 2, 13, 30, 0, 0, time.UTC), false},
+		{"overnight across the change", springForward, time.Date(2025, 3, 9, 9, 30, 0, 0, time.UTC), true},    // Sun 05:30 EDT
+		{"overnight ends on local time", springForward, time.Date(2025, 3, 9, 10, 30, 0, 0, time.UTC), false}, // Sun 06:30 EDT
+
+		{"the evening before a holiday", holiday, time.Date(2025, 12, 24, 19, 0, 0, 0, time.UTC), true},   // Wed 21:00 SAST
+		{"holiday cuts the night short", holiday, time.Date(2025, 12, 24, 23, 30, 0, 0, time.UTC), false}, // Thu 01:30 SAST
+		{"holiday by local date", holiday, time.Date(2025, 12, 31, 23, 30, 0, 0, time.UTC), true},         // Thu 1 Jan 01:30 SAST
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOpen(tt.hours, tt.now); got != tt.want {
+				t.Errorf("isOpen at %s = %v, want %v", tt.now.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBusinessHours(t *testing.T) {
+	tests := []struct {
+		name   string
+		hours  BusinessHours
+		fields []string
+	}{
+		{"valid", BusinessHours{Timezone: "Europe/London", Windows: []BusinessHoursWindow{{Day: 1, Opens: "09:00", Closes: "17:30"}}}, nil},
+		{"no timezone", BusinessHours{}, []string{"timezone"}},
+		{"unknown timezone", BusinessHours{Timezone: "Mars/Olympus_Mons"}, []string{"timezone"}},
+		{"bad day", BusinessHours{Timezone: "UTC", Windows: []BusinessHoursWindow{{Day: 7, Opens: "09:00", Closes: "17:00"}}}, []string{"windows[0].day"}},
+		{"bad times", BusinessHours{Timezone: "UTC", Windows: []BusinessHoursWindow{{Day: 1, Opens: "9am", Closes: "24:00"}}}, []string{"windows[0].opens", "windows[0].closes"}},
+		{"repeated holiday", BusinessHours{Timezone: "UTC", Holidays: []BusinessHoursHoliday{{Date: "2025-12-25"}, {Date: "2025-12-25"}}}, []string{"holidays[1].date"}},
+		{"bad holiday date", BusinessHours{Timezone: "UTC", Holidays: []BusinessHoursHoliday{{Date: "25/12/2025"}}}, []string{"holidays[0].date"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := validateBusinessHours(&tt.hours)
+			if len(fields) != len(tt.fields) {
+				t.Fatalf("problems = %v, want %v", fields, tt.fields)
+			}
+			for _, field := range tt.fields {
+				if _, ok := fields[field]; !ok {
+					t.Errorf("problems = %v, want one for %s", fields, field)
+				}
+			}
+		})
+	}
+}
+
+func TestCompanyOpenInItsOwnTimezone(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	initech := addCompany(t, s, "Initech")
+
+	// Both open 09:00 to 17:00 on Mondays, on their own clocks
+	for company, timezone := range map[int64]string{acme: "Africa/Johannesburg", globex: "America/Los_Angeles"} {
+		admin := addAgent(t, s, company, "admin"+timezone[:3], roleAdmin)
+		body := `{"timezone":"` + timezone + `","windows":[{"day":1,"opens":"09:00","closes":"17:00"}]}`
+		w := httptest.NewRecorder()
+		s.updateBusinessHours(w, asUser(httptest.NewRequest(http.MethodPut, "/api/business-hours", strings.NewReader(body)), admin))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		company int64
+		now     time.Time
+		want    bool
+	}{
+		{"Johannesburg mid-morning", acme, time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC), true},   // 10:00 SAST
+		{"Los Angeles before dawn", globex, time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC), false}, // 00:00 PST
+		{"Johannesburg evening", acme, time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC), false},     // 20:00 SAST
+		{"Los Angeles mid-morning", globex, time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC), true}, // 10:00 PST
+		{"no schedule", initech, time.Date(2025, 1, 6, 3, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.companyOpen(t.Context(), tt.company, tt.now); got != tt.want {
+				t.Errorf("companyOpen = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// maxDTMFDigits is about as long as an IVR menu path plus an account number
+// gets.
+const maxDTMFDigits = 32
+
+type CallDTMFRequest struct {
+	Digits string `json:"digits"`
+}
+
+type CallMuteRequest struct {
+	Muted *bool `json:"muted"`
+}
+
+type CallControlResponse struct {
+	Success bool   `json:"success"`
+	CallSid string `json:"call_sid"`
+	Muted   *bool  `json:"muted,omitempty"`
+}
+
+// sendCallDTMF plays touch tones to the other side of the agent's call, for
+// getting through an IVR. Twilio can only do that by redirecting the other
+// leg, so as with hold the agent's leg drops and is rung straight back once
+// the tones have played. callSid is the agent's (browser) leg.
+func (s *Server) sendCallDTMF(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req CallDTMFRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateDTMF(req.Digits); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	customer, ok := s.liveCustomerLeg(w, r, user, chi.URLParam(r, "callSid"), "Failed to send digits")
+	if !ok {
+		return
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(dtmfTwiML(req.Digits, user.AgentID))
+	if _, err := s.twilio.Api.UpdateCall(*customer.Sid, update); err != nil {
+		logErrorf(r.Context(), "Error sending digits to %s: %v", *customer.Sid, err)
+		respondError(w, http.StatusBadGateway, "Failed to send digits")
+		return
+	}
+
+	// The digits themselves can be PINs or account numbers, so only how
+	// many were sent goes in the log and the audit trail
+	logInfof(r.Context(), "🔢 %d digits sent to %s by %s", len(req.Digits), *customer.Sid, user.AgentID)
+	s.audit(r.Context(), user, auditCallDTMF, *customer.Sid, fmt.Sprintf("%d digits", len(req.Digits)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallControlResponse{Success: true, CallSid: *customer.Sid})
+}
+
+// validateDTMF accepts the keys on a phone keypad.
+func validateDTMF(digits string) error {
+	if digits == "" {
+		return errors.New("digits is required")
+	}
+	if len(digits) > maxDTMFDigits {
+		return fmt.Errorf("digits must be at most %d characters", maxDTMFDigits)
+	}
+	if strings.Trim(digits, "0123456789*#") != "" {
+		return errors.New("digits may only contain 0-9, * and #")
+	}
+	return nil
+}
+
+// dtmfTwiML plays digits and then puts the call back through to the agent.
+func dtmfTwiML(digits, agentID string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Play digits="%s"/>
+	<Dial action="/twilio/dial-complete?agent_id=%s">
+		<Client>%s</Client>
+	</Dial>
+</Response>`, html.EscapeString(digits), url.QueryEscape(agentID), html.EscapeString(agentID))
+}
+
+// muteCall mutes or unmutes the agent on their call. Twilio can't mute a
+// bridged leg from the server, so this tells the agent's browser to do it
+// through the Voice SDK. muted is optional and flips the current state
+// when left out. callSid is the agent's (browser) leg.
+func (s *Server) muteCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req CallMuteRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	callSID := chi.URLParam(r, "callSid")
+	agentLeg, err := fetchCall(s.twilio.Api, callSID)
+	if errors.Is(err, errCallNotFound) || (err == nil && !isAgentLeg(agentLeg, user.AgentID)) {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error fetching call %s: %v", callSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to mute call")
+		return
+	}
+	if agentLeg.Status == nil || *agentLeg.Status != "in-progress" {
+		respondError(w, http.StatusConflict, "Call is no longer in progress")
+		return
+	}
+
+	resp := CallControlResponse{Success: true, CallSid: callSID, Muted: req.Muted}
+	eventType := "call_mute_toggled"
+	if req.Muted != nil {
+		eventType = "call_muted"
+		if !*req.Muted {
+			eventType = "call_unmuted"
+		}
+	}
+	s.notify(realtimeEvent{
+		Type:      eventType,
+		CompanyID: user.CompanyID,
+		AgentID:   user.AgentID,
+		Data:      resp,
+	})
+	logInfof(r.Context(), "🔇 Sent %s for call %s to %s", eventType, callSID, user.AgentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
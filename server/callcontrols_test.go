@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendCallDTMF(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		digits string
+		want   int
+	}{
+		{"letters", "acme1", "12a", http.StatusBadRequest},
+		{"nothing", "acme1", "", http.StatusBadRequest},
+		{"too many", "acme1", strings.Repeat("1", maxDTMFDigits+1), http.StatusBadRequest},
+		{"someone else's call", "acme2", "1", http.StatusNotFound},
+		{"keypad", "acme1", "0123456789*#", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, users := holdServer(t, "in-progress")
+			w := httptest.NewRecorder()
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/CA-agent/dtmf", strings.NewReader(`{"digits":"`+tt.digits+`"}`)), users[tt.user])
+			s.sendCallDTMF(w, withURLParams(r, map[string]string{"callSid": "CA-agent"}))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			got := redirectedTo(fake, "CA-customer")
+			if tt.want != http.StatusOK {
+				if got != "" {
+					t.Errorf("sent digits on a refused request: %s", got)
+				}
+				return
+			}
+			if want := dtmfTwiML(tt.digits, "acme1"); got != want {
+				t.Errorf("customer sent to %s, want %s", got, want)
+			}
+
+			// The audit trail has how many digits, never the digits
+			var details string
+			s.db.QueryRow("SELECT details FROM audit_log WHERE action = ? AND actor = 'acme1'", auditCallDTMF).Scan(&details)
+			if details != "12 digits" {
+				t.Errorf("audit details = %q, want %q", details, "12 digits")
+			}
+		})
+	}
+}
+
+func TestMuteCall(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		status string // of the agent's leg
+		body   string
+		want   int
+		event  string
+	}{
+		{"mute", "acme1", "in-progress", `{"muted":true}`, http.StatusOK, "call_muted"},
+		{"unmute", "acme1", "in-progress", `{"muted":false}`, http.StatusOK, "call_unmuted"},
+		{"toggle", "acme1", "in-progress", `{}`, http.StatusOK, "call_mute_toggled"},
+		{"someone else's call", "acme2", "in-progress", `{"muted":true}`, http.StatusNotFound, ""},
+		{"call over", "acme1", "completed", `{"muted":true}`, http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, users := holdServer(t, "in-progress")
+			fake.responses["/Calls/CA-agent.json"] = `{"sid":"CA-agent","from":"client:acme1","to":"+27825550001","status":"` + tt.status + `"}`
+			events := s.hub.subscribe(users["acme1"].CompanyID)
+			defer s.hub.unsubscribe(users["acme1"].CompanyID, events)
+
+			w := httptest.NewRecorder()
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/CA-agent/mute", strings.NewReader(tt.body)), users[tt.user])
+			s.muteCall(w, withURLParams(r, map[string]string{"callSid": "CA-agent"}))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// Muting happens in the agent's browser; no call is redirected
+			for _, path := range fake.paths {
+				if strings.HasPrefix(path, http.MethodPost) {
+					t.Errorf("updated a call: %s", path)
+				}
+			}
+			select {
+			case ev := <-events:
+				if ev.Type != tt.event || ev.AgentID != "acme1" {
+					t.Errorf("event %s for %q, want %s for acme1", ev.Type, ev.AgentID, tt.event)
+				}
+				var sent CallControlResponse
+				json.NewDecoder(w.Body).Decode(&sent)
+				if data := ev.Data.(CallControlResponse); data.CallSid != "CA-agent" || (data.Muted == nil) != (sent.Muted == nil) {
+					t.Errorf("event data %+v, want the response %+v", data, sent)
+				}
+			default:
+				if tt.event != "" {
+					t.Errorf("no %s event", tt.event)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"strconv"
+)
+
+// collectCallerName reports whether unknown callers should be asked to record
+// their name. It is off unless COLLECT_CALLER_NAME or the company's
+// collect_caller_name setting turns it on.
+func (s *Server) collectCallerName(ctx context.Context, company *db.Company) bool {
+	enabled := os.Getenv("COLLECT_CALLER_NAME")
+	if company != nil {
+		enabled = s.companySetting(ctx, company.ID, settingCollectCallerName, enabled)
+	}
+	on, _ := strconv.ParseBool(enabled)
+	return on
+}
+
+// callerNameTwiML asks the caller for their name. Twilio skips the action
+// when nothing was recorded, so the Redirect carries on without a name.
+func callerNameTwiML(productName string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Welcome to %s. After the tone, please say your name.</Say>
+	<Record action="/twilio/incoming-call?step=name" maxLength="4" timeout="3" playBeep="true" trim="trim-silence"/>
+	<Redirect>/twilio/incoming-call?step=name</Redirect>
+</Response>`, html.EscapeString(productName))
+}
+
+// handleWhisper plays the caller's recorded name to the agent before the
+// call is bridged.
+func (s *Server) handleWhisper(w http.ResponseWriter, r *http.Request) {
+	recordingURL := ""
+	ref, err := s.queries.GetCallRef(r.Context(), r.URL.Query().Get("call_id"))
+	if err != nil {
+		logErrorf(r.Context(), "Error getting call for whisper: %v", err)
+	} else {
+		recordingURL = ref.NameRecordingUrl.String
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(whisperTwiML(recordingURL)))
+}
+
+// whisperTwiML announces the caller to the agent, or lets the call straight
+// through when there is no recording.
+func whisperTwiML(recordingURL string) string {
+	if recordingURL == "" {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<Response/>`
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Call from</Say>
+	<Play>%s</Play>
+</Response>`, html.EscapeString(recordingURL))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+	"strings"
+)
+
+// fallbackApology is what a caller hears when Twilio gives up on a call.
+const fallbackApology = "We're sorry, we couldn't connect your call. Please try again later."
+
+type CallErrorsResponse struct {
+	Success    bool           `json:"success"`
+	Errors     []db.CallError `json:"errors"`
+	Pagination Pagination     `json:"pagination"`
+}
+
+// handleCallFallback is the fallback URL Twilio fetches when the TwiML for a
+// call can't be had: the primary URL failed, timed out or returned something
+// Twilio couldn't run. The caller gets an apology rather than Twilio's
+// generic error, and the ErrorCode and ErrorUrl Twilio sends are kept for
+// GET /api/call-errors.
+//
+// Set it as the Voice Fallback URL on each Twilio number and on the TwiML
+// App agents call out through. Calls placed through the REST API carry it
+// already. <Dial> itself takes no fallback: a bridge that fails is reported
+// to its action URL instead.
+func (s *Server) handleCallFallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	from := r.FormValue("From")
+	to := r.FormValue("To")
+	errorCode := r.FormValue("ErrorCode")
+	errorURL := r.FormValue("ErrorUrl")
+
+	logErrorf(r.Context(), "🚨 Call fallback: CallSID=%s, ErrorCode=%s, ErrorUrl=%s", callSID, errorCode, errorURL)
+
+	companyID := s.fallbackCompany(r.Context(), callSID, from, to)
+	if err := s.queries.CreateCallError(r.Context(), db.CreateCallErrorParams{
+		CompanyID:  sql.NullInt64{Int64: companyID, Valid: companyID != 0},
+		CallSid:    nullString(callSID),
+		ErrorCode:  nullString(errorCode),
+		ErrorUrl:   nullString(errorURL),
+		FromNumber: nullString(from),
+		ToNumber:   nullString(to),
+	}); err != nil {
+		logErrorf(r.Context(), "Error recording call error for %s: %v", callSID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(announcementTwiML(fallbackApology)))
+}
+
+// fallbackCompany works out whose call failed: the company the call was
+// recorded against, else the company of the number dialed, else that of the
+// agent calling out. Zero when none of them are known.
+func (s *Server) fallbackCompany(ctx context.Context, callSID, from, to string) int64 {
+	if call, err := s.queries.GetCallBySid(ctx, callSID); err == nil && call.CompanyID.Valid {
+		return call.CompanyID.Int64
+	}
+	if company, _ := s.numberCompany(ctx, to); company != nil {
+		return company.ID
+	}
+	if agentID, ok := strings.CutPrefix(from, "client:"); ok {
+		if agent, err := s.queries.GetUserByAgentID(ctx, agentID); err == nil {
+			return agent.CompanyID
+		}
+	}
+	return 0
+}
+
+// getCallErrors lists the company's failed calls, newest first, for admins
+// to triage. It can be narrowed by error_code and a from/to date range.
+func (s *Server) getCallErrors(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	query := r.URL.Query()
+	since, err := parseTimeFilter(query.Get("from"), false)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid from date")
+		return
+	}
+	until, err := parseTimeFilter(query.Get("to"), true)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid to date")
+		return
+	}
+
+	companyID := sql.NullInt64{Int64: user.CompanyID, Valid: true}
+	errorCode := nullString(query.Get("error_code"))
+	page := parsePagination(r)
+
+	total, err := s.queries.CountCallErrors(r.Context(), db.CountCallErrorsParams{
+		CompanyID: companyID,
+		ErrorCode: errorCode,
+		Since:     since,
+		Until:     until,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call errors")
+		return
+	}
+	page.Total = total
+
+	callErrors, err := s.queries.ListCallErrors(r.Context(), db.ListCallErrorsParams{
+		CompanyID: companyID,
+		ErrorCode: errorCode,
+		Since:     since,
+		Until:     until,
+		Limit:     page.PageSize,
+		Offset:    page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call errors")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallErrorsResponse{
+		Success:    true,
+		Errors:     callErrors,
+		Pagination: page,
+	})
+}
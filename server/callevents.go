@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// callStatusRank orders Twilio call statuses so late or repeated events never
+// move a call backwards. Anything that ends a call shares the last rank.
+var callStatusRank = map[string]int{
+	"queued":      1,
+	"initiated":   1,
+	"ringing":     2,
+	"in-progress": 3,
+	"completed":   4,
+	"busy":        4,
+	"no-answer":   4,
+	"failed":      4,
+	"canceled":    4,
+}
+
+// numberStatusCallback is the <Number> attribute set that reports a dialed
+// customer leg's progress to handleCallEvent. Only the "answered" event marks
+// the leg in-progress, so a call that rang out is never counted as answered.
+func numberStatusCallback(agentID string) string {
+	return fmt.Sprintf(` statusCallbackEvent="initiated ringing answered completed" statusCallback="/twilio/call-events?agent_id=%s"`, url.QueryEscape(agentID))
+}
+
+// handleCallEvent is the status callback for dialed customer legs. It keeps
+// a calls row per leg, under the agent's call it was dialed from, and only
+// ever moves it forward through initiated, ringing, in-progress and a final
+// status.
+func (s *Server) handleCallEvent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	status := r.FormValue("CallStatus")
+	if callSID == "" || callStatusRank[status] == 0 {
+		logInfof(r.Context(), "Ignoring call event: CallSid=%s, CallStatus=%s", callSID, status)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// A leg is the dialing agent's company's call
+	agentID := r.URL.Query().Get("agent_id")
+	var companyID int64
+	if agent, err := s.queries.GetUserByAgentID(r.Context(), agentID); err == nil {
+		companyID = agent.CompanyID
+	}
+	callID, err := s.resolveCallID(r.Context(), callSID, companyID)
+	if err != nil {
+		logErrorf(r.Context(), "Error recording call id: %v", err)
+	}
+
+	// The row starts out initiated whatever event arrives first, so the
+	// event below is always applied as a transition
+	at := callEventTime(r.FormValue("Timestamp"))
+	if err := s.queries.CreateCallLeg(r.Context(), db.CreateCallLegParams{
+		CallSid:       callSID,
+		CallRefID:     nullString(callID),
+		ParentCallSid: nullString(r.FormValue("ParentCallSid")),
+		Direction:     "outbound",
+		FromNumber:    nullString(r.FormValue("From")),
+		ToNumber:      nullString(r.FormValue("To")),
+		AgentID:       nullString(agentID),
+		CompanyID:     sql.NullInt64{Int64: companyID, Valid: companyID != 0},
+		Status:        "initiated",
+		StartedAt:     at,
+	}); err != nil {
+		logErrorf(r.Context(), "Error recording call leg %s: %v", callSID, err)
+	}
+
+	call, err := s.queries.GetCallBySid(r.Context(), callSID)
+	if err != nil {
+		logErrorf(r.Context(), "Error getting call leg %s: %v", callSID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if callStatusRank[status] > callStatusRank[call.Status] {
+		params := db.UpdateCallStatusParams{
+			Status:        status,
+			CallSid:       callSID,
+			CurrentStatus: call.Status,
+		}
+		if callStatusRank[status] == callStatusRank["completed"] {
+			params.EndedAt = sql.NullTime{Time: at, Valid: true}
+			if seconds, err := strconv.ParseInt(r.FormValue("CallDuration"), 10, 64); err == nil {
+				params.DurationSeconds = sql.NullInt64{Int64: seconds, Valid: true}
+			}
+		}
+		if _, err := s.queries.UpdateCallStatus(r.Context(), params); err != nil {
+			logErrorf(r.Context(), "Error updating call leg %s: %v", callSID, err)
+		}
+		if status == "in-progress" {
+			s.markCallAnswered(r.Context(), callSID, at)
+		}
+		logInfof(r.Context(), "📶 Call %s: %s -> %s", callSID, call.Status, status)
+		s.trackAgentCall(r.Context(), agentID, status)
+
+		// The agent's call goes the way of the customer's leg
+		if call.ParentCallSid.Valid {
+			s.advanceCall(r.Context(), call.ParentCallSid.String, status, r.FormValue("CallDuration"), at)
+			if status == "in-progress" {
+				s.markCallAnswered(r.Context(), call.ParentCallSid.String, at)
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callEventTime reads Twilio's RFC 1123 event timestamp, falling back to now.
+func callEventTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC1123Z, value); err == nil {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
+
+func nullString(value string) sql.NullString {
+	value = strings.TrimSpace(value)
+	return sql.NullString{String: value, Valid: value != ""}
+}
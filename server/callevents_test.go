@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallEventSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []string // CallStatus of each event, in the order Twilio sent them
+		answered int      // the event from which the call counts as answered, -1 for never
+		status   string
+	}{
+		{"answered", []string{"initiated", "ringing", "in-progress", "completed"}, 2, "completed"},
+		{"rang out", []string{"initiated", "ringing", "no-answer"}, -1, "no-answer"},
+		{"busy", []string{"initiated", "busy"}, -1, "busy"},
+		{"late ringing after the answer", []string{"initiated", "in-progress", "ringing"}, 1, "in-progress"},
+		{"ending arrives first", []string{"completed", "in-progress", "ringing"}, -1, "completed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+
+			// The agent's browser call, which dials the customer
+			w := httptest.NewRecorder()
+			s.handleOutboundVoice(w, twilioWebhook("/twilio/voice", url.Values{
+				"CallSid": {"CA-agent"}, "From": {"client:acme1"}, "To": {"+27825550001"},
+			}))
+			if !strings.Contains(w.Body.String(), `statusCallbackEvent="initiated ringing answered completed"`) {
+				t.Fatalf("the customer leg doesn't report its events: %s", w.Body)
+			}
+
+			for i, status := range tt.events {
+				s.handleCallEvent(httptest.NewRecorder(), twilioWebhook("/twilio/call-events?agent_id=acme1", url.Values{
+					"CallSid": {"CA-customer"}, "ParentCallSid": {"CA-agent"}, "CallStatus": {status},
+					"From": {"+27110000001"}, "To": {"+27825550001"}, "CallDuration": {"30"},
+				}))
+
+				// Both legs count as answered from the customer picking up,
+				// and not before
+				want := tt.answered >= 0 && i >= tt.answered
+				for _, sid := range []string{"CA-customer", "CA-agent"} {
+					call, err := s.queries.GetCallBySid(t.Context(), sid)
+					if err != nil {
+						t.Fatalf("%s: %v", sid, err)
+					}
+					if call.AnsweredAt.Valid != want {
+						t.Errorf("after %s, %s answered = %v, want %v", status, sid, call.AnsweredAt.Valid, want)
+					}
+				}
+			}
+
+			leg, err := s.queries.GetCallBySid(t.Context(), "CA-customer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leg.Status != tt.status || leg.ParentCallSid.String != "CA-agent" || leg.CompanyID.Int64 != acme {
+				t.Errorf("customer leg %s under %q of company %d, want %s under CA-agent of %d",
+					leg.Status, leg.ParentCallSid.String, leg.CompanyID.Int64, tt.status, acme)
+			}
+			if call, _ := s.queries.GetCallBySid(t.Context(), "CA-agent"); call.Status != tt.status {
+				t.Errorf("agent call %s, want %s like the customer leg", call.Status, tt.status)
+			}
+		})
+	}
+}
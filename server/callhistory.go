@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CallsResponse struct {
+	Success    bool       `json:"success"`
+	Calls      []db.Call  `json:"calls"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// recordCall starts the history row for a call as its webhook arrives. callID
+// is the one the webhook already resolved, and companyID is the dialed
+// number's company for incoming calls, the agent's for outgoing ones.
+// Webhooks that run again for the same call, such as an incoming caller
+// returning from recording their name, only fill in what the first run
+// didn't have.
+func (s *Server) recordCall(ctx context.Context, r *http.Request, callID, direction, from, to, agentID string, companyID int64) {
+	callSID := r.FormValue("CallSid")
+	if callSID == "" {
+		return
+	}
+
+	status := r.FormValue("CallStatus")
+	if status == "" {
+		status = "initiated"
+	}
+
+	if err := s.queries.CreateCall(ctx, db.CreateCallParams{
+		CallSid:    callSID,
+		CallRefID:  nullString(callID),
+		Direction:  direction,
+		FromNumber: nullString(from),
+		ToNumber:   nullString(to),
+		AgentID:    nullString(agentID),
+		CompanyID:  sql.NullInt64{Int64: companyID, Valid: companyID != 0},
+		Status:     status,
+		StartedAt:  time.Now().UTC(),
+	}); err != nil {
+		logErrorf(r.Context(), "Error recording call %s: %v", callSID, err)
+	}
+}
+
+// clientStatusCallback is the <Client> attribute set that reports an agent
+// leg answering and ending to handleStatusCallback.
+const clientStatusCallback = ` statusCallbackEvent="answered completed" statusCallback="/twilio/status-callback"`
+
+// advanceCall moves a call's history row on to status, the way callStatusRank
+// allows: repeated and late reports are ignored, and a final status records
+// when the call ended and how many seconds it was connected. It reports
+// whether there is a row for the call.
+func (s *Server) advanceCall(ctx context.Context, callSID, status, duration string, at time.Time) bool {
+	call, err := s.queries.GetCallBySid(ctx, callSID)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		logErrorf(ctx, "Error getting call %s: %v", callSID, err)
+		return true
+	}
+	if callStatusRank[status] <= callStatusRank[call.Status] {
+		return true
+	}
+
+	params := db.UpdateCallStatusParams{
+		Status:        status,
+		CallSid:       callSID,
+		CurrentStatus: call.Status,
+	}
+	if callStatusRank[status] == callStatusRank["completed"] {
+		params.EndedAt = sql.NullTime{Time: at, Valid: true}
+		if seconds, err := strconv.ParseInt(duration, 10, 64); err == nil {
+			params.DurationSeconds = sql.NullInt64{Int64: seconds, Valid: true}
+		}
+	}
+	rows, err := s.queries.UpdateCallStatus(ctx, params)
+	if err != nil {
+		logErrorf(ctx, "Error updating call %s: %v", callSID, err)
+	}
+	// The update is guarded by the status read above, so only the report
+	// that actually ends the call counts it
+	if rows > 0 && callStatusRank[status] == callStatusRank["completed"] {
+		callsTotal.WithLabelValues(call.Direction, status).Inc()
+	}
+	if rows > 0 && call.CompanyID.Valid {
+		s.notify(realtimeEvent{
+			Type:      "call_status",
+			CompanyID: call.CompanyID.Int64,
+			AgentID:   call.AgentID.String,
+			Data: map[string]any{
+				"call_sid":  callSID,
+				"direction": call.Direction,
+				"status":    status,
+			},
+		})
+	}
+	return true
+}
+
+// markCallAnswered records that someone picked up the call, which only
+// its legs can tell: the agent's for incoming calls, the customer's for
+// outgoing ones.
+func (s *Server) markCallAnswered(ctx context.Context, callSID string, at time.Time) {
+	if err := s.queries.MarkCallAnswered(ctx, db.MarkCallAnsweredParams{
+		AnsweredAt: sql.NullTime{Time: at, Valid: true},
+		CallSid:    callSID,
+	}); err != nil {
+		logErrorf(ctx, "Error marking call %s answered: %v", callSID, err)
+	}
+}
+
+// handleStatusCallback keeps the call history current from Twilio status
+// callbacks. It takes the call's own callbacks, when the number or TwiML app
+// is set up to send them here, and those of the agent legs dialed for
+// incoming calls. An agent leg that rang out doesn't end the call, which
+// goes on to the queue, so only its answer and hang-up are passed on.
+func (s *Server) handleStatusCallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	status := r.FormValue("CallStatus")
+	at := callEventTime(r.FormValue("Timestamp"))
+
+	known := callStatusRank[status] > 0 && s.advanceCall(r.Context(), callSID, status, r.FormValue("CallDuration"), at)
+	if parent := r.FormValue("ParentCallSid"); !known && parent != "" && (status == "in-progress" || status == "completed") {
+		known = s.advanceCall(r.Context(), parent, status, r.FormValue("CallDuration"), at)
+		if status == "in-progress" {
+			s.markCallAnswered(r.Context(), parent, at)
+		}
+	}
+	if !known {
+		logInfof(r.Context(), "Ignoring status callback: CallSid=%s, CallStatus=%s", callSID, status)
+	}
+
+	// The browser end is the agent: To on the legs dialed to them, From on
+	// the calls they place
+	for _, party := range []string{r.FormValue("To"), r.FormValue("From")} {
+		if agentID, ok := strings.CutPrefix(party, "client:"); ok {
+			s.trackAgentCall(r.Context(), agentID, status)
+			break
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getCalls lists the company's calls, newest first, optionally for one agent
+// and between from and to.
+func (s *Server) getCalls(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	query := r.URL.Query()
+	since, err := parseTimeFilter(query.Get("from"), false)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid from date")
+		return
+	}
+	until, err := parseTimeFilter(query.Get("to"), true)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid to date")
+		return
+	}
+
+	companyID := sql.NullInt64{Int64: user.CompanyID, Valid: true}
+	agentID := sql.NullString{String: query.Get("agent_id"), Valid: query.Get("agent_id") != ""}
+	page := parsePagination(r)
+
+	total, err := s.queries.CountCallsByCompany(r.Context(), db.CountCallsByCompanyParams{
+		CompanyID: companyID,
+		AgentID:   agentID,
+		Since:     since,
+		Until:     until,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get calls")
+		return
+	}
+	page.Total = total
+
+	calls, err := s.queries.ListCallsByCompany(r.Context(), db.ListCallsByCompanyParams{
+		CompanyID: companyID,
+		AgentID:   agentID,
+		Since:     since,
+		Until:     until,
+		Limit:     page.PageSize,
+		Offset:    page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get calls")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallsResponse{
+		Success:    true,
+		Calls:      calls,
+		Pagination: page,
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// twilioWebhook builds a form-encoded webhook request as Twilio sends them.
+func twilioWebhook(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestIncomingCallsKeepTheirCompany(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	initech := addCompany(t, s, "Initech")
+	hooli := addCompany(t, s, "Hooli")
+	addAgent(t, s, acme, "acme1", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	addNumber(t, s, globex, "+27110000002") // nobody to answer
+	addNumber(t, s, initech, "+27110000003")
+	addNumber(t, s, hooli, "+27110000004")
+	exec(t, s, "UPDATE phone_numbers SET routing_type = ?, announcement = 'Closed for stocktaking' WHERE phone = '+27110000003'", routingAnnouncement)
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, 'true')", hooli, settingCollectCallerName)
+	exec(t, s, "INSERT INTO blocked_numbers (company_id, phone, reason) VALUES (?, '+27825550004', 'spam')", acme)
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		company int64 // 0 for no record
+		agent   string
+	}{
+		{"put through to an agent", "+27825550001", "+27110000001", acme, "acme1"},
+		{"left for voicemail", "+27825550002", "+27110000002", globex, ""},
+		{"announcement", "+27825550003", "+27110000003", initech, ""},
+		{"blocked", "+27825550004", "+27110000001", acme, ""},
+		{"asked for their name", "+27825550006", "+27110000004", hooli, ""},
+		{"number nobody owns", "+27825550005", "+27110000009", 0, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callSID := "CA-in-" + string(rune('a'+i))
+			w := httptest.NewRecorder()
+			s.handleIncomingCall(w, twilioWebhook("/twilio/voice", url.Values{
+				"CallSid": {callSID}, "From": {tt.from}, "To": {tt.to}, "CallStatus": {"ringing"},
+			}))
+
+			call, err := s.queries.GetCallBySid(t.Context(), callSID)
+			if tt.company == 0 {
+				if err != sql.ErrNoRows {
+					t.Errorf("recorded a call to a number nobody owns: %+v, %v", call, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.CompanyID.Int64 != tt.company || call.AgentID.String != tt.agent {
+				t.Errorf("recorded for %q of company %d, want %q of company %d", call.AgentID.String, call.CompanyID.Int64, tt.agent, tt.company)
+			}
+		})
+	}
+
+	// The company's history has every call made to it
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	w := httptest.NewRecorder()
+	s.getCalls(w, asUser(httptest.NewRequest(http.MethodGet, "/api/calls", nil), admin))
+	var resp CallsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Pagination.Total != 2 || len(resp.Calls) != 2 {
+		t.Errorf("Acme lists %d of %d calls, want 2", len(resp.Calls), resp.Pagination.Total)
+	}
+}
+
+func TestHandleCallEventKeepsLegInCalls(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	agent := addAgent(t, s, acme, "acme1", roleAgent)
+	exec(t, s, `INSERT INTO calls (call_sid, direction, agent_id, company_id, status, started_at)
+		VALUES ('CA-parent', 'outbound', 'acme1', ?, 'initiated', CURRENT_TIMESTAMP)`, acme)
+
+	for _, event := range []struct{ status, duration string }{
+		{"initiated", ""},
+		{"ringing", ""},
+		{"in-progress", ""},
+		{"ringing", ""}, // late, and ignored
+		{"completed", "42"},
+	} {
+		w := httptest.NewRecorder()
+		s.handleCallEvent(w, twilioWebhook("/twilio/call-events?agent_id=acme1", url.Values{
+			"CallSid": {"CA-leg"}, "ParentCallSid": {"CA-parent"}, "CallStatus": {event.status},
+			"CallDuration": {event.duration}, "From": {"+27110000001"}, "To": {"+27825550001"},
+		}))
+	}
+
+	tests := []struct {
+		callSID string
+		parent  string
+	}{
+		{"CA-leg", "CA-parent"},
+		{"CA-parent", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.callSID, func(t *testing.T) {
+			call, err := s.queries.GetCallBySid(t.Context(), tt.callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.ParentCallSid.String != tt.parent || call.CompanyID.Int64 != acme {
+				t.Errorf("parent = %q, company = %d, want %q, %d", call.ParentCallSid.String, call.CompanyID.Int64, tt.parent, acme)
+			}
+			if call.Status != "completed" || !call.AnsweredAt.Valid || call.DurationSeconds.Int64 != 42 {
+				t.Errorf("status = %s, answered = %v, duration = %d, want completed, answered, 42", call.Status, call.AnsweredAt.Valid, call.DurationSeconds.Int64)
+			}
+		})
+	}
+
+	// Lists count the agent's call once, not its leg as well
+	w := httptest.NewRecorder()
+	s.getCalls(w, asUser(httptest.NewRequest(http.MethodGet, "/api/calls", nil), agent))
+	var resp CallsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Pagination.Total != 1 || len(resp.Calls) != 1 || resp.Calls[0].CallSid != "CA-parent" {
+		t.Errorf("listed %+v, want only CA-parent", resp.Calls)
+	}
+}
+
+func TestMergeCallLogs(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	addAgent(t, s, globex, "globex1", roleAgent)
+	exec(t, s, `INSERT INTO calls (call_sid, direction, agent_id, company_id, status, started_at)
+		VALUES ('CA-parent', 'outbound', 'globex1', ?, 'completed', CURRENT_TIMESTAMP)`, acme)
+	createCallLogs(t, s)
+	exec(t, s, `INSERT INTO call_logs (call_sid, parent_call_sid, agent_id, direction, status, answered_at, answered_by, amd_outcome)
+		VALUES ('CA-leg', 'CA-parent', 'globex1', 'outbound', 'completed', '2024-01-02 03:04:05', 'machine_end_beep', 'dropped')`)
+	exec(t, s, `INSERT INTO call_logs (call_sid, agent_id, direction, status) VALUES ('CA-orphan', 'globex1', 'outbound', 'no-answer')`)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeCallLogs(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		callSID    string
+		company    int64
+		parent     string
+		answeredBy string
+	}{
+		{"CA-leg", acme, "CA-parent", "machine_end_beep"}, // the parent call's company
+		{"CA-orphan", globex, "", ""},                     // the agent's
+	}
+	for _, tt := range tests {
+		t.Run(tt.callSID, func(t *testing.T) {
+			call, err := s.queries.GetCallBySid(t.Context(), tt.callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.CompanyID.Int64 != tt.company || call.ParentCallSid.String != tt.parent || call.AnsweredBy.String != tt.answeredBy {
+				t.Errorf("company = %d, parent = %q, answered by %q, want %d, %q, %q",
+					call.CompanyID.Int64, call.ParentCallSid.String, call.AnsweredBy.String, tt.company, tt.parent, tt.answeredBy)
+			}
+		})
+	}
+
+	var tables int
+	s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'call_logs'").Scan(&tables)
+	if tables != 0 {
+		t.Error("call_logs is still there")
+	}
+}
+
+func TestWebhooksResolveCallIDOnce(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	addAgent(t, s, acme, "acme1", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	// Every upsert of a call ref is either an insert or an update
+	exec(t, s, "CREATE TABLE call_ref_writes (call_sid TEXT)")
+	exec(t, s, "CREATE TRIGGER count_call_ref_inserts AFTER INSERT ON call_refs BEGIN INSERT INTO call_ref_writes VALUES (NEW.call_sid); END")
+	exec(t, s, "CREATE TRIGGER count_call_ref_updates AFTER UPDATE ON call_refs BEGIN INSERT INTO call_ref_writes VALUES (NEW.call_sid); END")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		form    url.Values
+		company int64 // 0 when no call is recorded
+	}{
+		{"incoming", s.handleIncomingCall, url.Values{"From": {"+27825550001"}, "To": {"+27110000001"}}, acme},
+		{"incoming to a number nobody owns", s.handleIncomingCall, url.Values{"From": {"+27825550001"}, "To": {"+27110000009"}}, 0},
+		{"outbound", s.handleOutboundVoice, url.Values{"From": {"client:acme1"}, "To": {"+27825550001"}}, acme},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callSID := "CA-once-" + string(rune('a'+i))
+			tt.form.Set("CallSid", callSID)
+			tt.form.Set("CallStatus", "ringing")
+			tt.handler(httptest.NewRecorder(), twilioWebhook("/twilio/voice", tt.form))
+
+			var writes int
+			s.db.QueryRow("SELECT COUNT(*) FROM call_ref_writes WHERE call_sid = ?", callSID).Scan(&writes)
+			if writes != 1 {
+				t.Errorf("call ref written %d times, want once", writes)
+			}
+
+			var callID string
+			var company sql.NullInt64
+			if err := s.db.QueryRow("SELECT id, company_id FROM call_refs WHERE call_sid = ?", callSID).Scan(&callID, &company); err != nil {
+				t.Fatal(err)
+			}
+			if company.Int64 != tt.company {
+				t.Errorf("call ref company = %d, want %d", company.Int64, tt.company)
+			}
+			if tt.company == 0 {
+				return
+			}
+			call, err := s.queries.GetCallBySid(t.Context(), callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.CallRefID.String != callID {
+				t.Errorf("call recorded under call id %q, want %q", call.CallRefID.String, callID)
+			}
+		})
+	}
+}
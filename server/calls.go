@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"omnicall/db"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CallRefResponse struct {
+	Success bool        `json:"success"`
+	Call    *db.CallRef `json:"call,omitempty"`
+}
+
+// getCallRef looks one of the company's calls up by either our internal id or
+// the Twilio CallSid.
+func (s *Server) getCallRef(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	id := r.URL.Query().Get("id")
+	callSID := r.URL.Query().Get("call_sid")
+
+	var ref db.CallRef
+	var err error
+	switch {
+	case id != "":
+		ref, err = s.queries.GetCompanyCallRef(r.Context(), db.GetCompanyCallRefParams{
+			ID:        id,
+			CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+		})
+	case callSID != "":
+		ref, err = s.queries.GetCompanyCallRefBySid(r.Context(), db.GetCompanyCallRefBySidParams{
+			CallSid:   sql.NullString{String: callSID, Valid: true},
+			CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+		})
+	default:
+		respondError(w, http.StatusBadRequest, "id or call_sid is required")
+		return
+	}
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallRefResponse{
+		Success: true,
+		Call:    &ref,
+	})
+}
+
+// CallBundleMetadata is written as metadata.json inside a call bundle.
+type CallBundleMetadata struct {
+	CallID         string                 `json:"call_id,omitempty"`
+	CallSid        string                 `json:"call_sid"`
+	Call           db.Call                `json:"call"`
+	GeneratedAt    time.Time              `json:"generated_at"`
+	GeneratedBy    string                 `json:"generated_by"`
+	Transcriptions []db.CallTranscription `json:"transcriptions"`
+}
+
+// getCallBundle streams a zip with everything we hold about one of the
+// company's calls: its history row, the recording and any transcripts.
+// Artifacts that don't exist for the call are simply left out of the
+// archive, and so is a recording Twilio won't hand over.
+func (s *Server) getCallBundle(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	callSID := chi.URLParam(r, "callSid")
+	call, err := s.queries.GetCompanyCallBySid(r.Context(), db.GetCompanyCallBySidParams{
+		CallSid:   callSID,
+		CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call")
+		return
+	}
+
+	transcriptions, err := s.queries.ListCallTranscriptionsBySidAndCompany(r.Context(), db.ListCallTranscriptionsBySidAndCompanyParams{
+		CallSid:   sql.NullString{String: callSID, Valid: true},
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call")
+		return
+	}
+
+	metadata := CallBundleMetadata{
+		CallID:         call.CallRefID.String,
+		CallSid:        callSID,
+		Call:           call,
+		GeneratedAt:    time.Now().UTC(),
+		GeneratedBy:    user.AgentID,
+		Transcriptions: transcriptions,
+	}
+
+	logInfof(r.Context(), "📦 Call bundle for %s downloaded by %s (company %d)", callSID, user.AgentID, user.CompanyID)
+	s.audit(r.Context(), user, auditCallBundleDownload, callSID, "")
+
+	// A long recording can outlast the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="call-%s.zip"`, callSID))
+
+	zw := zip.NewWriter(w)
+
+	if f, err := zw.Create("metadata.json"); err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		enc.Encode(metadata)
+	}
+
+	if call.RecordingUrl.Valid && s.twilio != nil {
+		if resp, err := s.twilio.Get(call.RecordingUrl.String+".mp3", nil, nil); err != nil {
+			logErrorf(r.Context(), "Error fetching recording %s for call bundle %s: %v", call.RecordingSid.String, callSID, err)
+		} else {
+			// Audio is already compressed
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: "recording.mp3", Method: zip.Store})
+			if err == nil {
+				_, err = io.Copy(f, resp.Body)
+			}
+			if err != nil {
+				logErrorf(r.Context(), "Error adding recording to call bundle %s: %v", callSID, err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	if len(transcriptions) > 0 {
+		var transcript strings.Builder
+		for _, t := range transcriptions {
+			fmt.Fprintf(&transcript, "# %s\n\n%s\n\n", t.CreatedAt.Time.Format(time.RFC3339), t.Transcript)
+			if t.Summary.Valid {
+				fmt.Fprintf(&transcript, "Summary: %s\n\n", t.Summary.String)
+			}
+		}
+		if f, err := zw.Create("transcript.txt"); err == nil {
+			f.Write([]byte(transcript.String()))
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		logErrorf(r.Context(), "Error writing call bundle for %s: %v", callSID, err)
+	}
+}
+
+// resolveCallID returns the internal id for a call, creating one on first
+// sight of the CallSid. Calls without a CallSid (internal or simulated) always
+// get a fresh id. companyID is 0 while the webhook doesn't know whose call it
+// is; the first one that does settles it.
+func (s *Server) resolveCallID(ctx context.Context, callSID string, companyID int64) (string, error) {
+	ref, err := s.queries.UpsertCallRef(ctx, db.UpsertCallRefParams{
+		ID:        newCallID(),
+		CallSid:   sql.NullString{String: callSID, Valid: callSID != ""},
+		CompanyID: sql.NullInt64{Int64: companyID, Valid: companyID != 0},
+	})
+	if err != nil {
+		return "", err
+	}
+	return ref.ID, nil
+}
+
+// newCallID generates a random (version 4) UUID.
+func newCallID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/twilio/twilio-go"
+)
+
+func TestGetCallRef(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	agent := addAgent(t, s, acme, "acme1", roleAgent)
+
+	own, err := s.resolveCallID(t.Context(), "CA-own", acme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := s.resolveCallID(t.Context(), "CA-other", globex)
+	unsettled, _ := s.resolveCallID(t.Context(), "CA-unsettled", 0)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		id     string
+	}{
+		{"by id", "id=" + own, http.StatusOK, own},
+		{"by call sid", "call_sid=CA-own", http.StatusOK, own},
+		{"another company's id", "id=" + other, http.StatusNotFound, ""},
+		{"another company's call sid", "call_sid=CA-other", http.StatusNotFound, ""},
+		{"call nobody owns yet", "id=" + unsettled, http.StatusNotFound, ""},
+		{"unknown call sid", "call_sid=CA-missing", http.StatusNotFound, ""},
+		{"neither", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/calls/lookup?"+tt.query, nil), agent)
+			w := httptest.NewRecorder()
+			s.getCallRef(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.id == "" {
+				return
+			}
+			var resp CallRefResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Call.ID != tt.id {
+				t.Errorf("id = %q, want %q", resp.Call.ID, tt.id)
+			}
+		})
+	}
+}
+
+func TestResolveCallID(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+
+	first, _ := s.resolveCallID(t.Context(), "CA1", 0)
+	tests := []struct {
+		name      string
+		callSID   string
+		companyID int64
+		sameID    bool
+		company   int64
+	}{
+		{"company learned later", "CA1", acme, true, acme},
+		{"first company sticks", "CA1", globex, true, acme},
+		{"no company leaves it alone", "CA1", 0, true, acme},
+		{"no call sid", "", acme, false, acme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := s.resolveCallID(t.Context(), tt.callSID, tt.companyID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (id == first) != tt.sameID {
+				t.Errorf("id = %q, first was %q, want same = %v", id, first, tt.sameID)
+			}
+			ref, err := s.queries.GetCallRef(t.Context(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.CompanyID.Int64 != tt.company {
+				t.Errorf("company = %d, want %d", ref.CompanyID.Int64, tt.company)
+			}
+		})
+	}
+}
+
+// createCallLogs puts back the call_logs table customer legs were kept in
+// before they moved into calls.
+func createCallLogs(t *testing.T, s *Server) {
+	t.Helper()
+	exec(t, s, `CREATE TABLE call_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		call_sid TEXT NOT NULL UNIQUE,
+		parent_call_sid TEXT,
+		agent_id TEXT,
+		direction TEXT NOT NULL,
+		from_number TEXT,
+		to_number TEXT,
+		status TEXT NOT NULL,
+		answered_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		answered_by TEXT,
+		amd_outcome TEXT,
+		call_ref_id TEXT
+	)`)
+}
+
+func TestAddCallRefIDsBackfill(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	createCallLogs(t, s)
+	exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, status, started_at) VALUES ('CA-old', 'inbound', ?, 'completed', CURRENT_TIMESTAMP)`, acme)
+	exec(t, s, `INSERT INTO call_logs (call_sid, direction, status) VALUES ('CA-old', 'outbound', 'completed')`)
+	exec(t, s, `INSERT INTO call_logs (call_sid, direction, status) VALUES ('CA-leg', 'outbound', 'completed')`)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addCallRefIDs(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table   string
+		callSID string
+		company int64
+	}{
+		{"calls", "CA-old", acme},
+		{"call_logs", "CA-old", acme},
+		{"call_logs", "CA-leg", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table+" "+tt.callSID, func(t *testing.T) {
+			var refID string
+			if err := s.db.QueryRow("SELECT call_ref_id FROM "+tt.table+" WHERE call_sid = ?", tt.callSID).Scan(&refID); err != nil {
+				t.Fatal(err)
+			}
+			ref, err := s.queries.GetCallRef(t.Context(), refID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.CallSid.String != tt.callSID || ref.CompanyID.Int64 != tt.company {
+				t.Errorf("ref = %s for company %d, want %s for company %d", ref.CallSid.String, ref.CompanyID.Int64, tt.callSID, tt.company)
+			}
+		})
+	}
+}
+
+func TestGetCallBundle(t *testing.T) {
+	recordings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Recordings/RE1.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer recordings.Close()
+	// The Twilio client rewrites dotted hosts for regions and edges
+	recordingsURL := strings.Replace(recordings.URL, "127.0.0.1", "localhost", 1)
+
+	s := newTestServer(t)
+	s.twilio = twilio.NewRestClientWithParams(twilio.ClientParams{Username: "AC", Password: "secret"})
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	supervisor := addAgent(t, s, acme, "super1", roleSupervisor)
+
+	addCall := func(callSID string, companyID int64, recordingURL any) {
+		exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, status, started_at, recording_sid, recording_url)
+			VALUES (?, 'inbound', ?, 'completed', CURRENT_TIMESTAMP, 'RE1', ?)`, callSID, companyID, recordingURL)
+	}
+	addCall("CA-full", acme, recordingsURL+"/Recordings/RE1")
+	addCall("CA-bare", acme, nil)
+	addCall("CA-lost", acme, recordingsURL+"/Recordings/RE-missing")
+	addCall("CA-other", globex, nil)
+	customer, _ := exec(t, s, "INSERT INTO customers (company_id, first_name, last_name) VALUES (?, 'Pat', 'Doe')", acme).LastInsertId()
+	exec(t, s, "INSERT INTO call_transcriptions (customer_id, agent_id, call_sid, transcript) VALUES (?, ?, 'CA-full', 'Hello there')", customer, supervisor.ID)
+
+	tests := []struct {
+		name    string
+		callSID string
+		status  int
+		files   []string
+	}{
+		{"recording and transcript", "CA-full", http.StatusOK, []string{"metadata.json", "recording.mp3", "transcript.txt"}},
+		{"metadata only", "CA-bare", http.StatusOK, []string{"metadata.json"}},
+		{"recording Twilio can't find", "CA-lost", http.StatusOK, []string{"metadata.json"}},
+		{"another company's call", "CA-other", http.StatusNotFound, nil},
+		{"unknown call", "CA-missing", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/calls/"+tt.callSID+"/bundle", nil), supervisor)
+			r = withURLParams(r, map[string]string{"callSid": tt.callSID})
+			w := httptest.NewRecorder()
+			s.getCallBundle(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			contents := map[string]string{}
+			for _, f := range archive.File {
+				files = append(files, f.Name)
+				rc, _ := f.Open()
+				b, _ := io.ReadAll(rc)
+				rc.Close()
+				contents[f.Name] = string(b)
+			}
+			if !slices.Equal(files, tt.files) {
+				t.Errorf("files = %v, want %v", files, tt.files)
+			}
+			if audio, ok := contents["recording.mp3"]; ok && audio != "ID3 audio" {
+				t.Errorf("recording = %q", audio)
+			}
+			var metadata CallBundleMetadata
+			if err := json.Unmarshal([]byte(contents["metadata.json"]), &metadata); err != nil {
+				t.Fatal(err)
+			}
+			if metadata.Call.CallSid != tt.callSID {
+				t.Errorf("metadata call = %q, want %q", metadata.Call.CallSid, tt.callSID)
+			}
+		})
+	}
+}
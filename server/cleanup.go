@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"path"
+	"strconv"
+	"strings"
+
+	twilioClient "github.com/twilio/twilio-go/client"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// recordingDeleter is the slice of the Twilio API that cleanup needs, so a
+// fake can stand in for it.
+type recordingDeleter interface {
+	DeleteRecording(sid string, params *openapi.DeleteRecordingParams) error
+}
+
+type VoicemailCleanupRequest struct {
+	Before     string `json:"before"`
+	CustomerID int64  `json:"customer_id"`
+	FromNumber string `json:"from_number"`
+}
+
+type VoicemailsResponse struct {
+	Success    bool           `json:"success"`
+	Voicemails []db.Voicemail `json:"voicemails"`
+	Count      int            `json:"count"`
+}
+
+type VoicemailCleanupResponse struct {
+	Success bool  `json:"success"`
+	Deleted int   `json:"deleted"`
+	Failed  []int `json:"failed"`
+}
+
+// listVoicemailsForCleanup shows what a bulk delete with the same filters
+// would remove.
+func (s *Server) listVoicemailsForCleanup(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	customerID, _ := strconv.ParseInt(r.URL.Query().Get("customer_id"), 10, 64)
+	params, err := voicemailCleanupParams(user.CompanyID, VoicemailCleanupRequest{
+		Before:     r.URL.Query().Get("before"),
+		CustomerID: customerID,
+		FromNumber: r.URL.Query().Get("from_number"),
+	})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	voicemails, err := s.queries.ListVoicemailsForCleanup(r.Context(), params)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemails")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailsResponse{
+		Success:    true,
+		Voicemails: voicemails,
+		Count:      len(voicemails),
+	})
+}
+
+// bulkDeleteVoicemails deletes matching voicemail recordings from Twilio and
+// marks them deleted here. A filter is required so one request can't wipe a
+// company's voicemail by accident.
+func (s *Server) bulkDeleteVoicemails(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req VoicemailCleanupRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	params, err := voicemailCleanupParams(user.CompanyID, req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !params.Before.Valid && !params.CustomerID.Valid && !params.FromNumber.Valid {
+		respondError(w, http.StatusBadRequest, "At least one of before, customer_id or from_number is required")
+		return
+	}
+
+	voicemails, err := s.queries.ListVoicemailsForCleanup(r.Context(), params)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemails")
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	deleted, failed := s.deleteVoicemails(r.Context(), s.twilio.Api, voicemails)
+
+	details := fmt.Sprintf("deleted=%d failed=%d before=%s customer_id=%d from_number=%s", deleted, len(failed), req.Before, req.CustomerID, req.FromNumber)
+	logInfof(r.Context(), "🧹 Voicemail cleanup by %s (company %d): %s", user.AgentID, user.CompanyID, details)
+	s.audit(r.Context(), user, auditVoicemailCleanup, "", details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailCleanupResponse{
+		Success: len(failed) == 0,
+		Deleted: deleted,
+		Failed:  failed,
+	})
+}
+
+// deleteVoicemails removes each recording from Twilio, then marks the
+// voicemail deleted. A voicemail is only marked once Twilio no longer has
+// its recording, so a failed run can simply be repeated.
+func (s *Server) deleteVoicemails(ctx context.Context, api recordingDeleter, voicemails []db.Voicemail) (deleted int, failed []int) {
+	failed = []int{}
+	for _, vm := range voicemails {
+		if sid := recordingSID(vm.RecordingUrl); sid != "" {
+			err := api.DeleteRecording(sid, nil)
+			var restErr *twilioClient.TwilioRestError
+			if err != nil && !(errors.As(err, &restErr) && restErr.Status == http.StatusNotFound) {
+				logErrorf(ctx, "Error deleting recording %s for voicemail %d: %v", sid, vm.ID, err)
+				failed = append(failed, int(vm.ID))
+				continue
+			}
+		}
+
+		if err := s.queries.MarkVoicemailDeleted(ctx, db.MarkVoicemailDeletedParams{
+			ID:        vm.ID,
+			CompanyID: vm.CompanyID,
+		}); err != nil {
+			logErrorf(ctx, "Error marking voicemail %d deleted: %v", vm.ID, err)
+			failed = append(failed, int(vm.ID))
+			continue
+		}
+		deleted++
+	}
+	return deleted, failed
+}
+
+func voicemailCleanupParams(companyID int64, req VoicemailCleanupRequest) (db.ListVoicemailsForCleanupParams, error) {
+	before, err := parseTimeFilter(req.Before, false)
+	if err != nil {
+		return db.ListVoicemailsForCleanupParams{}, errors.New("Invalid before date")
+	}
+	return db.ListVoicemailsForCleanupParams{
+		CompanyID:  companyID,
+		Before:     before,
+		CustomerID: sql.NullInt64{Int64: req.CustomerID, Valid: req.CustomerID != 0},
+		FromNumber: sql.NullString{String: req.FromNumber, Valid: req.FromNumber != ""},
+	}, nil
+}
+
+// recordingSID pulls the recording SID out of a Twilio recording URL, or
+// returns "" for URLs that don't point at a Twilio recording.
+func recordingSID(recordingURL string) string {
+	sid := strings.TrimSuffix(path.Base(recordingURL), path.Ext(recordingURL))
+	if !strings.HasPrefix(sid, "RE") || !strings.Contains(recordingURL, "/Recordings/") {
+		return ""
+	}
+	return sid
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	twilioClient "github.com/twilio/twilio-go/client"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// fakeRecordingDeleter deletes recordings, except those it's told to fail.
+type fakeRecordingDeleter struct {
+	errs    map[string]error
+	deleted []string
+}
+
+func (f *fakeRecordingDeleter) DeleteRecording(sid string, params *openapi.DeleteRecordingParams) error {
+	if err := f.errs[sid]; err != nil {
+		return err
+	}
+	f.deleted = append(f.deleted, sid)
+	return nil
+}
+
+// addVoicemail stores a voicemail left at the given time and returns its id.
+func addVoicemail(t *testing.T, s *Server, companyID int64, customerID any, from, recordingURL, at string) int64 {
+	t.Helper()
+	id, err := exec(t, s, "INSERT INTO voicemails (company_id, customer_id, from_number, recording_url, created_at) VALUES (?, ?, ?, ?, ?)",
+		companyID, customerID, from, recordingURL, at).LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// deletedVoicemails lists the ids of the company's voicemails marked deleted.
+func deletedVoicemails(t *testing.T, s *Server, companyID int64) []int64 {
+	t.Helper()
+	rows, err := s.db.Query("SELECT id FROM voicemails WHERE company_id = ? AND deleted_at IS NOT NULL ORDER BY id", companyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestListVoicemailsForCleanup(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	customer, _ := exec(t, s, "INSERT INTO customers (company_id, first_name, last_name) VALUES (?, 'Pat', 'Doe')", acme).LastInsertId()
+
+	old := addVoicemail(t, s, acme, customer, "+27825550001", "https://api.twilio.com/Recordings/RE1", "2025-01-10 12:00:00")
+	oldOther := addVoicemail(t, s, acme, nil, "+27825550002", "https://api.twilio.com/Recordings/RE2", "2025-01-20 12:00:00")
+	recent := addVoicemail(t, s, acme, customer, "+27825550001", "https://api.twilio.com/Recordings/RE3", "2025-03-01 12:00:00")
+	gone := addVoicemail(t, s, acme, customer, "+27825550001", "https://api.twilio.com/Recordings/RE4", "2025-01-05 12:00:00")
+	exec(t, s, "UPDATE voicemails SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", gone)
+	addVoicemail(t, s, globex, nil, "+27825550001", "https://api.twilio.com/Recordings/RE5", "2025-01-01 12:00:00")
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int64
+	}{
+		{"everything live", "", []int64{old, oldOther, recent}},
+		{"before a date", "?before=2025-02-01", []int64{old, oldOther}},
+		{"by customer", "?customer_id=" + strconv.FormatInt(customer, 10), []int64{old, recent}},
+		{"by number", "?from_number=%2B27825550002", []int64{oldOther}},
+		{"filters combine", "?before=2025-02-01&from_number=%2B27825550001", []int64{old}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.listVoicemailsForCleanup(w, asUser(httptest.NewRequest(http.MethodGet, "/api/admin/voicemails"+tt.query, nil), admin))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var resp VoicemailsResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			var ids []int64
+			for _, vm := range resp.Voicemails {
+				ids = append(ids, vm.ID)
+			}
+			if !slices.Equal(ids, tt.want) || resp.Count != len(tt.want) {
+				t.Errorf("listed %v (count %d), want %v", ids, resp.Count, tt.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.listVoicemailsForCleanup(w, asUser(httptest.NewRequest(http.MethodGet, "/api/admin/voicemails?before=soon", nil), admin))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad date: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteVoicemails(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	deleted := addVoicemail(t, s, acme, nil, "+27825550001", "https://api.twilio.com/Recordings/RE1.mp3", "2025-01-01 12:00:00")
+	alreadyGone := addVoicemail(t, s, acme, nil, "+27825550001", "https://api.twilio.com/Recordings/RE2", "2025-01-01 12:00:00")
+	failing := addVoicemail(t, s, acme, nil, "+27825550001", "https://api.twilio.com/Recordings/RE3", "2025-01-01 12:00:00")
+	elsewhere := addVoicemail(t, s, acme, nil, "+27825550001", "https://example.com/voicemail.mp3", "2025-01-01 12:00:00")
+
+	params, _ := voicemailCleanupParams(acme, VoicemailCleanupRequest{})
+	voicemails, err := s.queries.ListVoicemailsForCleanup(t.Context(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeRecordingDeleter{errs: map[string]error{
+		"RE2": &twilioClient.TwilioRestError{Status: http.StatusNotFound},
+		"RE3": errors.New("twilio unavailable"),
+	}}
+	count, failed := s.deleteVoicemails(t.Context(), fake, voicemails)
+
+	// Recordings Twilio no longer has count as deleted; ones it couldn't
+	// delete stay for the next run, and recordings kept elsewhere aren't
+	// sent to Twilio at all
+	if count != 3 || !slices.Equal(failed, []int{int(failing)}) {
+		t.Errorf("deleted %d, failed %v; want 3 and [%d]", count, failed, failing)
+	}
+	if !slices.Equal(fake.deleted, []string{"RE1"}) {
+		t.Errorf("deleted recordings %v, want [RE1]", fake.deleted)
+	}
+	if got, want := deletedVoicemails(t, s, acme), []int64{deleted, alreadyGone, elsewhere}; !slices.Equal(got, want) {
+		t.Errorf("voicemails marked deleted = %v, want %v", got, want)
+	}
+}
+
+func TestBulkDeleteVoicemails(t *testing.T) {
+	s := newTestServer(t)
+	fake := withFakeTwilio(s)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	old := addVoicemail(t, s, acme, nil, "+27825550001", "https://api.twilio.com/Recordings/RE1", "2025-01-10 12:00:00")
+	addVoicemail(t, s, acme, nil, "+27825550001", "https://api.twilio.com/Recordings/RE2", "2025-03-01 12:00:00")
+	addVoicemail(t, s, globex, nil, "+27825550001", "https://api.twilio.com/Recordings/RE3", "2025-01-01 12:00:00")
+
+	bulkDelete := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.bulkDeleteVoicemails(w, asUser(httptest.NewRequest(http.MethodPost, "/api/admin/voicemails/bulk-delete", strings.NewReader(body)), admin))
+		return w
+	}
+
+	if w := bulkDelete(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("no filter: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(fake.requests) != 0 || len(deletedVoicemails(t, s, acme)) != 0 {
+		t.Fatal("deleted voicemails without a filter")
+	}
+
+	w := bulkDelete(`{"before":"2025-02-01"}`)
+	var resp VoicemailCleanupResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !resp.Success || resp.Deleted != 1 || len(resp.Failed) != 0 {
+		t.Fatalf("status = %d, response %+v; want one deleted", w.Code, resp)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("asked Twilio %d times, want 1", len(fake.requests))
+	}
+	if got := deletedVoicemails(t, s, acme); !slices.Equal(got, []int64{old}) {
+		t.Errorf("acme voicemails marked deleted = %v, want [%d]", got, old)
+	}
+	if got := deletedVoicemails(t, s, globex); len(got) != 0 {
+		t.Errorf("globex voicemails marked deleted = %v, want none", got)
+	}
+
+	var action, details string
+	if err := s.db.QueryRow("SELECT action, details FROM audit_log WHERE company_id = ? AND actor = ?", acme, admin.AgentID).Scan(&action, &details); err != nil {
+		t.Fatalf("audit entry: %v", err)
+	}
+	if action != auditVoicemailCleanup || !strings.Contains(details, "deleted=1 failed=0 before=2025-02-01") {
+		t.Errorf("audit entry %s %q, want %s with the count and filter", action, details, auditVoicemailCleanup)
+	}
+}
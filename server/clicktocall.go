@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"time"
+
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// DialRequest names who to call: one of the company's customers, or a
+// number. CustomerID wins when both are given.
+type DialRequest struct {
+	CustomerID int64  `json:"customer_id"`
+	To         string `json:"to"`
+}
+
+type DialResponse struct {
+	Success bool   `json:"success"`
+	CallSid string `json:"call_sid"`
+	CallID  string `json:"call_id,omitempty"`
+	Status  string `json:"status"`
+	To      string `json:"to"`
+	From    string `json:"from"`
+}
+
+// dialCall places a call for the signed-in agent through the REST API
+// rather than their browser. Twilio rings the agent's client first and,
+// once they answer, handleClickToCall bridges them to the customer. It
+// works for agents without the JS SDK loaded and for calls the server
+// starts itself.
+func (s *Server) dialCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req DialRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	field, number := "to", req.To
+	if req.CustomerID != 0 {
+		customer, err := s.queries.GetCompanyCustomer(r.Context(), db.GetCompanyCustomerParams{
+			ID:        req.CustomerID,
+			CompanyID: user.CompanyID,
+		})
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Customer not found")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to get customer")
+			return
+		}
+		if !customer.Phone.Valid {
+			respondValidationError(w, errCodeInvalidNumber, "Customer has no phone number", map[string]string{"customer_id": "No phone number on file"})
+			return
+		}
+		field, number = "customer_id", customer.Phone.String
+	}
+	if number == "" {
+		respondValidationError(w, errCodeMissingFields, "customer_id or to is required", map[string]string{"to": "This field is required"})
+		return
+	}
+
+	// The same checks the browser's calls go through
+	to, ok := toE164(number, defaultPhoneRegion())
+	if !ok {
+		respondValidationError(w, errCodeInvalidNumber, "Not a valid phone number", map[string]string{field: "Not a valid phone number"})
+		return
+	}
+	if !s.dialAllowed(r.Context(), user.CompanyID, to) {
+		respondValidationError(w, errCodeInvalidNumber, "Calls to that number are not allowed", map[string]string{field: "Outside the company's dial prefixes"})
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+	from, _ := s.agentCallerID(r.Context(), user)
+	if from == "" {
+		respondError(w, http.StatusInternalServerError, "No phone number to call from")
+		return
+	}
+
+	call, err := s.placeAgentCall(r.Context(), publicBaseURL(r), user, from, to)
+	if err != nil {
+		logErrorf(r.Context(), "Error dialing %s for %s: %v", to, user.AgentID, err)
+		respondError(w, http.StatusBadGateway, "Failed to place call")
+		return
+	}
+
+	logInfof(r.Context(), "📞 Click-to-call: %s dialing %s as %s, CallSID=%s", user.AgentID, to, from, call.CallSid)
+	s.audit(r.Context(), user, auditCallDial, call.CallSid, to)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(call)
+}
+
+// placeAgentCall has Twilio ring agent's client and, once they answer,
+// bridge them to to with from as caller ID. baseURL is where Twilio reaches
+// this server. The call is recorded before Twilio's first callback so it
+// shows up straight away.
+func (s *Server) placeAgentCall(ctx context.Context, baseURL string, agent *db.User, from, to string) (DialResponse, error) {
+	if s.twilio == nil {
+		return DialResponse{}, errTwilioNotConfigured
+	}
+
+	query := url.Values{}
+	query.Set("agent_id", agent.AgentID)
+	query.Set("to", to)
+	query.Set("from", from)
+	params := &openapi.CreateCallParams{}
+	params.SetTo("client:" + agent.AgentID)
+	params.SetFrom(from)
+	params.SetUrl(baseURL + "/twilio/click-to-call?" + query.Encode())
+	params.SetMethod(http.MethodGet)
+	params.SetFallbackUrl(baseURL + "/twilio/fallback")
+	params.SetStatusCallback(baseURL + "/twilio/status-callback")
+	params.SetStatusCallbackEvent([]string{"initiated", "ringing", "answered", "completed"})
+
+	call, err := s.twilio.Api.CreateCall(params)
+	if err != nil {
+		return DialResponse{}, err
+	}
+	if call.Sid == nil {
+		return DialResponse{}, errors.New("twilio returned no call SID")
+	}
+	status := "queued"
+	if call.Status != nil {
+		status = *call.Status
+	}
+
+	callID, err := s.resolveCallID(ctx, *call.Sid, agent.CompanyID)
+	if err != nil {
+		logErrorf(ctx, "Error recording call id: %v", err)
+	}
+	if err := s.queries.CreateCall(ctx, db.CreateCallParams{
+		CallSid:    *call.Sid,
+		CallRefID:  nullString(callID),
+		Direction:  "outbound",
+		FromNumber: nullString(from),
+		ToNumber:   nullString(to),
+		AgentID:    nullString(agent.AgentID),
+		CompanyID:  sql.NullInt64{Int64: agent.CompanyID, Valid: true},
+		Status:     status,
+		StartedAt:  time.Now().UTC(),
+	}); err != nil {
+		logErrorf(ctx, "Error recording call %s: %v", *call.Sid, err)
+	}
+
+	return DialResponse{
+		Success: true,
+		CallSid: *call.Sid,
+		CallID:  callID,
+		Status:  status,
+		To:      to,
+		From:    from,
+	}, nil
+}
+
+// handleClickToCall answers the agent's leg of a call dialCall placed and
+// bridges it to the customer. The number was checked before the call was
+// placed, and the signature covers the query it travels in.
+func (s *Server) handleClickToCall(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("agent_id")
+	to := r.URL.Query().Get("to")
+	from := r.URL.Query().Get("from")
+
+	var companyID int64
+	if agent, err := s.queries.GetUserByAgentID(r.Context(), agentID); err == nil {
+		companyID = agent.CompanyID
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(s.outboundDialTwiML(r.Context(), companyID, agentID, from, to, true)))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// Conference participant roles and statuses
+const (
+	conferenceRoleCaller = "caller"
+	conferenceRoleAgent  = "agent"
+
+	participantInvited = "invited"
+	participantJoined  = "joined"
+	participantLeft    = "left"
+)
+
+type ConferenceRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+type ConferenceResponse struct {
+	Success      bool                       `json:"success"`
+	Conference   string                     `json:"conference"`
+	Participants []db.ConferenceParticipant `json:"participants"`
+}
+
+// conferenceCall starts a warm transfer. The customer on the agent's call
+// moves into a conference of their own, and both the agent and the
+// colleague they want to consult are rung into it. Agents can leave without
+// ending it, so the first agent drops off once the handover is done and the
+// customer stays with the second. callSid is the agent's (browser) leg.
+func (s *Server) conferenceCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req ConferenceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	target, ok := s.availableAgent(w, r, user, req.AgentID)
+	if !ok {
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+	from := s.companyCallerID(r.Context(), user.CompanyID)
+	if from == "" {
+		respondError(w, http.StatusInternalServerError, "No phone number to call agents from")
+		return
+	}
+
+	customer, ok := s.liveCustomerLeg(w, r, user, chi.URLParam(r, "callSid"), "Failed to start conference")
+	if !ok {
+		return
+	}
+
+	// Moving the customer ends the bridged call, which takes the first
+	// agent's leg with it; they come back in on a new one below
+	name := "conf-" + *customer.Sid
+	update := &openapi.UpdateCallParams{}
+	update.SetUrl(conferenceJoinURL(r, name, conferenceRoleCaller))
+	update.SetMethod(http.MethodGet)
+	if _, err := s.twilio.Api.UpdateCall(*customer.Sid, update); err != nil {
+		logErrorf(r.Context(), "Error moving %s into conference: %v", *customer.Sid, err)
+		respondError(w, http.StatusBadGateway, "Failed to start conference")
+		return
+	}
+
+	resp := ConferenceResponse{Success: true, Conference: name, Participants: []db.ConferenceParticipant{}}
+	if participant, ok := s.addConferenceParticipant(r, user.CompanyID, name, *customer.Sid, "", conferenceRoleCaller); ok {
+		resp.Participants = append(resp.Participants, participant)
+	}
+
+	failed := false
+	for _, agentID := range []string{user.AgentID, target.AgentID} {
+		params := &openapi.CreateCallParams{}
+		params.SetTo("client:" + agentID)
+		params.SetFrom(from)
+		params.SetUrl(conferenceJoinURL(r, name, conferenceRoleAgent))
+		params.SetMethod(http.MethodGet)
+		call, err := s.twilio.Api.CreateCall(params)
+		if err != nil || call.Sid == nil {
+			logErrorf(r.Context(), "Error ringing %s into conference %s: %v", agentID, name, err)
+			failed = true
+			continue
+		}
+		if participant, ok := s.addConferenceParticipant(r, user.CompanyID, name, *call.Sid, agentID, conferenceRoleAgent); ok {
+			resp.Participants = append(resp.Participants, participant)
+		}
+	}
+
+	logInfof(r.Context(), "👥 Call %s moved into conference %s by %s with %s", *customer.Sid, name, user.AgentID, target.AgentID)
+	s.audit(r.Context(), user, auditCallConference, *customer.Sid, target.AgentID)
+	s.notify(realtimeEvent{
+		Type:      "call_conference",
+		CompanyID: user.CompanyID,
+		AgentID:   target.AgentID,
+		Data:      resp,
+	})
+
+	// The customer is safely on hold either way, so a colleague who couldn't
+	// be rung is reported without undoing the move
+	if failed {
+		respondError(w, http.StatusBadGateway, "Conference started but an agent could not be rung")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) addConferenceParticipant(r *http.Request, companyID int64, name, callSID, agentID, role string) (db.ConferenceParticipant, bool) {
+	participant, err := s.queries.CreateConferenceParticipant(r.Context(), db.CreateConferenceParticipantParams{
+		CompanyID:      companyID,
+		ConferenceName: name,
+		CallSid:        callSID,
+		AgentID:        nullString(agentID),
+		Role:           role,
+		Status:         participantInvited,
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error recording %s in conference %s: %v", callSID, name, err)
+		return participant, false
+	}
+	return participant, true
+}
+
+// conferenceJoinURL is where Twilio fetches the TwiML that puts a call into
+// the named conference.
+func conferenceJoinURL(r *http.Request, name, role string) string {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("role", role)
+	return publicBaseURL(r) + "/twilio/conference/join?" + query.Encode()
+}
+
+// handleConferenceJoin puts a call into a conference. The customer waits on
+// hold music until an agent arrives, and the conference ends when they hang
+// up. Agents start it but can leave without ending it, which is what lets
+// the first agent drop off and leave the customer with the second.
+func (s *Server) handleConferenceJoin(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	intro := ""
+	attrs := `startConferenceOnEnter="true" endConferenceOnExit="false"`
+	if r.URL.Query().Get("role") == conferenceRoleCaller {
+		intro = "\n\t<Say>Please hold while we bring in a colleague.</Say>"
+		attrs = `startConferenceOnEnter="false" endConferenceOnExit="true" waitUrl="http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3"`
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>%s
+	<Dial>
+		<Conference beep="false" %s statusCallback="/twilio/conference/events" statusCallbackEvent="join leave end">%s</Conference>
+	</Dial>
+</Response>`, intro, attrs, html.EscapeString(name))))
+}
+
+// handleConferenceEvent keeps conference_participants in step with who is
+// actually in each conference.
+func (s *Server) handleConferenceEvent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	name := r.FormValue("FriendlyName")
+	callSID := r.FormValue("CallSid")
+
+	var err error
+	switch event := r.FormValue("StatusCallbackEvent"); event {
+	case "participant-join":
+		_, err = s.queries.JoinConferenceParticipant(r.Context(), db.JoinConferenceParticipantParams{
+			ConferenceSid:  nullString(r.FormValue("ConferenceSid")),
+			ConferenceName: name,
+			CallSid:        callSID,
+		})
+		logInfof(r.Context(), "👥 %s joined conference %s", callSID, name)
+	case "participant-leave":
+		_, err = s.queries.LeaveConferenceParticipant(r.Context(), db.LeaveConferenceParticipantParams{
+			ConferenceName: name,
+			CallSid:        callSID,
+		})
+		logInfof(r.Context(), "👥 %s left conference %s", callSID, name)
+	case "conference-end":
+		_, err = s.queries.EndConference(r.Context(), name)
+		logInfof(r.Context(), "👥 Conference %s ended", name)
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error updating conference %s: %v", name, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
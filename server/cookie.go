@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSessionCookieName = "session_id"
+
+// sessionCookie describes how the session cookie is named and scoped, and
+// how long the session behind it lasts. The __Host- prefix makes browsers
+// insist on Secure, Path=/ and no Domain, and __Secure- on Secure, so those
+// are forced whenever the name asks for them. SameSite is Lax unless a
+// deployment serving the app over https from another site opts into None,
+// which browsers only accept on a Secure cookie.
+//
+// A session lives for lifetime after it is issued, and a request made within
+// refreshWindow of the end extends it by another lifetime, so agents in
+// regular use stay signed in.
+type sessionCookie struct {
+	name          string
+	domain        string
+	secure        bool
+	sameSite      http.SameSite
+	lifetime      time.Duration
+	refreshWindow time.Duration
+}
+
+// sessionCookieFromEnv reads SESSION_COOKIE_NAME, SESSION_COOKIE_DOMAIN,
+// COOKIE_SECURE, SESSION_COOKIE_SAMESITE (lax, strict or none), SESSION_LIFETIME
+// (default 168h) and SESSION_REFRESH_WINDOW (default 24h).
+//
+// COOKIE_SECURE marks the cookie Secure and makes SameSite default to none,
+// for an app served over https from another site. Without it the cookie
+// defaults to lax, which suits local development over plain http.
+// SESSION_COOKIE_SECURE is still read when COOKIE_SECURE isn't set.
+func sessionCookieFromEnv() (sessionCookie, error) {
+	c := sessionCookie{
+		name:          os.Getenv("SESSION_COOKIE_NAME"),
+		domain:        os.Getenv("SESSION_COOKIE_DOMAIN"),
+		sameSite:      http.SameSiteLaxMode,
+		lifetime:      7 * 24 * time.Hour,
+		refreshWindow: 24 * time.Hour,
+	}
+	if c.name == "" {
+		c.name = defaultSessionCookieName
+	}
+	if c.name != strings.Map(cookieNameChar, c.name) {
+		return c, errors.New("cookie name may only contain letters, digits and !#$%&'*+-.^_`|~")
+	}
+
+	secure := os.Getenv("COOKIE_SECURE")
+	if secure == "" {
+		secure = os.Getenv("SESSION_COOKIE_SECURE")
+	}
+	c.secure, _ = strconv.ParseBool(secure)
+	if c.secure {
+		c.sameSite = http.SameSiteNoneMode
+	}
+	if strings.HasPrefix(c.name, "__Host-") || strings.HasPrefix(c.name, "__Secure-") {
+		c.secure = true
+	}
+	if c.domain != "" && strings.HasPrefix(c.name, "__Host-") {
+		return c, errors.New("SESSION_COOKIE_DOMAIN can't be used with a __Host- cookie name")
+	}
+
+	switch strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")) {
+	case "":
+	case "lax":
+		c.sameSite = http.SameSiteLaxMode
+	case "strict":
+		c.sameSite = http.SameSiteStrictMode
+	case "none":
+		if !c.secure {
+			return c, errors.New("SESSION_COOKIE_SAMESITE=none needs COOKIE_SECURE=true")
+		}
+		c.sameSite = http.SameSiteNoneMode
+	default:
+		return c, errors.New("SESSION_COOKIE_SAMESITE must be lax, strict or none")
+	}
+
+	if value := os.Getenv("SESSION_LIFETIME"); value != "" {
+		lifetime, err := time.ParseDuration(value)
+		if err != nil || lifetime < time.Minute {
+			return c, errors.New("SESSION_LIFETIME must be a duration of at least 1m")
+		}
+		c.lifetime = lifetime
+	}
+	if value := os.Getenv("SESSION_REFRESH_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			return c, errors.New("SESSION_REFRESH_WINDOW must be a duration such as 24h")
+		}
+		c.refreshWindow = window
+	}
+	if c.refreshWindow >= c.lifetime {
+		return c, errors.New("SESSION_REFRESH_WINDOW must be shorter than SESSION_LIFETIME")
+	}
+	return c, nil
+}
+
+// cookieNameChar drops characters that aren't allowed in a cookie name.
+func cookieNameChar(r rune) rune {
+	if r > ' ' && r < 0x7f && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+		return r
+	}
+	return -1
+}
+
+// set issues the cookie for a new or extended session. Path is always "/",
+// which is what __Host- requires.
+func (c sessionCookie) set(w http.ResponseWriter, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name,
+		Value:    sessionID,
+		Path:     "/",
+		Domain:   c.domain,
+		MaxAge:   int(c.lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: c.sameSite,
+	})
+}
+
+// clear removes the cookie. Browsers only drop a cookie whose attributes
+// match the one they hold, so it mirrors set.
+func (c sessionCookie) clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name,
+		Value:    "",
+		Path:     "/",
+		Domain:   c.domain,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: c.sameSite,
+	})
+}
+
+// read returns the session id sent with the request.
+func (c sessionCookie) read(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(c.name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSessionCookieFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		secure   bool
+		sameSite http.SameSite
+		wantErr  bool
+	}{
+		{"local development", nil, false, http.SameSiteLaxMode, false},
+		{"secure", map[string]string{"COOKIE_SECURE": "true"}, true, http.SameSiteNoneMode, false},
+		{"old name still works", map[string]string{"SESSION_COOKIE_SECURE": "true"}, true, http.SameSiteNoneMode, false},
+		{"new name wins", map[string]string{"COOKIE_SECURE": "false", "SESSION_COOKIE_SECURE": "true"}, false, http.SameSiteLaxMode, false},
+		{"secure but lax", map[string]string{"COOKIE_SECURE": "true", "SESSION_COOKIE_SAMESITE": "lax"}, true, http.SameSiteLaxMode, false},
+		{"secure and strict", map[string]string{"COOKIE_SECURE": "1", "SESSION_COOKIE_SAMESITE": "strict"}, true, http.SameSiteStrictMode, false},
+		{"none without secure", map[string]string{"SESSION_COOKIE_SAMESITE": "none"}, false, 0, true},
+		{"__Host- name forces secure", map[string]string{"SESSION_COOKIE_NAME": "__Host-session"}, true, http.SameSiteLaxMode, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"COOKIE_SECURE", "SESSION_COOKIE_SECURE", "SESSION_COOKIE_SAMESITE", "SESSION_COOKIE_NAME"} {
+				t.Setenv(key, tt.env[key])
+			}
+			c, err := sessionCookieFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.secure != tt.secure || c.sameSite != tt.sameSite {
+				t.Errorf("secure = %v, sameSite = %v, want %v, %v", c.secure, c.sameSite, tt.secure, tt.sameSite)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Origins every company may call the API from, unless CORS_ALLOWED_ORIGINS
+// says otherwise
+var defaultCORSOrigins = []string{"http://localhost:8000", "http://localhost:3001", "http://localhost:5173"}
+
+// corsOriginsFromEnv reads CORS_ALLOWED_ORIGINS, a comma-separated list of
+// scheme://host[:port] origins. Responses allow credentials, which browsers
+// refuse alongside a wildcard, so the list has to name origins outright.
+func corsOriginsFromEnv() ([]string, error) {
+	value, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS")
+	if !ok {
+		return defaultCORSOrigins, nil
+	}
+	origins := splitList(value)
+	if len(origins) == 0 {
+		return nil, errors.New("CORS_ALLOWED_ORIGINS is set but lists no origins")
+	}
+	if slices.Contains(origins, "*") {
+		return nil, errors.New("CORS_ALLOWED_ORIGINS can't be * because credentials are allowed")
+	}
+	if err := validateAllowedOrigins(value); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// companyIDHeader names the company a request without a session is made on
+// behalf of, so an embedded widget's origin can be checked against that
+// company's list before anyone is signed in.
+const companyIDHeader = "X-Company-ID"
+
+// allowOrigin decides whether a cross-origin request may proceed. Besides the
+// configured list, companies can allow the origins they embed the dialer on.
+// Those origins only count for the company the request is for: the signed-in
+// user's, or else the one named in X-Company-ID. A preflight can't carry
+// header values, so one is let through when any company allows the origin;
+// it runs no handler, and the request it clears is checked again.
+func (s *Server) allowOrigin(r *http.Request, origin string) bool {
+	if slices.Contains(s.corsOrigins, origin) {
+		return true
+	}
+
+	companyID, ok := s.originCompany(r)
+	if !ok {
+		return false
+	}
+	if companyID != 0 {
+		allowed := s.companySetting(r.Context(), companyID, settingAllowedOrigins, "")
+		return slices.Contains(splitList(allowed), origin)
+	}
+	if !isPreflight(r) {
+		return false
+	}
+
+	settings, err := s.queries.ListCompanySettingsByKey(r.Context(), settingAllowedOrigins)
+	if err != nil {
+		return false
+	}
+	for _, setting := range settings {
+		if slices.Contains(splitList(setting.Value), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// originCompany works out which company a request is for, or 0 when it
+// doesn't say. A session and a header naming different companies don't add
+// up, so that's not ok.
+func (s *Server) originCompany(r *http.Request) (companyID int64, ok bool) {
+	if value := r.Header.Get(companyIDHeader); value != "" {
+		var err error
+		if companyID, err = strconv.ParseInt(value, 10, 64); err != nil || companyID <= 0 {
+			return 0, false
+		}
+	}
+	if user, err := s.sessionUser(r); err == nil {
+		if companyID != 0 && companyID != user.CompanyID {
+			return 0, false
+		}
+		return user.CompanyID, true
+	}
+	return companyID, true
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// rejectDisallowedOrigins answers cross-origin requests from origins that
+// aren't allowed with 403. The CORS handler only leaves the allow headers off,
+// which stops the browser reading the response but still runs the handler.
+// Requests from the API's own origin and ones without an Origin header, such
+// as Twilio's webhooks, are passed straight through.
+func (s *Server) rejectDisallowedOrigins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && !sameOrigin(r, origin) && !s.allowOrigin(r, origin) {
+			logWarnf(r.Context(), "🚫 Rejected request from origin %s", origin)
+			respondError(w, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// splitList splits a comma-separated list, dropping blanks.
+func splitList(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func validateAllowedOrigins(value string) error {
+	for _, origin := range splitList(value) {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return errors.New("Allowed origins must be comma-separated scheme://host[:port] values")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestRejectDisallowedOrigins(t *testing.T) {
+	s := newTestServer(t)
+	s.corsOrigins = []string{"https://app.example.com"}
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingAllowedOrigins, "https://acme.example.com")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", globex, settingAllowedOrigins, "https://globex.example.com")
+	globexAgent := addAgent(t, s, globex, "globex1", roleAgent)
+	globexSession := signIn(t, s, globexAgent)
+
+	acmeID := strconv.FormatInt(acme, 10)
+	globexID := strconv.FormatInt(globex, 10)
+	tests := []struct {
+		name      string
+		method    string
+		origin    string
+		companyID string
+		session   bool
+		allowed   bool
+	}{
+		{"no origin", http.MethodPost, "", "", false, true},
+		{"same origin", http.MethodPost, "http://example.com", "", false, true},
+		{"configured origin", http.MethodPost, "https://app.example.com", "", false, true},
+		{"widget origin for its company", http.MethodPost, "https://acme.example.com", acmeID, false, true},
+		{"widget origin for another company", http.MethodPost, "https://acme.example.com", globexID, false, false},
+		{"widget origin naming no company", http.MethodPost, "https://acme.example.com", "", false, false},
+		{"widget origin with a bad company", http.MethodPost, "https://acme.example.com", "acme", false, false},
+		{"widget origin with its own session", http.MethodGet, "https://globex.example.com", "", true, true},
+		{"widget origin with another company's session", http.MethodGet, "https://acme.example.com", "", true, false},
+		{"session and header disagree", http.MethodGet, "https://acme.example.com", acmeID, true, false},
+		{"preflight from a widget origin", http.MethodOptions, "https://acme.example.com", "", false, true},
+		{"preflight from an unknown origin", http.MethodOptions, "https://evil.example.com", "", false, false},
+		{"unknown origin", http.MethodPost, "https://evil.example.com", acmeID, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/auth/login", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			if tt.companyID != "" {
+				r.Header.Set(companyIDHeader, tt.companyID)
+			}
+			if tt.session {
+				r.AddCookie(globexSession)
+			}
+
+			ran := false
+			w := httptest.NewRecorder()
+			s.rejectDisallowedOrigins(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { ran = true })).ServeHTTP(w, r)
+			if ran != tt.allowed {
+				t.Errorf("handler ran = %v, want %v", ran, tt.allowed)
+			}
+			if !tt.allowed && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestCorsOriginsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"list", "https://app.example.com,http://localhost:3000", []string{"https://app.example.com", "http://localhost:3000"}, false},
+		{"spaces and stray commas", " https://app.example.com ,, http://localhost:3000 ,", []string{"https://app.example.com", "http://localhost:3000"}, false},
+		{"empty", "", nil, true},
+		{"only commas", " , ", nil, true},
+		{"wildcard", "*", nil, true},
+		{"wildcard among origins", "https://app.example.com, *", nil, true},
+		{"no scheme", "app.example.com", nil, true},
+		{"with a path", "https://app.example.com/dialer", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ALLOWED_ORIGINS", tt.value)
+			got, err := corsOriginsFromEnv()
+			if !slices.Equal(got, tt.want) || (err != nil) != tt.wantErr {
+				t.Errorf("corsOriginsFromEnv = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	// The localhost defaults only apply when nothing is configured
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	if got, err := corsOriginsFromEnv(); err != nil || !slices.Equal(got, defaultCORSOrigins) {
+		t.Errorf("unset: corsOriginsFromEnv = %v, %v; want %v", got, err, defaultCORSOrigins)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"time"
+)
+
+// CustomerExport is one customer as exported. PhoneE164 is the stored phone
+// in E.164, empty when it can't be read that way.
+type CustomerExport struct {
+	ID                 int64  `json:"id"`
+	FirstName          string `json:"first_name"`
+	LastName           string `json:"last_name"`
+	Email              string `json:"email"`
+	Phone              string `json:"phone"`
+	PhoneE164          string `json:"phone_e164"`
+	MedicalAidProvider string `json:"medical_aid_provider"`
+	MedicalAidNumber   string `json:"medical_aid_number"`
+	MedicalPlan        string `json:"medical_plan"`
+	IsVip              bool   `json:"is_vip"`
+	Notes              string `json:"notes"`
+	CreatedAt          string `json:"created_at"`
+	UpdatedAt          string `json:"updated_at"`
+}
+
+var customerExportColumns = []string{
+	"id", "first_name", "last_name", "email", "phone", "phone_e164",
+	"medical_aid_provider", "medical_aid_number", "medical_plan", "is_vip",
+	"notes", "created_at", "updated_at",
+}
+
+func newCustomerExport(c db.Customer) CustomerExport {
+	e164, _ := toE164(c.Phone.String, defaultPhoneRegion())
+	export := CustomerExport{
+		ID:                 c.ID,
+		FirstName:          c.FirstName,
+		LastName:           c.LastName,
+		Email:              c.Email.String,
+		Phone:              c.Phone.String,
+		PhoneE164:          e164,
+		MedicalAidProvider: c.MedicalAidProvider.String,
+		MedicalAidNumber:   c.MedicalAidNumber.String,
+		MedicalPlan:        c.MedicalPlan.String,
+		IsVip:              c.IsVip,
+		Notes:              c.Notes.String,
+	}
+	if c.CreatedAt.Valid {
+		export.CreatedAt = c.CreatedAt.Time.UTC().Format(time.RFC3339)
+	}
+	if c.UpdatedAt.Valid {
+		export.UpdatedAt = c.UpdatedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return export
+}
+
+func (e CustomerExport) record() []string {
+	return []string{
+		strconv.FormatInt(e.ID, 10), e.FirstName, e.LastName, e.Email, e.Phone, e.PhoneE164,
+		e.MedicalAidProvider, e.MedicalAidNumber, e.MedicalPlan, strconv.FormatBool(e.IsVip),
+		e.Notes, e.CreatedAt, e.UpdatedAt,
+	}
+}
+
+// customerExportWriter writes an export in one format. begin is only called
+// once the query is known to have worked, so a failure up to then can still
+// be answered with an error.
+type customerExportWriter interface {
+	begin() error
+	write(CustomerExport) error
+	end() error
+}
+
+type csvCustomerExport struct {
+	w *csv.Writer
+}
+
+func (c *csvCustomerExport) begin() error {
+	return c.w.Write(customerExportColumns)
+}
+
+func (c *csvCustomerExport) write(e CustomerExport) error {
+	return c.w.Write(e.record())
+}
+
+func (c *csvCustomerExport) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonCustomerExport writes the same body as the other customer lists,
+// minus pagination, one customer at a time.
+type jsonCustomerExport struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonCustomerExport) begin() error {
+	_, err := io.WriteString(j.w, `{"success":true,"customers":[`)
+	return err
+}
+
+func (j *jsonCustomerExport) write(e CustomerExport) error {
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(body)
+	return err
+}
+
+func (j *jsonCustomerExport) end() error {
+	_, err := io.WriteString(j.w, "]}\n")
+	return err
+}
+
+// exportCustomers downloads every one of the company's customers as CSV, or
+// JSON with ?format=json. Rows are written as they are read rather than
+// collected first.
+func (s *Server) exportCustomers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var out customerExportWriter
+	contentType := ""
+	switch format {
+	case "csv":
+		out = &csvCustomerExport{w: csv.NewWriter(w)}
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		out = &jsonCustomerExport{w: w}
+		contentType = "application/json"
+	default:
+		respondError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	// A large export can outlast the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("customers-%s.%s", time.Now().UTC().Format("2006-01-02"), format)
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		return out.begin()
+	}
+
+	rows := 0
+	err := s.queries.EachCustomerByCompany(r.Context(), user.CompanyID, func(c db.Customer) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		rows++
+		return out.write(newCustomerExport(c))
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = out.end()
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error exporting customers for company %d: %v", user.CompanyID, err)
+		// Once rows have gone out the download can only be cut short
+		if !started {
+			respondError(w, http.StatusInternalServerError, "Failed to export customers")
+		}
+		return
+	}
+
+	logInfof(r.Context(), "📤 %d customers exported as %s by %s", rows, format, user.AgentID)
+	s.audit(r.Context(), user, auditCustomerExport, format, strconv.Itoa(rows))
+}
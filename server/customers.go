@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CustomerCreate is the body for creating a customer. Optional fields left
+// empty are stored as null.
+type CustomerCreate struct {
+	FirstName          string `json:"first_name"`
+	LastName           string `json:"last_name"`
+	Email              string `json:"email"`
+	Phone              string `json:"phone"`
+	MedicalAidProvider string `json:"medical_aid_provider"`
+	MedicalAidNumber   string `json:"medical_aid_number"`
+	MedicalPlan        string `json:"medical_plan"`
+	Notes              string `json:"notes"`
+}
+
+// CustomerUpdate replaces every field of a customer, so fields left out are
+// cleared.
+type CustomerUpdate CustomerCreate
+
+// maxNotes keeps customer and call notes to something an agent can read
+// mid-call.
+const maxNotes = 5000
+
+// maxSearchTerm is the longest customer search accepted.
+const maxSearchTerm = 100
+
+type CustomersResponse struct {
+	Success    bool          `json:"success"`
+	Customers  []db.Customer `json:"customers"`
+	Pagination Pagination    `json:"pagination"`
+}
+
+func (c *CustomerCreate) validate() error {
+	c.FirstName = strings.TrimSpace(c.FirstName)
+	c.LastName = strings.TrimSpace(c.LastName)
+	if c.FirstName == "" || c.LastName == "" {
+		return errors.New("First and last name are required")
+	}
+	if email := strings.TrimSpace(c.Email); email != "" && !strings.Contains(email, "@") {
+		return errors.New("Invalid email address")
+	}
+	c.Notes = strings.TrimSpace(c.Notes)
+	if len(c.Notes) > maxNotes {
+		return errors.New("Notes must be at most 5000 characters")
+	}
+	return nil
+}
+
+// findCustomerByPhone matches one of a company's customers on the exact
+// stored phone first, then on the normalized number. It returns nil when
+// nobody matches, and always for company 0: customers are never looked up
+// across companies.
+func (s *Server) findCustomerByPhone(ctx context.Context, companyID int64, phone string) (*db.Customer, error) {
+	if companyID == 0 {
+		return nil, nil
+	}
+
+	// Try exact match first
+	customer, err := s.queries.GetCustomerByPhoneAndCompany(ctx, db.GetCustomerByPhoneAndCompanyParams{
+		Phone:     sql.NullString{String: phone, Valid: true},
+		CompanyID: companyID,
+	})
+	if err == nil {
+		return &customer, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// If not found, try normalizing phone number (remove spaces, hyphens, etc.)
+	normalizedPhone := normalizePhoneNumber(phone)
+	logInfof(ctx, "📞 Normalized input phone: %s", normalizedPhone)
+
+	customer, err = s.queries.GetCustomerByNormalizedPhone(ctx, db.GetCustomerByNormalizedPhoneParams{
+		PhoneNormalized: sql.NullString{String: normalizedPhone, Valid: true},
+		CompanyID:       companyID,
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logInfof(ctx, "✅ Found matching customer: %s %s", customer.FirstName, customer.LastName)
+	return &customer, nil
+}
+
+// normalizedPhones are the columns holding a normalized copy of a number:
+// customers keep the number as entered alongside it, while block lists and
+// company numbers are only ever stored normalized.
+var normalizedPhones = []struct {
+	table, source, target string
+}{
+	{"customers", "phone", "phone_normalized"},
+	{"blocked_numbers", "phone", "phone"},
+	{"phone_numbers", "phone", "phone"},
+}
+
+// backfillNormalizedPhones brings every normalized number up to date, for
+// rows saved before normalization existed or before DEFAULT_PHONE_REGION
+// last changed. Rows that are already current are left alone.
+func backfillNormalizedPhones(database *sql.DB) error {
+	for _, column := range normalizedPhones {
+		updated, err := backfillNormalizedColumn(database, column.table, column.source, column.target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", column.table, err)
+		}
+		if updated > 0 {
+			log.Printf("📞 Normalized %d phone numbers in %s", updated, column.table)
+		}
+	}
+	return nil
+}
+
+// backfillNormalizedColumn sets target to the normalized source on rows of
+// table where it's out of date. A number that would then clash with one
+// already stored is left as it is, with a warning, rather than merged.
+func backfillNormalizedColumn(database *sql.DB, table, source, target string) (int, error) {
+	rows, err := database.Query(fmt.Sprintf("SELECT id, %s, %s FROM %s WHERE %s IS NOT NULL", source, target, table, source))
+	if err != nil {
+		return 0, err
+	}
+	pending := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var phone string
+		var stored sql.NullString
+		if err := rows.Scan(&id, &phone, &stored); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		normalized := nullString(normalizePhoneNumber(phone))
+		// Numbers stored only normalized are never blanked out
+		if normalized != stored && (normalized.Valid || source != target) {
+			pending[id] = normalized.String
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for id, normalized := range pending {
+		_, err := database.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", table, target), nullString(normalized), id)
+		if isUniqueViolation(err) {
+			log.Printf("⚠️ Not normalizing %s %d: %s is already stored", table, id, normalized)
+			continue
+		}
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (s *Server) markCustomerVip(w http.ResponseWriter, r *http.Request) {
+	s.setCustomerVip(w, r, true)
+}
+
+func (s *Server) unmarkCustomerVip(w http.ResponseWriter, r *http.Request) {
+	s.setCustomerVip(w, r, false)
+}
+
+func (s *Server) setCustomerVip(w http.ResponseWriter, r *http.Request, vip bool) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid customer id")
+		return
+	}
+
+	rows, err := s.queries.SetCustomerVip(r.Context(), db.SetCustomerVipParams{
+		IsVip:     vip,
+		ID:        id,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update customer")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	customer, err := s.queries.GetCustomerByID(r.Context(), id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get customer")
+		return
+	}
+
+	logInfof(r.Context(), "⭐ Customer %d VIP set to %t by %s", id, vip, user.AgentID)
+	s.audit(r.Context(), user, auditCustomerVip, strconv.FormatInt(id, 10), strconv.FormatBool(vip))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomerResponse{
+		Success:  true,
+		Customer: &customer,
+	})
+}
+
+// getCustomers lists the company's customers by name. Admins can add the
+// deleted ones with ?include_deleted=1.
+func (s *Server) getCustomers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	page := parsePagination(r)
+	withDeleted, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	total, err := s.queries.CountCustomersByCompany(r.Context(), db.CountCustomersByCompanyParams{
+		CompanyID:      user.CompanyID,
+		IncludeDeleted: withDeleted,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get customers")
+		return
+	}
+	page.Total = total
+
+	customers, err := s.queries.ListCustomersByCompany(r.Context(), db.ListCustomersByCompanyParams{
+		CompanyID:      user.CompanyID,
+		IncludeDeleted: withDeleted,
+		Limit:          page.PageSize,
+		Offset:         page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get customers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomersResponse{
+		Success:    true,
+		Customers:  customers,
+		Pagination: page,
+	})
+}
+
+// searchCustomers finds the company's customers whose name, email or phone
+// contains q, case-insensitively, with exact matches first. Like the list,
+// it takes ?include_deleted=1 from admins.
+func (s *Server) searchCustomers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	page := parsePagination(r)
+	withDeleted, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	term := strings.TrimSpace(r.URL.Query().Get("q"))
+	if term == "" {
+		respondError(w, http.StatusBadRequest, "Search term is required")
+		return
+	}
+	if len(term) > maxSearchTerm {
+		respondError(w, http.StatusBadRequest, "Search term must be at most 100 characters")
+		return
+	}
+
+	escaped := escapeLike(term)
+	phone := nullString(normalizePhoneNumber(term))
+	total, err := s.queries.CountCustomerSearch(r.Context(), db.CountCustomerSearchParams{
+		CompanyID:      user.CompanyID,
+		IncludeDeleted: withDeleted,
+		Contains:       "%" + escaped + "%",
+		Phone:          phone,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to search customers")
+		return
+	}
+	page.Total = total
+
+	customers, err := s.queries.SearchCustomers(r.Context(), db.SearchCustomersParams{
+		CompanyID:      user.CompanyID,
+		IncludeDeleted: withDeleted,
+		Contains:       "%" + escaped + "%",
+		Phone:          phone,
+		Term:           term,
+		Prefix:         escaped + "%",
+		Limit:          page.PageSize,
+		Offset:         page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to search customers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomersResponse{
+		Success:    true,
+		Customers:  customers,
+		Pagination: page,
+	})
+}
+
+// escapeLike makes s match itself in a LIKE pattern with ESCAPE '\', so a
+// search for "50%" doesn't match everything starting with 50.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// getCustomer gets one of the company's customers. With ?include_deleted=1
+// admins can also open a deleted one, e.g. from an old call or message that
+// still points at them.
+func (s *Server) getCustomer(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid customer id")
+		return
+	}
+	withDeleted, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
+	var customer db.Customer
+	if withDeleted {
+		customer, err = s.queries.GetCompanyCustomerWithDeleted(r.Context(), db.GetCompanyCustomerWithDeletedParams{
+			ID:        id,
+			CompanyID: user.CompanyID,
+		})
+	} else {
+		customer, err = s.queries.GetCompanyCustomer(r.Context(), db.GetCompanyCustomerParams{
+			ID:        id,
+			CompanyID: user.CompanyID,
+		})
+	}
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get customer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomerResponse{
+		Success:  true,
+		Customer: &customer,
+	})
+}
+
+func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req CustomerCreate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	customer, err := s.queries.CreateCustomer(r.Context(), db.CreateCustomerParams{
+		CompanyID:          user.CompanyID,
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		Email:              nullString(req.Email),
+		Phone:              nullString(req.Phone),
+		PhoneNormalized:    nullString(normalizePhoneNumber(req.Phone)),
+		MedicalAidProvider: nullString(req.MedicalAidProvider),
+		MedicalAidNumber:   nullString(req.MedicalAidNumber),
+		MedicalPlan:        nullString(req.MedicalPlan),
+		Notes:              nullString(req.Notes),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create customer")
+		return
+	}
+
+	logInfof(r.Context(), "👤 Customer %d created by %s", customer.ID, user.AgentID)
+	s.audit(r.Context(), user, auditCustomerCreate, strconv.FormatInt(customer.ID, 10), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CustomerResponse{
+		Success:  true,
+		Customer: &customer,
+	})
+}
+
+func (s *Server) updateCustomer(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid customer id")
+		return
+	}
+
+	var req CustomerUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := (*CustomerCreate)(&req).validate(); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	customer, err := s.queries.UpdateCustomer(r.Context(), db.UpdateCustomerParams{
+		FirstName:          req.FirstName,
+		LastName:           req.LastName,
+		Email:              nullString(req.Email),
+		Phone:              nullString(req.Phone),
+		PhoneNormalized:    nullString(normalizePhoneNumber(req.Phone)),
+		MedicalAidProvider: nullString(req.MedicalAidProvider),
+		MedicalAidNumber:   nullString(req.MedicalAidNumber),
+		MedicalPlan:        nullString(req.MedicalPlan),
+		Notes:              nullString(req.Notes),
+		ID:                 id,
+		CompanyID:          user.CompanyID,
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update customer")
+		return
+	}
+
+	logInfof(r.Context(), "👤 Customer %d updated by %s", customer.ID, user.AgentID)
+	s.audit(r.Context(), user, auditCustomerUpdate, strconv.FormatInt(customer.ID, 10), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomerResponse{
+		Success:  true,
+		Customer: &customer,
+	})
+}
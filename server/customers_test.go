@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"omnicall/db"
+)
+
+func TestBackfillNormalizedPhones(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	// Stored before DEFAULT_PHONE_REGION was set, so still national
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone) VALUES (?, 'Pat', 'Doe', '071 123 4567')", acme)
+	exec(t, s, "INSERT INTO blocked_numbers (company_id, phone) VALUES (?, '0711234568')", acme)
+	exec(t, s, "INSERT INTO phone_numbers (company_id, phone) VALUES (?, '0110000001')", acme)
+	exec(t, s, "INSERT INTO phone_numbers (company_id, phone) VALUES (?, '+27110000002')", acme)
+	exec(t, s, "INSERT INTO phone_numbers (company_id, phone) VALUES (?, '0110000002')", acme)
+
+	t.Setenv("DEFAULT_PHONE_REGION", "ZA")
+	if err := backfillNormalizedPhones(s.db); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"customer", "SELECT phone_normalized FROM customers WHERE phone = '071 123 4567'", "+27711234567"},
+		{"blocked number", "SELECT phone FROM blocked_numbers", "+27711234568"},
+		{"company number", "SELECT phone FROM phone_numbers WHERE id = 1", "+27110000001"},
+		{"number that would clash", "SELECT phone FROM phone_numbers WHERE id = 3", "0110000002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if err := s.db.QueryRow(tt.query).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Customers are then found whichever way the number is written
+	customer, err := s.findCustomerByPhone(t.Context(), acme, "+27 71 123 4567")
+	if err != nil || customer == nil {
+		t.Errorf("customer not found by their normalized number: %v", err)
+	}
+}
+
+func TestEachCustomerByCompany(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized, notes, is_vip) VALUES (?, 'Pat', 'Doe', '071 123 4567', '+27711234567', 'Prefers mornings', 1)", acme)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, email) VALUES (?, 'Sam', 'Roe', 'sam@example.com')", acme)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name) VALUES (?, 'Lee', 'Poe')", globex)
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name    string
+		company int64
+		stop    int // stop after this many rows, 0 for all
+		want    int
+	}{
+		{"every customer", acme, 0, 2},
+		{"other company", globex, 0, 1},
+		{"stops at the first error", acme, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []db.Customer
+			err := s.queries.EachCustomerByCompany(t.Context(), tt.company, func(c db.Customer) error {
+				got = append(got, c)
+				if len(got) == tt.stop {
+					return errStop
+				}
+				return nil
+			})
+			if tt.stop != 0 && err != errStop || tt.stop == 0 && err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("got %d customers, want %d", len(got), tt.want)
+			}
+			// The cursor must read rows exactly as the generated query does
+			all, err := s.queries.GetCustomersByCompany(t.Context(), tt.company)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, all[:tt.want]) {
+				t.Errorf("cursor rows = %+v, want %+v", got, all[:tt.want])
+			}
+		})
+	}
+}
+
+func TestVipCallers(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	addAgent(t, s, acme, "acme1", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	// First-available rings acmeadmin; longest-idle passes over them for
+	// acme1, who hasn't had a call yet
+	exec(t, s, "INSERT INTO agent_activity (agent_id, last_call_at) VALUES ('acmeadmin', datetime('now', '-1 minute'))")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingVipRoutingStrategy, routingLongestIdle)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Doe', '+27825550001', '+27825550001')", acme)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Sam', 'Roe', '+27825550002', '+27825550002')", acme)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Lee', 'Poe', '+27825550003', '+27825550003')", globex)
+
+	setVip := func(id string, vip bool) int {
+		w := httptest.NewRecorder()
+		r := withURLParams(asUser(httptest.NewRequest(http.MethodPut, "/api/customers/"+id+"/vip", nil), admin), map[string]string{"id": id})
+		if vip {
+			s.markCustomerVip(w, r)
+		} else {
+			s.unmarkCustomerVip(w, r)
+		}
+		return w.Code
+	}
+	if got := setVip("1", true); got != http.StatusOK {
+		t.Fatalf("marking Pat: status = %d, want %d", got, http.StatusOK)
+	}
+	if got := setVip("3", true); got != http.StatusNotFound {
+		t.Errorf("marking another company's customer: status = %d, want %d", got, http.StatusNotFound)
+	}
+
+	events := s.hub.subscribe(acme)
+	defer s.hub.unsubscribe(acme, events)
+
+	tests := []struct {
+		name  string
+		phone string
+		vip   bool
+		agent string
+	}{
+		{"VIP", "+27825550001", true, "acme1"},
+		{"regular caller", "+27825550002", false, "acmeadmin"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.getCustomerByPhone(w, asUser(httptest.NewRequest(http.MethodGet, "/api/customers/lookup?phone="+url.QueryEscape(tt.phone), nil), admin))
+			var resp CustomerResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Customer == nil || resp.Customer.IsVip != tt.vip {
+				t.Errorf("lookup = %+v, want VIP = %v", resp.Customer, tt.vip)
+			}
+
+			s.handleIncomingCall(httptest.NewRecorder(), twilioWebhook("/twilio/incoming-call", url.Values{
+				"CallSid": {fmt.Sprintf("CA-vip-%d", i)}, "From": {tt.phone}, "To": {"+27110000001"}, "CallStatus": {"ringing"},
+			}))
+			select {
+			case ev := <-events:
+				customer, _ := ev.Data.(map[string]any)["customer"].(*db.Customer)
+				if ev.AgentID != tt.agent || customer == nil || customer.IsVip != tt.vip {
+					t.Errorf("screen-pop for %q with %+v, want %q with VIP = %v", ev.AgentID, customer, tt.agent, tt.vip)
+				}
+			default:
+				t.Error("no screen-pop")
+			}
+		})
+	}
+
+	if got := setVip("1", false); got != http.StatusOK {
+		t.Fatalf("unmarking Pat: status = %d, want %d", got, http.StatusOK)
+	}
+	customer, _ := s.queries.GetCustomerByID(t.Context(), 1)
+	if customer.IsVip {
+		t.Error("Pat is still a VIP")
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Cursors run a generated query's SQL but hand rows over one at a time, for
+// results too large to hold in memory. sqlc only ever writes its own files,
+// so these live beside them.
+
+// EachCustomerByCompany calls fn with each row GetCustomersByCompany would
+// return, stopping at the first error fn returns.
+func (q *Queries) EachCustomerByCompany(ctx context.Context, companyID int64, fn func(Customer) error) error {
+	return each(ctx, q.db, scanCustomer, fn, getCustomersByCompany, companyID)
+}
+
+// each runs query and calls fn with every row scan reads, stopping at the
+// first error either returns.
+func each[T any](ctx context.Context, conn DBTX, scan func(*sql.Rows) (T, error), fn func(T) error, query string, args ...interface{}) error {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// scanCustomer reads a row of customers columns in table order, as the
+// generated SELECT * queries return them.
+func scanCustomer(rows *sql.Rows) (Customer, error) {
+	var i Customer
+	err := rows.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
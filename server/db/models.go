@@ -9,6 +9,123 @@ import (
 	"time"
 )
 
+type AgentActivity struct {
+	AgentID    string    `json:"agent_id"`
+	LastCallAt time.Time `json:"last_call_at"`
+}
+
+type AgentStatus struct {
+	AgentID       string         `json:"agent_id"`
+	DndUntil      sql.NullTime   `json:"dnd_until"`
+	UpdatedAt     sql.NullTime   `json:"updated_at"`
+	WrapUpCallSid sql.NullString `json:"wrap_up_call_sid"`
+	WrapUpUntil   sql.NullTime   `json:"wrap_up_until"`
+	Presence      string         `json:"presence"`
+	LastSeenAt    sql.NullTime   `json:"last_seen_at"`
+}
+
+type AppSetting struct {
+	Key       string       `json:"key"`
+	Value     string       `json:"value"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+type AuditLog struct {
+	ID        int64          `json:"id"`
+	CompanyID int64          `json:"company_id"`
+	Actor     string         `json:"actor"`
+	Action    string         `json:"action"`
+	Target    sql.NullString `json:"target"`
+	Details   sql.NullString `json:"details"`
+	CreatedAt sql.NullTime   `json:"created_at"`
+}
+
+type BlockedNumber struct {
+	ID        int64        `json:"id"`
+	CompanyID int64        `json:"company_id"`
+	Phone     string       `json:"phone"`
+	Reason    string       `json:"reason"`
+	CreatedAt sql.NullTime `json:"created_at"`
+}
+
+type BusinessHoliday struct {
+	CompanyID int64  `json:"company_id"`
+	Date      string `json:"date"`
+	Name      string `json:"name"`
+}
+
+type BusinessSchedule struct {
+	CompanyID int64        `json:"company_id"`
+	Timezone  string       `json:"timezone"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+type BusinessScheduleWindow struct {
+	ID        int64  `json:"id"`
+	CompanyID int64  `json:"company_id"`
+	Day       int64  `json:"day"`
+	Opens     string `json:"opens"`
+	Closes    string `json:"closes"`
+}
+
+type Call struct {
+	ID                int64          `json:"id"`
+	CallSid           string         `json:"call_sid"`
+	Direction         string         `json:"direction"`
+	FromNumber        sql.NullString `json:"from_number"`
+	ToNumber          sql.NullString `json:"to_number"`
+	AgentID           sql.NullString `json:"agent_id"`
+	CompanyID         sql.NullInt64  `json:"company_id"`
+	Status            string         `json:"status"`
+	StartedAt         time.Time      `json:"started_at"`
+	EndedAt           sql.NullTime   `json:"ended_at"`
+	DurationSeconds   sql.NullInt64  `json:"duration_seconds"`
+	CreatedAt         sql.NullTime   `json:"created_at"`
+	UpdatedAt         sql.NullTime   `json:"updated_at"`
+	RecordingSid      sql.NullString `json:"recording_sid"`
+	RecordingUrl      sql.NullString `json:"recording_url"`
+	RecordingDuration sql.NullInt64  `json:"recording_duration"`
+	HoldStartedAt     sql.NullTime   `json:"hold_started_at"`
+	HoldSeconds       int64          `json:"hold_seconds"`
+	HeldBy            sql.NullString `json:"held_by"`
+	HeldCallSid       sql.NullString `json:"held_call_sid"`
+	AnsweredAt        sql.NullTime   `json:"answered_at"`
+	CallRefID         sql.NullString `json:"call_ref_id"`
+	ParentCallSid     sql.NullString `json:"parent_call_sid"`
+	AnsweredBy        sql.NullString `json:"answered_by"`
+	AmdOutcome        sql.NullString `json:"amd_outcome"`
+}
+
+type CallDisposition struct {
+	ID          int64          `json:"id"`
+	CompanyID   int64          `json:"company_id"`
+	AgentID     string         `json:"agent_id"`
+	CallSid     string         `json:"call_sid"`
+	Disposition string         `json:"disposition"`
+	Notes       sql.NullString `json:"notes"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
+	CallRefID   sql.NullString `json:"call_ref_id"`
+}
+
+type CallError struct {
+	ID         int64          `json:"id"`
+	CompanyID  sql.NullInt64  `json:"company_id"`
+	CallSid    sql.NullString `json:"call_sid"`
+	ErrorCode  sql.NullString `json:"error_code"`
+	ErrorUrl   sql.NullString `json:"error_url"`
+	FromNumber sql.NullString `json:"from_number"`
+	ToNumber   sql.NullString `json:"to_number"`
+	CreatedAt  sql.NullTime   `json:"created_at"`
+}
+
+type CallRef struct {
+	ID               string         `json:"id"`
+	CallSid          sql.NullString `json:"call_sid"`
+	CreatedAt        sql.NullTime   `json:"created_at"`
+	NameRecordingUrl sql.NullString `json:"name_recording_url"`
+	CompanyID        sql.NullInt64  `json:"company_id"`
+}
+
 type CallTranscription struct {
 	ID         int64          `json:"id"`
 	CustomerID int64          `json:"customer_id"`
@@ -19,10 +136,42 @@ type CallTranscription struct {
 	CreatedAt  sql.NullTime   `json:"created_at"`
 }
 
+type CallTransfer struct {
+	ID          int64        `json:"id"`
+	CallID      int64        `json:"call_id"`
+	FromAgentID string       `json:"from_agent_id"`
+	ToAgentID   string       `json:"to_agent_id"`
+	CreatedAt   sql.NullTime `json:"created_at"`
+}
+
 type Company struct {
 	ID        int64        `json:"id"`
 	Name      string       `json:"name"`
 	CreatedAt sql.NullTime `json:"created_at"`
+	DeletedAt sql.NullTime `json:"deleted_at"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+type CompanySetting struct {
+	CompanyID int64        `json:"company_id"`
+	Key       string       `json:"key"`
+	Value     string       `json:"value"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
+type ConferenceParticipant struct {
+	ID             int64          `json:"id"`
+	CompanyID      int64          `json:"company_id"`
+	ConferenceName string         `json:"conference_name"`
+	ConferenceSid  sql.NullString `json:"conference_sid"`
+	CallSid        string         `json:"call_sid"`
+	AgentID        sql.NullString `json:"agent_id"`
+	Role           string         `json:"role"`
+	Status         string         `json:"status"`
+	JoinedAt       sql.NullTime   `json:"joined_at"`
+	LeftAt         sql.NullTime   `json:"left_at"`
+	CreatedAt      sql.NullTime   `json:"created_at"`
+	UpdatedAt      sql.NullTime   `json:"updated_at"`
 }
 
 type Customer struct {
@@ -36,6 +185,11 @@ type Customer struct {
 	MedicalAidNumber   sql.NullString `json:"medical_aid_number"`
 	MedicalPlan        sql.NullString `json:"medical_plan"`
 	CreatedAt          sql.NullTime   `json:"created_at"`
+	IsVip              bool           `json:"is_vip"`
+	DeletedAt          sql.NullTime   `json:"deleted_at"`
+	UpdatedAt          sql.NullTime   `json:"updated_at"`
+	PhoneNormalized    sql.NullString `json:"phone_normalized"`
+	Notes              sql.NullString `json:"notes"`
 }
 
 type CustomerPremium struct {
@@ -46,20 +200,128 @@ type CustomerPremium struct {
 	CreatedAt     sql.NullTime `json:"created_at"`
 }
 
-type Session struct {
-	ID        string       `json:"id"`
+type IdempotencyKey struct {
+	ID             int64          `json:"id"`
+	UserID         int64          `json:"user_id"`
+	IdempotencyKey string         `json:"idempotency_key"`
+	Method         string         `json:"method"`
+	Path           string         `json:"path"`
+	RequestHash    string         `json:"request_hash"`
+	StatusCode     sql.NullInt64  `json:"status_code"`
+	ContentType    sql.NullString `json:"content_type"`
+	ResponseBody   []byte         `json:"response_body"`
+	CreatedAt      sql.NullTime   `json:"created_at"`
+	ExpiresAt      time.Time      `json:"expires_at"`
+}
+
+type Message struct {
+	ID           int64          `json:"id"`
+	MessageSid   sql.NullString `json:"message_sid"`
+	CompanyID    int64          `json:"company_id"`
+	Direction    string         `json:"direction"`
+	FromNumber   string         `json:"from_number"`
+	ToNumber     string         `json:"to_number"`
+	Body         string         `json:"body"`
+	Status       string         `json:"status"`
+	AgentID      sql.NullString `json:"agent_id"`
+	ErrorMessage sql.NullString `json:"error_message"`
+	CreatedAt    sql.NullTime   `json:"created_at"`
+	UpdatedAt    sql.NullTime   `json:"updated_at"`
+	CustomerID   sql.NullInt64  `json:"customer_id"`
+}
+
+type ParkedCall struct {
+	ID          int64          `json:"id"`
+	CompanyID   int64          `json:"company_id"`
+	Code        string         `json:"code"`
+	CallSid     string         `json:"call_sid"`
+	Phone       string         `json:"phone"`
+	ParkedBy    string         `json:"parked_by"`
+	RetrievedBy sql.NullString `json:"retrieved_by"`
+	Status      string         `json:"status"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
+	UpdatedAt   sql.NullTime   `json:"updated_at"`
+}
+
+type PasswordReset struct {
+	TokenHash string       `json:"token_hash"`
 	UserID    int64        `json:"user_id"`
-	CreatedAt sql.NullTime `json:"created_at"`
 	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt sql.NullTime `json:"created_at"`
+}
+
+type PhoneNumber struct {
+	ID           int64          `json:"id"`
+	CompanyID    int64          `json:"company_id"`
+	Phone        string         `json:"phone"`
+	Label        sql.NullString `json:"label"`
+	RoutingType  string         `json:"routing_type"`
+	Announcement sql.NullString `json:"announcement"`
+	CreatedAt    sql.NullTime   `json:"created_at"`
+	UpdatedAt    sql.NullTime   `json:"updated_at"`
+}
+
+type QueueEntry struct {
+	ID        int64         `json:"id"`
+	CallSid   string        `json:"call_sid"`
+	Phone     string        `json:"phone"`
+	Status    string        `json:"status"`
+	Position  sql.NullInt64 `json:"position"`
+	QueuedAt  sql.NullTime  `json:"queued_at"`
+	UpdatedAt sql.NullTime  `json:"updated_at"`
+	CompanyID sql.NullInt64 `json:"company_id"`
+}
+
+type Session struct {
+	ID        string         `json:"id"`
+	UserID    int64          `json:"user_id"`
+	CreatedAt sql.NullTime   `json:"created_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UserAgent sql.NullString `json:"user_agent"`
+	IpAddress sql.NullString `json:"ip_address"`
 }
 
 type User struct {
-	ID           int64        `json:"id"`
-	Email        string       `json:"email"`
-	PasswordHash string       `json:"password_hash"`
-	Firstname    string       `json:"firstname"`
-	Lastname     string       `json:"lastname"`
-	AgentID      string       `json:"agent_id"`
-	CompanyID    int64        `json:"company_id"`
-	CreatedAt    sql.NullTime `json:"created_at"`
+	ID                  int64         `json:"id"`
+	Email               string        `json:"email"`
+	PasswordHash        string        `json:"-"`
+	Firstname           string        `json:"firstname"`
+	Lastname            string        `json:"lastname"`
+	AgentID             string        `json:"agent_id"`
+	CompanyID           int64         `json:"company_id"`
+	CreatedAt           sql.NullTime  `json:"created_at"`
+	DeletedAt           sql.NullTime  `json:"deleted_at"`
+	UpdatedAt           sql.NullTime  `json:"updated_at"`
+	EmailVerified       bool          `json:"email_verified"`
+	FailedLoginAttempts int64         `json:"failed_login_attempts"`
+	LockedUntil         sql.NullTime  `json:"locked_until"`
+	Role                string        `json:"role"`
+	CallerNumberID      sql.NullInt64 `json:"caller_number_id"`
+}
+
+type Voicemail struct {
+	ID           int64          `json:"id"`
+	CompanyID    int64          `json:"company_id"`
+	CustomerID   sql.NullInt64  `json:"customer_id"`
+	CallSid      sql.NullString `json:"call_sid"`
+	FromNumber   string         `json:"from_number"`
+	RecordingUrl string         `json:"recording_url"`
+	Duration     int64          `json:"duration"`
+	DeletedAt    sql.NullTime   `json:"deleted_at"`
+	CreatedAt    sql.NullTime   `json:"created_at"`
+	UpdatedAt    sql.NullTime   `json:"updated_at"`
+	ReadAt       sql.NullTime   `json:"read_at"`
+}
+
+type VoicemailDrop struct {
+	ID          int64        `json:"id"`
+	CompanyID   int64        `json:"company_id"`
+	AgentID     string       `json:"agent_id"`
+	Name        string       `json:"name"`
+	Token       string       `json:"token"`
+	ContentType string       `json:"content_type"`
+	Audio       []byte       `json:"audio"`
+	CreatedAt   sql.NullTime `json:"created_at"`
 }
@@ -11,261 +11,4462 @@ import (
 	"time"
 )
 
-const createCompany = `-- name: CreateCompany :one
-INSERT INTO companies (name) VALUES (?) RETURNING id, name, created_at
+const agentPerformanceReport = `-- name: AgentPerformanceReport :many
+
+SELECT u.id AS user_id, u.agent_id, u.firstname, u.lastname,
+       COUNT(c.id) AS total_calls,
+       COUNT(c.answered_at) AS handled,
+       CAST(COALESCE(SUM(CASE WHEN c.answered_at IS NOT NULL THEN c.duration_seconds END), 0) AS INTEGER) AS talk_seconds,
+       COUNT(CASE WHEN c.answered_at IS NOT NULL THEN c.duration_seconds END) AS timed,
+       COUNT(CASE WHEN c.answered_at IS NULL AND c.status IN ('completed', 'busy', 'no-answer', 'failed', 'canceled') THEN 1 END) AS missed,
+       (SELECT COUNT(*) FROM call_transfers t JOIN calls tc ON tc.id = t.call_id
+        WHERE t.from_agent_id = u.agent_id AND tc.company_id = u.company_id
+          AND tc.started_at >= ?1 AND tc.started_at < ?2) AS transferred
+FROM users u
+LEFT JOIN calls c ON c.agent_id = u.agent_id AND c.company_id = u.company_id
+  AND c.parent_call_sid IS NULL
+  AND c.started_at >= ?1 AND c.started_at < ?2
+WHERE u.company_id = ?3
+GROUP BY u.id
+HAVING (CAST(?4 AS BOOLEAN) AND u.deleted_at IS NULL)
+    OR COUNT(c.id) > 0 OR transferred > 0
+ORDER BY u.lastname, u.firstname, u.id
 `
 
-func (q *Queries) CreateCompany(ctx context.Context, name string) (Company, error) {
-	row := q.db.QueryRowContext(ctx, createCompany, name)
-	var i Company
-	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
-	return i, err
+type AgentPerformanceReportParams struct {
+	Since           time.Time `json:"since"`
+	Until           time.Time `json:"until"`
+	CompanyID       int64     `json:"company_id"`
+	IncludeInactive bool      `json:"include_inactive"`
 }
 
-const createCustomer = `-- name: CreateCustomer :one
-INSERT INTO customers (company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at
+type AgentPerformanceReportRow struct {
+	UserID      int64  `json:"user_id"`
+	AgentID     string `json:"agent_id"`
+	Firstname   string `json:"firstname"`
+	Lastname    string `json:"lastname"`
+	TotalCalls  int64  `json:"total_calls"`
+	Handled     int64  `json:"handled"`
+	TalkSeconds int64  `json:"talk_seconds"`
+	Timed       int64  `json:"timed"`
+	Missed      int64  `json:"missed"`
+	Transferred int64  `json:"transferred"`
+}
+
+// One row per agent of the company. Calls count towards the agent they were
+// put through to, transfers towards the agent who handed the call on.
+// Agents with nothing in the range are only included on request, and
+// deleted agents only when they have something.
+func (q *Queries) AgentPerformanceReport(ctx context.Context, arg AgentPerformanceReportParams) ([]AgentPerformanceReportRow, error) {
+	rows, err := q.db.QueryContext(ctx, agentPerformanceReport,
+		arg.Since,
+		arg.Until,
+		arg.CompanyID,
+		arg.IncludeInactive,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AgentPerformanceReportRow{}
+	for rows.Next() {
+		var i AgentPerformanceReportRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.AgentID,
+			&i.Firstname,
+			&i.Lastname,
+			&i.TotalCalls,
+			&i.Handled,
+			&i.TalkSeconds,
+			&i.Timed,
+			&i.Missed,
+			&i.Transferred,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const callVolumeReport = `-- name: CallVolumeReport :many
+
+SELECT CAST(CASE WHEN CAST(?1 AS TEXT) = 'week'
+            THEN date(started_at, 'weekday 0', '-6 days')
+            ELSE date(started_at) END AS TEXT) AS bucket,
+       direction,
+       COUNT(*) AS total,
+       COUNT(answered_at) AS answered,
+       COUNT(CASE WHEN answered_at IS NULL AND status IN ('completed', 'busy', 'no-answer', 'failed', 'canceled') THEN 1 END) AS missed,
+       CAST(COALESCE(SUM(CASE WHEN answered_at IS NOT NULL THEN duration_seconds END), 0) AS INTEGER) AS answered_seconds,
+       COUNT(CASE WHEN answered_at IS NOT NULL THEN duration_seconds END) AS timed
+FROM calls
+WHERE company_id = ?2 AND parent_call_sid IS NULL
+  AND started_at >= ?3 AND started_at < ?4
+GROUP BY bucket, direction
+ORDER BY bucket, direction
 `
 
-type CreateCustomerParams struct {
-	CompanyID          int64          `json:"company_id"`
-	FirstName          string         `json:"first_name"`
-	LastName           string         `json:"last_name"`
-	Email              sql.NullString `json:"email"`
-	Phone              sql.NullString `json:"phone"`
-	MedicalAidProvider sql.NullString `json:"medical_aid_provider"`
-	MedicalAidNumber   sql.NullString `json:"medical_aid_number"`
-	MedicalPlan        sql.NullString `json:"medical_plan"`
+type CallVolumeReportParams struct {
+	GroupBy   string        `json:"group_by"`
+	CompanyID sql.NullInt64 `json:"company_id"`
+	Since     time.Time     `json:"since"`
+	Until     time.Time     `json:"until"`
 }
 
-func (q *Queries) CreateCustomer(ctx context.Context, arg CreateCustomerParams) (Customer, error) {
-	row := q.db.QueryRowContext(ctx, createCustomer,
+type CallVolumeReportRow struct {
+	Bucket          string `json:"bucket"`
+	Direction       string `json:"direction"`
+	Total           int64  `json:"total"`
+	Answered        int64  `json:"answered"`
+	Missed          int64  `json:"missed"`
+	AnsweredSeconds int64  `json:"answered_seconds"`
+	Timed           int64  `json:"timed"`
+}
+
+// One row per bucket and direction. Buckets are UTC days, or weeks starting
+// on Monday. Missed calls ended without anyone answering.
+func (q *Queries) CallVolumeReport(ctx context.Context, arg CallVolumeReportParams) ([]CallVolumeReportRow, error) {
+	rows, err := q.db.QueryContext(ctx, callVolumeReport,
+		arg.GroupBy,
 		arg.CompanyID,
-		arg.FirstName,
-		arg.LastName,
-		arg.Email,
+		arg.Since,
+		arg.Until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CallVolumeReportRow{}
+	for rows.Next() {
+		var i CallVolumeReportRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.Direction,
+			&i.Total,
+			&i.Answered,
+			&i.Missed,
+			&i.AnsweredSeconds,
+			&i.Timed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimQueueCallback = `-- name: ClaimQueueCallback :execrows
+UPDATE queue_entries SET status = 'connected', updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status = 'callback'
+`
+
+func (q *Queries) ClaimQueueCallback(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimQueueCallback, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const clearAgentWrapUp = `-- name: ClearAgentWrapUp :execrows
+UPDATE agent_status SET wrap_up_call_sid = NULL, wrap_up_until = NULL
+WHERE agent_id = ? AND wrap_up_until IS NOT NULL
+`
+
+func (q *Queries) ClearAgentWrapUp(ctx context.Context, agentID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, clearAgentWrapUp, agentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const clearCallerNumber = `-- name: ClearCallerNumber :exec
+UPDATE users SET caller_number_id = NULL WHERE caller_number_id = ?
+`
+
+func (q *Queries) ClearCallerNumber(ctx context.Context, callerNumberID sql.NullInt64) error {
+	_, err := q.db.ExecContext(ctx, clearCallerNumber, callerNumberID)
+	return err
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ?
+WHERE user_id = ? AND idempotency_key = ?
+`
+
+type CompleteIdempotencyKeyParams struct {
+	StatusCode     sql.NullInt64  `json:"status_code"`
+	ContentType    sql.NullString `json:"content_type"`
+	ResponseBody   []byte         `json:"response_body"`
+	UserID         int64          `json:"user_id"`
+	IdempotencyKey string         `json:"idempotency_key"`
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, completeIdempotencyKey,
+		arg.StatusCode,
+		arg.ContentType,
+		arg.ResponseBody,
+		arg.UserID,
+		arg.IdempotencyKey,
+	)
+	return err
+}
+
+const consumeEmailVerification = `-- name: ConsumeEmailVerification :one
+UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+RETURNING user_id
+`
+
+type ConsumeEmailVerificationParams struct {
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) ConsumeEmailVerification(ctx context.Context, arg ConsumeEmailVerificationParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, consumeEmailVerification, arg.TokenHash, arg.ExpiresAt)
+	var user_id int64
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const consumePasswordReset = `-- name: ConsumePasswordReset :execrows
+UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+`
+
+type ConsumePasswordResetParams struct {
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) ConsumePasswordReset(ctx context.Context, arg ConsumePasswordResetParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, consumePasswordReset, arg.TokenHash, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countAuditEntries = `-- name: CountAuditEntries :one
+SELECT COUNT(*) FROM audit_log
+WHERE company_id = ?1
+  AND (?2 IS NULL OR actor = ?2)
+  AND (?3 IS NULL OR action = ?3)
+  AND (?4 IS NULL OR created_at >= ?4)
+  AND (?5 IS NULL OR created_at < ?5)
+`
+
+type CountAuditEntriesParams struct {
+	CompanyID int64          `json:"company_id"`
+	Actor     sql.NullString `json:"actor"`
+	Action    sql.NullString `json:"action"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+}
+
+func (q *Queries) CountAuditEntries(ctx context.Context, arg CountAuditEntriesParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAuditEntries,
+		arg.CompanyID,
+		arg.Actor,
+		arg.Action,
+		arg.Since,
+		arg.Until,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCallErrors = `-- name: CountCallErrors :one
+SELECT COUNT(*) FROM call_errors
+WHERE company_id = ?1
+  AND (?2 IS NULL OR error_code = ?2)
+  AND (?3 IS NULL OR created_at >= ?3)
+  AND (?4 IS NULL OR created_at < ?4)
+`
+
+type CountCallErrorsParams struct {
+	CompanyID sql.NullInt64  `json:"company_id"`
+	ErrorCode sql.NullString `json:"error_code"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+}
+
+func (q *Queries) CountCallErrors(ctx context.Context, arg CountCallErrorsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countCallErrors,
+		arg.CompanyID,
+		arg.ErrorCode,
+		arg.Since,
+		arg.Until,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCallsByCompany = `-- name: CountCallsByCompany :one
+SELECT COUNT(*) FROM calls
+WHERE company_id = ?1 AND parent_call_sid IS NULL
+  AND (?2 IS NULL OR agent_id = ?2)
+  AND (?3 IS NULL OR started_at >= ?3)
+  AND (?4 IS NULL OR started_at < ?4)
+`
+
+type CountCallsByCompanyParams struct {
+	CompanyID sql.NullInt64  `json:"company_id"`
+	AgentID   sql.NullString `json:"agent_id"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+}
+
+func (q *Queries) CountCallsByCompany(ctx context.Context, arg CountCallsByCompanyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countCallsByCompany,
+		arg.CompanyID,
+		arg.AgentID,
+		arg.Since,
+		arg.Until,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCompanies = `-- name: CountCompanies :one
+SELECT COUNT(*) FROM companies
+WHERE (deleted_at IS NULL OR (CAST(?1 AS BOOLEAN) AND id = ?2))
+    AND (?3 = '' OR instr(lower(name), lower(?3)) > 0)
+`
+
+type CountCompaniesParams struct {
+	IncludeDeleted bool   `json:"include_deleted"`
+	CompanyID      int64  `json:"company_id"`
+	Search         string `json:"search"`
+}
+
+func (q *Queries) CountCompanies(ctx context.Context, arg CountCompaniesParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countCompanies, arg.IncludeDeleted, arg.CompanyID, arg.Search)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCustomerSearch = `-- name: CountCustomerSearch :one
+SELECT COUNT(*) FROM customers
+WHERE company_id = ?1 AND (deleted_at IS NULL OR CAST(?2 AS BOOLEAN))
+  AND (first_name LIKE ?3 ESCAPE '\'
+    OR last_name LIKE ?3 ESCAPE '\'
+    OR first_name || ' ' || last_name LIKE ?3 ESCAPE '\'
+    OR email LIKE ?3 ESCAPE '\'
+    OR phone LIKE ?3 ESCAPE '\'
+    OR phone_normalized = ?4)
+`
+
+type CountCustomerSearchParams struct {
+	CompanyID      int64          `json:"company_id"`
+	IncludeDeleted bool           `json:"include_deleted"`
+	Contains       string         `json:"contains"`
+	Phone          sql.NullString `json:"phone"`
+}
+
+func (q *Queries) CountCustomerSearch(ctx context.Context, arg CountCustomerSearchParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countCustomerSearch,
+		arg.CompanyID,
+		arg.IncludeDeleted,
+		arg.Contains,
 		arg.Phone,
-		arg.MedicalAidProvider,
-		arg.MedicalAidNumber,
-		arg.MedicalPlan,
 	)
-	var i Customer
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCustomersByCompany = `-- name: CountCustomersByCompany :one
+SELECT COUNT(*) FROM customers
+WHERE company_id = ?1 AND (deleted_at IS NULL OR CAST(?2 AS BOOLEAN))
+`
+
+type CountCustomersByCompanyParams struct {
+	CompanyID      int64 `json:"company_id"`
+	IncludeDeleted bool  `json:"include_deleted"`
+}
+
+func (q *Queries) CountCustomersByCompany(ctx context.Context, arg CountCustomersByCompanyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countCustomersByCompany, arg.CompanyID, arg.IncludeDeleted)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countMessagesByCustomer = `-- name: CountMessagesByCustomer :one
+SELECT COUNT(*) FROM messages WHERE company_id = ? AND customer_id = ?
+`
+
+type CountMessagesByCustomerParams struct {
+	CompanyID  int64         `json:"company_id"`
+	CustomerID sql.NullInt64 `json:"customer_id"`
+}
+
+func (q *Queries) CountMessagesByCustomer(ctx context.Context, arg CountMessagesByCustomerParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countMessagesByCustomer, arg.CompanyID, arg.CustomerID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOtherUsersByCompany = `-- name: CountOtherUsersByCompany :one
+SELECT COUNT(*) FROM users WHERE company_id = ? AND id != ? AND deleted_at IS NULL
+`
+
+type CountOtherUsersByCompanyParams struct {
+	CompanyID int64 `json:"company_id"`
+	ID        int64 `json:"id"`
+}
+
+func (q *Queries) CountOtherUsersByCompany(ctx context.Context, arg CountOtherUsersByCompanyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countOtherUsersByCompany, arg.CompanyID, arg.ID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsersByCompany = `-- name: CountUsersByCompany :one
+SELECT COUNT(*) FROM users
+WHERE company_id = ?1 AND deleted_at IS NULL
+    AND (?2 = ''
+        OR instr(lower(firstname || ' ' || lastname), lower(?2)) > 0
+        OR instr(lower(email), lower(?2)) > 0)
+`
+
+type CountUsersByCompanyParams struct {
+	CompanyID int64  `json:"company_id"`
+	Search    string `json:"search"`
+}
+
+func (q *Queries) CountUsersByCompany(ctx context.Context, arg CountUsersByCompanyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersByCompany, arg.CompanyID, arg.Search)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countVoicemailsByCompany = `-- name: CountVoicemailsByCompany :one
+SELECT COUNT(*) FROM voicemails
+WHERE company_id = ?1 AND deleted_at IS NULL
+  AND (NOT ?2 OR read_at IS NULL)
+`
+
+type CountVoicemailsByCompanyParams struct {
+	CompanyID  int64 `json:"company_id"`
+	UnreadOnly bool  `json:"unread_only"`
+}
+
+func (q *Queries) CountVoicemailsByCompany(ctx context.Context, arg CountVoicemailsByCompanyParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countVoicemailsByCompany, arg.CompanyID, arg.UnreadOnly)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAuditEntry = `-- name: CreateAuditEntry :exec
+
+INSERT INTO audit_log (company_id, actor, action, target, details)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type CreateAuditEntryParams struct {
+	CompanyID int64          `json:"company_id"`
+	Actor     string         `json:"actor"`
+	Action    string         `json:"action"`
+	Target    sql.NullString `json:"target"`
+	Details   sql.NullString `json:"details"`
+}
+
+// -----------------------
+// Audit Log Queries
+// -----------------------
+func (q *Queries) CreateAuditEntry(ctx context.Context, arg CreateAuditEntryParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditEntry,
+		arg.CompanyID,
+		arg.Actor,
+		arg.Action,
+		arg.Target,
+		arg.Details,
+	)
+	return err
+}
+
+const createBlockedNumber = `-- name: CreateBlockedNumber :one
+INSERT INTO blocked_numbers (company_id, phone, reason)
+VALUES (?, ?, ?) RETURNING id, company_id, phone, reason, created_at
+`
+
+type CreateBlockedNumberParams struct {
+	CompanyID int64  `json:"company_id"`
+	Phone     string `json:"phone"`
+	Reason    string `json:"reason"`
+}
+
+func (q *Queries) CreateBlockedNumber(ctx context.Context, arg CreateBlockedNumberParams) (BlockedNumber, error) {
+	row := q.db.QueryRowContext(ctx, createBlockedNumber, arg.CompanyID, arg.Phone, arg.Reason)
+	var i BlockedNumber
 	err := row.Scan(
 		&i.ID,
 		&i.CompanyID,
-		&i.FirstName,
-		&i.LastName,
-		&i.Email,
 		&i.Phone,
-		&i.MedicalAidProvider,
-		&i.MedicalAidNumber,
-		&i.MedicalPlan,
+		&i.Reason,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const createCustomerPremium = `-- name: CreateCustomerPremium :one
-INSERT INTO customer_premiums (customer_id, premium_amount, effective_date)
-VALUES (?, ?, ?) RETURNING id, customer_id, premium_amount, effective_date, created_at
+const createBusinessHoliday = `-- name: CreateBusinessHoliday :exec
+INSERT INTO business_holidays (company_id, date, name) VALUES (?, ?, ?)
 `
 
-type CreateCustomerPremiumParams struct {
-	CustomerID    int64     `json:"customer_id"`
-	PremiumAmount float64   `json:"premium_amount"`
-	EffectiveDate time.Time `json:"effective_date"`
+type CreateBusinessHolidayParams struct {
+	CompanyID int64  `json:"company_id"`
+	Date      string `json:"date"`
+	Name      string `json:"name"`
 }
 
-func (q *Queries) CreateCustomerPremium(ctx context.Context, arg CreateCustomerPremiumParams) (CustomerPremium, error) {
-	row := q.db.QueryRowContext(ctx, createCustomerPremium, arg.CustomerID, arg.PremiumAmount, arg.EffectiveDate)
-	var i CustomerPremium
+func (q *Queries) CreateBusinessHoliday(ctx context.Context, arg CreateBusinessHolidayParams) error {
+	_, err := q.db.ExecContext(ctx, createBusinessHoliday, arg.CompanyID, arg.Date, arg.Name)
+	return err
+}
+
+const createBusinessScheduleWindow = `-- name: CreateBusinessScheduleWindow :exec
+INSERT INTO business_schedule_windows (company_id, day, opens, closes) VALUES (?, ?, ?, ?)
+`
+
+type CreateBusinessScheduleWindowParams struct {
+	CompanyID int64  `json:"company_id"`
+	Day       int64  `json:"day"`
+	Opens     string `json:"opens"`
+	Closes    string `json:"closes"`
+}
+
+func (q *Queries) CreateBusinessScheduleWindow(ctx context.Context, arg CreateBusinessScheduleWindowParams) error {
+	_, err := q.db.ExecContext(ctx, createBusinessScheduleWindow,
+		arg.CompanyID,
+		arg.Day,
+		arg.Opens,
+		arg.Closes,
+	)
+	return err
+}
+
+const createCall = `-- name: CreateCall :exec
+
+INSERT INTO calls (call_sid, call_ref_id, direction, from_number, to_number, agent_id, company_id, status, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (call_sid) DO UPDATE SET
+    call_ref_id = COALESCE(call_ref_id, excluded.call_ref_id),
+    agent_id = COALESCE(excluded.agent_id, agent_id),
+    company_id = COALESCE(excluded.company_id, company_id)
+`
+
+type CreateCallParams struct {
+	CallSid    string         `json:"call_sid"`
+	CallRefID  sql.NullString `json:"call_ref_id"`
+	Direction  string         `json:"direction"`
+	FromNumber sql.NullString `json:"from_number"`
+	ToNumber   sql.NullString `json:"to_number"`
+	AgentID    sql.NullString `json:"agent_id"`
+	CompanyID  sql.NullInt64  `json:"company_id"`
+	Status     string         `json:"status"`
+	StartedAt  time.Time      `json:"started_at"`
+}
+
+// -----------------------
+// Call Queries
+// -----------------------
+func (q *Queries) CreateCall(ctx context.Context, arg CreateCallParams) error {
+	_, err := q.db.ExecContext(ctx, createCall,
+		arg.CallSid,
+		arg.CallRefID,
+		arg.Direction,
+		arg.FromNumber,
+		arg.ToNumber,
+		arg.AgentID,
+		arg.CompanyID,
+		arg.Status,
+		arg.StartedAt,
+	)
+	return err
+}
+
+const createCallDisposition = `-- name: CreateCallDisposition :one
+
+INSERT INTO call_dispositions (company_id, agent_id, call_sid, call_ref_id, disposition, notes)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING id, company_id, agent_id, call_sid, disposition, notes, created_at, call_ref_id
+`
+
+type CreateCallDispositionParams struct {
+	CompanyID   int64          `json:"company_id"`
+	AgentID     string         `json:"agent_id"`
+	CallSid     string         `json:"call_sid"`
+	CallRefID   sql.NullString `json:"call_ref_id"`
+	Disposition string         `json:"disposition"`
+	Notes       sql.NullString `json:"notes"`
+}
+
+// -----------------------
+// Call Disposition Queries
+// -----------------------
+func (q *Queries) CreateCallDisposition(ctx context.Context, arg CreateCallDispositionParams) (CallDisposition, error) {
+	row := q.db.QueryRowContext(ctx, createCallDisposition,
+		arg.CompanyID,
+		arg.AgentID,
+		arg.CallSid,
+		arg.CallRefID,
+		arg.Disposition,
+		arg.Notes,
+	)
+	var i CallDisposition
 	err := row.Scan(
 		&i.ID,
-		&i.CustomerID,
-		&i.PremiumAmount,
-		&i.EffectiveDate,
+		&i.CompanyID,
+		&i.AgentID,
+		&i.CallSid,
+		&i.Disposition,
+		&i.Notes,
 		&i.CreatedAt,
+		&i.CallRefID,
 	)
 	return i, err
 }
 
-const createSession = `-- name: CreateSession :one
-INSERT INTO sessions (id, user_id, expires_at)
-VALUES (?, ?, ?) RETURNING id, user_id, created_at, expires_at
+const createCallError = `-- name: CreateCallError :exec
+INSERT INTO call_errors (company_id, call_sid, error_code, error_url, from_number, to_number)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateCallErrorParams struct {
+	CompanyID  sql.NullInt64  `json:"company_id"`
+	CallSid    sql.NullString `json:"call_sid"`
+	ErrorCode  sql.NullString `json:"error_code"`
+	ErrorUrl   sql.NullString `json:"error_url"`
+	FromNumber sql.NullString `json:"from_number"`
+	ToNumber   sql.NullString `json:"to_number"`
+}
+
+func (q *Queries) CreateCallError(ctx context.Context, arg CreateCallErrorParams) error {
+	_, err := q.db.ExecContext(ctx, createCallError,
+		arg.CompanyID,
+		arg.CallSid,
+		arg.ErrorCode,
+		arg.ErrorUrl,
+		arg.FromNumber,
+		arg.ToNumber,
+	)
+	return err
+}
+
+const createCallLeg = `-- name: CreateCallLeg :exec
+
+INSERT OR IGNORE INTO calls (call_sid, call_ref_id, parent_call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateCallLegParams struct {
+	CallSid       string         `json:"call_sid"`
+	CallRefID     sql.NullString `json:"call_ref_id"`
+	ParentCallSid sql.NullString `json:"parent_call_sid"`
+	Direction     string         `json:"direction"`
+	FromNumber    sql.NullString `json:"from_number"`
+	ToNumber      sql.NullString `json:"to_number"`
+	AgentID       sql.NullString `json:"agent_id"`
+	CompanyID     sql.NullInt64  `json:"company_id"`
+	Status        string         `json:"status"`
+	StartedAt     time.Time      `json:"started_at"`
+}
+
+// A customer leg an agent dialed, kept under the call it was dialed from.
+// Lists and reports only count the parent.
+func (q *Queries) CreateCallLeg(ctx context.Context, arg CreateCallLegParams) error {
+	_, err := q.db.ExecContext(ctx, createCallLeg,
+		arg.CallSid,
+		arg.CallRefID,
+		arg.ParentCallSid,
+		arg.Direction,
+		arg.FromNumber,
+		arg.ToNumber,
+		arg.AgentID,
+		arg.CompanyID,
+		arg.Status,
+		arg.StartedAt,
+	)
+	return err
+}
+
+const createCallTransfer = `-- name: CreateCallTransfer :one
+INSERT INTO call_transfers (call_id, from_agent_id, to_agent_id)
+VALUES (?, ?, ?) RETURNING id, call_id, from_agent_id, to_agent_id, created_at
+`
+
+type CreateCallTransferParams struct {
+	CallID      int64  `json:"call_id"`
+	FromAgentID string `json:"from_agent_id"`
+	ToAgentID   string `json:"to_agent_id"`
+}
+
+func (q *Queries) CreateCallTransfer(ctx context.Context, arg CreateCallTransferParams) (CallTransfer, error) {
+	row := q.db.QueryRowContext(ctx, createCallTransfer, arg.CallID, arg.FromAgentID, arg.ToAgentID)
+	var i CallTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.CallID,
+		&i.FromAgentID,
+		&i.ToAgentID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createCompany = `-- name: CreateCompany :one
+INSERT INTO companies (name) VALUES (?) RETURNING id, name, created_at, deleted_at, updated_at
+`
+
+func (q *Queries) CreateCompany(ctx context.Context, name string) (Company, error) {
+	row := q.db.QueryRowContext(ctx, createCompany, name)
+	var i Company
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createConferenceParticipant = `-- name: CreateConferenceParticipant :one
+
+INSERT INTO conference_participants (company_id, conference_name, call_sid, agent_id, role, status)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING id, company_id, conference_name, conference_sid, call_sid, agent_id, role, status, joined_at, left_at, created_at, updated_at
+`
+
+type CreateConferenceParticipantParams struct {
+	CompanyID      int64          `json:"company_id"`
+	ConferenceName string         `json:"conference_name"`
+	CallSid        string         `json:"call_sid"`
+	AgentID        sql.NullString `json:"agent_id"`
+	Role           string         `json:"role"`
+	Status         string         `json:"status"`
+}
+
+// -----------------------
+// Conference Queries
+// -----------------------
+func (q *Queries) CreateConferenceParticipant(ctx context.Context, arg CreateConferenceParticipantParams) (ConferenceParticipant, error) {
+	row := q.db.QueryRowContext(ctx, createConferenceParticipant,
+		arg.CompanyID,
+		arg.ConferenceName,
+		arg.CallSid,
+		arg.AgentID,
+		arg.Role,
+		arg.Status,
+	)
+	var i ConferenceParticipant
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.ConferenceName,
+		&i.ConferenceSid,
+		&i.CallSid,
+		&i.AgentID,
+		&i.Role,
+		&i.Status,
+		&i.JoinedAt,
+		&i.LeftAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createCustomer = `-- name: CreateCustomer :one
+INSERT INTO customers (company_id, first_name, last_name, email, phone, phone_normalized, medical_aid_provider, medical_aid_number, medical_plan, notes)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes
+`
+
+type CreateCustomerParams struct {
+	CompanyID          int64          `json:"company_id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Email              sql.NullString `json:"email"`
+	Phone              sql.NullString `json:"phone"`
+	PhoneNormalized    sql.NullString `json:"phone_normalized"`
+	MedicalAidProvider sql.NullString `json:"medical_aid_provider"`
+	MedicalAidNumber   sql.NullString `json:"medical_aid_number"`
+	MedicalPlan        sql.NullString `json:"medical_plan"`
+	Notes              sql.NullString `json:"notes"`
+}
+
+func (q *Queries) CreateCustomer(ctx context.Context, arg CreateCustomerParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, createCustomer,
+		arg.CompanyID,
+		arg.FirstName,
+		arg.LastName,
+		arg.Email,
+		arg.Phone,
+		arg.PhoneNormalized,
+		arg.MedicalAidProvider,
+		arg.MedicalAidNumber,
+		arg.MedicalPlan,
+		arg.Notes,
+	)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const createCustomerPremium = `-- name: CreateCustomerPremium :one
+INSERT INTO customer_premiums (customer_id, premium_amount, effective_date)
+VALUES (?, ?, ?) RETURNING id, customer_id, premium_amount, effective_date, created_at
+`
+
+type CreateCustomerPremiumParams struct {
+	CustomerID    int64     `json:"customer_id"`
+	PremiumAmount float64   `json:"premium_amount"`
+	EffectiveDate time.Time `json:"effective_date"`
+}
+
+func (q *Queries) CreateCustomerPremium(ctx context.Context, arg CreateCustomerPremiumParams) (CustomerPremium, error) {
+	row := q.db.QueryRowContext(ctx, createCustomerPremium, arg.CustomerID, arg.PremiumAmount, arg.EffectiveDate)
+	var i CustomerPremium
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.PremiumAmount,
+		&i.EffectiveDate,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createEmailVerification = `-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES (?, ?, ?)
+`
+
+type CreateEmailVerificationParams struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) CreateEmailVerification(ctx context.Context, arg CreateEmailVerificationParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerification, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createIdempotencyKey = `-- name: CreateIdempotencyKey :exec
+
+INSERT INTO idempotency_keys (user_id, idempotency_key, method, path, request_hash, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateIdempotencyKeyParams struct {
+	UserID         int64     `json:"user_id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	RequestHash    string    `json:"request_hash"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// Fails on the unique key when another request already holds it
+func (q *Queries) CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, createIdempotencyKey,
+		arg.UserID,
+		arg.IdempotencyKey,
+		arg.Method,
+		arg.Path,
+		arg.RequestHash,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createMessage = `-- name: CreateMessage :one
+
+INSERT INTO messages (message_sid, company_id, customer_id, direction, from_number, to_number, body, status, agent_id, error_message)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, message_sid, company_id, direction, from_number, to_number, body, status, agent_id, error_message, created_at, updated_at, customer_id
+`
+
+type CreateMessageParams struct {
+	MessageSid   sql.NullString `json:"message_sid"`
+	CompanyID    int64          `json:"company_id"`
+	CustomerID   sql.NullInt64  `json:"customer_id"`
+	Direction    string         `json:"direction"`
+	FromNumber   string         `json:"from_number"`
+	ToNumber     string         `json:"to_number"`
+	Body         string         `json:"body"`
+	Status       string         `json:"status"`
+	AgentID      sql.NullString `json:"agent_id"`
+	ErrorMessage sql.NullString `json:"error_message"`
+}
+
+// -----------------------
+// Message Queries
+// -----------------------
+func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
+	row := q.db.QueryRowContext(ctx, createMessage,
+		arg.MessageSid,
+		arg.CompanyID,
+		arg.CustomerID,
+		arg.Direction,
+		arg.FromNumber,
+		arg.ToNumber,
+		arg.Body,
+		arg.Status,
+		arg.AgentID,
+		arg.ErrorMessage,
+	)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.MessageSid,
+		&i.CompanyID,
+		&i.Direction,
+		&i.FromNumber,
+		&i.ToNumber,
+		&i.Body,
+		&i.Status,
+		&i.AgentID,
+		&i.ErrorMessage,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CustomerID,
+	)
+	return i, err
+}
+
+const createParkedCall = `-- name: CreateParkedCall :one
+
+INSERT INTO parked_calls (company_id, code, call_sid, phone, parked_by, expires_at)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING id, company_id, code, call_sid, phone, parked_by, retrieved_by, status, expires_at, created_at, updated_at
+`
+
+type CreateParkedCallParams struct {
+	CompanyID int64     `json:"company_id"`
+	Code      string    `json:"code"`
+	CallSid   string    `json:"call_sid"`
+	Phone     string    `json:"phone"`
+	ParkedBy  string    `json:"parked_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// -----------------------
+// Parked Call Queries
+// -----------------------
+func (q *Queries) CreateParkedCall(ctx context.Context, arg CreateParkedCallParams) (ParkedCall, error) {
+	row := q.db.QueryRowContext(ctx, createParkedCall,
+		arg.CompanyID,
+		arg.Code,
+		arg.CallSid,
+		arg.Phone,
+		arg.ParkedBy,
+		arg.ExpiresAt,
+	)
+	var i ParkedCall
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Code,
+		&i.CallSid,
+		&i.Phone,
+		&i.ParkedBy,
+		&i.RetrievedBy,
+		&i.Status,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createPasswordReset = `-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)
+`
+
+type CreatePasswordResetParams struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) CreatePasswordReset(ctx context.Context, arg CreatePasswordResetParams) error {
+	_, err := q.db.ExecContext(ctx, createPasswordReset, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createPhoneNumber = `-- name: CreatePhoneNumber :one
+INSERT INTO phone_numbers (company_id, phone, label, routing_type, announcement)
+VALUES (?, ?, ?, ?, ?) RETURNING id, company_id, phone, label, routing_type, announcement, created_at, updated_at
+`
+
+type CreatePhoneNumberParams struct {
+	CompanyID    int64          `json:"company_id"`
+	Phone        string         `json:"phone"`
+	Label        sql.NullString `json:"label"`
+	RoutingType  string         `json:"routing_type"`
+	Announcement sql.NullString `json:"announcement"`
+}
+
+func (q *Queries) CreatePhoneNumber(ctx context.Context, arg CreatePhoneNumberParams) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, createPhoneNumber,
+		arg.CompanyID,
+		arg.Phone,
+		arg.Label,
+		arg.RoutingType,
+		arg.Announcement,
+	)
+	var i PhoneNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createQueueEntry = `-- name: CreateQueueEntry :exec
+
+INSERT OR IGNORE INTO queue_entries (company_id, call_sid, phone) VALUES (?, ?, ?)
+`
+
+type CreateQueueEntryParams struct {
+	CompanyID sql.NullInt64 `json:"company_id"`
+	CallSid   string        `json:"call_sid"`
+	Phone     string        `json:"phone"`
+}
+
+// -----------------------
+// Queue Queries
+// -----------------------
+func (q *Queries) CreateQueueEntry(ctx context.Context, arg CreateQueueEntryParams) error {
+	_, err := q.db.ExecContext(ctx, createQueueEntry, arg.CompanyID, arg.CallSid, arg.Phone)
+	return err
+}
+
+const createSession = `-- name: CreateSession :one
+INSERT INTO sessions (id, user_id, expires_at, user_agent, ip_address)
+VALUES (?, ?, ?, ?, ?) RETURNING id, user_id, created_at, expires_at, user_agent, ip_address
+`
+
+type CreateSessionParams struct {
+	ID        string         `json:"id"`
+	UserID    int64          `json:"user_id"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UserAgent sql.NullString `json:"user_agent"`
+	IpAddress sql.NullString `json:"ip_address"`
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UserAgent,
+		&i.IpAddress,
+	)
+	return i, err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id, email_verified, role)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, email, password_hash, firstname, lastname, agent_id, company_id, created_at, deleted_at, updated_at, email_verified, failed_login_attempts, locked_until, role, caller_number_id
+`
+
+type CreateUserParams struct {
+	Email         string `json:"email"`
+	PasswordHash  string `json:"-"`
+	Firstname     string `json:"firstname"`
+	Lastname      string `json:"lastname"`
+	AgentID       string `json:"agent_id"`
+	CompanyID     int64  `json:"company_id"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.Email,
+		arg.PasswordHash,
+		arg.Firstname,
+		arg.Lastname,
+		arg.AgentID,
+		arg.CompanyID,
+		arg.EmailVerified,
+		arg.Role,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Firstname,
+		&i.Lastname,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.EmailVerified,
+		&i.FailedLoginAttempts,
+		&i.LockedUntil,
+		&i.Role,
+		&i.CallerNumberID,
+	)
+	return i, err
+}
+
+const createVoicemail = `-- name: CreateVoicemail :one
+INSERT INTO voicemails (company_id, customer_id, call_sid, from_number, recording_url, duration)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at
+`
+
+type CreateVoicemailParams struct {
+	CompanyID    int64          `json:"company_id"`
+	CustomerID   sql.NullInt64  `json:"customer_id"`
+	CallSid      sql.NullString `json:"call_sid"`
+	FromNumber   string         `json:"from_number"`
+	RecordingUrl string         `json:"recording_url"`
+	Duration     int64          `json:"duration"`
+}
+
+func (q *Queries) CreateVoicemail(ctx context.Context, arg CreateVoicemailParams) (Voicemail, error) {
+	row := q.db.QueryRowContext(ctx, createVoicemail,
+		arg.CompanyID,
+		arg.CustomerID,
+		arg.CallSid,
+		arg.FromNumber,
+		arg.RecordingUrl,
+		arg.Duration,
+	)
+	var i Voicemail
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.CustomerID,
+		&i.CallSid,
+		&i.FromNumber,
+		&i.RecordingUrl,
+		&i.Duration,
+		&i.DeletedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReadAt,
+	)
+	return i, err
+}
+
+const createVoicemailDrop = `-- name: CreateVoicemailDrop :one
+INSERT INTO voicemail_drops (company_id, agent_id, name, token, content_type, audio)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, company_id, agent_id, name, token, content_type, created_at
+`
+
+type CreateVoicemailDropParams struct {
+	CompanyID   int64  `json:"company_id"`
+	AgentID     string `json:"agent_id"`
+	Name        string `json:"name"`
+	Token       string `json:"token"`
+	ContentType string `json:"content_type"`
+	Audio       []byte `json:"audio"`
+}
+
+type CreateVoicemailDropRow struct {
+	ID          int64        `json:"id"`
+	CompanyID   int64        `json:"company_id"`
+	AgentID     string       `json:"agent_id"`
+	Name        string       `json:"name"`
+	Token       string       `json:"token"`
+	ContentType string       `json:"content_type"`
+	CreatedAt   sql.NullTime `json:"created_at"`
+}
+
+func (q *Queries) CreateVoicemailDrop(ctx context.Context, arg CreateVoicemailDropParams) (CreateVoicemailDropRow, error) {
+	row := q.db.QueryRowContext(ctx, createVoicemailDrop,
+		arg.CompanyID,
+		arg.AgentID,
+		arg.Name,
+		arg.Token,
+		arg.ContentType,
+		arg.Audio,
+	)
+	var i CreateVoicemailDropRow
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.AgentID,
+		&i.Name,
+		&i.Token,
+		&i.ContentType,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const declineQueueCallback = `-- name: DeclineQueueCallback :exec
+UPDATE queue_entries SET status = 'declined', updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status = 'waiting'
+`
+
+func (q *Queries) DeclineQueueCallback(ctx context.Context, callSid string) error {
+	_, err := q.db.ExecContext(ctx, declineQueueCallback, callSid)
+	return err
+}
+
+const deleteBlockedNumber = `-- name: DeleteBlockedNumber :execrows
+DELETE FROM blocked_numbers WHERE id = ? AND company_id = ?
+`
+
+type DeleteBlockedNumberParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) DeleteBlockedNumber(ctx context.Context, arg DeleteBlockedNumberParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteBlockedNumber, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteBusinessHolidays = `-- name: DeleteBusinessHolidays :exec
+DELETE FROM business_holidays WHERE company_id = ?
+`
+
+func (q *Queries) DeleteBusinessHolidays(ctx context.Context, companyID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteBusinessHolidays, companyID)
+	return err
+}
+
+const deleteBusinessSchedule = `-- name: DeleteBusinessSchedule :exec
+DELETE FROM business_schedules WHERE company_id = ?
+`
+
+func (q *Queries) DeleteBusinessSchedule(ctx context.Context, companyID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteBusinessSchedule, companyID)
+	return err
+}
+
+const deleteBusinessScheduleWindows = `-- name: DeleteBusinessScheduleWindows :exec
+DELETE FROM business_schedule_windows WHERE company_id = ?
+`
+
+func (q *Queries) DeleteBusinessScheduleWindows(ctx context.Context, companyID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteBusinessScheduleWindows, companyID)
+	return err
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at <= ?
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?
+`
+
+type DeleteIdempotencyKeyParams struct {
+	UserID         int64  `json:"user_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, arg.UserID, arg.IdempotencyKey)
+	return err
+}
+
+const deleteOtherSessions = `-- name: DeleteOtherSessions :execrows
+DELETE FROM sessions WHERE user_id = ? AND id != ?
+`
+
+type DeleteOtherSessionsParams struct {
+	UserID int64  `json:"user_id"`
+	ID     string `json:"id"`
+}
+
+func (q *Queries) DeleteOtherSessions(ctx context.Context, arg DeleteOtherSessionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOtherSessions, arg.UserID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deletePhoneNumber = `-- name: DeletePhoneNumber :execrows
+DELETE FROM phone_numbers WHERE id = ? AND company_id = ?
+`
+
+type DeletePhoneNumberParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) DeletePhoneNumber(ctx context.Context, arg DeletePhoneNumberParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePhoneNumber, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions WHERE id = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, id)
+	return err
+}
+
+const deleteSessionsByUser = `-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions WHERE user_id = ?
+`
+
+func (q *Queries) DeleteSessionsByUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteSessionsByUser, userID)
+	return err
+}
+
+const deleteUserSession = `-- name: DeleteUserSession :execrows
+DELETE FROM sessions WHERE id = ? AND user_id = ?
+`
+
+type DeleteUserSessionParams struct {
+	ID     string `json:"id"`
+	UserID int64  `json:"user_id"`
+}
+
+func (q *Queries) DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteVoicemailDrop = `-- name: DeleteVoicemailDrop :execrows
+DELETE FROM voicemail_drops WHERE id = ? AND agent_id = ? AND company_id = ?
+`
+
+type DeleteVoicemailDropParams struct {
+	ID        int64  `json:"id"`
+	AgentID   string `json:"agent_id"`
+	CompanyID int64  `json:"company_id"`
+}
+
+func (q *Queries) DeleteVoicemailDrop(ctx context.Context, arg DeleteVoicemailDropParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteVoicemailDrop, arg.ID, arg.AgentID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const endCallHold = `-- name: EndCallHold :one
+UPDATE calls
+SET hold_seconds = hold_seconds + ?, hold_started_at = NULL, held_by = NULL, held_call_sid = NULL
+WHERE id = ? AND hold_started_at IS NOT NULL
+RETURNING id, call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at, ended_at, duration_seconds, created_at, updated_at, recording_sid, recording_url, recording_duration, hold_started_at, hold_seconds, held_by, held_call_sid, answered_at, call_ref_id, parent_call_sid, answered_by, amd_outcome
+`
+
+type EndCallHoldParams struct {
+	HoldSeconds int64 `json:"hold_seconds"`
+	ID          int64 `json:"id"`
+}
+
+func (q *Queries) EndCallHold(ctx context.Context, arg EndCallHoldParams) (Call, error) {
+	row := q.db.QueryRowContext(ctx, endCallHold, arg.HoldSeconds, arg.ID)
+	var i Call
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.Direction,
+		&i.FromNumber,
+		&i.ToNumber,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.Status,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.DurationSeconds,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RecordingSid,
+		&i.RecordingUrl,
+		&i.RecordingDuration,
+		&i.HoldStartedAt,
+		&i.HoldSeconds,
+		&i.HeldBy,
+		&i.HeldCallSid,
+		&i.AnsweredAt,
+		&i.CallRefID,
+		&i.ParentCallSid,
+		&i.AnsweredBy,
+		&i.AmdOutcome,
+	)
+	return i, err
+}
+
+const endConference = `-- name: EndConference :execrows
+
+UPDATE conference_participants
+SET status = 'left', left_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND status != 'left'
+`
+
+// Anyone still listed when the conference ends left with it
+func (q *Queries) EndConference(ctx context.Context, conferenceName string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, endConference, conferenceName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const endParkedCall = `-- name: EndParkedCall :one
+UPDATE parked_calls SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status = 'parked'
+RETURNING id, company_id, code, call_sid, phone, parked_by, retrieved_by, status, expires_at, created_at, updated_at
+`
+
+type EndParkedCallParams struct {
+	Status  string `json:"status"`
+	CallSid string `json:"call_sid"`
+}
+
+func (q *Queries) EndParkedCall(ctx context.Context, arg EndParkedCallParams) (ParkedCall, error) {
+	row := q.db.QueryRowContext(ctx, endParkedCall, arg.Status, arg.CallSid)
+	var i ParkedCall
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Code,
+		&i.CallSid,
+		&i.Phone,
+		&i.ParkedBy,
+		&i.RetrievedBy,
+		&i.Status,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const ensureCompany = `-- name: EnsureCompany :exec
+INSERT OR IGNORE INTO companies (name) VALUES (?)
+`
+
+func (q *Queries) EnsureCompany(ctx context.Context, name string) error {
+	_, err := q.db.ExecContext(ctx, ensureCompany, name)
+	return err
+}
+
+const expireAgentPresence = `-- name: ExpireAgentPresence :execrows
+
+UPDATE agent_status SET presence = 'offline', updated_at = CURRENT_TIMESTAMP
+WHERE presence != 'offline' AND (last_seen_at IS NULL OR last_seen_at < ?)
+`
+
+// Agents whose browser stopped sending heartbeats are taken offline
+func (q *Queries) ExpireAgentPresence(ctx context.Context, lastSeenAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, expireAgentPresence, lastSeenAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getAgentCallerNumber = `-- name: GetAgentCallerNumber :one
+
+SELECT phone_numbers.id, phone_numbers.company_id, phone_numbers.phone, phone_numbers.label, phone_numbers.routing_type, phone_numbers.announcement, phone_numbers.created_at, phone_numbers.updated_at FROM phone_numbers
+JOIN users ON users.caller_number_id = phone_numbers.id
+WHERE users.id = ? AND phone_numbers.company_id = users.company_id
+`
+
+// An agent's own number, as long as it's still one of their company's
+func (q *Queries) GetAgentCallerNumber(ctx context.Context, id int64) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, getAgentCallerNumber, id)
+	var i PhoneNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getAgentStatus = `-- name: GetAgentStatus :one
+
+SELECT agent_id, dnd_until, updated_at, wrap_up_call_sid, wrap_up_until, presence, last_seen_at FROM agent_status WHERE agent_id = ?
+`
+
+// -----------------------
+// Agent Status Queries
+// -----------------------
+func (q *Queries) GetAgentStatus(ctx context.Context, agentID string) (AgentStatus, error) {
+	row := q.db.QueryRowContext(ctx, getAgentStatus, agentID)
+	var i AgentStatus
+	err := row.Scan(
+		&i.AgentID,
+		&i.DndUntil,
+		&i.UpdatedAt,
+		&i.WrapUpCallSid,
+		&i.WrapUpUntil,
+		&i.Presence,
+		&i.LastSeenAt,
+	)
+	return i, err
+}
+
+const getAllCustomers = `-- name: GetAllCustomers :many
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE deleted_at IS NULL ORDER BY created_at DESC
+`
+
+func (q *Queries) GetAllCustomers(ctx context.Context) ([]Customer, error) {
+	rows, err := q.db.QueryContext(ctx, getAllCustomers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Customer{}
+	for rows.Next() {
+		var i Customer
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.MedicalAidProvider,
+			&i.MedicalAidNumber,
+			&i.MedicalPlan,
+			&i.CreatedAt,
+			&i.IsVip,
+			&i.DeletedAt,
+			&i.UpdatedAt,
+			&i.PhoneNormalized,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppSetting = `-- name: GetAppSetting :one
+
+SELECT value FROM app_settings WHERE key = ?
+`
+
+// -----------------------
+// App Setting Queries
+// -----------------------
+func (q *Queries) GetAppSetting(ctx context.Context, key string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getAppSetting, key)
+	var value string
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getBlockedNumberByPhone = `-- name: GetBlockedNumberByPhone :one
+
+SELECT id, company_id, phone, reason, created_at FROM blocked_numbers WHERE company_id = ? AND phone = ?
+`
+
+type GetBlockedNumberByPhoneParams struct {
+	CompanyID int64  `json:"company_id"`
+	Phone     string `json:"phone"`
+}
+
+// -----------------------
+// Blocked Number Queries
+// -----------------------
+func (q *Queries) GetBlockedNumberByPhone(ctx context.Context, arg GetBlockedNumberByPhoneParams) (BlockedNumber, error) {
+	row := q.db.QueryRowContext(ctx, getBlockedNumberByPhone, arg.CompanyID, arg.Phone)
+	var i BlockedNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Reason,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getBusinessSchedule = `-- name: GetBusinessSchedule :one
+SELECT company_id, timezone, updated_at FROM business_schedules WHERE company_id = ?
+`
+
+func (q *Queries) GetBusinessSchedule(ctx context.Context, companyID int64) (BusinessSchedule, error) {
+	row := q.db.QueryRowContext(ctx, getBusinessSchedule, companyID)
+	var i BusinessSchedule
+	err := row.Scan(
+		&i.CompanyID,
+		&i.Timezone,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCallBySid = `-- name: GetCallBySid :one
+SELECT id, call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at, ended_at, duration_seconds, created_at, updated_at, recording_sid, recording_url, recording_duration, hold_started_at, hold_seconds, held_by, held_call_sid, answered_at, call_ref_id, parent_call_sid, answered_by, amd_outcome FROM calls WHERE call_sid = ?
+`
+
+func (q *Queries) GetCallBySid(ctx context.Context, callSid string) (Call, error) {
+	row := q.db.QueryRowContext(ctx, getCallBySid, callSid)
+	var i Call
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.Direction,
+		&i.FromNumber,
+		&i.ToNumber,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.Status,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.DurationSeconds,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RecordingSid,
+		&i.RecordingUrl,
+		&i.RecordingDuration,
+		&i.HoldStartedAt,
+		&i.HoldSeconds,
+		&i.HeldBy,
+		&i.HeldCallSid,
+		&i.AnsweredAt,
+		&i.CallRefID,
+		&i.ParentCallSid,
+		&i.AnsweredBy,
+		&i.AmdOutcome,
+	)
+	return i, err
+}
+
+const getCallRef = `-- name: GetCallRef :one
+
+SELECT id, call_sid, created_at, name_recording_url, company_id FROM call_refs WHERE id = ?
+`
+
+// -----------------------
+// Call Reference Queries
+// -----------------------
+func (q *Queries) GetCallRef(ctx context.Context, id string) (CallRef, error) {
+	row := q.db.QueryRowContext(ctx, getCallRef, id)
+	var i CallRef
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.CreatedAt,
+		&i.NameRecordingUrl,
+		&i.CompanyID,
+	)
+	return i, err
+}
+
+const getCompany = `-- name: GetCompany :one
+SELECT id, name, created_at, deleted_at, updated_at FROM companies WHERE id = ? AND deleted_at IS NULL
+`
+
+func (q *Queries) GetCompany(ctx context.Context, id int64) (Company, error) {
+	row := q.db.QueryRowContext(ctx, getCompany, id)
+	var i Company
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCompanyCallBySid = `-- name: GetCompanyCallBySid :one
+SELECT id, call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at, ended_at, duration_seconds, created_at, updated_at, recording_sid, recording_url, recording_duration, hold_started_at, hold_seconds, held_by, held_call_sid, answered_at, call_ref_id, parent_call_sid, answered_by, amd_outcome FROM calls WHERE call_sid = ? AND company_id = ?
+`
+
+type GetCompanyCallBySidParams struct {
+	CallSid   string        `json:"call_sid"`
+	CompanyID sql.NullInt64 `json:"company_id"`
+}
+
+func (q *Queries) GetCompanyCallBySid(ctx context.Context, arg GetCompanyCallBySidParams) (Call, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCallBySid, arg.CallSid, arg.CompanyID)
+	var i Call
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.Direction,
+		&i.FromNumber,
+		&i.ToNumber,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.Status,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.DurationSeconds,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RecordingSid,
+		&i.RecordingUrl,
+		&i.RecordingDuration,
+		&i.HoldStartedAt,
+		&i.HoldSeconds,
+		&i.HeldBy,
+		&i.HeldCallSid,
+		&i.AnsweredAt,
+		&i.CallRefID,
+		&i.ParentCallSid,
+		&i.AnsweredBy,
+		&i.AmdOutcome,
+	)
+	return i, err
+}
+
+const getCompanyCallRef = `-- name: GetCompanyCallRef :one
+SELECT id, call_sid, created_at, name_recording_url, company_id FROM call_refs WHERE id = ? AND company_id = ?
+`
+
+type GetCompanyCallRefParams struct {
+	ID        string        `json:"id"`
+	CompanyID sql.NullInt64 `json:"company_id"`
+}
+
+func (q *Queries) GetCompanyCallRef(ctx context.Context, arg GetCompanyCallRefParams) (CallRef, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCallRef, arg.ID, arg.CompanyID)
+	var i CallRef
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.CreatedAt,
+		&i.NameRecordingUrl,
+		&i.CompanyID,
+	)
+	return i, err
+}
+
+const getCompanyCallRefBySid = `-- name: GetCompanyCallRefBySid :one
+SELECT id, call_sid, created_at, name_recording_url, company_id FROM call_refs WHERE call_sid = ? AND company_id = ?
+`
+
+type GetCompanyCallRefBySidParams struct {
+	CallSid   sql.NullString `json:"call_sid"`
+	CompanyID sql.NullInt64  `json:"company_id"`
+}
+
+func (q *Queries) GetCompanyCallRefBySid(ctx context.Context, arg GetCompanyCallRefBySidParams) (CallRef, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCallRefBySid, arg.CallSid, arg.CompanyID)
+	var i CallRef
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.CreatedAt,
+		&i.NameRecordingUrl,
+		&i.CompanyID,
+	)
+	return i, err
+}
+
+const getCompanyCallerNumber = `-- name: GetCompanyCallerNumber :one
+
+SELECT id, company_id, phone, label, routing_type, announcement, created_at, updated_at FROM phone_numbers WHERE company_id = ?
+ORDER BY routing_type != 'agents', id
+LIMIT 1
+`
+
+// Numbers agents answer come first, so calling back reaches someone
+func (q *Queries) GetCompanyCallerNumber(ctx context.Context, companyID int64) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCallerNumber, companyID)
+	var i PhoneNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCompanyCustomer = `-- name: GetCompanyCustomer :one
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type GetCompanyCustomerParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) GetCompanyCustomer(ctx context.Context, arg GetCompanyCustomerParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCustomer, arg.ID, arg.CompanyID)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCompanyCustomerWithDeleted = `-- name: GetCompanyCustomerWithDeleted :one
+
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE id = ? AND company_id = ?
+`
+
+type GetCompanyCustomerWithDeletedParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+// For following old calls and messages to a customer who has since been deleted
+func (q *Queries) GetCompanyCustomerWithDeleted(ctx context.Context, arg GetCompanyCustomerWithDeletedParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyCustomerWithDeleted, arg.ID, arg.CompanyID)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCompanyPhoneNumber = `-- name: GetCompanyPhoneNumber :one
+SELECT id, company_id, phone, label, routing_type, announcement, created_at, updated_at FROM phone_numbers WHERE id = ? AND company_id = ?
+`
+
+type GetCompanyPhoneNumberParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) GetCompanyPhoneNumber(ctx context.Context, arg GetCompanyPhoneNumberParams) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, getCompanyPhoneNumber, arg.ID, arg.CompanyID)
+	var i PhoneNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCompanySetting = `-- name: GetCompanySetting :one
+
+SELECT value FROM company_settings WHERE company_id = ? AND key = ?
+`
+
+type GetCompanySettingParams struct {
+	CompanyID int64  `json:"company_id"`
+	Key       string `json:"key"`
+}
+
+// -----------------------
+// Company Setting Queries
+// -----------------------
+func (q *Queries) GetCompanySetting(ctx context.Context, arg GetCompanySettingParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, getCompanySetting, arg.CompanyID, arg.Key)
+	var value string
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getCustomerByEmail = `-- name: GetCustomerByEmail :one
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE email = ? AND deleted_at IS NULL
+`
+
+func (q *Queries) GetCustomerByEmail(ctx context.Context, email sql.NullString) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCustomerByEmail, email)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCustomerByID = `-- name: GetCustomerByID :one
+
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE id = ? AND deleted_at IS NULL
+`
+
+// -----------------------
+// Customer Queries
+// -----------------------
+func (q *Queries) GetCustomerByID(ctx context.Context, id int64) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCustomerByID, id)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCustomerByNormalizedPhone = `-- name: GetCustomerByNormalizedPhone :one
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers
+WHERE phone_normalized = ? AND company_id = ?
+  AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT 1
+`
+
+type GetCustomerByNormalizedPhoneParams struct {
+	PhoneNormalized sql.NullString `json:"phone_normalized"`
+	CompanyID       int64          `json:"company_id"`
+}
+
+func (q *Queries) GetCustomerByNormalizedPhone(ctx context.Context, arg GetCustomerByNormalizedPhoneParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCustomerByNormalizedPhone, arg.PhoneNormalized, arg.CompanyID)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCustomerByPhoneAndCompany = `-- name: GetCustomerByPhoneAndCompany :one
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE phone = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type GetCustomerByPhoneAndCompanyParams struct {
+	Phone     sql.NullString `json:"phone"`
+	CompanyID int64          `json:"company_id"`
+}
+
+func (q *Queries) GetCustomerByPhoneAndCompany(ctx context.Context, arg GetCustomerByPhoneAndCompanyParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, getCustomerByPhoneAndCompany, arg.Phone, arg.CompanyID)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.MedicalAidProvider,
+		&i.MedicalAidNumber,
+		&i.MedicalPlan,
+		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
+	)
+	return i, err
+}
+
+const getCustomerPremiumsByCustomerID = `-- name: GetCustomerPremiumsByCustomerID :many
+
+SELECT id, customer_id, premium_amount, effective_date, created_at FROM customer_premiums WHERE customer_id = ? ORDER BY effective_date DESC
+`
+
+// -----------------------
+// Customer Premium Queries
+// -----------------------
+func (q *Queries) GetCustomerPremiumsByCustomerID(ctx context.Context, customerID int64) ([]CustomerPremium, error) {
+	rows, err := q.db.QueryContext(ctx, getCustomerPremiumsByCustomerID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CustomerPremium{}
+	for rows.Next() {
+		var i CustomerPremium
+		if err := rows.Scan(
+			&i.ID,
+			&i.CustomerID,
+			&i.PremiumAmount,
+			&i.EffectiveDate,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCustomersByCompany = `-- name: GetCustomersByCompany :many
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers WHERE company_id = ? AND deleted_at IS NULL ORDER BY created_at DESC
+`
+
+func (q *Queries) GetCustomersByCompany(ctx context.Context, companyID int64) ([]Customer, error) {
+	rows, err := q.db.QueryContext(ctx, getCustomersByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Customer{}
+	for rows.Next() {
+		var i Customer
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.MedicalAidProvider,
+			&i.MedicalAidNumber,
+			&i.MedicalPlan,
+			&i.CreatedAt,
+			&i.IsVip,
+			&i.DeletedAt,
+			&i.UpdatedAt,
+			&i.PhoneNormalized,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT id, user_id, idempotency_key, method, path, request_hash, status_code, content_type, response_body, created_at, expires_at FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?
+`
+
+type GetIdempotencyKeyParams struct {
+	UserID         int64  `json:"user_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.UserID, arg.IdempotencyKey)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.IdempotencyKey,
+		&i.Method,
+		&i.Path,
+		&i.RequestHash,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getLatestVoicemailByCustomer = `-- name: GetLatestVoicemailByCustomer :one
+SELECT id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at FROM voicemails
+WHERE customer_id = ? AND company_id = ?
+ORDER BY created_at DESC, id DESC
+LIMIT 1
+`
+
+type GetLatestVoicemailByCustomerParams struct {
+	CustomerID sql.NullInt64 `json:"customer_id"`
+	CompanyID  int64         `json:"company_id"`
+}
+
+func (q *Queries) GetLatestVoicemailByCustomer(ctx context.Context, arg GetLatestVoicemailByCustomerParams) (Voicemail, error) {
+	row := q.db.QueryRowContext(ctx, getLatestVoicemailByCustomer, arg.CustomerID, arg.CompanyID)
+	var i Voicemail
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.CustomerID,
+		&i.CallSid,
+		&i.FromNumber,
+		&i.RecordingUrl,
+		&i.Duration,
+		&i.DeletedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReadAt,
+	)
+	return i, err
+}
+
+const getPasswordReset = `-- name: GetPasswordReset :one
+SELECT token_hash, user_id, expires_at, used_at, created_at FROM password_resets WHERE token_hash = ?
+`
+
+func (q *Queries) GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	row := q.db.QueryRowContext(ctx, getPasswordReset, tokenHash)
+	var i PasswordReset
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPhoneNumberByPhone = `-- name: GetPhoneNumberByPhone :one
+
+SELECT id, company_id, phone, label, routing_type, announcement, created_at, updated_at FROM phone_numbers WHERE phone = ?
+`
+
+// -----------------------
+// Phone Number Queries
+// -----------------------
+func (q *Queries) GetPhoneNumberByPhone(ctx context.Context, phone string) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, getPhoneNumberByPhone, phone)
+	var i PhoneNumber
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Phone,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getQueueEntryBySid = `-- name: GetQueueEntryBySid :one
+SELECT id, call_sid, phone, status, position, queued_at, updated_at, company_id FROM queue_entries WHERE call_sid = ?
+`
+
+func (q *Queries) GetQueueEntryBySid(ctx context.Context, callSid string) (QueueEntry, error) {
+	row := q.db.QueryRowContext(ctx, getQueueEntryBySid, callSid)
+	var i QueueEntry
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.Phone,
+		&i.Status,
+		&i.Position,
+		&i.QueuedAt,
+		&i.UpdatedAt,
+		&i.CompanyID,
+	)
+	return i, err
+}
+
+const getSession = `-- name: GetSession :one
+SELECT id, user_id, created_at, expires_at, user_agent, ip_address FROM sessions WHERE id = ?
+`
+
+func (q *Queries) GetSession(ctx context.Context, id string) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getSession, id)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UserAgent,
+		&i.IpAddress,
+	)
+	return i, err
+}
+
+const getUserByAgentID = `-- name: GetUserByAgentID :one
+
+SELECT id, email, password_hash, firstname, lastname, agent_id, company_id, created_at, deleted_at, updated_at, email_verified, failed_login_attempts, locked_until, role, caller_number_id FROM users WHERE agent_id = ?
+`
+
+// Includes deleted users: agent ids are never handed out twice
+func (q *Queries) GetUserByAgentID(ctx context.Context, agentID string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByAgentID, agentID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Firstname,
+		&i.Lastname,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.EmailVerified,
+		&i.FailedLoginAttempts,
+		&i.LockedUntil,
+		&i.Role,
+		&i.CallerNumberID,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+
+SELECT u.id, u.email, u.password_hash, u.firstname, u.lastname, u.agent_id, u.company_id, u.created_at, u.deleted_at, u.updated_at, u.email_verified, u.failed_login_attempts, u.locked_until, u.role, u.caller_number_id FROM users u
+JOIN companies c ON c.id = u.company_id
+WHERE u.email = ? AND u.deleted_at IS NULL AND c.deleted_at IS NULL
+`
+
+// Users of a deleted company are gone along with it
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Firstname,
+		&i.Lastname,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.EmailVerified,
+		&i.FailedLoginAttempts,
+		&i.LockedUntil,
+		&i.Role,
+		&i.CallerNumberID,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+
+SELECT u.id, u.email, u.password_hash, u.firstname, u.lastname, u.agent_id, u.company_id, u.created_at, u.deleted_at, u.updated_at, u.email_verified, u.failed_login_attempts, u.locked_until, u.role, u.caller_number_id FROM users u
+JOIN companies c ON c.id = u.company_id
+WHERE u.id = ? AND u.deleted_at IS NULL AND c.deleted_at IS NULL
+`
+
+// Users of a deleted company are gone along with it
+func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Firstname,
+		&i.Lastname,
+		&i.AgentID,
+		&i.CompanyID,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.EmailVerified,
+		&i.FailedLoginAttempts,
+		&i.LockedUntil,
+		&i.Role,
+		&i.CallerNumberID,
+	)
+	return i, err
+}
+
+const getVoicemail = `-- name: GetVoicemail :one
+
+SELECT id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at FROM voicemails WHERE id = ? AND company_id = ?
+`
+
+type GetVoicemailParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+// -----------------------
+// Voicemail Queries
+// -----------------------
+func (q *Queries) GetVoicemail(ctx context.Context, arg GetVoicemailParams) (Voicemail, error) {
+	row := q.db.QueryRowContext(ctx, getVoicemail, arg.ID, arg.CompanyID)
+	var i Voicemail
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.CustomerID,
+		&i.CallSid,
+		&i.FromNumber,
+		&i.RecordingUrl,
+		&i.Duration,
+		&i.DeletedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReadAt,
+	)
+	return i, err
+}
+
+const getVoicemailDrop = `-- name: GetVoicemailDrop :one
+SELECT id, company_id, agent_id, name, token, content_type, created_at FROM voicemail_drops
+WHERE id = ? AND agent_id = ? AND company_id = ?
+`
+
+type GetVoicemailDropParams struct {
+	ID        int64  `json:"id"`
+	AgentID   string `json:"agent_id"`
+	CompanyID int64  `json:"company_id"`
+}
+
+type GetVoicemailDropRow struct {
+	ID          int64        `json:"id"`
+	CompanyID   int64        `json:"company_id"`
+	AgentID     string       `json:"agent_id"`
+	Name        string       `json:"name"`
+	Token       string       `json:"token"`
+	ContentType string       `json:"content_type"`
+	CreatedAt   sql.NullTime `json:"created_at"`
+}
+
+func (q *Queries) GetVoicemailDrop(ctx context.Context, arg GetVoicemailDropParams) (GetVoicemailDropRow, error) {
+	row := q.db.QueryRowContext(ctx, getVoicemailDrop, arg.ID, arg.AgentID, arg.CompanyID)
+	var i GetVoicemailDropRow
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.AgentID,
+		&i.Name,
+		&i.Token,
+		&i.ContentType,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getVoicemailDropAudio = `-- name: GetVoicemailDropAudio :one
+SELECT content_type, audio FROM voicemail_drops WHERE token = ?
+`
+
+type GetVoicemailDropAudioRow struct {
+	ContentType string `json:"content_type"`
+	Audio       []byte `json:"audio"`
+}
+
+func (q *Queries) GetVoicemailDropAudio(ctx context.Context, token string) (GetVoicemailDropAudioRow, error) {
+	row := q.db.QueryRowContext(ctx, getVoicemailDropAudio, token)
+	var i GetVoicemailDropAudioRow
+	err := row.Scan(&i.ContentType, &i.Audio)
+	return i, err
+}
+
+const incrementFailedLogins = `-- name: IncrementFailedLogins :one
+
+UPDATE users SET
+    failed_login_attempts = CASE WHEN failed_login_attempts + 1 >= ?1 THEN 0 ELSE failed_login_attempts + 1 END,
+    locked_until = CASE WHEN failed_login_attempts + 1 >= ?1 THEN ?2 ELSE locked_until END
+WHERE id = ?3
+RETURNING locked_until
+`
+
+type IncrementFailedLoginsParams struct {
+	MaxAttempts int64        `json:"max_attempts"`
+	LockedUntil sql.NullTime `json:"locked_until"`
+	ID          int64        `json:"id"`
+}
+
+// Counts a failed password. The failure that reaches max_attempts locks the
+// account until locked_until and starts the count over.
+func (q *Queries) IncrementFailedLogins(ctx context.Context, arg IncrementFailedLoginsParams) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, incrementFailedLogins, arg.MaxAttempts, arg.LockedUntil, arg.ID)
+	var locked_until sql.NullTime
+	err := row.Scan(&locked_until)
+	return locked_until, err
+}
+
+const joinConferenceParticipant = `-- name: JoinConferenceParticipant :execrows
+UPDATE conference_participants
+SET status = 'joined', conference_sid = ?, joined_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND call_sid = ?
+`
+
+type JoinConferenceParticipantParams struct {
+	ConferenceSid  sql.NullString `json:"conference_sid"`
+	ConferenceName string         `json:"conference_name"`
+	CallSid        string         `json:"call_sid"`
+}
+
+func (q *Queries) JoinConferenceParticipant(ctx context.Context, arg JoinConferenceParticipantParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, joinConferenceParticipant, arg.ConferenceSid, arg.ConferenceName, arg.CallSid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const leaveConferenceParticipant = `-- name: LeaveConferenceParticipant :execrows
+UPDATE conference_participants
+SET status = 'left', left_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND call_sid = ? AND status != 'left'
+`
+
+type LeaveConferenceParticipantParams struct {
+	ConferenceName string `json:"conference_name"`
+	CallSid        string `json:"call_sid"`
+}
+
+func (q *Queries) LeaveConferenceParticipant(ctx context.Context, arg LeaveConferenceParticipantParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, leaveConferenceParticipant, arg.ConferenceName, arg.CallSid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const leaveQueue = `-- name: LeaveQueue :exec
+UPDATE queue_entries SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status IN ('waiting', 'declined')
+`
+
+type LeaveQueueParams struct {
+	Status  string `json:"status"`
+	CallSid string `json:"call_sid"`
+}
+
+func (q *Queries) LeaveQueue(ctx context.Context, arg LeaveQueueParams) error {
+	_, err := q.db.ExecContext(ctx, leaveQueue, arg.Status, arg.CallSid)
+	return err
+}
+
+const listAgentIDsByCompany = `-- name: ListAgentIDsByCompany :many
+
+SELECT u.agent_id FROM users u
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL AND s.presence = 'online'
+  AND (s.dnd_until IS NULL OR datetime(s.dnd_until) <= datetime('now'))
+  AND (s.wrap_up_until IS NULL OR datetime(s.wrap_up_until) <= datetime('now'))
+ORDER BY u.id
+`
+
+// -----------------------
+// Routing Queries
+// -----------------------
+func (q *Queries) ListAgentIDsByCompany(ctx context.Context, companyID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listAgentIDsByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var agent_id string
+		if err := rows.Scan(&agent_id); err != nil {
+			return nil, err
+		}
+		items = append(items, agent_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAgentIDsByIdleTimeAndCompany = `-- name: ListAgentIDsByIdleTimeAndCompany :many
+SELECT u.agent_id FROM users u
+LEFT JOIN agent_activity a ON a.agent_id = u.agent_id
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL AND s.presence = 'online'
+  AND (s.dnd_until IS NULL OR datetime(s.dnd_until) <= datetime('now'))
+  AND (s.wrap_up_until IS NULL OR datetime(s.wrap_up_until) <= datetime('now'))
+ORDER BY a.last_call_at IS NOT NULL, a.last_call_at, u.id
+`
+
+func (q *Queries) ListAgentIDsByIdleTimeAndCompany(ctx context.Context, companyID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listAgentIDsByIdleTimeAndCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var agent_id string
+		if err := rows.Scan(&agent_id); err != nil {
+			return nil, err
+		}
+		items = append(items, agent_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAgentStatusesByCompany = `-- name: ListAgentStatusesByCompany :many
+SELECT u.agent_id, s.dnd_until, s.updated_at, s.wrap_up_call_sid, s.wrap_up_until, s.presence, s.last_seen_at
+FROM users u
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL
+ORDER BY u.id
+`
+
+type ListAgentStatusesByCompanyRow struct {
+	AgentID       string         `json:"agent_id"`
+	DndUntil      sql.NullTime   `json:"dnd_until"`
+	UpdatedAt     sql.NullTime   `json:"updated_at"`
+	WrapUpCallSid sql.NullString `json:"wrap_up_call_sid"`
+	WrapUpUntil   sql.NullTime   `json:"wrap_up_until"`
+	Presence      sql.NullString `json:"presence"`
+	LastSeenAt    sql.NullTime   `json:"last_seen_at"`
+}
+
+func (q *Queries) ListAgentStatusesByCompany(ctx context.Context, companyID int64) ([]ListAgentStatusesByCompanyRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAgentStatusesByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAgentStatusesByCompanyRow{}
+	for rows.Next() {
+		var i ListAgentStatusesByCompanyRow
+		if err := rows.Scan(
+			&i.AgentID,
+			&i.DndUntil,
+			&i.UpdatedAt,
+			&i.WrapUpCallSid,
+			&i.WrapUpUntil,
+			&i.Presence,
+			&i.LastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEntries = `-- name: ListAuditEntries :many
+SELECT id, company_id, actor, action, target, details, created_at FROM audit_log
+WHERE company_id = ?1
+  AND (?2 IS NULL OR actor = ?2)
+  AND (?3 IS NULL OR action = ?3)
+  AND (?4 IS NULL OR created_at >= ?4)
+  AND (?5 IS NULL OR created_at < ?5)
+ORDER BY created_at DESC, id DESC
+LIMIT ?6 OFFSET ?7
+`
+
+type ListAuditEntriesParams struct {
+	CompanyID int64          `json:"company_id"`
+	Actor     sql.NullString `json:"actor"`
+	Action    sql.NullString `json:"action"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+	Limit     int64          `json:"limit"`
+	Offset    int64          `json:"offset"`
+}
+
+func (q *Queries) ListAuditEntries(ctx context.Context, arg ListAuditEntriesParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEntries,
+		arg.CompanyID,
+		arg.Actor,
+		arg.Action,
+		arg.Since,
+		arg.Until,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuditLog{}
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.Actor,
+			&i.Action,
+			&i.Target,
+			&i.Details,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBlockedNumbersByCompany = `-- name: ListBlockedNumbersByCompany :many
+SELECT id, company_id, phone, reason, created_at FROM blocked_numbers WHERE company_id = ? ORDER BY created_at DESC
+`
+
+func (q *Queries) ListBlockedNumbersByCompany(ctx context.Context, companyID int64) ([]BlockedNumber, error) {
+	rows, err := q.db.QueryContext(ctx, listBlockedNumbersByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BlockedNumber{}
+	for rows.Next() {
+		var i BlockedNumber
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.Phone,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBusinessHolidays = `-- name: ListBusinessHolidays :many
+SELECT company_id, date, name FROM business_holidays WHERE company_id = ? ORDER BY date
+`
+
+func (q *Queries) ListBusinessHolidays(ctx context.Context, companyID int64) ([]BusinessHoliday, error) {
+	rows, err := q.db.QueryContext(ctx, listBusinessHolidays, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BusinessHoliday{}
+	for rows.Next() {
+		var i BusinessHoliday
+		if err := rows.Scan(
+			&i.CompanyID,
+			&i.Date,
+			&i.Name,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBusinessScheduleWindows = `-- name: ListBusinessScheduleWindows :many
+SELECT id, company_id, day, opens, closes FROM business_schedule_windows WHERE company_id = ? ORDER BY day, opens
+`
+
+func (q *Queries) ListBusinessScheduleWindows(ctx context.Context, companyID int64) ([]BusinessScheduleWindow, error) {
+	rows, err := q.db.QueryContext(ctx, listBusinessScheduleWindows, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BusinessScheduleWindow{}
+	for rows.Next() {
+		var i BusinessScheduleWindow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.Day,
+			&i.Opens,
+			&i.Closes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCallDispositions = `-- name: ListCallDispositions :many
+SELECT d.id, d.agent_id, u.firstname, u.lastname, d.disposition, d.notes, d.created_at
+FROM call_dispositions d
+JOIN users u ON u.agent_id = d.agent_id
+WHERE d.call_sid = ? AND d.company_id = ?
+ORDER BY d.created_at, d.id
+`
+
+type ListCallDispositionsParams struct {
+	CallSid   string `json:"call_sid"`
+	CompanyID int64  `json:"company_id"`
+}
+
+type ListCallDispositionsRow struct {
+	ID          int64          `json:"id"`
+	AgentID     string         `json:"agent_id"`
+	Firstname   string         `json:"firstname"`
+	Lastname    string         `json:"lastname"`
+	Disposition string         `json:"disposition"`
+	Notes       sql.NullString `json:"notes"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
+}
+
+func (q *Queries) ListCallDispositions(ctx context.Context, arg ListCallDispositionsParams) ([]ListCallDispositionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCallDispositions, arg.CallSid, arg.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListCallDispositionsRow{}
+	for rows.Next() {
+		var i ListCallDispositionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AgentID,
+			&i.Firstname,
+			&i.Lastname,
+			&i.Disposition,
+			&i.Notes,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCallErrors = `-- name: ListCallErrors :many
+SELECT id, company_id, call_sid, error_code, error_url, from_number, to_number, created_at FROM call_errors
+WHERE company_id = ?1
+  AND (?2 IS NULL OR error_code = ?2)
+  AND (?3 IS NULL OR created_at >= ?3)
+  AND (?4 IS NULL OR created_at < ?4)
+ORDER BY created_at DESC, id DESC
+LIMIT ?5 OFFSET ?6
+`
+
+type ListCallErrorsParams struct {
+	CompanyID sql.NullInt64  `json:"company_id"`
+	ErrorCode sql.NullString `json:"error_code"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+	Limit     int64          `json:"limit"`
+	Offset    int64          `json:"offset"`
+}
+
+func (q *Queries) ListCallErrors(ctx context.Context, arg ListCallErrorsParams) ([]CallError, error) {
+	rows, err := q.db.QueryContext(ctx, listCallErrors,
+		arg.CompanyID,
+		arg.ErrorCode,
+		arg.Since,
+		arg.Until,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CallError{}
+	for rows.Next() {
+		var i CallError
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.CallSid,
+			&i.ErrorCode,
+			&i.ErrorUrl,
+			&i.FromNumber,
+			&i.ToNumber,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCallTranscriptionsBySidAndCompany = `-- name: ListCallTranscriptionsBySidAndCompany :many
+
+SELECT ct.id, ct.customer_id, ct.agent_id, ct.call_sid, ct.transcript, ct.summary, ct.created_at FROM call_transcriptions ct
+JOIN customers c ON c.id = ct.customer_id
+WHERE ct.call_sid = ? AND c.company_id = ?
+ORDER BY ct.created_at ASC
+`
+
+type ListCallTranscriptionsBySidAndCompanyParams struct {
+	CallSid   sql.NullString `json:"call_sid"`
+	CompanyID int64          `json:"company_id"`
+}
+
+// -----------------------
+// Call Transcription Queries
+// -----------------------
+func (q *Queries) ListCallTranscriptionsBySidAndCompany(ctx context.Context, arg ListCallTranscriptionsBySidAndCompanyParams) ([]CallTranscription, error) {
+	rows, err := q.db.QueryContext(ctx, listCallTranscriptionsBySidAndCompany, arg.CallSid, arg.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CallTranscription{}
+	for rows.Next() {
+		var i CallTranscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.CustomerID,
+			&i.AgentID,
+			&i.CallSid,
+			&i.Transcript,
+			&i.Summary,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCallsByCompany = `-- name: ListCallsByCompany :many
+SELECT id, call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at, ended_at, duration_seconds, created_at, updated_at, recording_sid, recording_url, recording_duration, hold_started_at, hold_seconds, held_by, held_call_sid, answered_at, call_ref_id, parent_call_sid, answered_by, amd_outcome FROM calls
+WHERE company_id = ?1 AND parent_call_sid IS NULL
+  AND (?2 IS NULL OR agent_id = ?2)
+  AND (?3 IS NULL OR started_at >= ?3)
+  AND (?4 IS NULL OR started_at < ?4)
+ORDER BY started_at DESC, id DESC
+LIMIT ?5 OFFSET ?6
+`
+
+type ListCallsByCompanyParams struct {
+	CompanyID sql.NullInt64  `json:"company_id"`
+	AgentID   sql.NullString `json:"agent_id"`
+	Since     sql.NullTime   `json:"since"`
+	Until     sql.NullTime   `json:"until"`
+	Limit     int64          `json:"limit"`
+	Offset    int64          `json:"offset"`
+}
+
+func (q *Queries) ListCallsByCompany(ctx context.Context, arg ListCallsByCompanyParams) ([]Call, error) {
+	rows, err := q.db.QueryContext(ctx, listCallsByCompany,
+		arg.CompanyID,
+		arg.AgentID,
+		arg.Since,
+		arg.Until,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Call{}
+	for rows.Next() {
+		var i Call
+		if err := rows.Scan(
+			&i.ID,
+			&i.CallSid,
+			&i.Direction,
+			&i.FromNumber,
+			&i.ToNumber,
+			&i.AgentID,
+			&i.CompanyID,
+			&i.Status,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.DurationSeconds,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RecordingSid,
+			&i.RecordingUrl,
+			&i.RecordingDuration,
+			&i.HoldStartedAt,
+			&i.HoldSeconds,
+			&i.HeldBy,
+			&i.HeldCallSid,
+			&i.AnsweredAt,
+			&i.CallRefID,
+			&i.ParentCallSid,
+			&i.AnsweredBy,
+			&i.AmdOutcome,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCompaniesPaginated = `-- name: ListCompaniesPaginated :many
+
+SELECT id, name, created_at, deleted_at, updated_at FROM companies
+WHERE (deleted_at IS NULL OR (CAST(?1 AS BOOLEAN) AND id = ?2))
+    AND (?3 = '' OR instr(lower(name), lower(?3)) > 0)
+ORDER BY created_at DESC, id DESC
+LIMIT ?4 OFFSET ?5
+`
+
+type ListCompaniesPaginatedParams struct {
+	IncludeDeleted bool   `json:"include_deleted"`
+	CompanyID      int64  `json:"company_id"`
+	Search         string `json:"search"`
+	Limit          int64  `json:"limit"`
+	Offset         int64  `json:"offset"`
+}
+
+// search matches anywhere in the name, ignoring case; empty matches all.
+// include_deleted only brings back the caller's own company.
+func (q *Queries) ListCompaniesPaginated(ctx context.Context, arg ListCompaniesPaginatedParams) ([]Company, error) {
+	rows, err := q.db.QueryContext(ctx, listCompaniesPaginated,
+		arg.IncludeDeleted,
+		arg.CompanyID,
+		arg.Search,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Company{}
+	for rows.Next() {
+		var i Company
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.DeletedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCompanySettings = `-- name: ListCompanySettings :many
+SELECT company_id, "key", value, updated_at FROM company_settings WHERE company_id = ? ORDER BY key
+`
+
+func (q *Queries) ListCompanySettings(ctx context.Context, companyID int64) ([]CompanySetting, error) {
+	rows, err := q.db.QueryContext(ctx, listCompanySettings, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CompanySetting{}
+	for rows.Next() {
+		var i CompanySetting
+		if err := rows.Scan(
+			&i.CompanyID,
+			&i.Key,
+			&i.Value,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCompanySettingsByKey = `-- name: ListCompanySettingsByKey :many
+SELECT company_id, "key", value, updated_at FROM company_settings WHERE key = ?
+`
+
+func (q *Queries) ListCompanySettingsByKey(ctx context.Context, key string) ([]CompanySetting, error) {
+	rows, err := q.db.QueryContext(ctx, listCompanySettingsByKey, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CompanySetting{}
+	for rows.Next() {
+		var i CompanySetting
+		if err := rows.Scan(
+			&i.CompanyID,
+			&i.Key,
+			&i.Value,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listConversationsByCompany = `-- name: ListConversationsByCompany :many
+
+SELECT m.customer_id, c.first_name, c.last_name, c.phone,
+       m.id AS last_message_id, m.direction, m.body, m.status, m.created_at,
+       (SELECT COUNT(*) FROM messages t WHERE t.company_id = m.company_id AND t.customer_id = m.customer_id) AS message_count
+FROM messages m
+JOIN customers c ON c.id = m.customer_id
+WHERE m.company_id = ?
+  AND m.id = (SELECT MAX(l.id) FROM messages l WHERE l.company_id = m.company_id AND l.customer_id = m.customer_id)
+ORDER BY m.id DESC
+`
+
+type ListConversationsByCompanyRow struct {
+	CustomerID    sql.NullInt64  `json:"customer_id"`
+	FirstName     string         `json:"first_name"`
+	LastName      string         `json:"last_name"`
+	Phone         sql.NullString `json:"phone"`
+	LastMessageID int64          `json:"last_message_id"`
+	Direction     string         `json:"direction"`
+	Body          string         `json:"body"`
+	Status        string         `json:"status"`
+	CreatedAt     sql.NullTime   `json:"created_at"`
+	MessageCount  int64          `json:"message_count"`
+}
+
+// One row per customer who has texted or been texted, newest first, with
+// the last message of the thread
+func (q *Queries) ListConversationsByCompany(ctx context.Context, companyID int64) ([]ListConversationsByCompanyRow, error) {
+	rows, err := q.db.QueryContext(ctx, listConversationsByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListConversationsByCompanyRow{}
+	for rows.Next() {
+		var i ListConversationsByCompanyRow
+		if err := rows.Scan(
+			&i.CustomerID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Phone,
+			&i.LastMessageID,
+			&i.Direction,
+			&i.Body,
+			&i.Status,
+			&i.CreatedAt,
+			&i.MessageCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCustomersByCompany = `-- name: ListCustomersByCompany :many
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers
+WHERE company_id = ?1 AND (deleted_at IS NULL OR CAST(?2 AS BOOLEAN))
+ORDER BY last_name, first_name, id
+LIMIT ?3 OFFSET ?4
+`
+
+type ListCustomersByCompanyParams struct {
+	CompanyID      int64 `json:"company_id"`
+	IncludeDeleted bool  `json:"include_deleted"`
+	Limit          int64 `json:"limit"`
+	Offset         int64 `json:"offset"`
+}
+
+func (q *Queries) ListCustomersByCompany(ctx context.Context, arg ListCustomersByCompanyParams) ([]Customer, error) {
+	rows, err := q.db.QueryContext(ctx, listCustomersByCompany,
+		arg.CompanyID,
+		arg.IncludeDeleted,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Customer{}
+	for rows.Next() {
+		var i Customer
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.MedicalAidProvider,
+			&i.MedicalAidNumber,
+			&i.MedicalPlan,
+			&i.CreatedAt,
+			&i.IsVip,
+			&i.DeletedAt,
+			&i.UpdatedAt,
+			&i.PhoneNormalized,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesByCustomer = `-- name: ListMessagesByCustomer :many
+SELECT id, message_sid, company_id, direction, from_number, to_number, body, status, agent_id, error_message, created_at, updated_at, customer_id FROM messages
+WHERE company_id = ? AND customer_id = ?
+ORDER BY created_at, id
+LIMIT ? OFFSET ?
+`
+
+type ListMessagesByCustomerParams struct {
+	CompanyID  int64         `json:"company_id"`
+	CustomerID sql.NullInt64 `json:"customer_id"`
+	Limit      int64         `json:"limit"`
+	Offset     int64         `json:"offset"`
+}
+
+func (q *Queries) ListMessagesByCustomer(ctx context.Context, arg ListMessagesByCustomerParams) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listMessagesByCustomer,
+		arg.CompanyID,
+		arg.CustomerID,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Message{}
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageSid,
+			&i.CompanyID,
+			&i.Direction,
+			&i.FromNumber,
+			&i.ToNumber,
+			&i.Body,
+			&i.Status,
+			&i.AgentID,
+			&i.ErrorMessage,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CustomerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listParkedCalls = `-- name: ListParkedCalls :many
+SELECT id, company_id, code, call_sid, phone, parked_by, retrieved_by, status, expires_at, created_at, updated_at FROM parked_calls WHERE company_id = ? AND status = 'parked' ORDER BY created_at
+`
+
+func (q *Queries) ListParkedCalls(ctx context.Context, companyID int64) ([]ParkedCall, error) {
+	rows, err := q.db.QueryContext(ctx, listParkedCalls, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ParkedCall{}
+	for rows.Next() {
+		var i ParkedCall
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.Code,
+			&i.CallSid,
+			&i.Phone,
+			&i.ParkedBy,
+			&i.RetrievedBy,
+			&i.Status,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPhoneNumbersByCompany = `-- name: ListPhoneNumbersByCompany :many
+SELECT id, company_id, phone, label, routing_type, announcement, created_at, updated_at FROM phone_numbers WHERE company_id = ? ORDER BY phone
+`
+
+func (q *Queries) ListPhoneNumbersByCompany(ctx context.Context, companyID int64) ([]PhoneNumber, error) {
+	rows, err := q.db.QueryContext(ctx, listPhoneNumbersByCompany, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []PhoneNumber{}
+	for rows.Next() {
+		var i PhoneNumber
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.Phone,
+			&i.Label,
+			&i.RoutingType,
+			&i.Announcement,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listQueueCallbackCompanies = `-- name: ListQueueCallbackCompanies :many
+SELECT DISTINCT company_id FROM queue_entries
+WHERE status = 'callback' AND company_id IS NOT NULL
+ORDER BY company_id
+`
+
+func (q *Queries) ListQueueCallbackCompanies(ctx context.Context) ([]sql.NullInt64, error) {
+	rows, err := q.db.QueryContext(ctx, listQueueCallbackCompanies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []sql.NullInt64{}
+	for rows.Next() {
+		var company_id sql.NullInt64
+		if err := rows.Scan(&company_id); err != nil {
+			return nil, err
+		}
+		items = append(items, company_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessionsByUser = `-- name: ListSessionsByUser :many
+SELECT id, user_id, created_at, expires_at, user_agent, ip_address FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC
+`
+
+type ListSessionsByUserParams struct {
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) ListSessionsByUser(ctx context.Context, arg ListSessionsByUserParams) ([]Session, error) {
+	rows, err := q.db.QueryContext(ctx, listSessionsByUser, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Session{}
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.IpAddress,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByCompany = `-- name: ListUsersByCompany :many
+
+SELECT id, email, firstname, lastname, agent_id, role, caller_number_id, created_at FROM users
+WHERE company_id = ?1 AND deleted_at IS NULL
+    AND (?2 = ''
+        OR instr(lower(firstname || ' ' || lastname), lower(?2)) > 0
+        OR instr(lower(email), lower(?2)) > 0)
+ORDER BY lastname, firstname, id
+LIMIT ?3 OFFSET ?4
+`
+
+type ListUsersByCompanyParams struct {
+	CompanyID int64  `json:"company_id"`
+	Search    string `json:"search"`
+	Limit     int64  `json:"limit"`
+	Offset    int64  `json:"offset"`
+}
+
+type ListUsersByCompanyRow struct {
+	ID             int64         `json:"id"`
+	Email          string        `json:"email"`
+	Firstname      string        `json:"firstname"`
+	Lastname       string        `json:"lastname"`
+	AgentID        string        `json:"agent_id"`
+	Role           string        `json:"role"`
+	CallerNumberID sql.NullInt64 `json:"caller_number_id"`
+	CreatedAt      sql.NullTime  `json:"created_at"`
+}
+
+// Only what admins need to see, so the password hash never leaves the table
+func (q *Queries) ListUsersByCompany(ctx context.Context, arg ListUsersByCompanyParams) ([]ListUsersByCompanyRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByCompany,
+		arg.CompanyID,
+		arg.Search,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUsersByCompanyRow{}
+	for rows.Next() {
+		var i ListUsersByCompanyRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Firstname,
+			&i.Lastname,
+			&i.AgentID,
+			&i.Role,
+			&i.CallerNumberID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVoicemailDropsByAgent = `-- name: ListVoicemailDropsByAgent :many
+
+SELECT id, company_id, agent_id, name, token, content_type, created_at FROM voicemail_drops
+WHERE agent_id = ? AND company_id = ?
+ORDER BY name
+`
+
+type ListVoicemailDropsByAgentParams struct {
+	AgentID   string `json:"agent_id"`
+	CompanyID int64  `json:"company_id"`
+}
+
+type ListVoicemailDropsByAgentRow struct {
+	ID          int64        `json:"id"`
+	CompanyID   int64        `json:"company_id"`
+	AgentID     string       `json:"agent_id"`
+	Name        string       `json:"name"`
+	Token       string       `json:"token"`
+	ContentType string       `json:"content_type"`
+	CreatedAt   sql.NullTime `json:"created_at"`
+}
+
+// -----------------------
+// Voicemail Drop Queries
+// -----------------------
+func (q *Queries) ListVoicemailDropsByAgent(ctx context.Context, arg ListVoicemailDropsByAgentParams) ([]ListVoicemailDropsByAgentRow, error) {
+	rows, err := q.db.QueryContext(ctx, listVoicemailDropsByAgent, arg.AgentID, arg.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListVoicemailDropsByAgentRow{}
+	for rows.Next() {
+		var i ListVoicemailDropsByAgentRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.AgentID,
+			&i.Name,
+			&i.Token,
+			&i.ContentType,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVoicemailsByCompany = `-- name: ListVoicemailsByCompany :many
+SELECT id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at FROM voicemails
+WHERE company_id = ?1 AND deleted_at IS NULL
+  AND (NOT ?2 OR read_at IS NULL)
+ORDER BY created_at DESC, id DESC
+LIMIT ?3 OFFSET ?4
+`
+
+type ListVoicemailsByCompanyParams struct {
+	CompanyID  int64 `json:"company_id"`
+	UnreadOnly bool  `json:"unread_only"`
+	Limit      int64 `json:"limit"`
+	Offset     int64 `json:"offset"`
+}
+
+func (q *Queries) ListVoicemailsByCompany(ctx context.Context, arg ListVoicemailsByCompanyParams) ([]Voicemail, error) {
+	rows, err := q.db.QueryContext(ctx, listVoicemailsByCompany,
+		arg.CompanyID,
+		arg.UnreadOnly,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Voicemail{}
+	for rows.Next() {
+		var i Voicemail
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.CustomerID,
+			&i.CallSid,
+			&i.FromNumber,
+			&i.RecordingUrl,
+			&i.Duration,
+			&i.DeletedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVoicemailsForCleanup = `-- name: ListVoicemailsForCleanup :many
+SELECT id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at FROM voicemails
+WHERE company_id = ?1 AND deleted_at IS NULL
+  AND (?2 IS NULL OR created_at < ?2)
+  AND (?3 IS NULL OR customer_id = ?3)
+  AND (?4 IS NULL OR from_number = ?4)
+ORDER BY created_at, id
+`
+
+type ListVoicemailsForCleanupParams struct {
+	CompanyID  int64          `json:"company_id"`
+	Before     sql.NullTime   `json:"before"`
+	CustomerID sql.NullInt64  `json:"customer_id"`
+	FromNumber sql.NullString `json:"from_number"`
+}
+
+func (q *Queries) ListVoicemailsForCleanup(ctx context.Context, arg ListVoicemailsForCleanupParams) ([]Voicemail, error) {
+	rows, err := q.db.QueryContext(ctx, listVoicemailsForCleanup,
+		arg.CompanyID,
+		arg.Before,
+		arg.CustomerID,
+		arg.FromNumber,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Voicemail{}
+	for rows.Next() {
+		var i Voicemail
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.CustomerID,
+			&i.CallSid,
+			&i.FromNumber,
+			&i.RecordingUrl,
+			&i.Duration,
+			&i.DeletedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markCallAnswered = `-- name: MarkCallAnswered :exec
+
+UPDATE calls SET answered_at = COALESCE(answered_at, ?) WHERE call_sid = ?
+`
+
+type MarkCallAnsweredParams struct {
+	AnsweredAt sql.NullTime `json:"answered_at"`
+	CallSid    string       `json:"call_sid"`
+}
+
+// Kept from the first answer, however often the legs report it
+func (q *Queries) MarkCallAnswered(ctx context.Context, arg MarkCallAnsweredParams) error {
+	_, err := q.db.ExecContext(ctx, markCallAnswered, arg.AnsweredAt, arg.CallSid)
+	return err
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :exec
+UPDATE users SET email_verified = 1 WHERE id = ?
+`
+
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markUserEmailVerified, id)
+	return err
+}
+
+const markVoicemailDeleted = `-- name: MarkVoicemailDeleted :exec
+UPDATE voicemails SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ?
+`
+
+type MarkVoicemailDeletedParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) MarkVoicemailDeleted(ctx context.Context, arg MarkVoicemailDeletedParams) error {
+	_, err := q.db.ExecContext(ctx, markVoicemailDeleted, arg.ID, arg.CompanyID)
+	return err
+}
+
+const nextQueueEntry = `-- name: NextQueueEntry :one
+SELECT id, call_sid, phone, status, position, queued_at, updated_at, company_id FROM queue_entries
+WHERE company_id = ? AND status IN ('waiting', 'declined', 'callback')
+ORDER BY queued_at, id
+LIMIT 1
+`
+
+func (q *Queries) NextQueueEntry(ctx context.Context, companyID sql.NullInt64) (QueueEntry, error) {
+	row := q.db.QueryRowContext(ctx, nextQueueEntry, companyID)
+	var i QueueEntry
+	err := row.Scan(
+		&i.ID,
+		&i.CallSid,
+		&i.Phone,
+		&i.Status,
+		&i.Position,
+		&i.QueuedAt,
+		&i.UpdatedAt,
+		&i.CompanyID,
+	)
+	return i, err
+}
+
+const recordAgentCall = `-- name: RecordAgentCall :exec
+INSERT INTO agent_activity (agent_id, last_call_at) VALUES (?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET last_call_at = excluded.last_call_at
+`
+
+type RecordAgentCallParams struct {
+	AgentID    string    `json:"agent_id"`
+	LastCallAt time.Time `json:"last_call_at"`
+}
+
+func (q *Queries) RecordAgentCall(ctx context.Context, arg RecordAgentCallParams) error {
+	_, err := q.db.ExecContext(ctx, recordAgentCall, arg.AgentID, arg.LastCallAt)
+	return err
+}
+
+const releaseQueueCallback = `-- name: ReleaseQueueCallback :exec
+
+UPDATE queue_entries SET status = 'callback', updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status = 'connected'
+`
+
+// Puts back a callback that couldn't be placed, keeping its place in line
+func (q *Queries) ReleaseQueueCallback(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, releaseQueueCallback, id)
+	return err
+}
+
+const requestQueueCallback = `-- name: RequestQueueCallback :execrows
+UPDATE queue_entries SET status = 'callback', position = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status IN ('waiting', 'declined')
+`
+
+type RequestQueueCallbackParams struct {
+	Position sql.NullInt64 `json:"position"`
+	CallSid  string        `json:"call_sid"`
+}
+
+func (q *Queries) RequestQueueCallback(ctx context.Context, arg RequestQueueCallbackParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requestQueueCallback, arg.Position, arg.CallSid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :exec
+UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = ?
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, resetFailedLogins, id)
+	return err
+}
+
+const restoreCompany = `-- name: RestoreCompany :execrows
+UPDATE companies SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) RestoreCompany(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreCompany, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreCustomer = `-- name: RestoreCustomer :execrows
+UPDATE customers SET deleted_at = NULL WHERE id = ? AND company_id = ? AND deleted_at IS NOT NULL
+`
+
+type RestoreCustomerParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) RestoreCustomer(ctx context.Context, arg RestoreCustomerParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreCustomer, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreUser = `-- name: RestoreUser :execrows
+UPDATE users SET deleted_at = NULL WHERE id = ? AND company_id = ? AND deleted_at IS NOT NULL
+`
+
+type RestoreUserParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) RestoreUser(ctx context.Context, arg RestoreUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUser, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retrieveParkedCall = `-- name: RetrieveParkedCall :one
+UPDATE parked_calls SET status = 'retrieved', retrieved_by = ?, updated_at = CURRENT_TIMESTAMP
+WHERE company_id = ? AND code = ? AND status = 'parked'
+RETURNING id, company_id, code, call_sid, phone, parked_by, retrieved_by, status, expires_at, created_at, updated_at
+`
+
+type RetrieveParkedCallParams struct {
+	RetrievedBy sql.NullString `json:"retrieved_by"`
+	CompanyID   int64          `json:"company_id"`
+	Code        string         `json:"code"`
+}
+
+func (q *Queries) RetrieveParkedCall(ctx context.Context, arg RetrieveParkedCallParams) (ParkedCall, error) {
+	row := q.db.QueryRowContext(ctx, retrieveParkedCall, arg.RetrievedBy, arg.CompanyID, arg.Code)
+	var i ParkedCall
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.Code,
+		&i.CallSid,
+		&i.Phone,
+		&i.ParkedBy,
+		&i.RetrievedBy,
+		&i.Status,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const searchCustomers = `-- name: SearchCustomers :many
+
+SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes FROM customers
+WHERE company_id = ?1 AND (deleted_at IS NULL OR CAST(?2 AS BOOLEAN))
+  AND (first_name LIKE ?3 ESCAPE '\'
+    OR last_name LIKE ?3 ESCAPE '\'
+    OR first_name || ' ' || last_name LIKE ?3 ESCAPE '\'
+    OR email LIKE ?3 ESCAPE '\'
+    OR phone LIKE ?3 ESCAPE '\'
+    OR phone_normalized = ?4)
+ORDER BY
+  CASE
+    WHEN first_name = ?5 COLLATE NOCASE
+      OR last_name = ?5 COLLATE NOCASE
+      OR first_name || ' ' || last_name = ?5 COLLATE NOCASE
+      OR email = ?5 COLLATE NOCASE
+      OR phone = ?5
+      OR phone_normalized = ?4 THEN 0
+    WHEN first_name LIKE ?6 ESCAPE '\'
+      OR last_name LIKE ?6 ESCAPE '\'
+      OR email LIKE ?6 ESCAPE '\'
+      OR phone LIKE ?6 ESCAPE '\' THEN 1
+    ELSE 2
+  END,
+  last_name, first_name, id
+LIMIT ?7 OFFSET ?8
+`
+
+type SearchCustomersParams struct {
+	CompanyID      int64          `json:"company_id"`
+	IncludeDeleted bool           `json:"include_deleted"`
+	Contains       string         `json:"contains"`
+	Phone          sql.NullString `json:"phone"`
+	Term           string         `json:"term"`
+	Prefix         string         `json:"prefix"`
+	Limit          int64          `json:"limit"`
+	Offset         int64          `json:"offset"`
+}
+
+// Exact matches come first, then prefixes, then anything containing the term
+func (q *Queries) SearchCustomers(ctx context.Context, arg SearchCustomersParams) ([]Customer, error) {
+	rows, err := q.db.QueryContext(ctx, searchCustomers,
+		arg.CompanyID,
+		arg.IncludeDeleted,
+		arg.Contains,
+		arg.Phone,
+		arg.Term,
+		arg.Prefix,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Customer{}
+	for rows.Next() {
+		var i Customer
+		if err := rows.Scan(
+			&i.ID,
+			&i.CompanyID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.MedicalAidProvider,
+			&i.MedicalAidNumber,
+			&i.MedicalPlan,
+			&i.CreatedAt,
+			&i.IsVip,
+			&i.DeletedAt,
+			&i.UpdatedAt,
+			&i.PhoneNormalized,
+			&i.Notes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setAgentDnd = `-- name: SetAgentDnd :one
+INSERT INTO agent_status (agent_id, dnd_until) VALUES (?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET dnd_until = excluded.dnd_until, updated_at = CURRENT_TIMESTAMP
+RETURNING agent_id, dnd_until, updated_at, wrap_up_call_sid, wrap_up_until, presence, last_seen_at
+`
+
+type SetAgentDndParams struct {
+	AgentID  string       `json:"agent_id"`
+	DndUntil sql.NullTime `json:"dnd_until"`
+}
+
+func (q *Queries) SetAgentDnd(ctx context.Context, arg SetAgentDndParams) (AgentStatus, error) {
+	row := q.db.QueryRowContext(ctx, setAgentDnd, arg.AgentID, arg.DndUntil)
+	var i AgentStatus
+	err := row.Scan(
+		&i.AgentID,
+		&i.DndUntil,
+		&i.UpdatedAt,
+		&i.WrapUpCallSid,
+		&i.WrapUpUntil,
+		&i.Presence,
+		&i.LastSeenAt,
+	)
+	return i, err
+}
+
+const setAgentPresence = `-- name: SetAgentPresence :one
+INSERT INTO agent_status (agent_id, presence, last_seen_at) VALUES (?, ?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET presence = excluded.presence, last_seen_at = excluded.last_seen_at, updated_at = CURRENT_TIMESTAMP
+RETURNING agent_id, dnd_until, updated_at, wrap_up_call_sid, wrap_up_until, presence, last_seen_at
+`
+
+type SetAgentPresenceParams struct {
+	AgentID    string       `json:"agent_id"`
+	Presence   string       `json:"presence"`
+	LastSeenAt sql.NullTime `json:"last_seen_at"`
+}
+
+func (q *Queries) SetAgentPresence(ctx context.Context, arg SetAgentPresenceParams) (AgentStatus, error) {
+	row := q.db.QueryRowContext(ctx, setAgentPresence, arg.AgentID, arg.Presence, arg.LastSeenAt)
+	var i AgentStatus
+	err := row.Scan(
+		&i.AgentID,
+		&i.DndUntil,
+		&i.UpdatedAt,
+		&i.WrapUpCallSid,
+		&i.WrapUpUntil,
+		&i.Presence,
+		&i.LastSeenAt,
+	)
+	return i, err
+}
+
+const setAgentWrapUp = `-- name: SetAgentWrapUp :exec
+INSERT INTO agent_status (agent_id, wrap_up_call_sid, wrap_up_until) VALUES (?, ?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET wrap_up_call_sid = excluded.wrap_up_call_sid, wrap_up_until = excluded.wrap_up_until, updated_at = CURRENT_TIMESTAMP
+`
+
+type SetAgentWrapUpParams struct {
+	AgentID       string         `json:"agent_id"`
+	WrapUpCallSid sql.NullString `json:"wrap_up_call_sid"`
+	WrapUpUntil   sql.NullTime   `json:"wrap_up_until"`
+}
+
+func (q *Queries) SetAgentWrapUp(ctx context.Context, arg SetAgentWrapUpParams) error {
+	_, err := q.db.ExecContext(ctx, setAgentWrapUp, arg.AgentID, arg.WrapUpCallSid, arg.WrapUpUntil)
+	return err
+}
+
+const setCallNameRecording = `-- name: SetCallNameRecording :exec
+UPDATE call_refs SET name_recording_url = ? WHERE id = ?
+`
+
+type SetCallNameRecordingParams struct {
+	NameRecordingUrl sql.NullString `json:"name_recording_url"`
+	ID               string         `json:"id"`
+}
+
+func (q *Queries) SetCallNameRecording(ctx context.Context, arg SetCallNameRecordingParams) error {
+	_, err := q.db.ExecContext(ctx, setCallNameRecording, arg.NameRecordingUrl, arg.ID)
+	return err
+}
+
+const setCallRecording = `-- name: SetCallRecording :execrows
+UPDATE calls SET recording_sid = ?, recording_url = ?, recording_duration = ?
+WHERE call_sid = ?
+`
+
+type SetCallRecordingParams struct {
+	RecordingSid      sql.NullString `json:"recording_sid"`
+	RecordingUrl      sql.NullString `json:"recording_url"`
+	RecordingDuration sql.NullInt64  `json:"recording_duration"`
+	CallSid           string         `json:"call_sid"`
+}
+
+func (q *Queries) SetCallRecording(ctx context.Context, arg SetCallRecordingParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setCallRecording,
+		arg.RecordingSid,
+		arg.RecordingUrl,
+		arg.RecordingDuration,
+		arg.CallSid,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setCustomerVip = `-- name: SetCustomerVip :execrows
+UPDATE customers SET is_vip = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type SetCustomerVipParams struct {
+	IsVip     bool  `json:"is_vip"`
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) SetCustomerVip(ctx context.Context, arg SetCustomerVipParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setCustomerVip, arg.IsVip, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setParkedCallStatus = `-- name: SetParkedCallStatus :exec
+UPDATE parked_calls SET status = ? WHERE id = ?
+`
+
+type SetParkedCallStatusParams struct {
+	Status string `json:"status"`
+	ID     int64  `json:"id"`
+}
+
+func (q *Queries) SetParkedCallStatus(ctx context.Context, arg SetParkedCallStatusParams) error {
+	_, err := q.db.ExecContext(ctx, setParkedCallStatus, arg.Status, arg.ID)
+	return err
+}
+
+const setUserCallerNumber = `-- name: SetUserCallerNumber :execrows
+UPDATE users SET caller_number_id = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type SetUserCallerNumberParams struct {
+	CallerNumberID sql.NullInt64 `json:"caller_number_id"`
+	ID             int64         `json:"id"`
+	CompanyID      int64         `json:"company_id"`
+}
+
+func (q *Queries) SetUserCallerNumber(ctx context.Context, arg SetUserCallerNumberParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserCallerNumber, arg.CallerNumberID, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users SET role = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type SetUserRoleParams struct {
+	Role      string `json:"role"`
+	ID        int64  `json:"id"`
+	CompanyID int64  `json:"company_id"`
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setVoicemailRead = `-- name: SetVoicemailRead :one
+
+UPDATE voicemails
+SET read_at = CASE WHEN ?1 THEN COALESCE(read_at, CURRENT_TIMESTAMP) END
+WHERE id = ?2 AND company_id = ?3 AND deleted_at IS NULL
+RETURNING id, company_id, customer_id, call_sid, from_number, recording_url, duration, deleted_at, created_at, updated_at, read_at
+`
+
+type SetVoicemailReadParams struct {
+	Read      bool  `json:"read"`
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+// Marking a voicemail read again keeps when it was first read
+func (q *Queries) SetVoicemailRead(ctx context.Context, arg SetVoicemailReadParams) (Voicemail, error) {
+	row := q.db.QueryRowContext(ctx, setVoicemailRead, arg.Read, arg.ID, arg.CompanyID)
+	var i Voicemail
+	err := row.Scan(
+		&i.ID,
+		&i.CompanyID,
+		&i.CustomerID,
+		&i.CallSid,
+		&i.FromNumber,
+		&i.RecordingUrl,
+		&i.Duration,
+		&i.DeletedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ReadAt,
+	)
+	return i, err
+}
+
+const softDeleteCompany = `-- name: SoftDeleteCompany :execrows
+UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteCompany(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteCompany, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const softDeleteCustomer = `-- name: SoftDeleteCustomer :execrows
+UPDATE customers SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+`
+
+type SoftDeleteCustomerParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
+}
+
+func (q *Queries) SoftDeleteCustomer(ctx context.Context, arg SoftDeleteCustomerParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteCustomer, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :execrows
+UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ? AND deleted_at IS NULL
 `
 
-type CreateSessionParams struct {
-	ID        string    `json:"id"`
-	UserID    int64     `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+type SoftDeleteUserParams struct {
+	ID        int64 `json:"id"`
+	CompanyID int64 `json:"company_id"`
 }
 
-func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
-	row := q.db.QueryRowContext(ctx, createSession, arg.ID, arg.UserID, arg.ExpiresAt)
-	var i Session
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-	)
-	return i, err
+func (q *Queries) SoftDeleteUser(ctx context.Context, arg SoftDeleteUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeleteUser, arg.ID, arg.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id)
-VALUES (?, ?, ?, ?, ?, ?) RETURNING id, email, password_hash, firstname, lastname, agent_id, company_id, created_at
+const startCallHold = `-- name: StartCallHold :execrows
+UPDATE calls SET hold_started_at = ?, held_by = ?, held_call_sid = ?
+WHERE id = ? AND hold_started_at IS NULL
 `
 
-type CreateUserParams struct {
-	Email        string `json:"email"`
-	PasswordHash string `json:"password_hash"`
-	Firstname    string `json:"firstname"`
-	Lastname     string `json:"lastname"`
-	AgentID      string `json:"agent_id"`
-	CompanyID    int64  `json:"company_id"`
+type StartCallHoldParams struct {
+	HoldStartedAt sql.NullTime   `json:"hold_started_at"`
+	HeldBy        sql.NullString `json:"held_by"`
+	HeldCallSid   sql.NullString `json:"held_call_sid"`
+	ID            int64          `json:"id"`
 }
 
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser,
-		arg.Email,
-		arg.PasswordHash,
-		arg.Firstname,
-		arg.Lastname,
-		arg.AgentID,
-		arg.CompanyID,
-	)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Firstname,
-		&i.Lastname,
-		&i.AgentID,
-		&i.CompanyID,
-		&i.CreatedAt,
+func (q *Queries) StartCallHold(ctx context.Context, arg StartCallHoldParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, startCallHold,
+		arg.HoldStartedAt,
+		arg.HeldBy,
+		arg.HeldCallSid,
+		arg.ID,
 	)
-	return i, err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const deleteSession = `-- name: DeleteSession :exec
-DELETE FROM sessions WHERE id = ?
+const switchAgentPresence = `-- name: SwitchAgentPresence :execrows
+
+UPDATE agent_status SET presence = ?1, updated_at = CURRENT_TIMESTAMP
+WHERE agent_id = ?2 AND presence = ?3
 `
 
-func (q *Queries) DeleteSession(ctx context.Context, id string) error {
-	_, err := q.db.ExecContext(ctx, deleteSession, id)
-	return err
+type SwitchAgentPresenceParams struct {
+	Presence        string `json:"presence"`
+	AgentID         string `json:"agent_id"`
+	CurrentPresence string `json:"current_presence"`
+}
+
+// Calls only move agents between online and busy, so someone who went away
+// mid-call stays away
+func (q *Queries) SwitchAgentPresence(ctx context.Context, arg SwitchAgentPresenceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, switchAgentPresence, arg.Presence, arg.AgentID, arg.CurrentPresence)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const getAllCompanies = `-- name: GetAllCompanies :many
-SELECT id, name, created_at FROM companies ORDER BY created_at DESC
+const touchAgentPresence = `-- name: TouchAgentPresence :execrows
+UPDATE agent_status SET last_seen_at = ?
+WHERE agent_id = ? AND presence != 'offline'
 `
 
-func (q *Queries) GetAllCompanies(ctx context.Context) ([]Company, error) {
-	rows, err := q.db.QueryContext(ctx, getAllCompanies)
+type TouchAgentPresenceParams struct {
+	LastSeenAt sql.NullTime `json:"last_seen_at"`
+	AgentID    string       `json:"agent_id"`
+}
+
+func (q *Queries) TouchAgentPresence(ctx context.Context, arg TouchAgentPresenceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, touchAgentPresence, arg.LastSeenAt, arg.AgentID)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []Company{}
-	for rows.Next() {
-		var i Company
-		if err := rows.Scan(&i.ID, &i.Name, &i.CreatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected()
 }
 
-const getAllCustomers = `-- name: GetAllCustomers :many
-SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at FROM customers ORDER BY created_at DESC
+const updateCallAmd = `-- name: UpdateCallAmd :execrows
+UPDATE calls SET answered_by = ?, amd_outcome = ? WHERE call_sid = ?
 `
 
-func (q *Queries) GetAllCustomers(ctx context.Context) ([]Customer, error) {
-	rows, err := q.db.QueryContext(ctx, getAllCustomers)
+type UpdateCallAmdParams struct {
+	AnsweredBy sql.NullString `json:"answered_by"`
+	AmdOutcome sql.NullString `json:"amd_outcome"`
+	CallSid    string         `json:"call_sid"`
+}
+
+func (q *Queries) UpdateCallAmd(ctx context.Context, arg UpdateCallAmdParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateCallAmd, arg.AnsweredBy, arg.AmdOutcome, arg.CallSid)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []Customer{}
-	for rows.Next() {
-		var i Customer
-		if err := rows.Scan(
-			&i.ID,
-			&i.CompanyID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.MedicalAidProvider,
-			&i.MedicalAidNumber,
-			&i.MedicalPlan,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return result.RowsAffected()
 }
 
-const getCompany = `-- name: GetCompany :one
-SELECT id, name, created_at FROM companies WHERE id = ?
+const updateCallStatus = `-- name: UpdateCallStatus :execrows
+
+UPDATE calls SET status = ?1,
+    ended_at = COALESCE(?2, ended_at),
+    duration_seconds = COALESCE(?3, duration_seconds)
+WHERE call_sid = ?4 AND status = ?5
 `
 
-func (q *Queries) GetCompany(ctx context.Context, id int64) (Company, error) {
-	row := q.db.QueryRowContext(ctx, getCompany, id)
-	var i Company
-	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
-	return i, err
+type UpdateCallStatusParams struct {
+	Status          string        `json:"status"`
+	EndedAt         sql.NullTime  `json:"ended_at"`
+	DurationSeconds sql.NullInt64 `json:"duration_seconds"`
+	CallSid         string        `json:"call_sid"`
+	CurrentStatus   string        `json:"current_status"`
 }
 
-const getCustomerByEmail = `-- name: GetCustomerByEmail :one
-SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at FROM customers WHERE email = ?
+// Guarded by the status the caller read, so callbacks racing each other
+// can't move a call backwards
+func (q *Queries) UpdateCallStatus(ctx context.Context, arg UpdateCallStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateCallStatus,
+		arg.Status,
+		arg.EndedAt,
+		arg.DurationSeconds,
+		arg.CallSid,
+		arg.CurrentStatus,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateCompany = `-- name: UpdateCompany :one
+UPDATE companies SET name = ? WHERE id = ? AND deleted_at IS NULL RETURNING id, name, created_at, deleted_at, updated_at
 `
 
-func (q *Queries) GetCustomerByEmail(ctx context.Context, email sql.NullString) (Customer, error) {
-	row := q.db.QueryRowContext(ctx, getCustomerByEmail, email)
-	var i Customer
+type UpdateCompanyParams struct {
+	Name string `json:"name"`
+	ID   int64  `json:"id"`
+}
+
+func (q *Queries) UpdateCompany(ctx context.Context, arg UpdateCompanyParams) (Company, error) {
+	row := q.db.QueryRowContext(ctx, updateCompany, arg.Name, arg.ID)
+	var i Company
 	err := row.Scan(
 		&i.ID,
-		&i.CompanyID,
-		&i.FirstName,
-		&i.LastName,
-		&i.Email,
-		&i.Phone,
-		&i.MedicalAidProvider,
-		&i.MedicalAidNumber,
-		&i.MedicalPlan,
+		&i.Name,
 		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getCustomerByID = `-- name: GetCustomerByID :one
-
-SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at FROM customers WHERE id = ?
+const updateCustomer = `-- name: UpdateCustomer :one
+UPDATE customers
+SET first_name = ?, last_name = ?, email = ?, phone = ?, phone_normalized = ?,
+    medical_aid_provider = ?, medical_aid_number = ?, medical_plan = ?, notes = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+RETURNING id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at, is_vip, deleted_at, updated_at, phone_normalized, notes
 `
 
-// -----------------------
-// Customer Queries
-// -----------------------
-func (q *Queries) GetCustomerByID(ctx context.Context, id int64) (Customer, error) {
-	row := q.db.QueryRowContext(ctx, getCustomerByID, id)
+type UpdateCustomerParams struct {
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Email              sql.NullString `json:"email"`
+	Phone              sql.NullString `json:"phone"`
+	PhoneNormalized    sql.NullString `json:"phone_normalized"`
+	MedicalAidProvider sql.NullString `json:"medical_aid_provider"`
+	MedicalAidNumber   sql.NullString `json:"medical_aid_number"`
+	MedicalPlan        sql.NullString `json:"medical_plan"`
+	Notes              sql.NullString `json:"notes"`
+	ID                 int64          `json:"id"`
+	CompanyID          int64          `json:"company_id"`
+}
+
+func (q *Queries) UpdateCustomer(ctx context.Context, arg UpdateCustomerParams) (Customer, error) {
+	row := q.db.QueryRowContext(ctx, updateCustomer,
+		arg.FirstName,
+		arg.LastName,
+		arg.Email,
+		arg.Phone,
+		arg.PhoneNormalized,
+		arg.MedicalAidProvider,
+		arg.MedicalAidNumber,
+		arg.MedicalPlan,
+		arg.Notes,
+		arg.ID,
+		arg.CompanyID,
+	)
 	var i Customer
 	err := row.Scan(
 		&i.ID,
@@ -278,141 +4479,171 @@ func (q *Queries) GetCustomerByID(ctx context.Context, id int64) (Customer, erro
 		&i.MedicalAidNumber,
 		&i.MedicalPlan,
 		&i.CreatedAt,
+		&i.IsVip,
+		&i.DeletedAt,
+		&i.UpdatedAt,
+		&i.PhoneNormalized,
+		&i.Notes,
 	)
 	return i, err
 }
 
-const getCustomerByPhone = `-- name: GetCustomerByPhone :one
-SELECT id, company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan, created_at FROM customers WHERE phone = ?
+const updatePhoneNumber = `-- name: UpdatePhoneNumber :one
+UPDATE phone_numbers SET label = ?, routing_type = ?, announcement = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND company_id = ?
+RETURNING id, company_id, phone, label, routing_type, announcement, created_at, updated_at
 `
 
-func (q *Queries) GetCustomerByPhone(ctx context.Context, phone sql.NullString) (Customer, error) {
-	row := q.db.QueryRowContext(ctx, getCustomerByPhone, phone)
-	var i Customer
+type UpdatePhoneNumberParams struct {
+	Label        sql.NullString `json:"label"`
+	RoutingType  string         `json:"routing_type"`
+	Announcement sql.NullString `json:"announcement"`
+	ID           int64          `json:"id"`
+	CompanyID    int64          `json:"company_id"`
+}
+
+func (q *Queries) UpdatePhoneNumber(ctx context.Context, arg UpdatePhoneNumberParams) (PhoneNumber, error) {
+	row := q.db.QueryRowContext(ctx, updatePhoneNumber,
+		arg.Label,
+		arg.RoutingType,
+		arg.Announcement,
+		arg.ID,
+		arg.CompanyID,
+	)
+	var i PhoneNumber
 	err := row.Scan(
 		&i.ID,
 		&i.CompanyID,
-		&i.FirstName,
-		&i.LastName,
-		&i.Email,
 		&i.Phone,
-		&i.MedicalAidProvider,
-		&i.MedicalAidNumber,
-		&i.MedicalPlan,
+		&i.Label,
+		&i.RoutingType,
+		&i.Announcement,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getCustomerPremiumsByCustomerID = `-- name: GetCustomerPremiumsByCustomerID :many
+const updateSessionExpiry = `-- name: UpdateSessionExpiry :execrows
 
-SELECT id, customer_id, premium_amount, effective_date, created_at FROM customer_premiums WHERE customer_id = ? ORDER BY effective_date DESC
+UPDATE sessions SET expires_at = ?1
+WHERE id = ?2 AND expires_at = ?3
 `
 
-// -----------------------
-// Customer Premium Queries
-// -----------------------
-func (q *Queries) GetCustomerPremiumsByCustomerID(ctx context.Context, customerID int64) ([]CustomerPremium, error) {
-	rows, err := q.db.QueryContext(ctx, getCustomerPremiumsByCustomerID, customerID)
+type UpdateSessionExpiryParams struct {
+	NewExpiresAt time.Time `json:"new_expires_at"`
+	ID           string    `json:"id"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Guarded by the expiry the caller read, so a session is extended once
+// however many requests race to do it
+func (q *Queries) UpdateSessionExpiry(ctx context.Context, arg UpdateSessionExpiryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateSessionExpiry, arg.NewExpiresAt, arg.ID, arg.ExpiresAt)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []CustomerPremium{}
-	for rows.Next() {
-		var i CustomerPremium
-		if err := rows.Scan(
-			&i.ID,
-			&i.CustomerID,
-			&i.PremiumAmount,
-			&i.EffectiveDate,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected()
 }
 
-const getSession = `-- name: GetSession :one
-SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = ?
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = ? WHERE id = ?
 `
 
-func (q *Queries) GetSession(ctx context.Context, id string) (Session, error) {
-	row := q.db.QueryRowContext(ctx, getSession, id)
-	var i Session
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-	)
-	return i, err
+type UpdateUserPasswordParams struct {
+	PasswordHash string `json:"-"`
+	ID           int64  `json:"id"`
 }
 
-const getUserByAgentID = `-- name: GetUserByAgentID :one
-SELECT id, email, password_hash, firstname, lastname, agent_id, company_id, created_at FROM users WHERE agent_id = ?
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.PasswordHash, arg.ID)
+	return err
+}
+
+const upsertAppSetting = `-- name: UpsertAppSetting :exec
+INSERT INTO app_settings (key, value) VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
 `
 
-func (q *Queries) GetUserByAgentID(ctx context.Context, agentID string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByAgentID, agentID)
-	var i User
+type UpsertAppSettingParams struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (q *Queries) UpsertAppSetting(ctx context.Context, arg UpsertAppSettingParams) error {
+	_, err := q.db.ExecContext(ctx, upsertAppSetting, arg.Key, arg.Value)
+	return err
+}
+
+const upsertBusinessSchedule = `-- name: UpsertBusinessSchedule :one
+INSERT INTO business_schedules (company_id, timezone) VALUES (?, ?)
+ON CONFLICT (company_id) DO UPDATE SET timezone = excluded.timezone, updated_at = CURRENT_TIMESTAMP
+RETURNING company_id, timezone, updated_at
+`
+
+type UpsertBusinessScheduleParams struct {
+	CompanyID int64  `json:"company_id"`
+	Timezone  string `json:"timezone"`
+}
+
+func (q *Queries) UpsertBusinessSchedule(ctx context.Context, arg UpsertBusinessScheduleParams) (BusinessSchedule, error) {
+	row := q.db.QueryRowContext(ctx, upsertBusinessSchedule, arg.CompanyID, arg.Timezone)
+	var i BusinessSchedule
 	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Firstname,
-		&i.Lastname,
-		&i.AgentID,
 		&i.CompanyID,
-		&i.CreatedAt,
+		&i.Timezone,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, password_hash, firstname, lastname, agent_id, company_id, created_at FROM users WHERE email = ?
+const upsertCallRef = `-- name: UpsertCallRef :one
+
+INSERT INTO call_refs (id, call_sid, company_id) VALUES (?, ?, ?)
+ON CONFLICT (call_sid) DO UPDATE SET company_id = COALESCE(call_refs.company_id, excluded.company_id)
+RETURNING id, call_sid, created_at, name_recording_url, company_id
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
-	var i User
+type UpsertCallRefParams struct {
+	ID        string         `json:"id"`
+	CallSid   sql.NullString `json:"call_sid"`
+	CompanyID sql.NullInt64  `json:"company_id"`
+}
+
+// A call's company is settled by whichever webhook learns it first
+func (q *Queries) UpsertCallRef(ctx context.Context, arg UpsertCallRefParams) (CallRef, error) {
+	row := q.db.QueryRowContext(ctx, upsertCallRef, arg.ID, arg.CallSid, arg.CompanyID)
+	var i CallRef
 	err := row.Scan(
 		&i.ID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Firstname,
-		&i.Lastname,
-		&i.AgentID,
-		&i.CompanyID,
+		&i.CallSid,
 		&i.CreatedAt,
+		&i.NameRecordingUrl,
+		&i.CompanyID,
 	)
 	return i, err
 }
 
-const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, password_hash, firstname, lastname, agent_id, company_id, created_at FROM users WHERE id = ?
+const upsertCompanySetting = `-- name: UpsertCompanySetting :one
+INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)
+ON CONFLICT (company_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+RETURNING company_id, "key", value, updated_at
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByID, id)
-	var i User
+type UpsertCompanySettingParams struct {
+	CompanyID int64  `json:"company_id"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+}
+
+func (q *Queries) UpsertCompanySetting(ctx context.Context, arg UpsertCompanySettingParams) (CompanySetting, error) {
+	row := q.db.QueryRowContext(ctx, upsertCompanySetting, arg.CompanyID, arg.Key, arg.Value)
+	var i CompanySetting
 	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Firstname,
-		&i.Lastname,
-		&i.AgentID,
 		&i.CompanyID,
-		&i.CreatedAt,
+		&i.Key,
+		&i.Value,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
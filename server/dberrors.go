@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isUniqueViolation reports whether err is a UNIQUE constraint failing. With
+// columns (as "table.column") given, only a violation naming one of them
+// counts, which tells apart tables with more than one unique column.
+func isUniqueViolation(err error, columns ...string) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return false
+	}
+	if len(columns) == 0 {
+		return true
+	}
+
+	// SQLite only names the constraint's columns in the message, e.g.
+	// "UNIQUE constraint failed: users.email"
+	_, failed, _ := strings.Cut(sqliteErr.Error(), ": ")
+	for _, failedColumn := range strings.Split(failed, ", ") {
+		for _, column := range columns {
+			if failedColumn == column {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	addAgent(t, s, acme, "agent1", roleAgent)
+
+	insertCompany := func(name any) error {
+		_, err := s.db.Exec("INSERT INTO companies (name) VALUES (?)", name)
+		return err
+	}
+	insertUser := func(email, agentID string) error {
+		_, err := s.db.Exec(`INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id, role)
+			VALUES (?, 'x', 'A', 'B', ?, ?, 'agent')`, email, agentID, acme)
+		return err
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		columns []string
+		want    bool
+	}{
+		{"duplicate company name", insertCompany("Acme"), nil, true},
+		{"duplicate company name, named", insertCompany("Acme"), []string{"companies.name"}, true},
+		{"duplicate email", insertUser("agent1@example.com", "agent2"), []string{"users.email"}, true},
+		{"duplicate email, other column asked", insertUser("agent1@example.com", "agent3"), []string{"users.agent_id"}, false},
+		{"duplicate agent id", insertUser("other@example.com", "agent1"), []string{"users.agent_id"}, true},
+		{"duplicate agent id, either asked", insertUser("another@example.com", "agent1"), []string{"users.email", "users.agent_id"}, true},
+		{"wrapped", fmt.Errorf("creating user: %w", insertUser("third@example.com", "agent1")), nil, true},
+		{"other constraint", insertCompany(nil), nil, false},
+		{"not a database error", errors.New("UNIQUE constraint failed: companies.name"), nil, false},
+		{"no error", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUniqueViolation(tt.err, tt.columns...); got != tt.want {
+				t.Errorf("isUniqueViolation(%v, %v) = %v, want %v", tt.err, tt.columns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateCompanyDuplicate(t *testing.T) {
+	s := newTestServer(t)
+	gone := addCompany(t, s, "Gone")
+	exec(t, s, "UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", gone)
+	addCompany(t, s, "Acme")
+
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"Acme", http.StatusBadRequest},
+		{"Gone", http.StatusCreated}, // the name is free again once deleted
+		{"Initech", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.createCompany(w, httptest.NewRequest(http.MethodPost, "/api/companies", strings.NewReader(`{"name":"`+tt.name+`"}`)))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
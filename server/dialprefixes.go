@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// maxDialPrefixDigits covers a calling code plus an area or mobile prefix.
+const maxDialPrefixDigits = 6
+
+// outboundDialPrefixes are the E.164 prefixes a company's agents may dial,
+// e.g. "+27,+267". The company's outbound_dial_prefixes setting takes
+// precedence over OUTBOUND_DIAL_PREFIXES; with neither set any number may
+// be dialed.
+func (s *Server) outboundDialPrefixes(ctx context.Context, companyID int64) []string {
+	prefixes := os.Getenv("OUTBOUND_DIAL_PREFIXES")
+	if companyID != 0 {
+		prefixes = s.companySetting(ctx, companyID, settingOutboundDialPrefixes, prefixes)
+	}
+	return splitList(prefixes)
+}
+
+// dialAllowed reports whether an E.164 number may be dialed by a company's
+// agents.
+func (s *Server) dialAllowed(ctx context.Context, companyID int64, number string) bool {
+	prefixes := s.outboundDialPrefixes(ctx, companyID)
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(number, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateDialPrefixes(value string) error {
+	for _, prefix := range splitList(value) {
+		digits := strings.TrimPrefix(prefix, "+")
+		if digits == prefix || digits == "" || len(digits) > maxDialPrefixDigits || strings.Trim(digits, "0123456789") != "" {
+			return errors.New("Dial prefixes must be comma-separated values such as +27 or +1212")
+		}
+	}
+	return nil
+}
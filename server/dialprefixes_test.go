@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOutboundToNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      string
+		env     string // OUTBOUND_DIAL_PREFIXES
+		setting string // outbound_dial_prefixes, empty for none
+		dialed  string // empty when the call is refused
+		said    string
+	}{
+		{"E.164", "+27825550001", "", "", "+27825550001", ""},
+		{"national", "082 555 0001", "", "", "+27825550001", ""},
+		{"empty", "", "", "", "", "The number you dialed is not valid."},
+		{"not a number", "call me", "", "", "", "The number you dialed is not valid."},
+		{"too short", "12345", "", "", "", "The number you dialed is not valid."},
+		{"inside the company's prefixes", "+27825550001", "", "+1,+27", "+27825550001", ""},
+		{"outside the company's prefixes", "+12015550123", "", "+27", "", "Calls to that number are not allowed."},
+		{"outside the default prefixes", "+27825550001", "+44", "", "", "Calls to that number are not allowed."},
+		{"company overriding the default", "+27825550001", "+44", "+27", "+27825550001", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_PHONE_REGION", "ZA")
+			t.Setenv("OUTBOUND_DIAL_PREFIXES", tt.env)
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+			if tt.setting != "" {
+				exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingOutboundDialPrefixes, tt.setting)
+			}
+
+			w := httptest.NewRecorder()
+			s.handleOutboundVoice(w, twilioWebhook("/twilio/voice", url.Values{
+				"CallSid": {"CA-agent"}, "From": {"client:acme1"}, "To": {tt.to},
+			}))
+			body := w.Body.String()
+
+			var recorded string
+			s.db.QueryRow("SELECT to_number FROM calls WHERE call_sid = 'CA-agent'").Scan(&recorded)
+			if tt.dialed == "" {
+				if strings.Contains(body, "<Dial") || !strings.Contains(body, "<Say>"+tt.said+"</Say>") {
+					t.Errorf("got %s, want it refused with %q", body, tt.said)
+				}
+				if recorded != "" {
+					t.Errorf("recorded a call to %s", recorded)
+				}
+				return
+			}
+			if !strings.Contains(body, ">"+tt.dialed+"</Number>") {
+				t.Errorf("got %s, want %s dialed", body, tt.dialed)
+			}
+			if recorded != tt.dialed {
+				t.Errorf("recorded a call to %q, want %s", recorded, tt.dialed)
+			}
+		})
+	}
+}
+
+func TestValidateDialPrefixes(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"+27":             true,
+		" +27 , +1212 ,":  true,
+		"":                true,
+		"27":              false,
+		"+":               false,
+		"+27a":            false,
+		"+1234567":        false,
+		"+27,0800":        false,
+		"+27, premium+44": false,
+	} {
+		if err := validateDialPrefixes(value); (err == nil) != ok {
+			t.Errorf("validateDialPrefixes(%q) = %v, want ok %v", value, err, ok)
+		}
+	}
+}
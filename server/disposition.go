@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultDispositionTimeout is how long an agent stays in wrap-up waiting
+// for a disposition before routing takes them back anyway.
+const defaultDispositionTimeout = 5 * time.Minute
+
+// How a call ended, as recorded by the agent who took it
+var callDispositions = []string{"resolved", "callback", "no-answer", "voicemail", "other"}
+
+type CallDispositionRequest struct {
+	Disposition string `json:"disposition"`
+	Notes       string `json:"notes"`
+}
+
+type CallDispositionResponse struct {
+	Success     bool                `json:"success"`
+	Disposition *db.CallDisposition `json:"disposition,omitempty"`
+}
+
+type CallNotesResponse struct {
+	Success bool                         `json:"success"`
+	Notes   []db.ListCallDispositionsRow `json:"notes"`
+}
+
+// requireDisposition reports whether agents must submit a disposition before
+// their next call. It is off unless REQUIRE_DISPOSITION or the company's
+// require_disposition setting turns it on.
+func (s *Server) requireDisposition(ctx context.Context, companyID int64) bool {
+	enabled := s.companySetting(ctx, companyID, settingRequireDisposition, os.Getenv("REQUIRE_DISPOSITION"))
+	on, _ := strconv.ParseBool(enabled)
+	return on
+}
+
+// dispositionTimeout comes from DISPOSITION_TIMEOUT or the company's
+// disposition_timeout setting, in seconds.
+func (s *Server) dispositionTimeout(ctx context.Context, companyID int64) time.Duration {
+	value := s.companySetting(ctx, companyID, settingDispositionTimeout, os.Getenv("DISPOSITION_TIMEOUT"))
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultDispositionTimeout
+}
+
+func validateDispositionTimeout(value string) error {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 30 || seconds > 3600 {
+		return errors.New("Timeout must be between 30 and 3600 seconds")
+	}
+	return nil
+}
+
+// startWrapUp holds an agent back from routing after an answered call when
+// their company wants a disposition first.
+func (s *Server) startWrapUp(ctx context.Context, agentID, callSID string) {
+	agent, err := s.queries.GetUserByAgentID(ctx, agentID)
+	if err != nil || agent.DeletedAt.Valid || !s.requireDisposition(ctx, agent.CompanyID) {
+		return
+	}
+
+	until := time.Now().Add(s.dispositionTimeout(ctx, agent.CompanyID)).UTC()
+	if err := s.queries.SetAgentWrapUp(ctx, db.SetAgentWrapUpParams{
+		AgentID:       agentID,
+		WrapUpCallSid: sql.NullString{String: callSID, Valid: callSID != ""},
+		WrapUpUntil:   sql.NullTime{Time: until, Valid: true},
+	}); err != nil {
+		logErrorf(ctx, "Error starting wrap-up for %s: %v", agentID, err)
+		return
+	}
+
+	logInfof(ctx, "📝 Agent %s in wrap-up for call %s until %s", agentID, callSID, until.Format(time.RFC3339))
+	s.notifyAgentStatus(ctx, agentID)
+}
+
+// createCallDisposition records how the agent's call ended, with any notes
+// on it. It also ends the agent's wrap-up, so they go back into routing.
+// Agents can add more than one note to a call.
+func (s *Server) createCallDisposition(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req CallDispositionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Disposition = strings.TrimSpace(req.Disposition)
+	if !slices.Contains(callDispositions, req.Disposition) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Disposition must be one of: %s", strings.Join(callDispositions, ", ")))
+		return
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	if len(req.Notes) > maxNotes {
+		respondError(w, http.StatusBadRequest, "Notes must be at most 5000 characters")
+		return
+	}
+
+	callSID := chi.URLParam(r, "callSid")
+	if !s.companyCall(w, r, user, callSID, "Failed to save disposition") {
+		return
+	}
+	callID, err := s.resolveCallID(r.Context(), callSID, user.CompanyID)
+	if err != nil {
+		logErrorf(r.Context(), "Error recording call id: %v", err)
+	}
+	disposition, err := s.queries.CreateCallDisposition(r.Context(), db.CreateCallDispositionParams{
+		CompanyID:   user.CompanyID,
+		AgentID:     user.AgentID,
+		CallSid:     callSID,
+		CallRefID:   nullString(callID),
+		Disposition: req.Disposition,
+		Notes:       sql.NullString{String: req.Notes, Valid: req.Notes != ""},
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save disposition")
+		return
+	}
+
+	if cleared, err := s.queries.ClearAgentWrapUp(r.Context(), user.AgentID); err != nil {
+		logErrorf(r.Context(), "Error ending wrap-up for %s: %v", user.AgentID, err)
+	} else if cleared > 0 {
+		logInfof(r.Context(), "📝 Agent %s finished wrap-up for call %s", user.AgentID, callSID)
+		s.notifyAgentStatus(r.Context(), user.AgentID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CallDispositionResponse{
+		Success:     true,
+		Disposition: &disposition,
+	})
+}
+
+// getCallNotes lists the dispositions and notes agents left on a call,
+// oldest first, with who wrote each.
+func (s *Server) getCallNotes(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	callSID := chi.URLParam(r, "callSid")
+	if !s.companyCall(w, r, user, callSID, "Failed to get call notes") {
+		return
+	}
+
+	notes, err := s.queries.ListCallDispositions(r.Context(), db.ListCallDispositionsParams{
+		CallSid:   callSID,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get call notes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallNotesResponse{
+		Success: true,
+		Notes:   notes,
+	})
+}
+
+// companyCall checks that callSID is one of user's company's calls,
+// answering with a 404 when it isn't.
+func (s *Server) companyCall(w http.ResponseWriter, r *http.Request, user *db.User, callSID, failure string) bool {
+	_, err := s.queries.GetCompanyCallBySid(r.Context(), db.GetCompanyCallBySidParams{
+		CallSid:   callSID,
+		CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return false
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, failure)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapUpUntilDisposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string // require_disposition, empty for none
+		wrapUp  bool
+	}{
+		{"required", "true", true},
+		{"not required", "false", false},
+		{"off by default", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			acmeID := addCompany(t, s, "Acme")
+			agent := addAgent(t, s, acmeID, "acme1", roleAgent)
+			acme, _ := s.queries.GetCompany(t.Context(), acmeID)
+			if tt.setting != "" {
+				exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acmeID, settingRequireDisposition, tt.setting)
+			}
+			exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, '60')", acmeID, settingDispositionTimeout)
+			exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, agent_id, status, started_at)
+				VALUES ('CA-1', 'inbound', ?, 'acme1', 'in-progress', CURRENT_TIMESTAMP)`, acmeID)
+
+			status := func() AgentStatusResponse {
+				w := httptest.NewRecorder()
+				s.getMyStatus(w, asUser(httptest.NewRequest(http.MethodGet, "/api/me/status", nil), agent))
+				var resp AgentStatusResponse
+				json.NewDecoder(w.Body).Decode(&resp)
+				return resp
+			}
+			routed := func() bool {
+				_, err := newRouters(s.queries)[routingFirstAvailable].SelectAgent(t.Context(), &acme, CallContext{})
+				if err != nil && !errors.Is(err, errNoAgentAvailable) {
+					t.Fatal(err)
+				}
+				return err == nil
+			}
+
+			s.handleDialComplete(httptest.NewRecorder(), twilioWebhook("/twilio/dial-complete?agent_id=acme1", url.Values{
+				"CallSid": {"CA-1"}, "DialCallStatus": {"completed"}, "DialCallDuration": {"42"},
+			}))
+			resp := status()
+			if (resp.Status == "wrap_up") != tt.wrapUp || routed() == tt.wrapUp {
+				t.Fatalf("after the call: status %q, routed %v; want wrap-up %v", resp.Status, routed(), tt.wrapUp)
+			}
+			if !tt.wrapUp {
+				return
+			}
+			if resp.WrapUpCallSid != "CA-1" || resp.WrapUpUntil == nil {
+				t.Fatalf("wrap-up for %q until %v, want CA-1", resp.WrapUpCallSid, resp.WrapUpUntil)
+			}
+			if until := time.Until(*resp.WrapUpUntil); until < 55*time.Second || until > 60*time.Second {
+				t.Errorf("wrap-up ends %s from now, want the company's 60s", until)
+			}
+
+			// A bad disposition doesn't count
+			dispose := func(body string) int {
+				w := httptest.NewRecorder()
+				r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/CA-1/disposition", strings.NewReader(body)), agent)
+				s.createCallDisposition(w, withURLParams(r, map[string]string{"callSid": "CA-1"}))
+				return w.Code
+			}
+			if code := dispose(`{"disposition":"shrug"}`); code != http.StatusBadRequest || status().Status != "wrap_up" {
+				t.Errorf("bad disposition: status = %d, agent %q; want %d and still in wrap-up", code, status().Status, http.StatusBadRequest)
+			}
+			if code := dispose(`{"disposition":"resolved"}`); code != http.StatusCreated {
+				t.Fatalf("status = %d, want %d", code, http.StatusCreated)
+			}
+			if got := status().Status; got != "available" || !routed() {
+				t.Errorf("after the disposition: status %q, routed %v; want available and routed", got, routed())
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		s := newTestServer(t)
+		acmeID := addCompany(t, s, "Acme")
+		addAgent(t, s, acmeID, "acme1", roleAgent)
+		acme, _ := s.queries.GetCompany(t.Context(), acmeID)
+		exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, 'true')", acmeID, settingRequireDisposition)
+		router := newRouters(s.queries)[routingFirstAvailable]
+
+		s.startWrapUp(t.Context(), "acme1", "CA-1")
+		if _, err := router.SelectAgent(t.Context(), &acme, CallContext{}); !errors.Is(err, errNoAgentAvailable) {
+			t.Fatalf("in wrap-up: error = %v, want %v", err, errNoAgentAvailable)
+		}
+
+		// Nobody submits anything, and routing takes the agent back anyway
+		exec(t, s, "UPDATE agent_status SET wrap_up_until = ? WHERE agent_id = 'acme1'", time.Now().Add(-time.Second).UTC())
+		if agentID, err := router.SelectAgent(t.Context(), &acme, CallContext{}); agentID != "acme1" {
+			t.Errorf("after the timeout: picked %q, %v; want acme1", agentID, err)
+		}
+	})
+}
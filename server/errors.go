@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Error codes the frontend can rely on. Messages may be reworded; codes
+// stay put.
+const (
+	errCodeInvalidBody     = "INVALID_BODY"
+	errCodeMissingFields   = "MISSING_FIELDS"
+	errCodeInvalidAgentID  = "INVALID_AGENT_ID"
+	errCodeWeakPassword    = "WEAK_PASSWORD"
+	errCodeEmailTaken      = "EMAIL_TAKEN"
+	errCodeAgentIDTaken    = "AGENT_ID_TAKEN"
+	errCodeInvalidNumber   = "INVALID_PHONE_NUMBER"
+	errCodeInvalidSchedule = "INVALID_SCHEDULE"
+	errCodeUnknownCompany  = "UNKNOWN_COMPANY"
+	errCodeCompanyTaken    = "COMPANY_TAKEN"
+)
+
+// ErrorResponse is the body of every error. Detail repeats Message for
+// clients written before codes existed. Fields maps a request field to
+// what's wrong with it.
+type ErrorResponse struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Detail    string            `json:"detail"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// newErrorResponse builds an error body. requestID has already put the ID
+// on the response, which saves every caller passing the request in.
+func newErrorResponse(w http.ResponseWriter, code, message string, fields map[string]string) ErrorResponse {
+	return ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Detail:    message,
+		Fields:    fields,
+		RequestID: w.Header().Get(requestIDHeader),
+	}
+}
+
+// respondError responds with a generic code for the status, e.g. NOT_FOUND.
+func respondError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newErrorResponse(w, statusErrorCode(status), message, nil))
+}
+
+// respondValidationError refuses a request with a 400, saying which fields
+// were at fault.
+func respondValidationError(w http.ResponseWriter, code, message string, fields map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(newErrorResponse(w, code, message, fields))
+}
+
+func statusErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "ERROR"
+	}
+	return strings.ToUpper(strings.ReplaceAll(text, " ", "_"))
+}
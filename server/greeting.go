@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"omnicall/db"
+)
+
+// Built-in voices; Polly and Google voices are named by prefix
+var greetingVoices = map[string]bool{
+	"man":   true,
+	"woman": true,
+	"alice": true,
+}
+
+var (
+	greetingVoicePattern    = regexp.MustCompile(`^(Polly|Google)\.[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?$`)
+	greetingLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// incomingGreetingTwiML is what a caller hears before being put through to
+// an agent: the company's recorded greeting, its greeting text, or a welcome
+// naming the product. Callers who have just given their name are only
+// thanked, since they already heard the welcome.
+func (s *Server) incomingGreetingTwiML(ctx context.Context, company *db.Company, nameStep bool) string {
+	id := companyID(company)
+
+	if nameStep {
+		return s.sayTwiML(ctx, id, "Thank you. Please wait while we connect you to an agent.")
+	}
+	if company != nil {
+		if audioURL := s.companySetting(ctx, id, settingGreetingAudioURL, ""); audioURL != "" {
+			return fmt.Sprintf("<Play>%s</Play>", html.EscapeString(audioURL))
+		}
+		if text := s.companySetting(ctx, id, settingGreetingText, ""); text != "" {
+			return s.sayTwiML(ctx, id, text)
+		}
+	}
+	return s.sayTwiML(ctx, id, fmt.Sprintf("Welcome to %s. Please wait while we connect you to an agent.", s.productName(ctx, id)))
+}
+
+// sayTwiML speaks text in the company's greeting voice and language, leaving
+// Twilio's defaults in place for whichever it hasn't chosen.
+func (s *Server) sayTwiML(ctx context.Context, companyID int64, text string) string {
+	attrs := ""
+	if companyID != 0 {
+		if voice := s.companySetting(ctx, companyID, settingGreetingVoice, ""); voice != "" {
+			attrs += fmt.Sprintf(` voice="%s"`, html.EscapeString(voice))
+		}
+		if language := s.companySetting(ctx, companyID, settingGreetingLanguage, ""); language != "" {
+			attrs += fmt.Sprintf(` language="%s"`, html.EscapeString(language))
+		}
+	}
+	return fmt.Sprintf("<Say%s>%s</Say>", attrs, html.EscapeString(text))
+}
+
+// validateGreetingVoice accepts one of Twilio's built-in voices or a Polly
+// or Google voice name. An empty value goes back to the default.
+func validateGreetingVoice(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || greetingVoices[value] || greetingVoicePattern.MatchString(value) {
+		return nil
+	}
+	return errors.New("Voice must be man, woman, alice, or a Polly or Google voice name")
+}
+
+// validateGreetingLanguage accepts a language tag such as en-US or fr-CA.
+// An empty value goes back to the default.
+func validateGreetingLanguage(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || greetingLanguagePattern.MatchString(value) {
+		return nil
+	}
+	return errors.New("Language must be a language tag such as en-US")
+}
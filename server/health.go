@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout keeps a locked or unreachable database from holding up
+// the load balancer's probe.
+const healthCheckTimeout = 2 * time.Second
+
+// healthPaths are probed by load balancers and orchestrators, which must
+// never be rate limited.
+var healthPaths = []string{"/health", "/live", "/ready"}
+
+// Health statuses
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+type HealthResponse struct {
+	Status        string `json:"status"`
+	DB            string `json:"db,omitempty"`
+	Twilio        string `json:"twilio,omitempty"`
+	Version       string `json:"version"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// live reports that the process is up and serving, whatever state its
+// dependencies are in, so a database outage doesn't get it restarted.
+func (s *Server) live(w http.ResponseWriter, r *http.Request) {
+	s.respondHealth(w, HealthResponse{Status: healthOK})
+}
+
+// ready reports whether the instance can serve traffic: 503 when the
+// database doesn't answer. Missing Twilio credentials are reported but
+// don't fail it, since everything but calls still works without them.
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: healthOK, DB: healthOK, Twilio: "configured"}
+	if s.twilio == nil {
+		resp.Twilio = "missing"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		logErrorf(r.Context(), "Health check failed to reach the database: %v", err)
+		resp.Status = healthDegraded
+		resp.DB = "unreachable"
+	}
+	s.respondHealth(w, resp)
+}
+
+// health is the readiness check under the path deployments already probe.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	s.ready(w, r)
+}
+
+func (s *Server) respondHealth(w http.ResponseWriter, resp HealthResponse) {
+	resp.Version = apiVersion
+	resp.UptimeSeconds = int64(time.Since(s.started).Seconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
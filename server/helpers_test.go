@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/twilio/twilio-go"
+	"github.com/twilio/twilio-go/client"
+	"golang.org/x/crypto/bcrypt"
+	"omnicall/db"
+)
+
+// newTestServer returns a Server over a fresh in-memory database with every
+// migration applied.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	database, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is its own database
+	database.SetMaxOpenConns(1)
+	t.Cleanup(func() { database.Close() })
+	if err := migrate(database); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := db.New(database)
+	return &Server{
+		db:              database,
+		queries:         queries,
+		routers:         newRouters(queries),
+		defaultRouting:  routingFirstAvailable,
+		presenceTimeout: 2 * time.Minute,
+		hub:             newEventHub(),
+		maintenance:     new(atomic.Bool),
+		cookie:          sessionCookie{name: defaultSessionCookieName, lifetime: time.Hour},
+		passwords:       passwordPolicy{minLength: 8, cost: bcrypt.MinCost},
+		started:         time.Now(),
+	}
+}
+
+// captureLogs sends everything logged until the test ends to the returned
+// buffer.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// exec runs setup SQL, failing the test when it doesn't work.
+func exec(t *testing.T, s *Server, query string, args ...any) sql.Result {
+	t.Helper()
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return result
+}
+
+func addCompany(t *testing.T, s *Server, name string) int64 {
+	t.Helper()
+	id, _ := exec(t, s, "INSERT INTO companies (name) VALUES (?)", name).LastInsertId()
+	return id
+}
+
+// addAgent adds a user who is online and so can be routed to.
+func addAgent(t *testing.T, s *Server, companyID int64, agentID, role string) *db.User {
+	t.Helper()
+	exec(t, s, `INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id, role)
+		VALUES (?, 'x', ?, 'Test', ?, ?, ?)`, agentID+"@example.com", agentID, agentID, companyID, role)
+	exec(t, s, "INSERT INTO agent_status (agent_id, presence, last_seen_at) VALUES (?, 'online', ?)", agentID, time.Now().UTC())
+	user, err := s.queries.GetUserByAgentID(context.Background(), agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func addNumber(t *testing.T, s *Server, companyID int64, phone string) {
+	t.Helper()
+	exec(t, s, "INSERT INTO phone_numbers (company_id, phone) VALUES (?, ?)", companyID, phone)
+}
+
+// signIn starts a session for user and returns its cookie.
+func signIn(t *testing.T, s *Server, user *db.User) *http.Cookie {
+	t.Helper()
+	session, err := s.queries.CreateSession(context.Background(), db.CreateSessionParams{
+		ID:        generateSessionID(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: s.cookie.name, Value: session.ID}
+}
+
+// asUser makes r come from user, as requireAuth would.
+func asUser(r *http.Request, user *db.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
+
+// withURLParams sets the route parameters chi would have matched.
+func withURLParams(r *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for key, value := range params {
+		rctx.URLParams.Add(key, value)
+	}
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// fakeTwilio stands in for Twilio's REST API, recording the form of every
+// request. Calls it's asked to create are queued, unless fail is set. With
+// responses set it answers by the end of the request path instead, such as
+// "/Calls/CA1.json", and with a 404 for anything else.
+type fakeTwilio struct {
+	fail      bool
+	requests  []url.Values
+	paths     []string
+	responses map[string]string
+	oauth     client.OAuth
+}
+
+// withFakeTwilio points s's Twilio client at a fakeTwilio.
+func withFakeTwilio(s *Server) *fakeTwilio {
+	fake := &fakeTwilio{}
+	s.twilio = twilio.NewRestClientWithParams(twilio.ClientParams{Client: fake})
+	return fake
+}
+
+func (f *fakeTwilio) AccountSid() string          { return "ACtest" }
+func (f *fakeTwilio) SetTimeout(time.Duration)    {}
+func (f *fakeTwilio) SetOauth(oauth client.OAuth) { f.oauth = oauth }
+func (f *fakeTwilio) OAuth() client.OAuth         { return f.oauth }
+
+func (f *fakeTwilio) SendRequest(method, rawURL string, data url.Values, headers map[string]interface{}, body ...byte) (*http.Response, error) {
+	f.requests = append(f.requests, data)
+	f.paths = append(f.paths, method+" "+rawURL)
+	if f.fail {
+		return nil, errors.New("twilio unavailable")
+	}
+	response := `{"sid":"CAfake","status":"queued"}`
+	if f.responses != nil {
+		var ok bool
+		for suffix, r := range f.responses {
+			if strings.HasSuffix(rawURL, suffix) {
+				response, ok = r, true
+			}
+		}
+		if !ok {
+			return nil, &client.TwilioRestError{Status: http.StatusNotFound, Message: "not found"}
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+const defaultHoldMusicURL = "http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3"
+
+type CallHoldResponse struct {
+	Success bool     `json:"success"`
+	CallSid string   `json:"call_sid"`
+	Call    *db.Call `json:"call"`
+}
+
+// holdMusicURL is what a company's callers hear on hold. HOLD_MUSIC_URL sets
+// the default for companies that haven't chosen.
+func (s *Server) holdMusicURL(ctx context.Context, companyID int64) string {
+	music := os.Getenv("HOLD_MUSIC_URL")
+	if music == "" {
+		music = defaultHoldMusicURL
+	}
+	if companyID != 0 {
+		music = s.companySetting(ctx, companyID, settingHoldMusicURL, music)
+	}
+	return music
+}
+
+// holdTwiML plays hold music until the call is redirected again.
+func holdTwiML(musicURL string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Play loop="0">%s</Play>
+</Response>`, html.EscapeString(musicURL))
+}
+
+// holdCall puts the customer on the agent's call on hold music. Like
+// parking, this ends the agent's leg; resuming rings them back. callSid is
+// the agent's (browser) leg.
+func (s *Server) holdCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	callSID := chi.URLParam(r, "callSid")
+	customer, ok := s.liveCustomerLeg(w, r, user, callSID, "Failed to hold call")
+	if !ok {
+		return
+	}
+
+	call, err := s.callRecord(r.Context(), *customer.Sid, callSID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to hold call")
+		return
+	}
+
+	// Claiming the hold first means a second request can't start another
+	rows, err := s.queries.StartCallHold(r.Context(), db.StartCallHoldParams{
+		HoldStartedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		HeldBy:        nullString(user.AgentID),
+		HeldCallSid:   nullString(*customer.Sid),
+		ID:            call.ID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to hold call")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusConflict, "Call is already on hold")
+		return
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(holdTwiML(s.holdMusicURL(r.Context(), user.CompanyID)))
+	if _, err := s.twilio.Api.UpdateCall(*customer.Sid, update); err != nil {
+		logErrorf(r.Context(), "Error moving %s to hold: %v", *customer.Sid, err)
+		if _, err := s.queries.EndCallHold(r.Context(), db.EndCallHoldParams{ID: call.ID}); err != nil {
+			logErrorf(r.Context(), "Error clearing hold on call %s: %v", call.CallSid, err)
+		}
+		respondError(w, http.StatusBadGateway, "Failed to hold call")
+		return
+	}
+
+	logInfof(r.Context(), "⏸️ Call %s put on hold by %s", *customer.Sid, user.AgentID)
+	s.audit(r.Context(), user, auditCallHold, *customer.Sid, "")
+
+	if held, err := s.queries.GetCallBySid(r.Context(), call.CallSid); err == nil {
+		call = held
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallHoldResponse{
+		Success: true,
+		CallSid: *customer.Sid,
+		Call:    &call,
+	})
+}
+
+// resumeCall takes a held customer off hold and rings them back through to
+// the agent who held them. callSid is the leg the call was held from.
+func (s *Server) resumeCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	call, err := s.heldCall(r.Context(), chi.URLParam(r, "callSid"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && call.CompanyID.Int64 != user.CompanyID) {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error finding held call %s: %v", chi.URLParam(r, "callSid"), err)
+		respondError(w, http.StatusBadGateway, "Failed to resume call")
+		return
+	}
+	if !call.HoldStartedAt.Valid {
+		respondError(w, http.StatusConflict, "Call is not on hold")
+		return
+	}
+	if call.HeldBy.String != user.AgentID {
+		respondError(w, http.StatusForbidden, "Call is held by another agent")
+		return
+	}
+
+	customerSID := call.HeldCallSid.String
+	customer, err := fetchCall(s.twilio.Api, customerSID)
+	if errors.Is(err, errCallNotFound) || (err == nil && (customer.Status == nil || *customer.Status != "in-progress")) {
+		s.endHold(r.Context(), call)
+		respondError(w, http.StatusConflict, "Call is no longer in progress")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error fetching held call %s: %v", customerSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to resume call")
+		return
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(retrieveTwiML(user.AgentID))
+	if _, err := s.twilio.Api.UpdateCall(customerSID, update); err != nil {
+		logErrorf(r.Context(), "Error taking %s off hold: %v", customerSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to resume call")
+		return
+	}
+
+	resumed, err := s.endHold(r.Context(), call)
+	if err == nil {
+		call = resumed
+	}
+
+	logInfof(r.Context(), "▶️ Call %s resumed by %s after %ds on hold in total", customerSID, user.AgentID, call.HoldSeconds)
+	s.audit(r.Context(), user, auditCallResume, customerSID, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallHoldResponse{
+		Success: true,
+		CallSid: customerSID,
+		Call:    &call,
+	})
+}
+
+// heldCall finds the history row for the leg a call was held from. Outbound
+// calls are kept under that leg; inbound ones under the customer's leg that
+// dialed it.
+func (s *Server) heldCall(ctx context.Context, callSID string) (db.Call, error) {
+	call, err := s.queries.GetCallBySid(ctx, callSID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return call, err
+	}
+
+	agentLeg, err := fetchCall(s.twilio.Api, callSID)
+	if errors.Is(err, errCallNotFound) || (err == nil && agentLeg.ParentCallSid == nil) {
+		return db.Call{}, sql.ErrNoRows
+	}
+	if err != nil {
+		return db.Call{}, err
+	}
+	return s.queries.GetCallBySid(ctx, *agentLeg.ParentCallSid)
+}
+
+// endHold takes a call off hold, adding the time it spent there to its
+// total.
+func (s *Server) endHold(ctx context.Context, call db.Call) (db.Call, error) {
+	seconds := int64(time.Since(call.HoldStartedAt.Time).Seconds())
+	ended, err := s.queries.EndCallHold(ctx, db.EndCallHoldParams{
+		HoldSeconds: max(seconds, 0),
+		ID:          call.ID,
+	})
+	if err != nil {
+		logErrorf(ctx, "Error ending hold on call %s: %v", call.CallSid, err)
+	}
+	return ended, err
+}
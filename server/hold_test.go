@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"omnicall/db"
+	"testing"
+)
+
+// holdServer has acme1 on an outbound call, CA-agent, whose customer leg
+// CA-customer is in the given status. Acme plays its own hold music.
+func holdServer(t *testing.T, customerStatus string) (*Server, *fakeTwilio, map[string]*db.User) {
+	t.Helper()
+	s := newTestServer(t)
+	fake := withFakeTwilio(s)
+	customer := `{"sid":"CA-customer","parent_call_sid":"CA-agent","from":"+27110000001","to":"+27825550001","status":"` + customerStatus + `"}`
+	fake.responses = map[string]string{
+		"/Calls/CA-agent.json":    `{"sid":"CA-agent","from":"client:acme1","to":"+27825550001","status":"in-progress"}`,
+		"/Calls.json":             `{"calls":[` + customer + `]}`,
+		"/Calls/CA-customer.json": customer,
+	}
+	acme := addCompany(t, s, "Acme")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, 'https://acme.example/hold.mp3')", acme, settingHoldMusicURL)
+	exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, agent_id, status, started_at)
+		VALUES ('CA-agent', 'outbound', ?, 'acme1', 'in-progress', CURRENT_TIMESTAMP)`, acme)
+	return s, fake, map[string]*db.User{
+		"acme1": addAgent(t, s, acme, "acme1", roleAgent),
+		"acme2": addAgent(t, s, acme, "acme2", roleAgent),
+	}
+}
+
+func holdRequest(s *Server, handler http.HandlerFunc, user *db.User, action, callSID string) (int, CallHoldResponse) {
+	w := httptest.NewRecorder()
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/"+callSID+"/"+action, nil), user)
+	handler(w, withURLParams(r, map[string]string{"callSid": callSID}))
+	var resp CallHoldResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+func TestHoldAndResume(t *testing.T) {
+	s, fake, users := holdServer(t, "in-progress")
+	hold := func(user, callSID string) (int, CallHoldResponse) {
+		return holdRequest(s, s.holdCall, users[user], "hold", callSID)
+	}
+	resume := func(user, callSID string) (int, CallHoldResponse) {
+		return holdRequest(s, s.resumeCall, users[user], "resume", callSID)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		request func(user, callSID string) (int, CallHoldResponse)
+		user    string
+		callSID string
+		want    int
+	}{
+		{"unknown call", hold, "acme1", "CA-gone", http.StatusNotFound},
+		{"someone else's call", hold, "acme2", "CA-agent", http.StatusNotFound},
+		{"resume before holding", resume, "acme1", "CA-agent", http.StatusConflict},
+	} {
+		if code, _ := tt.request(tt.user, tt.callSID); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if redirectedTo(fake, "CA-customer") != "" {
+		t.Fatal("redirected the customer on a refused request")
+	}
+
+	code, resp := hold("acme1", "CA-agent")
+	if code != http.StatusOK || resp.CallSid != "CA-customer" {
+		t.Fatalf("status = %d, call %q; want %d holding CA-customer", code, resp.CallSid, http.StatusOK)
+	}
+	if got, want := redirectedTo(fake, "CA-customer"), holdTwiML("https://acme.example/hold.mp3"); got != want {
+		t.Errorf("customer sent to %s, want the company's hold music %s", got, want)
+	}
+	if !resp.Call.HoldStartedAt.Valid || resp.Call.HeldBy.String != "acme1" || resp.Call.HeldCallSid.String != "CA-customer" {
+		t.Errorf("call %+v, want it on hold by acme1", resp.Call)
+	}
+	if code, _ := hold("acme1", "CA-agent"); code != http.StatusConflict {
+		t.Errorf("holding twice: status = %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := resume("acme2", "CA-agent"); code != http.StatusForbidden {
+		t.Errorf("another agent resuming: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Hold time adds up over every hold on the call
+	exec(t, s, "UPDATE calls SET hold_started_at = datetime('now', '-30 seconds') WHERE call_sid = 'CA-agent'")
+	code, resp = resume("acme1", "CA-agent")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	if got, want := redirectedTo(fake, "CA-customer"), retrieveTwiML("acme1"); got != want {
+		t.Errorf("customer sent to %s, want back to acme1 %s", got, want)
+	}
+	if resp.Call.HoldStartedAt.Valid || resp.Call.HoldSeconds < 30 {
+		t.Errorf("call on hold %v for %ds, want off hold after 30s", resp.Call.HoldStartedAt.Valid, resp.Call.HoldSeconds)
+	}
+	hold("acme1", "CA-agent")
+	exec(t, s, "UPDATE calls SET hold_started_at = datetime('now', '-20 seconds') WHERE call_sid = 'CA-agent'")
+	if _, resp = resume("acme1", "CA-agent"); resp.Call.HoldSeconds < 50 || resp.Call.HoldSeconds > 52 {
+		t.Errorf("held %ds in total, want 50s", resp.Call.HoldSeconds)
+	}
+}
+
+func TestHoldEndedCall(t *testing.T) {
+	s, _, users := holdServer(t, "ringing")
+	if code, _ := holdRequest(s, s.holdCall, users["acme1"], "hold", "CA-agent"); code != http.StatusConflict {
+		t.Errorf("holding a call not in progress: status = %d, want %d", code, http.StatusConflict)
+	}
+
+	// The customer hangs up while on hold: resuming clears the hold
+	s, fake, users := holdServer(t, "in-progress")
+	holdRequest(s, s.holdCall, users["acme1"], "hold", "CA-agent")
+	fake.responses["/Calls/CA-customer.json"] = `{"sid":"CA-customer","status":"completed"}`
+	if code, _ := holdRequest(s, s.resumeCall, users["acme1"], "resume", "CA-agent"); code != http.StatusConflict {
+		t.Errorf("resuming a call that ended: status = %d, want %d", code, http.StatusConflict)
+	}
+	if call, _ := s.queries.GetCallBySid(t.Context(), "CA-agent"); call.HoldStartedAt.Valid {
+		t.Error("call still on hold after it ended")
+	}
+}
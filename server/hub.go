@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events a subscriber can fall behind before new
+// ones are dropped for it.
+const eventBuffer = 16
+
+// eventHeartbeat is how often an idle event stream gets a comment line, well
+// inside the idle timeouts of the proxies between it and the browser.
+const eventHeartbeat = 25 * time.Second
+
+// realtimeEvent is a notification for the browsers of one company, such as
+// the screen-pop for an incoming call.
+type realtimeEvent struct {
+	Type      string `json:"type"`
+	CompanyID int64  `json:"-"`
+	AgentID   string `json:"agent_id,omitempty"`
+	Data      any    `json:"data"`
+}
+
+// eventHub fans events out to each company's subscribers. Publishing never
+// waits on a subscriber: a full buffer means that subscriber misses the
+// event, which is better than holding up the call that caused it.
+type eventHub struct {
+	mu          sync.RWMutex
+	subscribers map[int64]map[chan realtimeEvent]struct{}
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[int64]map[chan realtimeEvent]struct{})}
+}
+
+func (h *eventHub) subscribe(companyID int64) chan realtimeEvent {
+	ch := make(chan realtimeEvent, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	if h.subscribers[companyID] == nil {
+		h.subscribers[companyID] = make(map[chan realtimeEvent]struct{})
+	}
+	h.subscribers[companyID][ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(companyID int64, ch chan realtimeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[companyID][ch]; !ok {
+		return
+	}
+	delete(h.subscribers[companyID], ch)
+	if len(h.subscribers[companyID]) == 0 {
+		delete(h.subscribers, companyID)
+	}
+	close(ch)
+}
+
+// close ends every subscription, so the streams holding them return and the
+// server can shut down.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscribers := range h.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	h.subscribers = make(map[int64]map[chan realtimeEvent]struct{})
+	h.closed = true
+}
+
+// publish hands ev to every subscriber of its company that has room for it
+// and returns how many didn't.
+func (h *eventHub) publish(ev realtimeEvent) (dropped int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subscribers[ev.CompanyID] {
+		select {
+		case ch <- ev:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// notify publishes an event on a best-effort basis. Notifications are
+// never allowed to fail or slow the request that raised them, so even a
+// panicking hub only costs a log line.
+func (s *Server) notify(ev realtimeEvent) {
+	if s.hub == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			logWarnf(context.Background(), "⚠️ Realtime hub failed publishing %s: %v", ev.Type, err)
+		}
+	}()
+	if dropped := s.hub.publish(ev); dropped > 0 {
+		logWarnf(context.Background(), "⚠️ Dropped %s notification for %d subscriber(s) of company %d", ev.Type, dropped, ev.CompanyID)
+	}
+}
+
+// streamEvents sends the caller's company events to the browser as
+// server-sent events, one JSON realtimeEvent per message, until the browser
+// goes away or the server shuts down. EventSource reconnects by itself, so
+// events raised while it is away are simply missed.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	// The stream outlives any write timeout meant for ordinary requests
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		logErrorf(r.Context(), "Error starting event stream: %v", err)
+		return
+	}
+
+	events := s.hub.subscribe(user.CompanyID)
+	defer s.hub.unsubscribe(user.CompanyID, events)
+	logInfof(r.Context(), "📡 Event stream opened by %s", user.AgentID)
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				logErrorf(r.Context(), "Error encoding %s event: %v", ev.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIncomingCallSurvivesHub(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *Server, companyID int64)
+		log   string // the warning left behind, empty for none
+	}{
+		{"healthy", func(s *Server, companyID int64) { s.hub.subscribe(companyID) }, ""},
+		{"no hub", func(s *Server, companyID int64) { s.hub = nil }, ""},
+		{"subscriber fallen behind", func(s *Server, companyID int64) {
+			ch := s.hub.subscribe(companyID)
+			for range eventBuffer {
+				ch <- realtimeEvent{}
+			}
+		}, "Dropped incoming_call notification"},
+		{"hub panics", func(s *Server, companyID int64) {
+			// Publishing to a channel closed behind the hub's back panics
+			close(s.hub.subscribe(companyID))
+		}, "Realtime hub failed publishing"},
+	}
+
+	var want string
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+			tt.setup(s, acme)
+			logs := captureLogs(t)
+
+			w := httptest.NewRecorder()
+			s.handleIncomingCall(w, twilioWebhook("/twilio/incoming-call", url.Values{
+				"CallSid": {"CA-hub"}, "From": {"+27825550001"}, "To": {"+27110000001"}, "CallStatus": {"ringing"},
+			}))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Client") {
+				t.Fatalf("status = %d, want %d dialing the agent: %s", w.Code, http.StatusOK, w.Body)
+			}
+			// The healthy hub's call sets what every other one must match
+			if i == 0 {
+				want = w.Body.String()
+			} else if got := w.Body.String(); got != want {
+				t.Errorf("TwiML = %s, want the same as with a healthy hub: %s", got, want)
+			}
+			if _, err := s.queries.GetCallBySid(t.Context(), "CA-hub"); err != nil {
+				t.Errorf("call not recorded: %v", err)
+			}
+			if tt.log != "" && !strings.Contains(logs.String(), tt.log) {
+				t.Errorf("logs = %q, want %q", logs, tt.log)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+// idempotencyTTLFromEnv reads how long a response is kept for retries,
+// IDEMPOTENCY_KEY_TTL, which defaults to a day.
+func idempotencyTTLFromEnv() (time.Duration, error) {
+	value := os.Getenv("IDEMPOTENCY_KEY_TTL")
+	if value == "" {
+		return 24 * time.Hour, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < time.Minute {
+		return 0, errors.New("IDEMPOTENCY_KEY_TTL must be a duration of at least 1m")
+	}
+	return ttl, nil
+}
+
+// idempotencyHash fingerprints a request, so a key reused for a different
+// request can be told from a retry.
+func idempotencyHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent lets a client retry a request safely by sending the same
+// Idempotency-Key header. The first request with a key runs as usual and its
+// response is kept until the key expires; a retry is answered with that
+// response instead of running again. The unique key on the table decides
+// between retries racing each other: whichever loses is told the first is
+// still in progress. Server errors aren't kept, so those can be retried.
+// Requests without the header are passed straight through.
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		user, _ := userFromContext(r.Context())
+
+		body, err := io.ReadAll(r.Body)
+		if respondBodyTooLarge(w, err) {
+			return
+		}
+		if err != nil {
+			respondValidationError(w, errCodeInvalidBody, "Invalid request body", nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := idempotencyHash(r, body)
+
+		now := time.Now().UTC()
+		if _, err := s.queries.DeleteExpiredIdempotencyKeys(r.Context(), now); err != nil {
+			logWarnf(r.Context(), "Error clearing expired idempotency keys: %v", err)
+		}
+		err = s.queries.CreateIdempotencyKey(r.Context(), db.CreateIdempotencyKeyParams{
+			UserID:         user.ID,
+			IdempotencyKey: key,
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			RequestHash:    hash,
+			ExpiresAt:      now.Add(s.idempotencyTTL),
+		})
+		if isUniqueViolation(err) {
+			s.replayIdempotent(w, r, user.ID, key, hash)
+			return
+		}
+		if err != nil {
+			logErrorf(r.Context(), "Error claiming idempotency key: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to process request")
+			return
+		}
+
+		keyParams := db.DeleteIdempotencyKeyParams{UserID: user.ID, IdempotencyKey: key}
+		completed := false
+		defer func() {
+			// A panic or a server error frees the key for the retry
+			if !completed {
+				if err := s.queries.DeleteIdempotencyKey(context.WithoutCancel(r.Context()), keyParams); err != nil {
+					logErrorf(r.Context(), "Error releasing idempotency key: %v", err)
+				}
+			}
+		}()
+
+		var response bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&response)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		if err := s.queries.CompleteIdempotencyKey(r.Context(), db.CompleteIdempotencyKeyParams{
+			StatusCode:     sql.NullInt64{Int64: int64(status), Valid: true},
+			ContentType:    nullString(ww.Header().Get("Content-Type")),
+			ResponseBody:   response.Bytes(),
+			UserID:         user.ID,
+			IdempotencyKey: key,
+		}); err != nil {
+			logErrorf(r.Context(), "Error storing idempotent response: %v", err)
+			return
+		}
+		completed = true
+	})
+}
+
+// replayIdempotent answers a request whose key is already taken: with the
+// stored response when it's a retry of the same request, and with an error
+// when the first is still running or the key was used for something else.
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, userID int64, key, hash string) {
+	stored, err := s.queries.GetIdempotencyKey(r.Context(), db.GetIdempotencyKeyParams{
+		UserID:         userID,
+		IdempotencyKey: key,
+	})
+	if err == sql.ErrNoRows {
+		// Released between the insert and now
+		respondError(w, http.StatusConflict, "Request with this Idempotency-Key failed, retry it")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error getting idempotency key: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if stored.RequestHash != hash {
+		respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if !stored.StatusCode.Valid {
+		respondError(w, http.StatusConflict, "Request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	logInfof(r.Context(), "🔁 Replaying response for idempotency key %s", key)
+	if stored.ContentType.Valid {
+		w.Header().Set("Content-Type", stored.ContentType.String)
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(int(stored.StatusCode.Int64))
+	w.Write(stored.ResponseBody)
+}
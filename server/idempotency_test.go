@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		hangUp   bool // the client goes away while the request runs
+		replayed bool
+	}{
+		{"kept", http.StatusCreated, false, true},
+		{"client error kept", http.StatusBadRequest, false, true},
+		{"server error released", http.StatusInternalServerError, false, false},
+		{"client gone released", http.StatusInternalServerError, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.idempotencyTTL = time.Hour
+			acme := addCompany(t, s, "Acme")
+			agent := addAgent(t, s, acme, "agent1", roleAgent)
+
+			runs := 0
+			var cancel context.CancelFunc
+			handler := s.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				if runs == 1 && tt.hangUp {
+					cancel()
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if runs == 1 {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(`{"run":"first"}`))
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"run":"retry"}`))
+			}))
+
+			send := func() *httptest.ResponseRecorder {
+				ctx, c := context.WithCancel(context.Background())
+				cancel = c
+				defer c()
+				r := httptest.NewRequest(http.MethodPost, "/api/sms/send", strings.NewReader(`{"to":"+27825550001"}`)).WithContext(ctx)
+				r.Header.Set(idempotencyKeyHeader, "key-1")
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, asUser(r, agent))
+				return w
+			}
+			send()
+			w := send()
+
+			if replayed := w.Header().Get(idempotencyReplayedHeader) == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.replayed)
+			}
+			wantRuns := 2
+			if tt.replayed {
+				wantRuns = 1
+			}
+			if runs != wantRuns {
+				t.Errorf("handler ran %d times, want %d", runs, wantRuns)
+			}
+			if tt.replayed && (w.Code != tt.status || !strings.Contains(w.Body.String(), "first")) {
+				t.Errorf("retry = %d %s, want the first response", w.Code, w.Body)
+			}
+		})
+	}
+}
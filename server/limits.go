@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUploadBody lets multipart uploads past the usual body limit, up to what
+// the largest of them, a voicemail drop, needs. Upload handlers hold their
+// own bodies to less.
+const maxUploadBody = maxVoicemailDropSize + 1<<20
+
+// httpLimits bounds what one request can cost the server: how large a body
+// it may send, and how long reading it, writing the response and an idle
+// keep-alive connection may take.
+type httpLimits struct {
+	maxBody      int64
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+}
+
+// httpLimitsFromEnv reads MAX_BODY_BYTES (default 1 MiB), and
+// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT (defaults 15s,
+// 30s and 2m).
+func httpLimitsFromEnv() (httpLimits, error) {
+	limits := httpLimits{
+		maxBody:      1 << 20,
+		readTimeout:  15 * time.Second,
+		writeTimeout: 30 * time.Second,
+		idleTimeout:  2 * time.Minute,
+	}
+	if value := os.Getenv("MAX_BODY_BYTES"); value != "" {
+		maxBody, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBody < 1<<10 {
+			return limits, errors.New("MAX_BODY_BYTES must be a number of bytes of at least 1024")
+		}
+		limits.maxBody = maxBody
+	}
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", &limits.readTimeout},
+		{"HTTP_WRITE_TIMEOUT", &limits.writeTimeout},
+		{"HTTP_IDLE_TIMEOUT", &limits.idleTimeout},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < time.Second {
+			return limits, errors.New(setting.name + " must be a duration of at least 1s")
+		}
+		*setting.value = timeout
+	}
+	return limits, nil
+}
+
+// limitBody caps every request body at the configured size. Reading past it
+// fails with an *http.MaxBytesError, which decodeJSON answers with a 413.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.limits.maxBody
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			limit = max(limit, maxUploadBody)
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondBodyTooLarge answers with a 413 when err is a body going over its
+// limit, and reports whether it was.
+func respondBodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit))
+	return true
+}
+
+// decodeJSON reads a JSON request body into v. Fields v doesn't have are
+// refused rather than dropped, so a misspelt field fails loudly. When the
+// body won't do, decodeJSON answers the request itself and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return true
+	}
+	if respondBodyTooLarge(w, err) {
+		return false
+	}
+
+	// The decoder only names the field in its message: json: unknown field "x"
+	var fields map[string]string
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if name, err := strconv.Unquote(field); err == nil {
+			fields = map[string]string{name: "Unknown field"}
+		}
+	}
+	respondValidationError(w, errCodeInvalidBody, "Invalid request body", fields)
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitBody(t *testing.T) {
+	s := newTestServer(t)
+	s.limits.maxBody = 1 << 10
+	handler := s.limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		size        int
+		contentType string
+		status      int
+	}{
+		{"small body", 100, "application/json", http.StatusOK},
+		{"at the limit", 1 << 10, "application/json", http.StatusOK},
+		{"over the limit", 1<<10 + 1, "application/json", http.StatusRequestEntityTooLarge},
+		{"far over the limit", 10 << 20, "application/json", http.StatusRequestEntityTooLarge},
+		{"upload past the usual limit", 2 << 10, "multipart/form-data; boundary=x", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A JSON object padded to exactly size bytes
+			body := `{"note":"` + strings.Repeat("a", tt.size-len(`{"note":""}`)) + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/api/customers", strings.NewReader(body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %.200s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		ok     bool
+		fields map[string]string
+	}{
+		{"known fields", `{"email":"pat@example.com","password":"x"}`, true, nil},
+		{"unknown field", `{"email":"pat@example.com","pasword":"x"}`, false, map[string]string{"pasword": "Unknown field"}},
+		{"malformed", `{"email":`, false, nil},
+		{"wrong type", `{"email":42}`, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req LoginRequest
+			w := httptest.NewRecorder()
+			ok := decodeJSON(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(tt.body)), &req)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok {
+				return
+			}
+			var resp ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if w.Code != http.StatusBadRequest || resp.Code != errCodeInvalidBody {
+				t.Errorf("status = %d, code = %q, want %d, %q", w.Code, resp.Code, http.StatusBadRequest, errCodeInvalidBody)
+			}
+			for field, message := range tt.fields {
+				if resp.Fields[field] != message {
+					t.Errorf("fields = %v, want %v", resp.Fields, tt.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPLimitsFromEnv(t *testing.T) {
+	defaults := httpLimits{maxBody: 1 << 20, readTimeout: 15 * time.Second, writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    httpLimits
+		wantErr bool
+	}{
+		{"defaults", nil, defaults, false},
+		{"body limit", map[string]string{"MAX_BODY_BYTES": "2048"}, httpLimits{maxBody: 2048, readTimeout: 15 * time.Second, writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute}, false},
+		{"smallest body limit", map[string]string{"MAX_BODY_BYTES": "1024"}, httpLimits{maxBody: 1024, readTimeout: 15 * time.Second, writeTimeout: 30 * time.Second, idleTimeout: 2 * time.Minute}, false},
+		{"body limit too small", map[string]string{"MAX_BODY_BYTES": "1023"}, defaults, true},
+		{"timeouts", map[string]string{"HTTP_READ_TIMEOUT": "5s", "HTTP_WRITE_TIMEOUT": "1m", "HTTP_IDLE_TIMEOUT": "1s"}, httpLimits{maxBody: 1 << 20, readTimeout: 5 * time.Second, writeTimeout: time.Minute, idleTimeout: time.Second}, false},
+		{"timeout too short", map[string]string{"HTTP_READ_TIMEOUT": "500ms"}, defaults, true},
+		{"timeout not a duration", map[string]string{"HTTP_IDLE_TIMEOUT": "forever"}, defaults, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"MAX_BODY_BYTES", "HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_IDLE_TIMEOUT"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got, err := httpLimitsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("limits = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
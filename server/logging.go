@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestIDHeader carries a request's correlation ID. A proxy (or caller)
+// can supply one, and every response says which one it was logged under.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps a supplied ID from bloating every log line.
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// newLogger logs JSON to out at LOG_LEVEL (info unless set), tagging each
+// record with the request ID from its context.
+func newLogger(out io.Writer) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, errors.New("LOG_LEVEL must be debug, info, warn or error")
+		}
+	}
+	return slog.New(requestIDHandler{slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})}), nil
+}
+
+// requestIDHandler adds request_id to records logged with a request's
+// context.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID gives every request a correlation ID, keeping one supplied in
+// X-Request-ID if it's sensible, and echoes it on the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = generateRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func generateRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogger logs one line per request once it has been served. Only the
+// path is logged: query strings can carry tokens and phone numbers.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
+
+// logDebugf, logInfof, logWarnf and logErrorf log a formatted message at
+// their level, with the request ID when ctx belongs to a request.
+func logDebugf(ctx context.Context, format string, args ...any) {
+	logf(ctx, slog.LevelDebug, format, args...)
+}
+
+func logInfof(ctx context.Context, format string, args ...any) {
+	logf(ctx, slog.LevelInfo, format, args...)
+}
+
+func logWarnf(ctx context.Context, format string, args ...any) {
+	logf(ctx, slog.LevelWarn, format, args...)
+}
+
+func logErrorf(ctx context.Context, format string, args ...any) {
+	logf(ctx, slog.LevelError, format, args...)
+}
+
+func logf(ctx context.Context, level slog.Level, format string, args ...any) {
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+	slog.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// redact keeps the last four characters of an identifier, which is enough
+// to tell which one was used without the log giving it away.
+func redact(s string) string {
+	if len(s) <= 4 {
+		return "****"
+	}
+	return "…" + s[len(s)-4:]
+}
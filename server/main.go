@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"omnicall/db"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -17,13 +28,37 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/twilio/twilio-go"
+	"github.com/twilio/twilio-go/client"
 	twilioJwt "github.com/twilio/twilio-go/client/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Server struct {
-	db      *sql.DB
-	queries *db.Queries
+	db                *sql.DB
+	queries           *db.Queries
+	routers           map[string]Router
+	defaultCompanyID  int64 // takes calls to unregistered numbers; 0 rejects them
+	defaultRouting    string
+	defaultVipRouting string
+	limiter           *rateLimiter
+	authLimiter       *attemptLimiter
+	passwords         passwordPolicy
+	twilio            *twilio.RestClient // nil without Twilio credentials
+	twilioConfig      twilioConfig
+	twilioValidator   *client.RequestValidator
+	presenceTimeout   time.Duration
+	idempotencyTTL    time.Duration
+	cookie            sessionCookie
+	corsOrigins       []string
+	trustedProxies    []netip.Prefix // whose X-Forwarded-For is believed
+	hub               *eventHub
+	backups           backupConfig
+	limits            httpLimits
+	operatorToken     string       // for deployment-wide endpoints; "" turns them off
+	maintenance       *atomic.Bool // the saved maintenance switch, shared with copies
+	started           time.Time
 }
 
 // Request/Response types
@@ -33,7 +68,10 @@ type RegisterRequest struct {
 	Firstname string `json:"firstname"`
 	Lastname  string `json:"lastname"`
 	AgentID   string `json:"agent_id"`
-	CompanyID int64  `json:"company_id"`
+	// Exactly one of these: joining an existing company makes an agent,
+	// while naming a new one creates it with the registrant as its admin
+	CompanyID   int64  `json:"company_id"`
+	CompanyName string `json:"company_name"`
 }
 
 type LoginRequest struct {
@@ -45,10 +83,15 @@ type CompanyCreate struct {
 	Name string `json:"name"`
 }
 
+type CompanyUpdate struct {
+	Name string `json:"name"`
+}
+
 type AuthResponse struct {
-	Success   bool        `json:"success"`
-	User      *db.User    `json:"user,omitempty"`
-	SessionID string      `json:"sessionId,omitempty"`
+	Success              bool     `json:"success"`
+	User                 *db.User `json:"user,omitempty"`
+	SessionID            string   `json:"sessionId,omitempty"`
+	VerificationRequired bool     `json:"verification_required,omitempty"`
 }
 
 type UserResponse struct {
@@ -57,8 +100,9 @@ type UserResponse struct {
 }
 
 type CompaniesResponse struct {
-	Success   bool         `json:"success"`
-	Companies []db.Company `json:"companies"`
+	Success    bool         `json:"success"`
+	Companies  []db.Company `json:"companies"`
+	Pagination Pagination   `json:"pagination"`
 }
 
 type CompanyResponse struct {
@@ -71,18 +115,23 @@ type CustomerResponse struct {
 	Customer *db.Customer `json:"customer,omitempty"`
 }
 
-type ErrorResponse struct {
-	Detail string `json:"detail"`
-}
-
 type TwilioTokenResponse struct {
-	Token    string `json:"token"`
-	Identity string `json:"identity"`
+	Token     string    `json:"token"`
+	Identity  string    `json:"identity"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 func main() {
 	// Load .env file if it exists
-	if err := godotenv.Load(); err != nil {
+	envErr := godotenv.Load()
+
+	// JSON logs from here on, including everything sent through log
+	logger, err := newLogger(os.Stderr)
+	if err != nil {
+		log.Fatal("Invalid logging settings:", err)
+	}
+	slog.SetDefault(logger)
+	if envErr != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
@@ -92,190 +141,522 @@ func main() {
 		dbPath = "./omnicall.db"
 	}
 
+	// Stored numbers are normalized in this region, starting with the schema
+	// backfill
+	if region := defaultPhoneRegion(); region != "" {
+		if !validPhoneRegion(region) {
+			log.Fatalf("Unknown DEFAULT_PHONE_REGION %q", region)
+		}
+	}
+
 	// Initialize database
 	database, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
-	defer database.Close()
 
-	// Initialize schema
-	if err := initSchema(database); err != nil {
-		log.Fatal("Failed to initialize schema:", err)
+	// Cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Bring the schema up to date
+	if err := migrate(database); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
+	twilioCfg, err := twilioConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid Twilio settings:", err)
 	}
 
 	queries := db.New(database)
-	server := &Server{db: database, queries: queries}
+	if err := bootstrapCompany(ctx, queries, os.Getenv("BOOTSTRAP_COMPANY")); err != nil {
+		log.Fatal("Failed to create bootstrap company:", err)
+	}
+	server := &Server{
+		db:                database,
+		queries:           queries,
+		routers:           newRouters(queries),
+		defaultRouting:    os.Getenv("ROUTING_STRATEGY"),
+		defaultVipRouting: os.Getenv("VIP_ROUTING_STRATEGY"),
+		limiter:           newRateLimiterFromEnv(),
+		authLimiter:       newAttemptLimiterFromEnv(),
+		twilioConfig:      twilioCfg,
+		hub:               newEventHub(),
+		maintenance:       new(atomic.Bool),
+		started:           time.Now(),
+	}
+	if server.defaultCompanyID, err = defaultCompanyFromEnv(ctx, queries); err != nil {
+		log.Fatal("Invalid default company:", err)
+	}
+	if server.corsOrigins, err = corsOriginsFromEnv(); err != nil {
+		log.Fatal("Invalid CORS settings:", err)
+	}
+	slog.Info("CORS origins allowed", "origins", server.corsOrigins)
+	if server.trustedProxies, err = trustedProxiesFromEnv(); err != nil {
+		log.Fatal("Invalid proxy settings:", err)
+	}
+	if server.cookie, err = sessionCookieFromEnv(); err != nil {
+		log.Fatal("Invalid session settings:", err)
+	}
+	if server.passwords, err = passwordPolicyFromEnv(); err != nil {
+		log.Fatal("Invalid password policy:", err)
+	}
+	if server.twilio, err = newTwilioClient(twilioCfg); err != nil {
+		log.Println("Twilio credentials not set, calls and messages through the REST API will fail")
+	}
+	if err := runTwilioStartupCheck(twilioCfg, server.twilio); err != nil {
+		log.Fatal("Twilio startup check failed: ", err)
+	}
+	if server.presenceTimeout, err = presenceTimeoutFromEnv(); err != nil {
+		log.Fatal("Invalid agent presence settings:", err)
+	}
+	if server.idempotencyTTL, err = idempotencyTTLFromEnv(); err != nil {
+		log.Fatal("Invalid idempotency key settings:", err)
+	}
+	if server.twilioValidator, err = twilioValidatorFromEnv(); err != nil {
+		log.Fatal("Invalid Twilio webhook settings:", err)
+	}
+	if server.twilioValidator == nil {
+		slog.Warn("Twilio webhook signatures are not being checked")
+	}
+	if server.operatorToken, err = operatorTokenFromEnv(); err != nil {
+		log.Fatal("Invalid operator settings:", err)
+	}
+	if err := server.loadMaintenance(ctx); err != nil {
+		log.Fatal("Failed to read maintenance mode:", err)
+	}
+	if server.backups, err = backupConfigFromEnv(); err != nil {
+		log.Fatal("Invalid backup settings:", err)
+	}
+	if server.backups.interval > 0 {
+		go server.runScheduledBackups(ctx)
+	}
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		if _, err := safeExternalURL(context.Background(), base, true); err != nil {
+			log.Fatal("Invalid PUBLIC_BASE_URL:", err)
+		}
+	}
+	callbackInterval, err := queueCallbackInterval()
+	if err != nil {
+		log.Fatal("Invalid queue settings:", err)
+	}
+	// Callbacks are placed without a request to learn the server's address from
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" && server.twilio != nil {
+		go server.runQueueCallbacks(ctx, strings.TrimSuffix(base, "/"), callbackInterval)
+	} else {
+		slog.Warn("Queued callbacks are only placed when an agent takes the next caller: set PUBLIC_BASE_URL and Twilio credentials to place them automatically")
+	}
+	if err := validateDialPrefixes(os.Getenv("OUTBOUND_DIAL_PREFIXES")); err != nil {
+		log.Fatal("Invalid OUTBOUND_DIAL_PREFIXES:", err)
+	}
+	if server.limits, err = httpLimitsFromEnv(); err != nil {
+		log.Fatal("Invalid HTTP limits:", err)
+	}
+	shutdownTimeout, err := shutdownTimeoutFromEnv()
+	if err != nil {
+		log.Fatal("Invalid shutdown settings:", err)
+	}
+	if server.defaultRouting == "" {
+		server.defaultRouting = routingFirstAvailable
+	}
+	if err := validateRoutingStrategy(server.defaultRouting); err != nil {
+		log.Fatal("Invalid ROUTING_STRATEGY:", err)
+	}
+	if server.defaultVipRouting != "" {
+		if err := validateRoutingStrategy(server.defaultVipRouting); err != nil {
+			log.Fatal("Invalid VIP_ROUTING_STRATEGY:", err)
+		}
+	}
 
 	// Setup router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
+	r.Use(requestID)
+	r.Use(requestLogger)
+	r.Use(instrumentHTTP)
 	r.Use(middleware.Recoverer)
+	r.Use(server.rejectDisallowedOrigins)
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:8000", "http://localhost:3001", "http://localhost:5173"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowOriginFunc:  server.allowOrigin,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{requestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
+	r.Use(server.maintenanceMode)
+	r.Use(server.limitBody)
 
 	// Routes
 	r.Get("/", server.root)
 	r.Get("/health", server.health)
-
-	// Auth routes
-	r.Post("/api/auth/register", server.register)
-	r.Post("/api/auth/login", server.login)
+	r.Get("/live", server.live)
+	r.Get("/ready", server.ready)
+	r.Get("/version", server.version)
+	r.Get("/api/config", server.getConfig)
+	r.Handle("/metrics", promhttp.Handler())
+
+	// Auth routes. The ones that take an email are throttled against
+	// guessing; logins only count the ones that fail.
+	r.With(server.authRateLimit).Post("/api/auth/register", server.register)
+	r.With(server.authFailureLimit).Post("/api/auth/login", server.login)
 	r.Post("/api/auth/logout", server.logout)
-	r.Get("/api/auth/me", server.getCurrentUser)
-
-	// Company routes
-	r.Get("/api/companies", server.getCompanies)
-	r.Post("/api/companies", server.createCompany)
-
-	// Customer routes
-	r.Get("/api/customers/by-phone", server.getCustomerByPhone)
-
-	// Twilio routes
-	r.Get("/api/twilio/token", server.getTwilioToken)
-
-	// Twilio webhooks (public endpoints for TwiML)
-	r.Post("/twilio/outbound-voice", server.handleOutboundVoice)
-	r.Get("/twilio/outbound-voice", server.handleOutboundVoice)
-	r.Post("/twilio/incoming-call", server.handleIncomingCall)
-	r.Get("/twilio/incoming-call", server.handleIncomingCall)
-
-	fmt.Println("\n🚀 OmniCall API Server running on http://localhost:3000")
-	fmt.Println("📊 Health check: http://localhost:3000/health")
-	fmt.Println("🔐 Auth API: http://localhost:3000/api/auth")
-	fmt.Println("🏢 Companies API: http://localhost:3000/api/companies")
-	fmt.Println("📞 Twilio API: http://localhost:3000/api/twilio\n")
-
-	log.Fatal(http.ListenAndServe(":3000", r))
-}
-
-func initSchema(database *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS companies (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL UNIQUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		email TEXT NOT NULL UNIQUE,
-		password_hash TEXT NOT NULL,
-		firstname TEXT NOT NULL,
-		lastname TEXT NOT NULL,
-		agent_id TEXT NOT NULL UNIQUE,
-		company_id INTEGER NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (company_id) REFERENCES companies (id)
-	);
-
-	CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		expires_at DATETIME NOT NULL,
-		FOREIGN KEY (user_id) REFERENCES users (id)
-	);
-	`
-	_, err := database.Exec(schema)
-	return err
+	r.With(server.authRateLimit).Post("/api/auth/forgot-password", server.forgotPassword)
+	r.Post("/api/auth/reset-password", server.resetPassword)
+	r.Get("/api/auth/verify", server.verifyEmail)
+	r.With(server.authRateLimit).Post("/api/auth/resend-verification", server.resendVerification)
+
+	// Deployment-wide controls are for the operator, not any one company
+	r.Group(func(r chi.Router) {
+		r.Use(server.requireOperator)
+
+		r.Get("/api/admin/maintenance", server.getMaintenance)
+		r.Put("/api/admin/maintenance", server.updateMaintenance)
+		r.Post("/api/admin/backup", server.createBackup)
+	})
+
+	// Everything else under /api needs a session
+	r.Group(func(r chi.Router) {
+		r.Use(server.requireAuth)
+		r.Use(server.rateLimit)
+
+		r.Get("/api/auth/me", server.getCurrentUser)
+		r.Post("/api/auth/change-password", server.changePassword)
+		r.Get("/api/auth/sessions", server.getSessions)
+		r.Delete("/api/auth/sessions/{id}", server.revokeSession)
+		r.Post("/api/auth/sessions/revoke-all", server.revokeOtherSessions)
+
+		// Company routes
+		r.Get("/api/companies", server.getCompanies)
+
+		// Customer routes
+		r.Get("/api/customers", server.getCustomers)
+		r.Post("/api/customers", server.createCustomer)
+		r.Get("/api/customers/by-phone", server.getCustomerByPhone)
+		r.Get("/api/customers/search", server.searchCustomers)
+		r.Get("/api/customers/{id}", server.getCustomer)
+		r.Put("/api/customers/{id}", server.updateCustomer)
+		r.Put("/api/customers/{id}/vip", server.markCustomerVip)
+		r.Delete("/api/customers/{id}/vip", server.unmarkCustomerVip)
+		r.Post("/api/customers/{id}/voicemail/replay", server.replayVoicemail)
+		r.Get("/api/voicemails", server.getVoicemails)
+		r.Patch("/api/voicemails/{id}", server.updateVoicemail)
+		r.Delete("/api/customers/{id}", server.softDeleteHandler("Customer", "deleted", server.deleteCustomer))
+
+		// Phone number routes
+		r.Get("/api/phone-numbers", server.getPhoneNumbers)
+
+		// Agent status routes
+		r.Get("/api/agents/status", server.getAgentStatuses)
+		r.Post("/api/agents/status", server.setAgentPresence)
+		r.Post("/api/agents/heartbeat", server.agentHeartbeat)
+		r.Get("/api/agents/{agentId}/status", server.getAgentStatus)
+
+		// The signed-in agent's own data
+		r.Get("/api/me/calls", server.getMyCalls)
+		r.Get("/api/me/status", server.getMyStatus)
+		r.Put("/api/me/dnd", server.setMyDnd)
+		r.Get("/api/me/voicemail-drops", server.getVoicemailDrops)
+
+		// Admin routes
+		r.Group(func(r chi.Router) {
+			r.Use(server.requireRole(roleAdmin))
+
+			r.Get("/api/audit-log", server.getAuditLog)
+			r.Get("/api/users", server.getUsers)
+			r.Put("/api/users/{id}/caller-number", server.setUserCallerNumber)
+			r.Put("/api/users/{id}/role", server.setUserRole)
+			r.Delete("/api/users/{id}", server.softDeleteHandler("User", "deleted", server.deleteUser))
+			r.Post("/api/users/{id}/restore", server.softDeleteHandler("User", "restored", server.restoreUser))
+			r.Put("/api/agents/{agentId}/dnd", server.setAgentDnd)
+
+			r.Post("/api/customers/{id}/restore", server.softDeleteHandler("Customer", "restored", server.restoreCustomer))
+
+			r.Post("/api/phone-numbers", server.createPhoneNumber)
+			r.Put("/api/phone-numbers/{id}", server.updatePhoneNumber)
+			r.Delete("/api/phone-numbers/{id}", server.deletePhoneNumber)
+
+			r.Get("/api/blocked-numbers", server.getBlockedNumbers)
+			r.Post("/api/blocked-numbers", server.createBlockedNumber)
+			r.Delete("/api/blocked-numbers/{id}", server.deleteBlockedNumber)
+
+			r.Put("/api/settings/{key}", server.updateSetting)
+
+			r.Get("/api/customers/export", server.exportCustomers)
+			r.Get("/api/reports/calls", server.getCallVolumeReport)
+			r.Get("/api/reports/agents", server.getAgentPerformanceReport)
+			r.Get("/api/call-errors", server.getCallErrors)
+
+			r.Get("/api/business-hours", server.getBusinessHours)
+			r.Put("/api/business-hours", server.updateBusinessHours)
+			r.Delete("/api/business-hours", server.deleteBusinessHours)
+
+			r.Post("/api/companies", server.createCompany)
+			r.Put("/api/companies/{id}", server.updateCompany)
+			r.Delete("/api/companies/{id}", server.softDeleteHandler("Company", "deleted", server.deleteCompany))
+			r.Post("/api/companies/{id}/restore", server.softDeleteHandler("Company", "restored", server.restoreCompany))
+
+			r.Get("/api/admin/voicemails", server.listVoicemailsForCleanup)
+			r.Post("/api/admin/voicemails/bulk-delete", server.bulkDeleteVoicemails)
+
+			r.Post("/api/twilio/simulate-incoming", server.simulateIncomingCall)
+		})
+
+		// Realtime events
+		r.Get("/api/events", server.streamEvents)
+
+		// Settings routes
+		r.Get("/api/settings", server.getSettings)
+
+		// Call routes
+		r.Get("/api/calls", server.getCalls)
+		r.Get("/api/calls/lookup", server.getCallRef)
+		r.With(server.idempotent).Post("/api/calls/dial", server.dialCall)
+		r.With(server.requireRole(roleSupervisor, roleAdmin)).Get("/api/calls/{callSid}/bundle", server.getCallBundle)
+		r.Post("/api/calls/{callSid}/voicemail-drop", server.playVoicemailDrop)
+		r.Post("/api/calls/{callSid}/disposition", server.createCallDisposition)
+		r.Get("/api/calls/{callSid}/notes", server.getCallNotes)
+		r.Post("/api/calls/{callSid}/notes", server.createCallDisposition)
+		r.Post("/api/calls/{callSid}/park", server.parkCall)
+		r.Get("/api/calls/{callSid}/recording", server.getCallRecording)
+		r.Post("/api/calls/{callSid}/transfer", server.transferCall)
+		r.Post("/api/calls/{callSid}/conference", server.conferenceCall)
+		r.Post("/api/calls/{callSid}/hold", server.holdCall)
+		r.Post("/api/calls/{callSid}/resume", server.resumeCall)
+		r.Post("/api/calls/{callSid}/dtmf", server.sendCallDTMF)
+		r.Post("/api/calls/{callSid}/mute", server.muteCall)
+
+		// Call parking
+		r.Get("/api/parked-calls", server.getParkedCalls)
+		r.Post("/api/parked-calls/{code}/retrieve", server.retrieveParkedCall)
+
+		// Voicemail drop routes
+		r.Get("/api/voicemail-drops", server.getVoicemailDrops)
+		r.Post("/api/voicemail-drops", server.uploadVoicemailDrop)
+		r.Delete("/api/voicemail-drops/{id}", server.deleteVoicemailDrop)
+
+		// SMS routes
+		r.With(server.idempotent).Post("/api/sms/send", server.sendSMS)
+		r.Get("/api/messages", server.getMessages)
+		r.Get("/api/conversations", server.getConversations)
+
+		// Twilio routes
+		r.Get("/api/twilio/token", server.getTwilioToken)
+	})
+
+	// Twilio webhooks (public endpoints for TwiML, signed by Twilio)
+	r.Group(func(r chi.Router) {
+		r.Use(server.verifyTwilioSignature)
+
+		r.Post("/twilio/outbound-voice", server.handleOutboundVoice)
+		r.Get("/twilio/outbound-voice", server.handleOutboundVoice)
+		r.Get("/twilio/click-to-call", server.handleClickToCall)
+		r.Post("/twilio/incoming-call", server.handleIncomingCall)
+		r.Get("/twilio/incoming-call", server.handleIncomingCall)
+		r.Post("/twilio/whisper", server.handleWhisper)
+		r.Get("/twilio/whisper", server.handleWhisper)
+		r.Post("/twilio/dial-complete", server.handleDialComplete)
+		r.Post("/twilio/queue/wait", server.handleQueueWait)
+		r.Post("/twilio/queue/callback", server.handleQueueCallback)
+		r.Post("/twilio/queue/leave", server.handleQueueLeave)
+		r.Post("/twilio/call-events", server.handleCallEvent)
+		r.Post("/twilio/status-callback", server.handleStatusCallback)
+		r.Post("/twilio/fallback", server.handleCallFallback)
+		r.Post("/twilio/park/expired", server.handleParkExpired)
+		r.Post("/twilio/amd", server.handleAmdResult)
+		r.Post("/twilio/voicemail", server.handleVoicemailRecorded)
+		r.Post("/twilio/voicemail-callback", server.handleVoicemailCallback)
+		r.Post("/twilio/incoming-sms", server.handleIncomingSMS)
+		r.Post("/twilio/recording-callback", server.handleRecordingCallback)
+		r.Post("/twilio/recording-announcement", server.handleRecordingAnnouncement)
+		r.Get("/twilio/transfer-voice", server.handleTransferVoice)
+		r.Get("/twilio/conference/join", server.handleConferenceJoin)
+		r.Post("/twilio/conference/events", server.handleConferenceEvent)
+	})
+
+	// Recordings are fetched by <Play>, and the unguessable token is what
+	// protects them
+	r.Get("/twilio/voicemail-drops/{token}", server.serveVoicemailDrop)
+
+	slog.Info("🚀 OmniCall API Server running", "url", "http://localhost:3000")
+
+	// Streaming responses (events, exports, recordings) lift the write
+	// timeout for themselves
+	httpServer := &http.Server{
+		Addr:         ":3000",
+		Handler:      r,
+		ReadTimeout:  server.limits.readTimeout,
+		WriteTimeout: server.limits.writeTimeout,
+		IdleTimeout:  server.limits.idleTimeout,
+	}
+	httpServer.RegisterOnShutdown(server.hub.close)
+	err = serve(ctx, httpServer, shutdownTimeout)
+
+	// Only once no handler can still be using it
+	log.Println("🛑 Closing database")
+	if closeErr := database.Close(); closeErr != nil {
+		logErrorf(ctx, "Error closing database: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatal("Server stopped:", err)
+	}
+	log.Println("👋 Shutdown complete")
 }
 
 // Handlers
 func (s *Server) root(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "OmniCall API Server",
-		"version": "1.0.0",
+		"message": s.requestProductName(r) + " API Server",
+		"version": apiVersion,
 		"endpoints": map[string]string{
 			"health":    "/health",
+			"live":      "/live",
+			"ready":     "/ready",
+			"version":   "/version",
+			"config":    "/api/config",
 			"auth":      "/api/auth",
 			"companies": "/api/companies",
 		},
 	})
 }
 
-func (s *Server) health(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-}
-
 func (s *Server) register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate required fields
-	if req.Email == "" || req.Password == "" || req.Firstname == "" || req.Lastname == "" || req.AgentID == "" {
-		respondError(w, http.StatusBadRequest, "All fields are required")
+	missing := map[string]string{}
+	for field, value := range map[string]string{
+		"email":     req.Email,
+		"password":  req.Password,
+		"firstname": req.Firstname,
+		"lastname":  req.Lastname,
+		"agent_id":  req.AgentID,
+	} {
+		if value == "" {
+			missing[field] = "This field is required"
+		}
+	}
+	req.CompanyName = strings.TrimSpace(req.CompanyName)
+	if req.CompanyID == 0 && req.CompanyName == "" {
+		missing["company_id"] = "Pick a company or name a new one"
+	}
+	if len(missing) > 0 {
+		respondValidationError(w, errCodeMissingFields, "All fields are required", missing)
+		return
+	}
+	if req.CompanyID != 0 && req.CompanyName != "" {
+		respondError(w, http.StatusBadRequest, "Give either company_id or company_name, not both")
 		return
 	}
 
-	// Check if user exists
-	if _, err := s.queries.GetUserByEmail(r.Context(), req.Email); err == nil {
-		respondError(w, http.StatusBadRequest, "User with this email already exists")
+	// Agent IDs double as Twilio client identities
+	req.AgentID = strings.TrimSpace(req.AgentID)
+	if err := validateAgentID(req.AgentID); err != nil {
+		respondValidationError(w, errCodeInvalidAgentID, err.Error(), map[string]string{"agent_id": err.Error()})
 		return
 	}
 
-	// Check if agent_id exists
-	if _, err := s.queries.GetUserByAgentID(r.Context(), req.AgentID); err == nil {
-		respondError(w, http.StatusBadRequest, "Agent ID already exists")
+	if unmet := validatePassword(s.passwords, req.Password); len(unmet) > 0 {
+		respondWeakPassword(w, "password", unmet)
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.hash(req.Password)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to process password")
 		return
 	}
 
-	// Create user
-	user, err := s.queries.CreateUser(r.Context(), db.CreateUserParams{
-		Email:        req.Email,
-		PasswordHash: string(hashedPassword),
-		Firstname:    req.Firstname,
-		Lastname:     req.Lastname,
-		AgentID:      req.AgentID,
-		CompanyID:    req.CompanyID,
+	// The user and their session are created together, so a failed session
+	// doesn't leave behind an account the client was told nothing about
+	var user db.User
+	var session db.Session
+	errUnknownCompany := errors.New("unknown company")
+	err = s.withTx(r.Context(), func(q *db.Queries) error {
+		// Only whoever creates a company starts out as its admin; anyone
+		// joining one is an agent until an admin says otherwise
+		companyID, role := req.CompanyID, roleAgent
+		if req.CompanyName != "" {
+			company, err := q.CreateCompany(r.Context(), req.CompanyName)
+			if err != nil {
+				return err
+			}
+			companyID, role = company.ID, roleAdmin
+		} else if _, err := q.GetCompany(r.Context(), companyID); err == sql.ErrNoRows {
+			return errUnknownCompany
+		} else if err != nil {
+			return err
+		}
+
+		var err error
+		user, err = q.CreateUser(r.Context(), db.CreateUserParams{
+			Email:         req.Email,
+			PasswordHash:  string(hashedPassword),
+			Firstname:     req.Firstname,
+			Lastname:      req.Lastname,
+			AgentID:       req.AgentID,
+			CompanyID:     companyID,
+			EmailVerified: !requireEmailVerification(),
+			Role:          role,
+		})
+		// Unverified accounts don't get a session until they've verified
+		// and logged in
+		if err != nil || !user.EmailVerified {
+			return err
+		}
+		session, err = q.CreateSession(r.Context(), db.CreateSessionParams{
+			ID:        generateSessionID(),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(s.cookie.lifetime),
+			UserAgent: nullString(r.UserAgent()),
+			IpAddress: nullString(s.clientIP(r)),
+		})
+		return err
 	})
+	if errors.Is(err, errUnknownCompany) {
+		respondValidationError(w, errCodeUnknownCompany, "Company not found", map[string]string{"company_id": "No such company"})
+		return
+	}
+	// The unique indexes settle who got an email or agent ID first, where a
+	// lookup beforehand could race another registration
+	if isUniqueViolation(err, "companies.name") {
+		respondValidationError(w, errCodeCompanyTaken, "Company with this name already exists", map[string]string{"company_name": "Already taken"})
+		return
+	}
+	if isUniqueViolation(err, "users.email") {
+		respondValidationError(w, errCodeEmailTaken, "User with this email already exists", map[string]string{"email": "Already registered"})
+		return
+	}
+	if isUniqueViolation(err, "users.agent_id") {
+		respondValidationError(w, errCodeAgentIDTaken, "Agent ID already exists", map[string]string{"agent_id": "Already taken"})
+		return
+	}
 	if err != nil {
+		logErrorf(r.Context(), "Error registering %s: %v", req.AgentID, err)
 		respondError(w, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
 
-	// Create session
-	sessionID := generateSessionID()
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-
-	session, err := s.queries.CreateSession(r.Context(), db.CreateSessionParams{
-		ID:        sessionID,
-		UserID:    user.ID,
-		ExpiresAt: expiresAt,
-	})
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create session")
+	if !user.EmailVerified {
+		s.sendVerification(r, &user)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AuthResponse{
+			Success:              true,
+			User:                 &user,
+			VerificationRequired: true,
+		})
 		return
 	}
 
 	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		MaxAge:   7 * 24 * 60 * 60,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	s.cookie.set(w, session.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -288,8 +669,7 @@ func (s *Server) register(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -305,35 +685,74 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Locked accounts are refused before spending a bcrypt comparison
+	if user.LockedUntil.Valid && time.Now().Before(user.LockedUntil.Time) {
+		respondLocked(w, user.LockedUntil.Time)
+		return
+	}
+
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		if lockedUntil := s.recordFailedLogin(r.Context(), &user); !lockedUntil.IsZero() {
+			respondLocked(w, lockedUntil)
+			return
+		}
 		respondError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
-	// Create session
-	sessionID := generateSessionID()
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+	// Hashes from before BCRYPT_COST was raised are brought up to it now,
+	// while the password is at hand. Failing only leaves the old hash.
+	if s.passwords.needsRehash(user.PasswordHash) {
+		if hashed, err := s.passwords.hash(req.Password); err != nil {
+			logErrorf(r.Context(), "Error rehashing password for user %d: %v", user.ID, err)
+		} else if err := s.queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+			PasswordHash: string(hashed),
+			ID:           user.ID,
+		}); err != nil {
+			logErrorf(r.Context(), "Error storing rehashed password for user %d: %v", user.ID, err)
+		} else {
+			logInfof(r.Context(), "🔐 Password hash for user %d upgraded to cost %d", user.ID, s.passwords.cost)
+		}
+	}
 
-	session, err := s.queries.CreateSession(r.Context(), db.CreateSessionParams{
-		ID:        sessionID,
-		UserID:    user.ID,
-		ExpiresAt: expiresAt,
+	// A correct password clears earlier failures, in the same transaction
+	// as the session it's exchanged for
+	unverified := requireEmailVerification() && !user.EmailVerified
+	var session db.Session
+	err = s.withTx(r.Context(), func(q *db.Queries) error {
+		if user.FailedLoginAttempts > 0 || user.LockedUntil.Valid {
+			if err := q.ResetFailedLogins(r.Context(), user.ID); err != nil {
+				return err
+			}
+		}
+		if unverified {
+			return nil
+		}
+		var err error
+		session, err = q.CreateSession(r.Context(), db.CreateSessionParams{
+			ID:        generateSessionID(),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(s.cookie.lifetime),
+			UserAgent: nullString(r.UserAgent()),
+			IpAddress: nullString(s.clientIP(r)),
+		})
+		return err
 	})
 	if err != nil {
+		logErrorf(r.Context(), "Error creating session for user %d: %v", user.ID, err)
 		respondError(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
 
+	// The frontend offers to resend the email on this detail
+	if unverified {
+		respondError(w, http.StatusForbidden, "verification_required")
+		return
+	}
+
 	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    session.ID,
-		Path:     "/",
-		MaxAge:   7 * 24 * 60 * 60,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	s.cookie.set(w, session.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(AuthResponse{
@@ -344,84 +763,90 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err == nil {
-		s.queries.DeleteSession(r.Context(), cookie.Value)
+	if sessionID, err := s.cookie.read(r); err == nil {
+		s.queries.DeleteSession(r.Context(), sessionID)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-	})
+	s.cookie.clear(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
 func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Not authenticated")
-		return
-	}
-
-	// Get session
-	session, err := s.queries.GetSession(r.Context(), cookie.Value)
-	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Session expired")
-		return
-	}
-
-	// Check expiration
-	if time.Now().After(session.ExpiresAt) {
-		s.queries.DeleteSession(r.Context(), session.ID)
-		respondError(w, http.StatusUnauthorized, "Session expired")
-		return
-	}
-
-	// Get user
-	user, err := s.queries.GetUserByID(r.Context(), session.UserID)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "User not found")
-		return
-	}
+	user, _ := userFromContext(r.Context())
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(UserResponse{
 		Success: true,
-		User:    &user,
+		User:    user,
 	})
 }
 
+// getCompanies lists companies a page at a time, optionally only those
+// whose name contains search.
 func (s *Server) getCompanies(w http.ResponseWriter, r *http.Request) {
-	companies, err := s.queries.GetAllCompanies(r.Context())
+	page := parsePagination(r)
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+	withDeleted, ok := includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	user, _ := userFromContext(r.Context())
+
+	total, err := s.queries.CountCompanies(r.Context(), db.CountCompaniesParams{
+		IncludeDeleted: withDeleted,
+		CompanyID:      user.CompanyID,
+		Search:         search,
+	})
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get companies")
 		return
 	}
 
-	// Create default company if none exist
-	if len(companies) == 0 {
-		company, err := s.queries.CreateCompany(r.Context(), "Default Company")
-		if err == nil {
-			companies = append(companies, company)
-		}
+	companies, err := s.queries.ListCompaniesPaginated(r.Context(), db.ListCompaniesPaginatedParams{
+		IncludeDeleted: withDeleted,
+		CompanyID:      user.CompanyID,
+		Search:         search,
+		Limit:          page.PageSize,
+		Offset:         page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get companies")
+		return
 	}
+	page.Total = total
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(CompaniesResponse{
-		Success:   true,
-		Companies: companies,
+		Success:    true,
+		Companies:  companies,
+		Pagination: page,
 	})
 }
 
+// bootstrapCompany creates the first company of a fresh install, named by
+// BOOTSTRAP_COMPANY, so agents have something to register with. It does
+// nothing once any company exists.
+func bootstrapCompany(ctx context.Context, queries *db.Queries, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+	total, err := queries.CountCompanies(ctx, db.CountCompaniesParams{})
+	if err != nil || total > 0 {
+		return err
+	}
+	if err := queries.EnsureCompany(ctx, name); err != nil {
+		return err
+	}
+	log.Printf("🏢 Created bootstrap company %q", name)
+	return nil
+}
+
 func (s *Server) createCompany(w http.ResponseWriter, r *http.Request) {
 	var req CompanyCreate
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -431,12 +856,12 @@ func (s *Server) createCompany(w http.ResponseWriter, r *http.Request) {
 	}
 
 	company, err := s.queries.CreateCompany(r.Context(), req.Name)
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusBadRequest, "Company with this name already exists")
+		return
+	}
 	if err != nil {
-		if err.Error() == "UNIQUE constraint failed: companies.name" {
-			respondError(w, http.StatusBadRequest, "Company with this name already exists")
-		} else {
-			respondError(w, http.StatusInternalServerError, "Failed to create company")
-		}
+		respondError(w, http.StatusInternalServerError, "Failed to create company")
 		return
 	}
 
@@ -448,132 +873,117 @@ func (s *Server) createCompany(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *Server) getCustomerByPhone(w http.ResponseWriter, r *http.Request) {
-	phone := r.URL.Query().Get("phone")
-	if phone == "" {
-		respondError(w, http.StatusBadRequest, "Phone number is required")
+// updateCompany renames the user's own company.
+func (s *Server) updateCompany(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid company id")
 		return
 	}
 
-	log.Printf("🔍 Looking up customer by phone: %s", phone)
+	var req CompanyUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "Company name is required")
+		return
+	}
 
-	// Try exact match first
-	customer, err := s.queries.GetCustomerByPhone(r.Context(), sql.NullString{
-		String: phone,
-		Valid:  true,
-	})
-	if err != nil && err != sql.ErrNoRows {
-		respondError(w, http.StatusInternalServerError, "Failed to get customer")
+	// Other companies look no different from ones that don't exist
+	if id != user.CompanyID {
+		respondError(w, http.StatusNotFound, "Company not found")
 		return
 	}
 
-	// If not found, try normalizing phone number (remove spaces, hyphens, etc.)
-	found := false
+	company, err := s.queries.UpdateCompany(r.Context(), db.UpdateCompanyParams{Name: req.Name, ID: id})
 	if err == sql.ErrNoRows {
-		// Normalize the input phone number
-		normalizedPhone := normalizePhoneNumber(phone)
-		log.Printf("📞 Normalized input phone: %s", normalizedPhone)
-
-		// Query all customers and find matching normalized phone
-		customers, err := s.queries.GetAllCustomers(r.Context())
-		if err != nil {
-			respondError(w, http.StatusInternalServerError, "Failed to get customers")
-			return
-		}
-
-		log.Printf("👥 Checking %d customers", len(customers))
-
-		// Find customer with matching normalized phone
-		for _, c := range customers {
-			if c.Phone.Valid {
-				normalizedCustomerPhone := normalizePhoneNumber(c.Phone.String)
-				log.Printf("  Comparing: input=%s vs customer=%s (original: %s)", normalizedPhone, normalizedCustomerPhone, c.Phone.String)
-				if normalizedCustomerPhone == normalizedPhone {
-					customer = c
-					found = true
-					log.Printf("✅ Found matching customer: %s %s", c.FirstName, c.LastName)
-					break
-				}
-			}
-		}
-	} else if err == nil {
-		found = true
+		respondError(w, http.StatusNotFound, "Company not found")
+		return
 	}
-
-	if !found {
-		log.Printf("❌ No customer found for phone: %s", phone)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(CustomerResponse{
-			Success:  false,
-			Customer: nil,
-		})
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusConflict, "Company with this name already exists")
 		return
 	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update company")
+		return
+	}
+
+	logInfof(r.Context(), "🏢 Company %d renamed to %q by %s", id, company.Name, user.AgentID)
+	s.audit(r.Context(), user, auditCompanyUpdate, strconv.FormatInt(id, 10), company.Name)
 
-	log.Printf("✅ Returning customer: %s %s", customer.FirstName, customer.LastName)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CustomerResponse{
-		Success:  true,
-		Customer: &customer,
+	json.NewEncoder(w).Encode(CompanyResponse{
+		Success: true,
+		Company: &company,
 	})
 }
 
-func (s *Server) getTwilioToken(w http.ResponseWriter, r *http.Request) {
-	// Get current user from session
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Not authenticated")
+func (s *Server) getCustomerByPhone(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	phone := r.URL.Query().Get("phone")
+	if phone == "" {
+		respondError(w, http.StatusBadRequest, "Phone number is required")
 		return
 	}
 
-	session, err := s.queries.GetSession(r.Context(), cookie.Value)
+	logInfof(r.Context(), "🔍 Looking up customer by phone: %s", phone)
+
+	customer, err := s.findCustomerByPhone(r.Context(), user.CompanyID, phone)
 	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Session expired")
+		respondError(w, http.StatusInternalServerError, "Failed to get customer")
 		return
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		s.queries.DeleteSession(r.Context(), session.ID)
-		respondError(w, http.StatusUnauthorized, "Session expired")
+	// Customers of other companies aren't found, so they look no different
+	// from a number nobody has
+	if customer == nil {
+		logInfof(r.Context(), "❌ No customer found for phone: %s", phone)
+		respondError(w, http.StatusNotFound, "Customer not found")
 		return
 	}
 
-	user, err := s.queries.GetUserByID(r.Context(), session.UserID)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "User not found")
-		return
-	}
+	logInfof(r.Context(), "✅ Returning customer: %s %s (VIP: %t)", customer.FirstName, customer.LastName, customer.IsVip)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CustomerResponse{
+		Success:  true,
+		Customer: customer,
+	})
+}
+
+func (s *Server) getTwilioToken(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 
-	// Get Twilio credentials from environment
-	accountSID := os.Getenv("TWILIO_ACCOUNT_SID")
-	apiKeySID := os.Getenv("TWILIO_API_KEY_SID")
-	apiKeySecret := os.Getenv("TWILIO_API_KEY_SECRET")
-	twimlAppSID := os.Getenv("TWILIO_TWIML_APP_SID")
+	user, _ := userFromContext(r.Context())
 
-	log.Printf("Twilio Credentials Check - AccountSID: %s, APIKeySID: %s, TwiMLAppSID: %s",
-		accountSID, apiKeySID, twimlAppSID)
+	cfg := s.twilioConfig
 
-	if accountSID == "" || apiKeySID == "" || apiKeySecret == "" {
-		log.Printf("ERROR: Twilio credentials missing - AccountSID: %t, APIKeySID: %t, APIKeySecret: %t",
-			accountSID != "", apiKeySID != "", apiKeySecret != "")
+	// Missing settings were reported at startup
+	if !cfg.hasCredentials() {
+		observeTokenRequest(tokenOutcomeMissingCredentials, start)
 		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured. Please set TWILIO_ACCOUNT_SID, TWILIO_API_KEY_SID, and TWILIO_API_KEY_SECRET environment variables.")
 		return
 	}
 
 	// Create identity from user's agent ID
 	identity := user.AgentID
-	log.Printf("Generating Twilio token for identity: %s", identity)
 
 	// Create access token parameters using Twilio SDK
 	params := twilioJwt.AccessTokenParams{
-		AccountSid:    accountSID,
-		SigningKeySid: apiKeySID,
-		Secret:        apiKeySecret,
+		AccountSid:    cfg.accountSID,
+		SigningKeySid: cfg.apiKeySID,
+		Secret:        cfg.apiKeySecret,
 		Identity:      identity,
-		Ttl:           3600, // 1 hour in seconds
+		Ttl:           cfg.tokenTTL.Seconds(),
 	}
+	// Taken before signing, so it errs on the early side
+	expiresAt := time.Now().Add(cfg.tokenTTL).UTC()
 
-	log.Printf("Creating access token with params...")
 	// Create the access token
 	accessToken := twilioJwt.CreateAccessToken(params)
 
@@ -581,112 +991,446 @@ func (s *Server) getTwilioToken(w http.ResponseWriter, r *http.Request) {
 	voiceGrant := &twilioJwt.VoiceGrant{
 		Incoming: twilioJwt.Incoming{Allow: true},
 		Outgoing: twilioJwt.Outgoing{
-			ApplicationSid: twimlAppSID,
+			ApplicationSid: cfg.twimlAppSID,
 		},
 	}
 
-	log.Printf("Adding voice grant...")
 	// Add the voice grant to the token
 	accessToken.AddGrant(voiceGrant)
 
-	log.Printf("Generating JWT token string...")
 	// Generate the JWT string
 	tokenString, err := accessToken.ToJwt()
 	if err != nil {
-		log.Printf("ERROR: Failed to generate Twilio token: %v", err)
+		logErrorf(r.Context(), "ERROR: Failed to generate Twilio token: %v", err)
+		observeTokenRequest(tokenOutcomeJWTError, start)
 		respondError(w, http.StatusInternalServerError, "Failed to generate access token")
 		return
 	}
 
-	log.Printf("Successfully generated Twilio token for %s", identity)
+	logDebugf(r.Context(), "Issued Twilio token for %s (account %s, key %s, app %s)",
+		redact(identity), redact(cfg.accountSID), redact(cfg.apiKeySID), redact(cfg.twimlAppSID))
+	observeTokenRequest(tokenOutcomeSuccess, start)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(TwilioTokenResponse{
-		Token:    tokenString,
-		Identity: identity,
+		Token:     tokenString,
+		Identity:  identity,
+		ExpiresAt: expiresAt,
 	})
 }
 
 func (s *Server) handleOutboundVoice(w http.ResponseWriter, r *http.Request) {
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
-		log.Printf("Error parsing form: %v", err)
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	// Agents replaying a voicemail connect with a customer instead of a number
+	if r.FormValue("CustomerId") != "" {
+		s.handleVoicemailPlayback(w, r)
+		return
 	}
 
 	toNumber := r.FormValue("To")
 	callSID := r.FormValue("CallSid")
-
-	// Get phone number from environment
-	fromNumber := os.Getenv("TWILIO_PHONE_NUMBER")
+	agentID := strings.TrimPrefix(r.FormValue("From"), "client:")
+
+	// Customers see the agent's own number or one of their company's. The
+	// agent is the identity their access token was issued for.
+	var agent *db.User
+	var agentCompanyID int64
+	if found, err := s.queries.GetUserByAgentID(r.Context(), agentID); err == nil && !found.DeletedAt.Valid {
+		agent = &found
+		agentCompanyID = found.CompanyID
+	}
+	fromNumber, source := s.agentCallerID(r.Context(), agent)
 	if fromNumber == "" {
-		fromNumber = "+13612664115" // Fallback to your number
+		logInfof(r.Context(), "No caller ID for agent %s: register a phone number or set TWILIO_PHONE_NUMBER", agentID)
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Outbound calling is not set up for your company.</Say>
+	<Hangup/>
+</Response>`))
+		return
 	}
 
-	if toNumber == "" {
-		log.Println("No 'To' number received from Twilio")
-		toNumber = "+1234567890" // Fallback
+	// A free agent asking for the next caller in the queue
+	if r.FormValue("Queue") != "" {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(s.nextInQueueTwiML(r, agentCompanyID, fromNumber)))
+		return
 	}
 
-	log.Printf("📞 Outbound call: To=%s, From=%s, CallSID=%s", toNumber, fromNumber, callSID)
+	// Only well-formed numbers the company allows are dialed, so a typo or
+	// a tampered request can't reach premium-rate or foreign lines
+	dialed, ok := toE164(toNumber, defaultPhoneRegion())
+	if !ok {
+		logWarnf(r.Context(), "Agent %s dialed an invalid number %q", agentID, toNumber)
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(announcementTwiML("The number you dialed is not valid.")))
+		return
+	}
+	if !s.dialAllowed(r.Context(), agentCompanyID, dialed) {
+		logWarnf(r.Context(), "Agent %s dialed %s, which is outside the company's dial prefixes", agentID, dialed)
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(announcementTwiML("Calls to that number are not allowed.")))
+		return
+	}
+	toNumber = dialed
 
-	// Return TwiML that tells Twilio to dial the number
-	twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-	<Dial callerId="%s">
-		<Number>%s</Number>
-	</Dial>
-</Response>`, fromNumber, toNumber)
+	callID, err := s.resolveCallID(r.Context(), callSID, agentCompanyID)
+	if err != nil {
+		logErrorf(r.Context(), "Error recording call id: %v", err)
+	}
+
+	logInfof(r.Context(), "📞 Outbound call: To=%s, From=%s, CallSID=%s, CallID=%s", toNumber, fromNumber, callSID, callID)
+
+	// The customer only ever sees a company number, never the agent
+	logInfof(r.Context(), "🎭 Agent %s reaches %s as %s (%s)", r.FormValue("From"), toNumber, fromNumber, source)
 
+	// Return TwiML that tells Twilio to dial the number. Agents can skip the
+	// answering machine message for a call they want to leave one on
+	// themselves.
+	s.recordCall(r.Context(), r, callID, "outbound", fromNumber, toNumber, agentID, agentCompanyID)
+
+	skipAmd, _ := strconv.ParseBool(r.FormValue("SkipVoicemailDrop"))
 	w.Header().Set("Content-Type", "application/xml")
-	w.Write([]byte(twiml))
+	w.Write([]byte(s.outboundDialTwiML(r.Context(), agentCompanyID, agentID, fromNumber, toNumber, !skipAmd)))
+}
+
+// outboundDialTwiML bridges an agent's call to the customer at to, shown
+// from as the caller ID. amd turns on the company's answering machine
+// message, if it has one.
+func (s *Server) outboundDialTwiML(ctx context.Context, companyID int64, agentID, from, to string, amd bool) string {
+	attrs := numberStatusCallback(agentID)
+	if amd {
+		attrs += s.amdAttributes(ctx, agentID)
+	}
+
+	// Recorded calls tell the customer as they pick up
+	dialAttrs := ""
+	if s.recordingEnabled(ctx, companyID) {
+		dialAttrs = dialRecordingAttrs
+		if s.recordingAnnounced(ctx, companyID) {
+			attrs += ` url="/twilio/recording-announcement"`
+		}
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Dial callerId="%s"%s>
+		<Number%s>%s</Number>
+	</Dial>
+</Response>`, html.EscapeString(from), dialAttrs, attrs, html.EscapeString(to))
 }
 
 func (s *Server) handleIncomingCall(w http.ResponseWriter, r *http.Request) {
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
-		log.Printf("Error parsing form: %v", err)
+		logErrorf(r.Context(), "Error parsing form: %v", err)
 	}
 
 	from := r.FormValue("From")
 	to := r.FormValue("To")
 	callSID := r.FormValue("CallSid")
 
-	log.Printf("📞 Incoming call: From=%s, To=%s, CallSID=%s", from, to, callSID)
+	// The dialed number settles whose call this is
+	company, number := s.numberCompany(r.Context(), to)
+	callID, err := s.resolveCallID(r.Context(), callSID, companyID(company))
+	if err != nil {
+		logErrorf(r.Context(), "Error recording call id: %v", err)
+	}
+
+	logInfof(r.Context(), "📞 Incoming call: From=%s, To=%s, CallSID=%s, CallID=%s", from, to, callSID, callID)
+
+	call := CallContext{
+		CallID:  callID,
+		CallSid: callSID,
+		From:    from,
+		To:      to,
+	}
+
+	// Callers coming back from recording their name
+	nameStep := r.URL.Query().Get("step") == "name"
+	whisper := false
+	if recordingURL := r.FormValue("RecordingUrl"); nameStep && recordingURL != "" && callID != "" {
+		if err := s.queries.SetCallNameRecording(r.Context(), db.SetCallNameRecordingParams{
+			NameRecordingUrl: sql.NullString{String: recordingURL, Valid: true},
+			ID:               callID,
+		}); err != nil {
+			logErrorf(r.Context(), "Error saving caller name recording: %v", err)
+		} else {
+			whisper = true
+		}
+	}
+
+	route := s.routeIncomingCall(r.Context(), call, company, number, nameStep, whisper)
+	// Calls to numbers nobody owns aren't any company's history
+	if !route.Blocked || route.CompanyID != 0 {
+		s.recordCall(r.Context(), r, callID, "inbound", from, to, route.AgentID, route.CompanyID)
+	}
+
+	// Screen-pop is best-effort and must never hold up the call
+	if route.AgentID != "" {
+		s.notify(realtimeEvent{
+			Type:      "incoming_call",
+			CompanyID: route.CompanyID,
+			AgentID:   route.AgentID,
+			Data: map[string]any{
+				"call_id":  callID,
+				"call_sid": callSID,
+				"from":     from,
+				"customer": route.Customer,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(route.TwiML))
+}
+
+// incomingRoute is what the incoming-call pipeline decided for a call.
+type incomingRoute struct {
+	CompanyID int64 // 0 when the dialed number isn't tied to a company
+	Customer  *db.Customer
+	Blocked   bool   // turned away: a blocked caller, or a number nobody owns
+	AgentID   string // empty when the call isn't handed to an agent yet
+	TwiML     string
+}
+
+// routeIncomingCall runs blocking, customer matching and agent selection for
+// a call and builds the TwiML Twilio should run. company and number are the
+// dialed number's, as numberCompany found them. It records nothing itself,
+// so simulated calls can go through exactly the same steps.
+func (s *Server) routeIncomingCall(ctx context.Context, call CallContext, company *db.Company, number *db.PhoneNumber, nameStep, whisper bool) incomingRoute {
+	from := call.From
+
+	// The dialed number decides whose call this is. Without a company there
+	// are no agents, customers or settings to go on, and ringing another
+	// company's agents would hand them someone else's caller.
+	if company == nil {
+		logInfof(ctx, "🚫 Rejected call to %s: the number isn't registered to a company (CallID=%s)", call.To, call.CallID)
+		return incomingRoute{Blocked: true, TwiML: rejectTwiML("")}
+	}
 
-	// Get the first agent from the database to route the call to
-	// In a production system, you'd implement proper call routing logic
-	var agentID string
-	query := `SELECT agent_id FROM users LIMIT 1`
-	err := s.db.QueryRow(query).Scan(&agentID)
+	// Reject callers the company has blocked before playing any greeting.
+	// Another company blocking the same caller doesn't count here.
+	blocked, err := s.queries.GetBlockedNumberByPhone(ctx, db.GetBlockedNumberByPhoneParams{
+		CompanyID: company.ID,
+		Phone:     normalizePhoneNumber(from),
+	})
+	if err == nil {
+		logInfof(ctx, "🚫 Blocked call: From=%s, CallID=%s, Reason=%s", from, call.CallID, blocked.Reason)
+		return incomingRoute{CompanyID: company.ID, Blocked: true, TwiML: rejectTwiML(blocked.Reason)}
+	}
+
+	// Announcement-only numbers play their message and never reach an agent
+	if number != nil && number.RoutingType == routingAnnouncement {
+		logInfof(ctx, "📢 Announcement number %s: From=%s, CallID=%s", number.Phone, from, call.CallID)
+		return incomingRoute{CompanyID: companyID(company), TwiML: announcementTwiML(number.Announcement.String)}
+	}
+
+	customer, err := s.findCustomerByPhone(ctx, companyID(company), from)
 	if err != nil {
-		log.Printf("Error getting agent: %v", err)
-		agentID = "agent001" // Fallback to default agent
+		logErrorf(ctx, "Error looking up caller: %v", err)
+	}
+	call.Customer = customer
+	if call.isVip() {
+		logInfof(ctx, "⭐ VIP caller: %s %s", customer.FirstName, customer.LastName)
+	}
+
+	// Nobody is rung while the company is closed
+	if company != nil && !s.companyOpen(ctx, company.ID, time.Now()) {
+		logInfof(ctx, "🌙 Call from %s outside business hours (CallID=%s)", from, call.CallID)
+		return incomingRoute{
+			CompanyID: companyID(company),
+			Customer:  customer,
+			TwiML:     s.afterHoursTwiML(ctx, company, from),
+		}
 	}
 
-	log.Printf("Routing call to agent: %s", agentID)
+	// Unknown callers may be asked for their name first, which the agent then
+	// hears as a whisper before the call is bridged
+	greeting := s.incomingGreetingTwiML(ctx, company, nameStep)
+	clientAttrs := ""
+	if nameStep {
+		if whisper {
+			clientAttrs = fmt.Sprintf(` url="/twilio/whisper?call_id=%s"`, url.QueryEscape(call.CallID))
+		}
+	} else if call.Customer == nil && s.collectCallerName(ctx, company) {
+		logInfof(ctx, "🎙️ Asking unknown caller %s for their name", from)
+		return incomingRoute{CompanyID: company.ID, TwiML: callerNameTwiML(s.productName(ctx, company.ID))}
+	}
 
-	// Return TwiML to route the call to the agent's browser
+	// Nobody to ring means the caller leaves a message instead
+	agentID, err := s.chooseAgent(ctx, company, call)
+	if err != nil {
+		if !errors.Is(err, errNoAgentAvailable) {
+			logErrorf(ctx, "Error getting agent: %v", err)
+		}
+		logInfof(ctx, "📭 No agent available for %s, offering voicemail (CallID=%s)", from, call.CallID)
+		return incomingRoute{
+			CompanyID: companyID(company),
+			Customer:  customer,
+			TwiML:     s.voicemailPromptTwiML(ctx, company, from),
+		}
+	}
+
+	logInfof(ctx, "Routing call to agent: %s", agentID)
+
+	// The dial action is told who was dialed so an answered call can put
+	// that agent into wrap-up
+	action := "/twilio/dial-complete?agent_id=" + url.QueryEscape(agentID)
+	clientAttrs += clientStatusCallback
+
+	// Callers hear about recording in the greeting, before anyone answers
+	dialAttrs := ""
+	if s.recordingEnabled(ctx, companyID(company)) {
+		dialAttrs = dialRecordingAttrs
+		if s.recordingAnnounced(ctx, companyID(company)) {
+			greeting += "\n\t" + s.sayTwiML(ctx, companyID(company), recordingAnnouncement)
+		}
+	}
+
+	dial := fmt.Sprintf(`<Dial action="%s"%s>
+		<Client%s>%s</Client>
+	</Dial>`, html.EscapeString(action), dialAttrs, clientAttrs, html.EscapeString(agentID))
+
+	// Show the agent the company number; the real caller travels as a
+	// custom parameter so screen-pop still has it
+	if maskedNumber := s.maskingNumber(ctx, company); maskedNumber != "" {
+		logInfof(ctx, "🎭 Masking caller %s as %s for agent %s", from, maskedNumber, agentID)
+		dial = fmt.Sprintf(`<Dial callerId="%s" action="%s"%s>
+		<Client%s>
+			<Identity>%s</Identity>
+			<Parameter name="caller" value="%s"/>
+		</Client>
+	</Dial>`, html.EscapeString(maskedNumber), html.EscapeString(action), dialAttrs, clientAttrs, html.EscapeString(agentID), html.EscapeString(from))
+	}
+
+	// Route the call to the agent's browser. If they don't answer, the dial
+	// action puts the caller in the queue.
 	twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <Response>
-	<Say>Welcome to OmniCall. Please wait while we connect you to an agent.</Say>
-	<Dial>
-		<Client>%s</Client>
-	</Dial>
-	<Say>Sorry, the agent is not available. Please try again later.</Say>
-</Response>`, agentID)
-
-	w.Header().Set("Content-Type", "application/xml")
-	w.Write([]byte(twiml))
+	%s
+	%s
+</Response>`, greeting, dial)
+
+	return incomingRoute{
+		CompanyID: companyID(company),
+		Customer:  customer,
+		AgentID:   agentID,
+		TwiML:     twiml,
+	}
 }
 
 // Helper functions
+
+// userContextKey is the request context key requireAuth stores the
+// authenticated user under.
+type userContextKey struct{}
+
+// requireAuth turns away requests without a live session and hands the rest
+// on with the session's user in the request context. Expired sessions are
+// deleted on the way.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := s.cookie.read(r)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, "Not authenticated")
+			return
+		}
+
+		session, err := s.queries.GetSession(r.Context(), sessionID)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, "Session expired")
+			return
+		}
+
+		if time.Now().After(session.ExpiresAt) {
+			s.queries.DeleteSession(r.Context(), session.ID)
+			respondError(w, http.StatusUnauthorized, "Session expired")
+			return
+		}
+		s.refreshSession(r.Context(), w, session)
+
+		user, err := s.queries.GetUserByID(r.Context(), session.UserID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, &user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// refreshSession extends a session that is within the refresh window of
+// expiring. The update only applies while the expiry is still the one read,
+// so concurrent requests extend it once and only the one that did re-sets
+// the cookie.
+func (s *Server) refreshSession(ctx context.Context, w http.ResponseWriter, session db.Session) {
+	if time.Until(session.ExpiresAt) > s.cookie.refreshWindow {
+		return
+	}
+
+	refreshed, err := s.queries.UpdateSessionExpiry(ctx, db.UpdateSessionExpiryParams{
+		NewExpiresAt: time.Now().Add(s.cookie.lifetime),
+		ID:           session.ID,
+		ExpiresAt:    session.ExpiresAt,
+	})
+	if err != nil {
+		logErrorf(ctx, "Error refreshing session for user %d: %v", session.UserID, err)
+		return
+	}
+	if refreshed > 0 {
+		s.cookie.set(w, session.ID)
+	}
+}
+
+// userFromContext returns the user requireAuth authenticated. Handlers
+// mounted behind requireAuth can count on it being there.
+func userFromContext(ctx context.Context) (*db.User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(*db.User)
+	return user, ok
+}
+
+// sessionUser returns the user behind a live session cookie, for callers that
+// only want to know who is asking rather than turn the request away.
+func (s *Server) sessionUser(r *http.Request) (*db.User, error) {
+	sessionID, err := s.cookie.read(r)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.queries.GetSession(r.Context(), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, errors.New("session expired")
+	}
+	user, err := s.queries.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func generateSessionID() string {
 	b := make([]byte, 32)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
+// normalizePhoneNumber is the form phone numbers are stored and compared in:
+// E.164 where the number can be read that way, otherwise its digits and any
+// plus sign.
 func normalizePhoneNumber(phone string) string {
+	if e164, ok := toE164(phone, defaultPhoneRegion()); ok {
+		return e164
+	}
+
 	// Remove all spaces, hyphens, parentheses, and dots
 	normalized := ""
 	for _, char := range phone {
@@ -696,9 +1440,3 @@ func normalizePhoneNumber(phone string) string {
 	}
 	return normalized
 }
-
-func respondError(w http.ResponseWriter, status int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(ErrorResponse{Detail: message})
-}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"omnicall/db"
+)
+
+func TestRegisterCompany(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	empty := addCompany(t, s, "Empty")
+	deleted := addCompany(t, s, "Gone")
+	exec(t, s, "UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", deleted)
+	addAgent(t, s, acme, "acmeadmin", roleAdmin)
+
+	tests := []struct {
+		name    string
+		company string
+		status  int
+		code    string
+		role    string
+	}{
+		{"joins a company with an admin", fmt.Sprintf(`"company_id":%d`, acme), http.StatusOK, "", roleAgent},
+		{"joins a company without users", fmt.Sprintf(`"company_id":%d`, empty), http.StatusOK, "", roleAgent},
+		{"unknown company", `"company_id":9999`, http.StatusBadRequest, errCodeUnknownCompany, ""},
+		{"deleted company", fmt.Sprintf(`"company_id":%d`, deleted), http.StatusBadRequest, errCodeUnknownCompany, ""},
+		{"new company", `"company_name":"Initech"`, http.StatusOK, "", roleAdmin},
+		{"new company with a taken name", `"company_name":"Acme"`, http.StatusBadRequest, errCodeCompanyTaken, ""},
+		{"no company", `"company_name":" "`, http.StatusBadRequest, errCodeMissingFields, ""},
+		{"both", fmt.Sprintf(`"company_id":%d,"company_name":"Other"`, acme), http.StatusBadRequest, "", ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"email":"u%[1]d@example.com","password":"password1","firstname":"U","lastname":"Ser","agent_id":"user%[1]d",%[2]s}`, i, tt.company)
+			w := httptest.NewRecorder()
+			s.register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				var resp ErrorResponse
+				json.NewDecoder(w.Body).Decode(&resp)
+				if tt.code != "" && resp.Code != tt.code {
+					t.Errorf("code = %q, want %q", resp.Code, tt.code)
+				}
+				return
+			}
+			var resp AuthResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.User.Role != tt.role {
+				t.Errorf("role = %q, want %q", resp.User.Role, tt.role)
+			}
+		})
+	}
+}
+
+func TestRouteIncomingCallBlocked(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	addAgent(t, s, acme, "acme1", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	addNumber(t, s, globex, "+27110000002")
+	exec(t, s, "INSERT INTO blocked_numbers (company_id, phone, reason) VALUES (?, ?, ?)", acme, "+27825550001", "spam")
+	exec(t, s, "INSERT INTO blocked_numbers (company_id, phone, reason) VALUES (?, ?, ?)", acme, "+27825550002", "busy")
+	exec(t, s, "INSERT INTO blocked_numbers (company_id, phone, reason) VALUES (?, ?, ?)", globex, "+27825550003", "spam")
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		blocked bool
+		reject  string
+	}{
+		{"blocked by the dialed company", "+27825550001", "+27110000001", true, `<Reject reason="rejected"/>`},
+		{"blocked as busy", "+27825550002", "+27110000001", true, `<Reject reason="busy"/>`},
+		{"blocked by another company", "+27825550003", "+27110000001", false, ""},
+		{"blocked caller dialing another company", "+27825550001", "+27110000002", false, ""},
+		{"caller nobody blocked", "+27825550009", "+27110000001", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			company, number := s.numberCompany(t.Context(), tt.to)
+			route := s.routeIncomingCall(t.Context(), CallContext{CallID: newCallID(), From: tt.from, To: tt.to}, company, number, false, false)
+			if route.Blocked != tt.blocked {
+				t.Fatalf("blocked = %v, want %v", route.Blocked, tt.blocked)
+			}
+			if tt.blocked && !strings.Contains(route.TwiML, tt.reject) {
+				t.Errorf("TwiML = %s, want it to contain %s", route.TwiML, tt.reject)
+			}
+			if !tt.blocked && strings.Contains(route.TwiML, "<Reject") {
+				t.Errorf("TwiML rejects an unblocked caller: %s", route.TwiML)
+			}
+		})
+	}
+}
+
+func TestRouteIncomingCallUnregisteredNumber(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	addAgent(t, s, acme, "acme1", roleAgent)
+	addAgent(t, s, globex, "globex1", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	// Only Globex knows this caller, so a lookup across companies would
+	// show Globex's customer to Acme's agents
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Doe', '+27825550001', '+27825550001')", globex)
+
+	tests := []struct {
+		name           string
+		defaultCompany int64
+		to             string
+		company        int64
+		agent          string
+	}{
+		{"rejected without a default company", 0, "+27110000009", 0, ""},
+		{"taken by the default company", globex, "+27110000009", globex, "globex1"},
+		{"registered number ignores the default", globex, "+27110000001", acme, "acme1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.defaultCompanyID = tt.defaultCompany
+			company, number := s.numberCompany(t.Context(), tt.to)
+			route := s.routeIncomingCall(t.Context(), CallContext{CallID: newCallID(), From: "+27825550001", To: tt.to}, company, number, false, false)
+			if route.CompanyID != tt.company || route.AgentID != tt.agent {
+				t.Fatalf("routed to %q of company %d, want %q of company %d", route.AgentID, route.CompanyID, tt.agent, tt.company)
+			}
+			if tt.company == 0 && (!route.Blocked || !strings.Contains(route.TwiML, "<Reject")) {
+				t.Errorf("call to an unregistered number wasn't rejected: %s", route.TwiML)
+			}
+			if route.Customer != nil && route.Customer.CompanyID != tt.company {
+				t.Errorf("matched company %d's customer on a call to company %d", route.Customer.CompanyID, tt.company)
+			}
+		})
+	}
+}
+
+func TestFindCustomerByPhone(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Doe', '082 555 0001', '+27825550001')", globex)
+
+	tests := []struct {
+		name    string
+		company int64
+		phone   string
+		found   bool
+	}{
+		{"exact phone", globex, "082 555 0001", true},
+		{"normalized phone", globex, "+27825550001", true},
+		{"another company's customer", acme, "082 555 0001", false},
+		{"no company", 0, "082 555 0001", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customer, err := s.findCustomerByPhone(t.Context(), tt.company, tt.phone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found := customer != nil; found != tt.found {
+				t.Errorf("found = %v, want %v", found, tt.found)
+			}
+		})
+	}
+}
+
+func TestGetCustomerByPhone(t *testing.T) {
+	t.Setenv("DEFAULT_PHONE_REGION", "ZA")
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	initech := addCompany(t, s, "Initech")
+	// The same caller is a customer of two companies
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Acme', '082 555 0001', '+27825550001')", acme)
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Globex', '+27825550001', '+27825550001')", globex)
+
+	tests := []struct {
+		name     string
+		agent    *db.User
+		phone    string
+		status   int
+		lastName string
+	}{
+		{"acme by its exact phone", addAgent(t, s, acme, "acme1", roleAgent), "082 555 0001", http.StatusOK, "Acme"},
+		{"acme by the normalized phone", addAgent(t, s, acme, "acme2", roleAgent), "+27825550001", http.StatusOK, "Acme"},
+		{"globex by its exact phone", addAgent(t, s, globex, "globex1", roleAgent), "+27825550001", http.StatusOK, "Globex"},
+		{"globex by a national phone", addAgent(t, s, globex, "globex2", roleAgent), "0825550001", http.StatusOK, "Globex"},
+		{"company without that customer", addAgent(t, s, initech, "initech1", roleAgent), "+27825550001", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/customers/by-phone?phone="+url.QueryEscape(tt.phone), nil), tt.agent)
+			w := httptest.NewRecorder()
+			s.getCustomerByPhone(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp CustomerResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Customer.CompanyID != tt.agent.CompanyID || resp.Customer.LastName != tt.lastName {
+				t.Errorf("customer = %s of company %d, want %s of company %d", resp.Customer.LastName, resp.Customer.CompanyID, tt.lastName, tt.agent.CompanyID)
+			}
+		})
+	}
+}
+
+func TestRegisterConcurrently(t *testing.T) {
+	tests := []struct {
+		name string
+		body func(i int) string // the registration made by goroutine i
+		code string
+	}{
+		{"same email", func(i int) string {
+			return fmt.Sprintf(`{"email":"pat@example.com","password":"password1","firstname":"Pat","lastname":"Doe","agent_id":"pat%d","company_id":1}`, i)
+		}, errCodeEmailTaken},
+		{"same agent id", func(i int) string {
+			return fmt.Sprintf(`{"email":"pat%d@example.com","password":"password1","firstname":"Pat","lastname":"Doe","agent_id":"pat","company_id":1}`, i)
+		}, errCodeAgentIDTaken},
+		{"same new company", func(i int) string {
+			return fmt.Sprintf(`{"email":"pat%[1]d@example.com","password":"password1","firstname":"Pat","lastname":"Doe","agent_id":"pat%[1]d","company_name":"Initech"}`, i)
+		}, errCodeCompanyTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			addCompany(t, s, "Acme")
+
+			const racers = 2
+			responses := make([]*httptest.ResponseRecorder, racers)
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := range racers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(tt.body(i)))
+					<-start
+					responses[i] = httptest.NewRecorder()
+					s.register(responses[i], r)
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			var created, refused int
+			for _, w := range responses {
+				switch w.Code {
+				case http.StatusOK:
+					created++
+				case http.StatusBadRequest:
+					var resp ErrorResponse
+					json.NewDecoder(w.Body).Decode(&resp)
+					if resp.Code != tt.code {
+						t.Errorf("code = %q, want %q", resp.Code, tt.code)
+					}
+					refused++
+				default:
+					t.Errorf("status = %d: %s", w.Code, w.Body)
+				}
+			}
+			if created != 1 || refused != racers-1 {
+				t.Errorf("created %d and refused %d, want 1 and %d", created, refused, racers-1)
+			}
+		})
+	}
+}
+
+func TestBootstrapCompanyConcurrently(t *testing.T) {
+	s := newTestServer(t)
+
+	// Listing companies on a fresh install creates nothing, however many
+	// first requests arrive together
+	const racers = 8
+	responses := make([]*httptest.ResponseRecorder, racers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			responses[i] = httptest.NewRecorder()
+			s.getCompanies(responses[i], asUser(httptest.NewRequest(http.MethodGet, "/api/companies", nil), &db.User{}))
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, w := range responses {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"companies":[]`) {
+			t.Errorf("status = %d, want %d with no companies: %s", w.Code, http.StatusOK, w.Body)
+		}
+	}
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM companies").Scan(&count)
+	if count != 0 {
+		t.Fatalf("listing created %d companies", count)
+	}
+
+	errs := make([]error, racers)
+	start = make(chan struct{})
+	for i := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = bootstrapCompany(t.Context(), s.queries, "Acme")
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("bootstrap: %v", err)
+		}
+	}
+	// Later starts leave things alone, whatever the company is called now
+	if err := bootstrapCompany(t.Context(), s.queries, "Globex"); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	rows, err := s.db.Query("SELECT name FROM companies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	if len(names) != 1 || names[0] != "Acme" {
+		t.Errorf("companies = %v, want [Acme]", names)
+	}
+}
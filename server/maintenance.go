@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const appSettingMaintenance = "maintenance_mode"
+
+// Routes that keep working while the API is in maintenance
+var maintenanceExemptPaths = []string{"/health", "/live", "/ready", "/metrics", "/api/auth/login", "/api/admin/maintenance", "/api/admin/backup"}
+
+type MaintenanceUpdate struct {
+	Enabled bool `json:"enabled"`
+}
+
+type MaintenanceResponse struct {
+	Success bool `json:"success"`
+	Enabled bool `json:"enabled"`
+}
+
+// loadMaintenance reads the saved maintenance switch into memory at startup.
+// From then on updateMaintenance keeps the two in step, so requests never
+// have to go to the database for it.
+func (s *Server) loadMaintenance(ctx context.Context) error {
+	value, err := s.queries.GetAppSetting(ctx, appSettingMaintenance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.maintenance.Store(value == "on")
+	return nil
+}
+
+// maintenanceEnabled reports whether maintenance mode is on, either forced by
+// MAINTENANCE_MODE or switched on through the operator endpoint.
+func (s *Server) maintenanceEnabled() bool {
+	if on, _ := strconv.ParseBool(os.Getenv("MAINTENANCE_MODE")); on {
+		return true
+	}
+	return s.maintenance.Load()
+}
+
+// maintenanceMode turns away state-changing requests during maintenance.
+// Reads, health checks and metrics still go through, and Twilio gets TwiML
+// it can play to the caller instead of an error.
+func (s *Server) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.maintenanceEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/twilio/") {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>We are currently performing maintenance. Please try again later.</Say>
+	<Hangup/>
+</Response>`))
+			return
+		}
+
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if readOnly || slices.Contains(maintenanceExemptPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := os.Getenv("MAINTENANCE_RETRY_AFTER")
+		if retryAfter == "" {
+			retryAfter = "300"
+		}
+		w.Header().Set("Retry-After", retryAfter)
+		respondError(w, http.StatusServiceUnavailable, "Service is under maintenance, please try again later")
+	})
+}
+
+func (s *Server) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MaintenanceResponse{
+		Success: true,
+		Enabled: s.maintenanceEnabled(),
+	})
+}
+
+// updateMaintenance switches maintenance mode for the whole deployment. Only
+// the operator can, as it stops every company's calls.
+func (s *Server) updateMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	value := "off"
+	if req.Enabled {
+		value = "on"
+	}
+	if err := s.queries.UpsertAppSetting(r.Context(), db.UpsertAppSettingParams{
+		Key:   appSettingMaintenance,
+		Value: value,
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update maintenance mode")
+		return
+	}
+
+	s.maintenance.Store(req.Enabled)
+
+	logInfof(r.Context(), "🛠️ Maintenance mode turned %s by the operator from %s", value, s.clientIP(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MaintenanceResponse{
+		Success: true,
+		Enabled: s.maintenanceEnabled(),
+	})
+}
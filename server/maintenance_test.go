@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testOperatorToken = "0123456789abcdef0123456789abcdef"
+
+func TestRequireOperator(t *testing.T) {
+	tests := []struct {
+		name          string
+		operatorToken string
+		authorization string
+		tenantAdmin   bool
+		status        int
+	}{
+		{"operator", testOperatorToken, "Bearer " + testOperatorToken, false, http.StatusOK},
+		{"tenant admin", testOperatorToken, "", true, http.StatusUnauthorized},
+		{"wrong token", testOperatorToken, "Bearer " + strings.Repeat("x", 32), false, http.StatusUnauthorized},
+		{"token without bearer", testOperatorToken, testOperatorToken, false, http.StatusUnauthorized},
+		{"turned off", "", "Bearer ", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.operatorToken = tt.operatorToken
+			r := httptest.NewRequest(http.MethodPut, "/api/admin/maintenance", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.tenantAdmin {
+				acme := addCompany(t, s, "Acme")
+				r = asUser(r, addAgent(t, s, acme, "acmeadmin", roleAdmin))
+			}
+			w := httptest.NewRecorder()
+			s.requireOperator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestOperatorTokenFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"unset", "", false},
+		{"long enough", testOperatorToken, false},
+		{"too short", "letmein", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPERATOR_TOKEN", tt.value)
+			token, err := operatorTokenFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && token != tt.value {
+				t.Errorf("token = %q, want %q", token, tt.value)
+			}
+		})
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	t.Setenv("MAINTENANCE_MODE", "")
+	s := newTestServer(t)
+	handler := s.maintenanceMode(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	post := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/customers", nil))
+		return w.Code
+	}
+
+	if got := post(); got != http.StatusOK {
+		t.Fatalf("before: status = %d, want %d", got, http.StatusOK)
+	}
+
+	w := httptest.NewRecorder()
+	s.updateMaintenance(w, httptest.NewRequest(http.MethodPut, "/api/admin/maintenance", strings.NewReader(`{"enabled":true}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", w.Code, w.Body)
+	}
+	if got := post(); got != http.StatusServiceUnavailable {
+		t.Errorf("switched on: status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	// The switch is saved for the next start, but requests don't go back
+	// to the database for it
+	exec(t, s, "UPDATE app_settings SET value = 'off' WHERE key = ?", appSettingMaintenance)
+	if got := post(); got != http.StatusServiceUnavailable {
+		t.Errorf("changed in the database: status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	restarted := newTestServer(t)
+	restarted.queries = s.queries
+	exec(t, s, "UPDATE app_settings SET value = 'on' WHERE key = ?", appSettingMaintenance)
+	if err := restarted.loadMaintenance(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.maintenanceEnabled() {
+		t.Error("after a restart: maintenance is off, want on")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"omnicall/db"
+	"os"
+	"strconv"
+)
+
+// maskingNumber returns the company number both parties should see instead
+// of each other's, or "" when caller-ID masking is off. Masking is on unless
+// CALLER_ID_MASKING or the company's caller_id_masking setting turns it off.
+func (s *Server) maskingNumber(ctx context.Context, company *db.Company) string {
+	enabled := os.Getenv("CALLER_ID_MASKING")
+	if company != nil {
+		enabled = s.companySetting(ctx, company.ID, settingCallerIDMasking, enabled)
+	}
+	if on, err := strconv.ParseBool(enabled); err == nil && !on {
+		return ""
+	}
+	return s.companyCallerID(ctx, companyID(company))
+}
+
+func validateBoolSetting(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("Value must be true or false")
+	}
+	return nil
+}
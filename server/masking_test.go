@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallerIDMasking(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		setting string // empty for none
+		masked  bool
+	}{
+		{"on by default", "", "", true},
+		{"turned off for the company", "", "false", false},
+		{"turned off for the server", "false", "", false},
+		{"company turns it back on", "false", "true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CALLER_ID_MASKING", tt.env)
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+			if tt.setting != "" {
+				exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingCallerIDMasking, tt.setting)
+			}
+
+			// The agent sees the company number, and the real caller only
+			// as a parameter for the screen-pop
+			company, number := s.numberCompany(t.Context(), "+27110000001")
+			route := s.routeIncomingCall(t.Context(), CallContext{CallID: newCallID(), From: "+27825550001", To: "+27110000001"}, company, number, false, false)
+			if got := strings.Contains(route.TwiML, `<Dial callerId="+27110000001"`); got != tt.masked {
+				t.Errorf("incoming Dial shows the company number = %v, want %v: %s", got, tt.masked, route.TwiML)
+			}
+			if got := strings.Contains(route.TwiML, `<Parameter name="caller" value="+27825550001"/>`); got != tt.masked {
+				t.Errorf("incoming Dial passes the caller as a parameter = %v, want %v: %s", got, tt.masked, route.TwiML)
+			}
+		})
+	}
+
+	// Customers called by an agent see the company number either way
+	for _, setting := range []string{"true", "false"} {
+		t.Run("outbound with masking "+setting, func(t *testing.T) {
+			s := newTestServer(t)
+			acme := addCompany(t, s, "Acme")
+			addAgent(t, s, acme, "acme1", roleAgent)
+			addNumber(t, s, acme, "+27110000001")
+			exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingCallerIDMasking, setting)
+
+			w := httptest.NewRecorder()
+			s.handleOutboundVoice(w, twilioWebhook("/twilio/voice", url.Values{
+				"CallSid": {"CA-out"}, "From": {"client:acme1"}, "To": {"+27825550001"},
+			}))
+			if body := w.Body.String(); !strings.Contains(body, `<Dial callerId="+27110000001"`) || strings.Contains(body, "client:acme1") {
+				t.Errorf("outbound TwiML doesn't dial from the company number: %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+)
+
+// The /api/me routes are the signed-in agent's own view of agent data. They
+// always act on the session's agent, so any agent_id a client sends along is
+// never read.
+
+// getMyCalls lists the agent's calls in their company, newest first: the
+// ones they placed and the incoming ones put through to them.
+func (s *Server) getMyCalls(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	companyID := sql.NullInt64{Int64: user.CompanyID, Valid: true}
+	agentID := sql.NullString{String: user.AgentID, Valid: true}
+	page := parsePagination(r)
+
+	total, err := s.queries.CountCallsByCompany(r.Context(), db.CountCallsByCompanyParams{
+		CompanyID: companyID,
+		AgentID:   agentID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get calls")
+		return
+	}
+	page.Total = total
+
+	calls, err := s.queries.ListCallsByCompany(r.Context(), db.ListCallsByCompanyParams{
+		CompanyID: companyID,
+		AgentID:   agentID,
+		Limit:     page.PageSize,
+		Offset:    page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get calls")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallsResponse{
+		Success:    true,
+		Calls:      calls,
+		Pagination: page,
+	})
+}
+
+func (s *Server) getMyStatus(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	s.writeAgentStatus(w, r, user)
+}
+
+func (s *Server) setMyDnd(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	s.updateAgentDnd(w, r, user, user)
+}
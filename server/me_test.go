@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestGetMyCalls(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	agent := addAgent(t, s, acme, "acme1", roleAgent)
+	addAgent(t, s, acme, "acme2", roleAgent)
+
+	calls := []struct {
+		callSID, parent, direction, agentID string
+		company                             int64
+	}{
+		{"CA-in", "", "inbound", "acme1", acme},         // answered by the agent
+		{"CA-out", "", "outbound", "acme1", acme},       // placed by the agent
+		{"CA-leg", "CA-out", "outbound", "acme1", acme}, // the customer leg of CA-out
+		{"CA-colleague", "", "inbound", "acme2", acme},
+		{"CA-unanswered", "", "inbound", "", acme},
+		{"CA-elsewhere", "", "inbound", "acme1", globex}, // another company's record
+	}
+	for _, c := range calls {
+		exec(t, s, `INSERT INTO calls (call_sid, parent_call_sid, direction, agent_id, company_id, status, started_at)
+			VALUES (?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, 'completed', CURRENT_TIMESTAMP)`, c.callSID, c.parent, c.direction, c.agentID, c.company)
+	}
+
+	r := asUser(httptest.NewRequest(http.MethodGet, "/api/me/calls?agent_id=acme2", nil), agent)
+	w := httptest.NewRecorder()
+	s.getMyCalls(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp CallsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	var got []string
+	for _, call := range resp.Calls {
+		got = append(got, call.CallSid)
+	}
+	slices.Sort(got)
+	if want := []string{"CA-in", "CA-out"}; !slices.Equal(got, want) || resp.Pagination.Total != int64(len(want)) {
+		t.Errorf("calls = %v of %d, want %v", got, resp.Pagination.Total, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Twilio token outcomes
+const (
+	tokenOutcomeSuccess            = "success"
+	tokenOutcomeMissingCredentials = "missing-credentials"
+	tokenOutcomeJWTError           = "jwt-error"
+)
+
+var (
+	twilioTokenRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "twilio_token_requests_total",
+		Help: "Twilio access token requests, by outcome.",
+	}, []string{"outcome"})
+
+	twilioTokenDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "twilio_token_duration_seconds",
+		Help:    "Time taken to issue Twilio access tokens, by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	callsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calls_total",
+		Help: "Calls that have ended, by direction and final status.",
+	}, []string{"direction", "status"})
+)
+
+func init() {
+	// Export every outcome from the start so failure alerts have a baseline
+	for _, outcome := range []string{tokenOutcomeSuccess, tokenOutcomeMissingCredentials, tokenOutcomeJWTError} {
+		twilioTokenRequests.WithLabelValues(outcome)
+		twilioTokenDuration.WithLabelValues(outcome)
+	}
+}
+
+// observeTokenRequest records the outcome of a token request started at start.
+func observeTokenRequest(outcome string, start time.Time) {
+	twilioTokenRequests.WithLabelValues(outcome).Inc()
+	twilioTokenDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths can't add a series per path.
+const unmatchedRoute = "unmatched"
+
+// instrumentHTTP counts and times requests by route pattern, such as
+// /api/calls/{callSid}/hold, rather than by path. /metrics itself is left
+// out so scrapes don't show up as traffic.
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetric reads one series, such as
+// twilio_token_requests_total{outcome="success"}, off the metrics endpoint.
+// Series that haven't been exported yet read as 0.
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", series, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestTokenRequestMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     twilioConfig
+		status  int
+		outcome string
+	}{
+		{"issued", twilioConfig{accountSID: "ACtest", apiKeySID: "SKtest", apiKeySecret: "secret", twimlAppSID: "APtest", tokenTTL: time.Hour}, http.StatusOK, tokenOutcomeSuccess},
+		{"no credentials", twilioConfig{}, http.StatusInternalServerError, tokenOutcomeMissingCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.twilioConfig = tt.cfg
+			agent := addAgent(t, s, addCompany(t, s, "Acme"), "acme1", roleAgent)
+
+			before := map[string]float64{}
+			for _, outcome := range []string{tokenOutcomeSuccess, tokenOutcomeMissingCredentials, tokenOutcomeJWTError} {
+				before[outcome] = scrapeMetric(t, `twilio_token_requests_total{outcome="`+outcome+`"}`)
+			}
+			timed := scrapeMetric(t, `twilio_token_duration_seconds_count{outcome="`+tt.outcome+`"}`)
+
+			w := httptest.NewRecorder()
+			s.getTwilioToken(w, asUser(httptest.NewRequest(http.MethodGet, "/api/twilio/token", nil), agent))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			for outcome, count := range before {
+				want := count
+				if outcome == tt.outcome {
+					want++
+				}
+				if got := scrapeMetric(t, `twilio_token_requests_total{outcome="`+outcome+`"}`); got != want {
+					t.Errorf("%s requests = %v, want %v", outcome, got, want)
+				}
+			}
+			if got := scrapeMetric(t, `twilio_token_duration_seconds_count{outcome="`+tt.outcome+`"}`); got != timed+1 {
+				t.Errorf("%s timings = %v, want %v", tt.outcome, got, timed+1)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(instrumentHTTP)
+	r.Get("/api/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r.Handle("/metrics", promhttp.Handler())
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		series string
+	}{
+		{"labelled by route, not path", "/api/things/42", `http_requests_total{method="GET",route="/api/things/{id}",status="418"}`},
+		{"no route", "/no/such/thing", `http_requests_total{method="GET",route="unmatched",status="404"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := scrapeMetric(t, tt.series)
+			get(tt.path)
+			if got := scrapeMetric(t, tt.series); got != before+1 {
+				t.Errorf("%s = %v, want %v", tt.series, got, before+1)
+			}
+		})
+	}
+
+	// Scrapes render, and don't count as traffic
+	scrapes := `http_requests_total{method="GET",route="/metrics",status="200"}`
+	for range 2 {
+		w := get("/metrics")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "# TYPE http_requests_total counter") {
+			t.Fatalf("status = %d, want %d with the request counter: %s", w.Code, http.StatusOK, w.Body)
+		}
+	}
+	if got := scrapeMetric(t, scrapes); got != 0 {
+		t.Errorf("%s = %v, want 0", scrapes, got)
+	}
+}
+
+func TestCallsTotal(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, status, started_at)
+		VALUES ('CA-counted', 'inbound', ?, 'ringing', CURRENT_TIMESTAMP)`, acme)
+	series := `calls_total{direction="inbound",status="completed"}`
+	before := scrapeMetric(t, series)
+
+	// Repeated reports of the end of a call count it once
+	for _, status := range []string{"in-progress", "completed", "completed"} {
+		s.advanceCall(t.Context(), "CA-counted", status, "30", time.Now().UTC())
+	}
+	if got := scrapeMetric(t, series); got != before+1 {
+		t.Errorf("%s = %v, want %v", series, got, before+1)
+	}
+}
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// migration is one versioned change to the schema. Migrations are applied in
+// order, each in its own transaction, and never edited once released: changing
+// the schema means adding a migration to the end of migrations.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "baseline schema", baselineSchema},
+	{2, "user roles", addUserRoles},
+	{3, "user caller numbers", addUserCallerNumbers},
+	{4, "business hours", addBusinessHours},
+	{5, "customer notes", addCustomerNotes},
+	{6, "call answered time", addCallAnsweredAt},
+	{7, "idempotency keys", addIdempotencyKeys},
+	{8, "call errors", addCallErrors},
+	{9, "call ref ids", addCallRefIDs},
+	{10, "queue companies", addQueueCompanies},
+	{11, "updated_at on insert", addUpdatedAtInsertTriggers},
+	{12, "call legs in calls", mergeCallLogs},
+}
+
+// migrate brings the schema up to date, applying the migrations
+// schema_migrations doesn't list yet. It runs on every start.
+func migrate(database *sql.DB) error {
+	_, err := database.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(database, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("🗄️ Applied migration %d: %s", m.version, m.name)
+	}
+
+	// Not a migration, since stored numbers have to follow
+	// DEFAULT_PHONE_REGION whenever it changes
+	return backfillNormalizedPhones(database)
+}
+
+func appliedMigrations(database *sql.DB) (map[int]bool, error) {
+	rows, err := database.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration runs m and records it in one transaction, so a migration
+// that fails part way leaves nothing behind and is tried again next start.
+func applyMigration(database *sql.DB, m migration) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// baselineSchema is the schema as it stood when migrations were introduced.
+// Databases from before then already have some or all of it, so every step
+// checks before it changes anything.
+func baselineSchema(tx *sql.Tx) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS companies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		password_hash TEXT NOT NULL,
+		firstname TEXT NOT NULL,
+		lastname TEXT NOT NULL,
+		agent_id TEXT NOT NULL UNIQUE,
+		company_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		email_verified BOOLEAN NOT NULL DEFAULT 0,
+		failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+		locked_until DATETIME,
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		user_agent TEXT,
+		ip_address TEXT,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS customers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT,
+		phone TEXT,
+		medical_aid_provider TEXT,
+		medical_aid_number TEXT,
+		medical_plan TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		is_vip BOOLEAN NOT NULL DEFAULT 0,
+		deleted_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		phone_normalized TEXT,
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS customer_premiums (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		customer_id INTEGER NOT NULL,
+		premium_amount REAL NOT NULL,
+		effective_date DATE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (customer_id) REFERENCES customers (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS call_transcriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		customer_id INTEGER NOT NULL,
+		agent_id INTEGER NOT NULL,
+		call_sid TEXT,
+		transcript TEXT NOT NULL,
+		summary TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (customer_id) REFERENCES customers (id),
+		FOREIGN KEY (agent_id) REFERENCES users (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS blocked_numbers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		phone TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT 'rejected',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		UNIQUE (company_id, phone)
+	);
+
+	CREATE TABLE IF NOT EXISTS company_settings (
+		company_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (company_id, key),
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS call_refs (
+		id TEXT PRIMARY KEY,
+		call_sid TEXT UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		name_recording_url TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS agent_activity (
+		agent_id TEXT PRIMARY KEY,
+		last_call_at DATETIME NOT NULL,
+		FOREIGN KEY (agent_id) REFERENCES users (agent_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS voicemails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		customer_id INTEGER,
+		call_sid TEXT,
+		from_number TEXT NOT NULL,
+		recording_url TEXT NOT NULL,
+		duration INTEGER NOT NULL DEFAULT 0,
+		deleted_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		read_at DATETIME,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		FOREIGN KEY (customer_id) REFERENCES customers (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT,
+		details TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE INDEX IF NOT EXISTS audit_log_company_created ON audit_log (company_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS queue_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		call_sid TEXT NOT NULL UNIQUE,
+		phone TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'waiting',
+		position INTEGER,
+		queued_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS agent_status (
+		agent_id TEXT PRIMARY KEY,
+		dnd_until DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		wrap_up_call_sid TEXT,
+		wrap_up_until DATETIME,
+		presence TEXT NOT NULL DEFAULT 'offline',
+		last_seen_at DATETIME,
+		FOREIGN KEY (agent_id) REFERENCES users (agent_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS phone_numbers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		phone TEXT NOT NULL UNIQUE,
+		label TEXT,
+		routing_type TEXT NOT NULL DEFAULT 'agents',
+		announcement TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS voicemail_drops (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		agent_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		content_type TEXT NOT NULL,
+		audio BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		FOREIGN KEY (agent_id) REFERENCES users (agent_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS call_dispositions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		agent_id TEXT NOT NULL,
+		call_sid TEXT NOT NULL,
+		disposition TEXT NOT NULL,
+		notes TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		FOREIGN KEY (agent_id) REFERENCES users (agent_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS call_dispositions_call_sid ON call_dispositions (call_sid);
+
+	CREATE TABLE IF NOT EXISTS call_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		call_sid TEXT NOT NULL UNIQUE,
+		parent_call_sid TEXT,
+		agent_id TEXT,
+		direction TEXT NOT NULL,
+		from_number TEXT,
+		to_number TEXT,
+		status TEXT NOT NULL,
+		answered_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		answered_by TEXT,
+		amd_outcome TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS parked_calls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		code TEXT NOT NULL,
+		call_sid TEXT NOT NULL,
+		phone TEXT NOT NULL,
+		parked_by TEXT NOT NULL,
+		retrieved_by TEXT,
+		status TEXT NOT NULL DEFAULT 'parked',
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		FOREIGN KEY (parked_by) REFERENCES users (agent_id)
+	);
+
+	-- Codes are only reserved while the call is still parked
+	CREATE UNIQUE INDEX IF NOT EXISTS parked_calls_code_active ON parked_calls (company_id, code) WHERE status = 'parked';
+
+	CREATE TABLE IF NOT EXISTS password_resets (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS calls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		call_sid TEXT NOT NULL UNIQUE,
+		direction TEXT NOT NULL,
+		from_number TEXT,
+		to_number TEXT,
+		agent_id TEXT,
+		company_id INTEGER,
+		status TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		ended_at DATETIME,
+		duration_seconds INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		recording_sid TEXT,
+		recording_url TEXT,
+		recording_duration INTEGER,
+		hold_started_at DATETIME,
+		hold_seconds INTEGER NOT NULL DEFAULT 0,
+		held_by TEXT,
+		held_call_sid TEXT,
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+
+	CREATE INDEX IF NOT EXISTS calls_company_started ON calls (company_id, started_at);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_sid TEXT UNIQUE,
+		company_id INTEGER NOT NULL,
+		direction TEXT NOT NULL,
+		from_number TEXT NOT NULL,
+		to_number TEXT NOT NULL,
+		body TEXT NOT NULL,
+		status TEXT NOT NULL,
+		agent_id TEXT,
+		error_message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		customer_id INTEGER,
+		FOREIGN KEY (company_id) REFERENCES companies (id),
+		FOREIGN KEY (customer_id) REFERENCES customers (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS call_transfers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		call_id INTEGER NOT NULL,
+		from_agent_id TEXT NOT NULL,
+		to_agent_id TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (call_id) REFERENCES calls (id)
+	);
+
+	CREATE INDEX IF NOT EXISTS call_transfers_call ON call_transfers (call_id);
+
+	CREATE TABLE IF NOT EXISTS conference_participants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		conference_name TEXT NOT NULL,
+		conference_sid TEXT,
+		call_sid TEXT NOT NULL,
+		agent_id TEXT,
+		role TEXT NOT NULL,
+		status TEXT NOT NULL,
+		joined_at DATETIME,
+		left_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (conference_name, call_sid),
+		FOREIGN KEY (company_id) REFERENCES companies (id)
+	);
+	`
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after their table was first created
+	columns := []struct{ table, name, definition string }{
+		{"customers", "is_vip", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"customers", "deleted_at", "DATETIME"},
+		{"companies", "deleted_at", "DATETIME"},
+		{"users", "deleted_at", "DATETIME"},
+		{"call_refs", "name_recording_url", "TEXT"},
+		{"companies", "updated_at", "DATETIME"},
+		{"users", "updated_at", "DATETIME"},
+		{"customers", "updated_at", "DATETIME"},
+		{"voicemails", "updated_at", "DATETIME"},
+		{"phone_numbers", "updated_at", "DATETIME"},
+		{"agent_status", "wrap_up_call_sid", "TEXT"},
+		{"agent_status", "wrap_up_until", "DATETIME"},
+		{"call_logs", "answered_by", "TEXT"},
+		{"call_logs", "amd_outcome", "TEXT"},
+		{"users", "email_verified", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "failed_login_attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "locked_until", "DATETIME"},
+		{"sessions", "user_agent", "TEXT"},
+		{"sessions", "ip_address", "TEXT"},
+		{"customers", "phone_normalized", "TEXT"},
+		{"agent_status", "presence", "TEXT NOT NULL DEFAULT 'offline'"},
+		{"agent_status", "last_seen_at", "DATETIME"},
+		{"messages", "customer_id", "INTEGER"},
+		{"calls", "recording_sid", "TEXT"},
+		{"calls", "recording_url", "TEXT"},
+		{"calls", "recording_duration", "INTEGER"},
+		{"voicemails", "read_at", "DATETIME"},
+		{"calls", "hold_started_at", "DATETIME"},
+		{"calls", "hold_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"calls", "held_by", "TEXT"},
+		{"calls", "held_call_sid", "TEXT"},
+	}
+	// Accounts from before verification existed count as verified, so
+	// turning it on later doesn't lock anyone out
+	hadEmailVerified, err := hasColumn(tx, "users", "email_verified")
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if err := ensureColumn(tx, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	if !hadEmailVerified {
+		if _, err := tx.Exec("UPDATE users SET email_verified = 1"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS customers_phone_normalized ON customers (phone_normalized, company_id)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS messages_company_customer ON messages (company_id, customer_id, id)`); err != nil {
+		return err
+	}
+
+	// SQLite can't add a column with a CURRENT_TIMESTAMP default, so rows
+	// from before updated_at existed start out at their creation time
+	for _, table := range []string{"companies", "users", "customers", "voicemails", "phone_numbers"} {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET updated_at = created_at WHERE updated_at IS NULL", table)); err != nil {
+			return err
+		}
+	}
+
+	// Soft-deleted rows don't hold on to their name or email, so these are
+	// unique only among live rows
+	for _, c := range []struct{ table, column string }{{"companies", "name"}, {"users", "email"}} {
+		if err := dropColumnUnique(tx, c.table, c.column); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+	CREATE UNIQUE INDEX IF NOT EXISTS companies_name_active ON companies (name) WHERE deleted_at IS NULL;
+	CREATE UNIQUE INDEX IF NOT EXISTS users_email_active ON users (email) WHERE deleted_at IS NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Keep updated_at current on every update. Created last because
+	// rebuilding a table above drops its triggers.
+	for _, table := range updatedAtTables {
+		if err := addUpdatedAtTrigger(tx, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatedAtTables are the baseline tables with an updated_at column bumped
+// by a trigger.
+var updatedAtTables = []string{
+	"companies",
+	"users",
+	"customers",
+	"company_settings",
+	"app_settings",
+	"voicemails",
+	"queue_entries",
+	"agent_status",
+	"phone_numbers",
+	"call_logs",
+	"parked_calls",
+	"calls",
+	"messages",
+	"conference_participants",
+}
+
+// addUpdatedAtTrigger keeps a table's updated_at current on every update.
+// Statements that set updated_at themselves are left alone.
+func addUpdatedAtTrigger(tx *sql.Tx, table string) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS %[1]s_updated_at AFTER UPDATE ON %[1]s
+	FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+	BEGIN
+		UPDATE %[1]s SET updated_at = strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now') WHERE rowid = NEW.rowid;
+	END;
+	`, table))
+	return err
+}
+
+// addUpdatedAtInsertTrigger sets updated_at on inserts that leave it unset,
+// since where the column was added to an existing table it has no default.
+func addUpdatedAtInsertTrigger(tx *sql.Tx, table string) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS %[1]s_updated_at_insert AFTER INSERT ON %[1]s
+	FOR EACH ROW WHEN NEW.updated_at IS NULL
+	BEGIN
+		UPDATE %[1]s SET updated_at = strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now') WHERE rowid = NEW.rowid;
+	END;
+	`, table))
+	return err
+}
+
+// ensureColumn adds a column to an existing table unless it's already there.
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := hasColumn(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// dropColumnUnique removes an inline UNIQUE from a column of an existing
+// table. SQLite can't alter constraints, so the table is rebuilt from its own
+// definition with the keyword taken out.
+func dropColumnUnique(tx *sql.Tx, table, column string) error {
+	var definition string
+	err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&definition)
+	if err != nil {
+		return err
+	}
+
+	unique := regexp.MustCompile(`(?m)^(\s*` + column + `\s[^,\n]*?)\s+UNIQUE\b`)
+	if !unique.MatchString(definition) {
+		return nil
+	}
+	rebuilt := unique.ReplaceAllString(definition, "$1")
+	rebuilt = strings.Replace(rebuilt, "CREATE TABLE "+table, "CREATE TABLE "+table+"_rebuild", 1)
+
+	for _, stmt := range []string{
+		rebuilt,
+		fmt.Sprintf("INSERT INTO %s_rebuild SELECT * FROM %s", table, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s_rebuild RENAME TO %s", table, table),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Dropped UNIQUE on %s.%s", table, column)
+	return nil
+}
+
+// addUserRoles gives users a role. Everyone starts out an agent, apart from
+// the longest-standing user of each company, who becomes its admin so
+// existing companies aren't left without one.
+func addUserRoles(tx *sql.Tx) error {
+	if err := ensureColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'agent'"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+	UPDATE users SET role = 'admin'
+	WHERE id IN (SELECT MIN(id) FROM users WHERE deleted_at IS NULL GROUP BY company_id)
+	`)
+	return err
+}
+
+// addUserCallerNumbers lets an agent dial out from a number of their own
+// rather than their company's.
+func addUserCallerNumbers(tx *sql.Tx) error {
+	return ensureColumn(tx, "users", "caller_number_id", "INTEGER REFERENCES phone_numbers(id)")
+}
+
+// addBusinessHours adds opening hours and holidays. No company has a
+// schedule yet, so every company stays open around the clock.
+func addBusinessHours(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS business_schedules (
+		company_id INTEGER PRIMARY KEY,
+		timezone TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS business_schedule_windows (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER NOT NULL,
+		day INTEGER NOT NULL,
+		opens TEXT NOT NULL,
+		closes TEXT NOT NULL,
+		FOREIGN KEY (company_id) REFERENCES companies(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS business_schedule_windows_company ON business_schedule_windows (company_id);
+
+	CREATE TABLE IF NOT EXISTS business_holidays (
+		company_id INTEGER NOT NULL,
+		date TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (company_id, date),
+		FOREIGN KEY (company_id) REFERENCES companies(id)
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	return addUpdatedAtTrigger(tx, "business_schedules")
+}
+
+// addCustomerNotes gives agents somewhere to keep free-form notes about a
+// customer.
+func addCustomerNotes(tx *sql.Tx) error {
+	return ensureColumn(tx, "customers", "notes", "TEXT")
+}
+
+// addCallAnsweredAt records when someone picked up, which a call's final
+// status doesn't say. Earlier outbound calls get it from their customer leg
+// in call_logs; earlier inbound calls left no record of being answered.
+func addCallAnsweredAt(tx *sql.Tx) error {
+	if err := ensureColumn(tx, "calls", "answered_at", "DATETIME"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+	UPDATE calls SET answered_at = (
+		SELECT MIN(l.answered_at) FROM call_logs l WHERE l.parent_call_sid = calls.call_sid
+	)
+	WHERE answered_at IS NULL AND direction = 'outbound'
+	`)
+	return err
+}
+
+// addIdempotencyKeys keeps the responses retried requests are answered with.
+func addIdempotencyKeys(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		method TEXT NOT NULL,
+		path TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER,
+		content_type TEXT,
+		response_body BLOB,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		UNIQUE (user_id, idempotency_key),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idempotency_keys_expires ON idempotency_keys (expires_at);
+	`)
+	return err
+}
+
+// addCallErrors records what Twilio reports to the fallback URL.
+func addCallErrors(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS call_errors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		company_id INTEGER,
+		call_sid TEXT,
+		error_code TEXT,
+		error_url TEXT,
+		from_number TEXT,
+		to_number TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (company_id) REFERENCES companies(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS call_errors_company_created ON call_errors (company_id, created_at);
+	`)
+	return err
+}
+
+// addCallRefIDs points call records at the internal call id instead of the
+// Twilio CallSid, and records which company each call id belongs to.
+// CallSids recorded before there was a call id for them get one here.
+func addCallRefIDs(tx *sql.Tx) error {
+	if err := ensureColumn(tx, "call_refs", "company_id", "INTEGER REFERENCES companies(id)"); err != nil {
+		return err
+	}
+	for _, table := range []string{"calls", "call_logs", "call_dispositions"} {
+		if err := ensureColumn(tx, table, "call_ref_id", "TEXT REFERENCES call_refs(id)"); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(`
+	SELECT call_sid FROM calls
+	UNION SELECT call_sid FROM call_logs
+	UNION SELECT call_sid FROM call_dispositions
+	EXCEPT SELECT call_sid FROM call_refs
+	`)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for rows.Next() {
+		var callSID string
+		if err := rows.Scan(&callSID); err != nil {
+			rows.Close()
+			return err
+		}
+		missing = append(missing, callSID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, callSID := range missing {
+		if _, err := tx.Exec("INSERT INTO call_refs (id, call_sid) VALUES (?, ?)", newCallID(), callSID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+	UPDATE call_refs SET company_id = COALESCE(
+		(SELECT company_id FROM calls WHERE calls.call_sid = call_refs.call_sid),
+		(SELECT MIN(company_id) FROM call_dispositions d WHERE d.call_sid = call_refs.call_sid)
+	)
+	WHERE company_id IS NULL;
+
+	UPDATE calls SET call_ref_id = (SELECT id FROM call_refs r WHERE r.call_sid = calls.call_sid) WHERE call_ref_id IS NULL;
+	UPDATE call_logs SET call_ref_id = (SELECT id FROM call_refs r WHERE r.call_sid = call_logs.call_sid) WHERE call_ref_id IS NULL;
+	UPDATE call_dispositions SET call_ref_id = (SELECT id FROM call_refs r WHERE r.call_sid = call_dispositions.call_sid) WHERE call_ref_id IS NULL;
+
+	CREATE INDEX IF NOT EXISTS calls_call_ref_id ON calls (call_ref_id);
+	CREATE INDEX IF NOT EXISTS call_logs_call_ref_id ON call_logs (call_ref_id);
+	CREATE INDEX IF NOT EXISTS call_dispositions_call_ref_id ON call_dispositions (call_ref_id);
+	`)
+	return err
+}
+
+// addQueueCompanies gives every company a queue of its own. Entries are
+// matched to a company through their call; callers still in line whose
+// company can't be told were in the old shared queue, so they're closed.
+func addQueueCompanies(tx *sql.Tx) error {
+	if err := ensureColumn(tx, "queue_entries", "company_id", "INTEGER REFERENCES companies(id)"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+	UPDATE queue_entries SET company_id = COALESCE(
+		(SELECT company_id FROM calls WHERE calls.call_sid = queue_entries.call_sid),
+		(SELECT company_id FROM call_refs WHERE call_refs.call_sid = queue_entries.call_sid)
+	)
+	WHERE company_id IS NULL;
+
+	UPDATE queue_entries SET status = 'left'
+	WHERE company_id IS NULL AND status IN ('waiting', 'declined', 'callback');
+
+	CREATE INDEX IF NOT EXISTS queue_entries_company_status ON queue_entries (company_id, status);
+	`)
+	return err
+}
+
+// addUpdatedAtInsertTriggers fills in updated_at on insert where the
+// baseline added the column without a default, and on the rows inserted
+// since then with it left empty.
+func addUpdatedAtInsertTriggers(tx *sql.Tx) error {
+	for _, table := range append(updatedAtTables, "business_schedules") {
+		if err := addUpdatedAtInsertTrigger(tx, table); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"companies", "users", "customers", "voicemails", "phone_numbers"} {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET updated_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE updated_at IS NULL", table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeCallLogs moves the customer legs agents dial out of call_logs and
+// into calls, so a call's history is kept in one table whichever way it
+// went. Legs point at the agent's call through parent_call_sid and belong
+// to its company, or failing that the agent's.
+func mergeCallLogs(tx *sql.Tx) error {
+	for _, column := range []string{"parent_call_sid", "answered_by", "amd_outcome"} {
+		if err := ensureColumn(tx, "calls", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+	INSERT OR IGNORE INTO calls (call_sid, call_ref_id, parent_call_sid, direction, from_number, to_number, agent_id, company_id,
+		status, started_at, answered_at, answered_by, amd_outcome, created_at, updated_at)
+	SELECT l.call_sid, l.call_ref_id, l.parent_call_sid, l.direction, l.from_number, l.to_number, l.agent_id,
+		COALESCE(
+			(SELECT company_id FROM calls p WHERE p.call_sid = l.parent_call_sid),
+			(SELECT company_id FROM users u WHERE u.agent_id = l.agent_id)
+		),
+		l.status, COALESCE(l.created_at, CURRENT_TIMESTAMP), l.answered_at, l.answered_by, l.amd_outcome, l.created_at, l.updated_at
+	FROM call_logs l;
+
+	DROP TABLE call_logs;
+
+	CREATE INDEX IF NOT EXISTS calls_parent_call_sid ON calls (parent_call_sid);
+	`)
+	return err
+}
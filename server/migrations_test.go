@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUpdatedAtOnInsert(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+
+	tests := []struct {
+		table  string
+		insert string
+		args   []any
+	}{
+		{"companies", "INSERT INTO companies (name, updated_at) VALUES ('Globex', NULL)", nil},
+		{"users", `INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id, role, updated_at)
+			VALUES ('pat@example.com', 'x', 'Pat', 'Doe', 'pat', ?, 'agent', NULL)`, []any{acme}},
+		{"customers", "INSERT INTO customers (company_id, first_name, last_name, updated_at) VALUES (?, 'Pat', 'Doe', NULL)", []any{acme}},
+		{"queue_entries", "INSERT INTO queue_entries (company_id, call_sid, phone, status, updated_at) VALUES (?, 'CA1', '+27825550001', 'waiting', NULL)", []any{acme}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			id, _ := exec(t, s, tt.insert, tt.args...).LastInsertId()
+			var updatedAt sql.NullString
+			if err := s.db.QueryRow("SELECT updated_at FROM "+tt.table+" WHERE rowid = ?", id).Scan(&updatedAt); err != nil {
+				t.Fatal(err)
+			}
+			if !updatedAt.Valid {
+				t.Error("updated_at is NULL")
+			}
+		})
+	}
+}
+
+func TestAddUpdatedAtInsertTriggers(t *testing.T) {
+	s := newTestServer(t)
+	createCallLogs(t, s)
+	// Rows inserted before the triggers existed
+	exec(t, s, "DROP TRIGGER companies_updated_at_insert")
+	exec(t, s, "INSERT INTO companies (name, created_at, updated_at) VALUES ('Old', '2024-01-02 03:04:05', NULL)")
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addUpdatedAtInsertTriggers(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	exec(t, s, "INSERT INTO companies (name, updated_at) VALUES ('New', NULL)")
+
+	tests := []struct {
+		name string
+		want string // "" for any time
+	}{
+		{"Old", "2024-01-02 03:04:05"},
+		{"New", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updatedAt sql.NullString
+			if err := s.db.QueryRow("SELECT strftime('%Y-%m-%d %H:%M:%S', updated_at) FROM companies WHERE name = ?", tt.name).Scan(&updatedAt); err != nil {
+				t.Fatal(err)
+			}
+			if !updatedAt.Valid {
+				t.Fatal("updated_at is NULL")
+			}
+			if tt.want != "" && updatedAt.String != tt.want {
+				t.Errorf("updated_at = %s, want %s", updatedAt.String, tt.want)
+			}
+		})
+	}
+}
+
+// schemaOf lists every table, index and trigger definition in database.
+func schemaOf(t *testing.T, database *sql.DB) string {
+	t.Helper()
+	rows, err := database.Query("SELECT type, name, COALESCE(sql, '') FROM sqlite_master ORDER BY type, name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var schema strings.Builder
+	for rows.Next() {
+		var kind, name, definition string
+		if err := rows.Scan(&kind, &name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		schema.WriteString(kind + " " + name + ": " + definition + "\n")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema.String()
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup string // an existing database from before migrations
+		runs  int
+	}{
+		{"fresh database", "", 1},
+		{"run twice", "", 2},
+		{"run three times", "", 3},
+		{"database from before migrations", `
+			CREATE TABLE companies (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+			INSERT INTO companies (name) VALUES ('Acme');`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			database.SetMaxOpenConns(1)
+			defer database.Close()
+			if tt.setup != "" {
+				if _, err := database.Exec(tt.setup); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var first string
+			for run := 1; run <= tt.runs; run++ {
+				if err := migrate(database); err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+				schema := schemaOf(t, database)
+				if run == 1 {
+					first = schema
+				} else if schema != first {
+					t.Errorf("run %d changed the schema:\n%s\nwant:\n%s", run, schema, first)
+				}
+			}
+
+			var applied, last int
+			if err := database.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_migrations").Scan(&applied, &last); err != nil {
+				t.Fatal(err)
+			}
+			if applied != len(migrations) || last != migrations[len(migrations)-1].version {
+				t.Errorf("recorded %d migrations up to %d, want %d up to %d", applied, last, len(migrations), migrations[len(migrations)-1].version)
+			}
+			if tt.setup != "" {
+				var name string
+				if err := database.QueryRow("SELECT name FROM companies").Scan(&name); err != nil || name != "Acme" {
+					t.Errorf("existing company = %q, %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyMigration(t *testing.T) {
+	s := newTestServer(t)
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name    string
+		version int
+		table   string // created by up
+		up      func(*sql.Tx) error
+		want    error
+		kept    bool // the table and the record are there afterwards
+	}{
+		{"succeeds", 998, "done", func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE done (id INTEGER)")
+			return err
+		}, nil, true},
+		{"fails part way", 999, "half_done", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+				return err
+			}
+			return errBroken
+		}, errBroken, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyMigration(s.db, migration{version: tt.version, name: tt.name, up: tt.up})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			var tables, recorded int
+			s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", tt.table).Scan(&tables)
+			s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", tt.version).Scan(&recorded)
+			if kept := tables == 1 && recorded == 1; kept != tt.kept || tables != recorded {
+				t.Errorf("tables = %d, records = %d, want kept = %v", tables, recorded, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// operatorTokenFromEnv reads OPERATOR_TOKEN, the credential for endpoints
+// that act on the whole deployment rather than one company, such as
+// maintenance mode and backups. Those endpoints are off while it's unset.
+func operatorTokenFromEnv() (string, error) {
+	token := os.Getenv("OPERATOR_TOKEN")
+	if token != "" && len(token) < 32 {
+		return "", errors.New("OPERATOR_TOKEN must be at least 32 characters")
+	}
+	return token, nil
+}
+
+// requireOperator lets through requests that carry the operator token as a
+// bearer token. A tenant admin only runs their own company, and anyone who
+// registers a new company becomes one, so sessions don't count here.
+func (s *Server) requireOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.operatorToken == "" {
+			respondError(w, http.StatusNotFound, "Operator endpoints are not enabled")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.operatorToken)) != 1 {
+			logWarnf(r.Context(), "🔒 Rejected operator request to %s from %s", r.URL.Path, s.clientIP(r))
+			respondError(w, http.StatusUnauthorized, "Operator token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 25
+	maxPageSize     = 100
+)
+
+// Pagination is returned next to every paginated list.
+type Pagination struct {
+	Page     int64 `json:"page"`
+	PageSize int64 `json:"page_size"`
+	Total    int64 `json:"total"`
+}
+
+// parsePagination reads page and page_size from the query string. Missing or
+// out-of-range values are clamped rather than rejected.
+func parsePagination(r *http.Request) Pagination {
+	page, _ := strconv.ParseInt(r.URL.Query().Get("page"), 10, 64)
+	if page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.ParseInt(r.URL.Query().Get("page_size"), 10, 64)
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	return Pagination{Page: page, PageSize: pageSize}
+}
+
+func (p Pagination) Offset() int64 {
+	return (p.Page - 1) * p.PageSize
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// Parked call statuses
+const (
+	parkedWaiting = "parked"
+	parkedExpired = "expired"
+	parkedEnded   = "ended"
+)
+
+type ParkedCallsResponse struct {
+	Success     bool            `json:"success"`
+	ParkedCalls []db.ParkedCall `json:"parked_calls"`
+}
+
+type ParkedCallResponse struct {
+	Success    bool           `json:"success"`
+	ParkedCall *db.ParkedCall `json:"parked_call,omitempty"`
+}
+
+// parkTimeout is how long, in seconds, a call stays parked before it goes
+// back to the queue. Set PARK_TIMEOUT to change it.
+func parkTimeout() int {
+	if seconds, err := strconv.Atoi(os.Getenv("PARK_TIMEOUT")); err == nil && seconds > 0 {
+		return seconds
+	}
+	return 300
+}
+
+// getParkedCalls lists the calls waiting to be picked up in the caller's
+// company.
+func (s *Server) getParkedCalls(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	parked, err := s.queries.ListParkedCalls(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get parked calls")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ParkedCallsResponse{
+		Success:     true,
+		ParkedCalls: parked,
+	})
+}
+
+// parkCall moves the customer on the agent's call into a hold conference of
+// its own and hands back a code any agent in the company can retrieve it
+// with. callSid is the agent's (browser) leg, inbound or outbound.
+func (s *Server) parkCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	callSID := chi.URLParam(r, "callSid")
+	customer, err := customerLeg(s.twilio.Api, callSID, user.AgentID)
+	if errors.Is(err, errCallNotFound) {
+		respondError(w, http.StatusNotFound, "No active call found")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error finding customer leg of %s: %v", callSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to park call")
+		return
+	}
+
+	timeout := parkTimeout()
+	parked, err := s.createParkedCall(r, db.CreateParkedCallParams{
+		CompanyID: user.CompanyID,
+		CallSid:   *customer.Sid,
+		Phone:     customerPhone(customer, callSID),
+		ParkedBy:  user.AgentID,
+		ExpiresAt: time.Now().Add(time.Duration(timeout) * time.Second).UTC(),
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error parking %s: %v", *customer.Sid, err)
+		respondError(w, http.StatusInternalServerError, "Failed to park call")
+		return
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(parkTwiML(parked.CallSid, timeout))
+	if _, err := s.twilio.Api.UpdateCall(parked.CallSid, update); err != nil {
+		logErrorf(r.Context(), "Error moving %s to hold: %v", parked.CallSid, err)
+		s.setParkedCallStatus(r, parked.ID, parkedEnded)
+		respondError(w, http.StatusBadGateway, "Failed to park call")
+		return
+	}
+
+	logInfof(r.Context(), "🅿️ Call %s parked by %s as %s", parked.CallSid, user.AgentID, parked.Code)
+	s.audit(r.Context(), user, auditCallPark, parked.CallSid, parked.Code)
+	s.notify(realtimeEvent{
+		Type:      "call_parked",
+		CompanyID: user.CompanyID,
+		AgentID:   user.AgentID,
+		Data:      parked,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ParkedCallResponse{
+		Success:    true,
+		ParkedCall: &parked,
+	})
+}
+
+// createParkedCall stores a parked call under a fresh four-digit code,
+// picking again when the code is already taken in the company.
+func (s *Server) createParkedCall(r *http.Request, arg db.CreateParkedCallParams) (db.ParkedCall, error) {
+	for {
+		arg.Code = fmt.Sprintf("%04d", rand.IntN(10000))
+		parked, err := s.queries.CreateParkedCall(r.Context(), arg)
+		if isUniqueViolation(err) {
+			continue
+		}
+		return parked, err
+	}
+}
+
+// retrieveParkedCall takes a parked call out of hold and rings it through to
+// the retrieving agent. Should they not answer, the dial action queues the
+// caller like any other missed call.
+func (s *Server) retrieveParkedCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	// Claiming the row first means two agents can't both pick it up
+	parked, err := s.queries.RetrieveParkedCall(r.Context(), db.RetrieveParkedCallParams{
+		RetrievedBy: sql.NullString{String: user.AgentID, Valid: true},
+		CompanyID:   user.CompanyID,
+		Code:        chi.URLParam(r, "code"),
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Parked call not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retrieve parked call")
+		return
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(retrieveTwiML(user.AgentID))
+	if _, err := s.twilio.Api.UpdateCall(parked.CallSid, update); err != nil {
+		if _, fetchErr := fetchCall(s.twilio.Api, parked.CallSid); errors.Is(fetchErr, errCallNotFound) {
+			s.setParkedCallStatus(r, parked.ID, parkedEnded)
+			respondError(w, http.StatusNotFound, "Parked call has ended")
+			return
+		}
+		logErrorf(r.Context(), "Error retrieving parked call %s: %v", parked.CallSid, err)
+		s.setParkedCallStatus(r, parked.ID, parkedWaiting)
+		respondError(w, http.StatusBadGateway, "Failed to retrieve parked call")
+		return
+	}
+
+	logInfof(r.Context(), "🅿️ Call %s retrieved by %s", parked.CallSid, user.AgentID)
+	s.audit(r.Context(), user, auditCallRetrieve, parked.CallSid, parked.Code)
+	s.notify(realtimeEvent{
+		Type:      "call_retrieved",
+		CompanyID: user.CompanyID,
+		AgentID:   user.AgentID,
+		Data:      parked,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ParkedCallResponse{
+		Success:    true,
+		ParkedCall: &parked,
+	})
+}
+
+func (s *Server) setParkedCallStatus(r *http.Request, id int64, status string) {
+	if err := s.queries.SetParkedCallStatus(r.Context(), db.SetParkedCallStatusParams{
+		Status: status,
+		ID:     id,
+	}); err != nil {
+		logErrorf(r.Context(), "Error setting parked call %d to %s: %v", id, status, err)
+	}
+}
+
+// handleParkExpired is the action of the hold conference's dial, which ends
+// when the park times out or the caller hangs up. Timed-out callers join the
+// queue. Retrieval moves the caller out of the dial without running it.
+func (s *Server) handleParkExpired(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+
+	callSID := r.FormValue("CallSid")
+	status := parkedExpired
+	if r.FormValue("CallStatus") == "completed" {
+		status = parkedEnded
+	}
+	parked, err := s.queries.EndParkedCall(r.Context(), db.EndParkedCallParams{
+		Status:  status,
+		CallSid: callSID,
+	})
+	if err == sql.ErrNoRows {
+		// Already being retrieved; hold on until the redirect lands
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Pause length="10"/>
+</Response>`))
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error ending parked call %s: %v", callSID, err)
+		parked.Phone = r.FormValue("From")
+	}
+	if status == parkedEnded {
+		logInfof(r.Context(), "🅿️ Parked call %s hung up", callSID)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response/>`))
+		return
+	}
+
+	logInfof(r.Context(), "🅿️ Parked call %s (%s) timed out", callSID, parked.Code)
+	s.notify(realtimeEvent{
+		Type:      "call_park_expired",
+		CompanyID: parked.CompanyID,
+		AgentID:   parked.ParkedBy,
+		Data:      parked,
+	})
+	w.Write([]byte(s.queueCallTwiML(r, parked.CompanyID, callSID, parked.Phone)))
+}
+
+// customerLeg finds the customer's side of agentID's call. On inbound calls
+// the agent's leg was dialed by the customer's; on outbound calls it dialed
+// the customer.
+func customerLeg(api callController, callSID, agentID string) (*openapi.ApiV2010Call, error) {
+	call, err := fetchCall(api, callSID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := "client:" + agentID
+	switch {
+	case call.From != nil && *call.From == identity:
+		return dialedLeg(api, callSID)
+	case call.To != nil && *call.To == identity && call.ParentCallSid != nil:
+		return fetchCall(api, *call.ParentCallSid)
+	}
+	return nil, errCallNotFound
+}
+
+// customerPhone is the customer's number on their leg: the caller on an
+// inbound call, the number dialed on an outbound one.
+func customerPhone(customer *openapi.ApiV2010Call, agentCallSID string) string {
+	if customer.ParentCallSid != nil && *customer.ParentCallSid == agentCallSID && customer.To != nil {
+		return *customer.To
+	}
+	if customer.From != nil {
+		return *customer.From
+	}
+	return ""
+}
+
+// parkTwiML holds the caller in a conference nobody else is in yet. The
+// dial's time limit is the park timeout.
+func parkTwiML(callSID string, timeout int) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Please hold.</Say>
+	<Dial timeLimit="%d" action="/twilio/park/expired">
+		<Conference beep="false" startConferenceOnEnter="false" waitUrl="http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3">park-%s</Conference>
+	</Dial>
+</Response>`, timeout, html.EscapeString(callSID))
+}
+
+func retrieveTwiML(agentID string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Dial action="/twilio/dial-complete?agent_id=%s">
+		<Client>%s</Client>
+	</Dial>
+</Response>`, url.QueryEscape(agentID), html.EscapeString(agentID))
+}
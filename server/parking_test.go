@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"omnicall/db"
+	"strings"
+	"testing"
+)
+
+// parkingServer has acme1 on an outbound call, CA-agent, to a customer on
+// CA-customer, with acme2 free to pick it up and globex1 at another company.
+func parkingServer(t *testing.T) (*Server, *fakeTwilio, map[string]*db.User) {
+	t.Helper()
+	t.Setenv("PARK_TIMEOUT", "120")
+	s := newTestServer(t)
+	fake := withFakeTwilio(s)
+	fake.responses = map[string]string{
+		"/Calls/CA-agent.json":    `{"sid":"CA-agent","from":"client:acme1","to":"+27825550001"}`,
+		"/Calls.json":             `{"calls":[{"sid":"CA-customer","parent_call_sid":"CA-agent","from":"+27110000001","to":"+27825550001"}]}`,
+		"/Calls/CA-customer.json": `{"sid":"CA-customer"}`,
+	}
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	return s, fake, map[string]*db.User{
+		"acme1":   addAgent(t, s, acme, "acme1", roleAgent),
+		"acme2":   addAgent(t, s, acme, "acme2", roleAgent),
+		"globex1": addAgent(t, s, globex, "globex1", roleAgent),
+	}
+}
+
+func park(t *testing.T, s *Server, user *db.User, callSID string) (*httptest.ResponseRecorder, *db.ParkedCall) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/"+callSID+"/park", nil), user)
+	s.parkCall(w, withURLParams(r, map[string]string{"callSid": callSID}))
+	var resp ParkedCallResponse
+	json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&resp)
+	return w, resp.ParkedCall
+}
+
+func retrieve(s *Server, user *db.User, code string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/parked-calls/"+code+"/retrieve", nil), user)
+	s.retrieveParkedCall(w, withURLParams(r, map[string]string{"code": code}))
+	return w
+}
+
+// redirectedTo is the TwiML the last request to Twilio sent callSID to.
+func redirectedTo(fake *fakeTwilio, callSID string) string {
+	var twiml string
+	for i, path := range fake.paths {
+		if strings.HasPrefix(path, http.MethodPost) && strings.HasSuffix(path, "/Calls/"+callSID+".json") {
+			twiml = fake.requests[i].Get("Twiml")
+		}
+	}
+	return twiml
+}
+
+func parkedStatus(t *testing.T, s *Server, id int64) string {
+	t.Helper()
+	var status string
+	if err := s.db.QueryRow("SELECT status FROM parked_calls WHERE id = ?", id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestParkAndRetrieve(t *testing.T) {
+	s, fake, users := parkingServer(t)
+
+	// Only the agent on the call can park it
+	if w, _ := park(t, s, users["acme2"], "CA-agent"); w.Code != http.StatusNotFound {
+		t.Errorf("someone else's call: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w, _ := park(t, s, users["acme1"], "CA-gone"); w.Code != http.StatusNotFound {
+		t.Errorf("no such call: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w, parked := park(t, s, users["acme1"], "CA-agent")
+	if w.Code != http.StatusCreated || parked == nil {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if len(parked.Code) != 4 || parked.CallSid != "CA-customer" || parked.Phone != "+27825550001" || parked.Status != parkedWaiting {
+		t.Errorf("parked %+v, want the customer's leg and number under a four-digit code", parked)
+	}
+	if got, want := redirectedTo(fake, "CA-customer"), parkTwiML("CA-customer", 120); got != want {
+		t.Errorf("customer sent to %s, want the hold conference %s", got, want)
+	}
+
+	// The company sees the parked call; nobody else can touch it
+	for user, want := range map[string]int{"acme2": 1, "globex1": 0} {
+		w := httptest.NewRecorder()
+		s.getParkedCalls(w, asUser(httptest.NewRequest(http.MethodGet, "/api/parked-calls", nil), users[user]))
+		var resp ParkedCallsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.ParkedCalls) != want {
+			t.Errorf("%s lists %d parked calls, want %d", user, len(resp.ParkedCalls), want)
+		}
+	}
+	if w := retrieve(s, users["globex1"], parked.Code); w.Code != http.StatusNotFound {
+		t.Errorf("another company retrieving: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := retrieve(s, users["acme2"], parked.Code); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got, want := redirectedTo(fake, "CA-customer"), retrieveTwiML("acme2"); got != want {
+		t.Errorf("customer sent to %s, want acme2 %s", got, want)
+	}
+	if status := parkedStatus(t, s, parked.ID); status != "retrieved" {
+		t.Errorf("status = %s, want retrieved", status)
+	}
+	if w := retrieve(s, users["acme1"], parked.Code); w.Code != http.StatusNotFound {
+		t.Errorf("retrieving twice: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Leaving the hold conference for the retrieving agent doesn't queue
+	// the caller
+	w = httptest.NewRecorder()
+	s.handleParkExpired(w, twilioWebhook("/twilio/park/expired", url.Values{"CallSid": {"CA-customer"}, "CallStatus": {"in-progress"}}))
+	if strings.Contains(w.Body.String(), "<Enqueue") {
+		t.Errorf("retrieved call queued: %s", w.Body)
+	}
+}
+
+func TestParkExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string // CallStatus when the hold conference's dial ends
+		want   string
+		queued bool
+	}{
+		{"timed out", "in-progress", parkedExpired, true},
+		{"caller hung up", "completed", parkedEnded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, users := parkingServer(t)
+			_, parked := park(t, s, users["acme1"], "CA-agent")
+			if parked == nil {
+				t.Fatal("call not parked")
+			}
+
+			w := httptest.NewRecorder()
+			s.handleParkExpired(w, twilioWebhook("/twilio/park/expired", url.Values{"CallSid": {"CA-customer"}, "CallStatus": {tt.status}}))
+			if queued := strings.Contains(w.Body.String(), "<Enqueue"); queued != tt.queued {
+				t.Errorf("queued = %v, want %v: %s", queued, tt.queued, w.Body)
+			}
+			if status := parkedStatus(t, s, parked.ID); status != tt.want {
+				t.Errorf("status = %s, want %s", status, tt.want)
+			}
+			if w := retrieve(s, users["acme2"], parked.Code); w.Code != http.StatusNotFound {
+				t.Errorf("retrieving after: status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Longest password bcrypt can use; it ignores anything past 72 bytes.
+const maxPasswordLength = 72
+
+// Password rules a client can be told it missed
+const (
+	passwordRuleMinLength = "min_length"
+	passwordRuleMaxLength = "max_length"
+	passwordRuleUppercase = "uppercase"
+	passwordRuleLowercase = "lowercase"
+	passwordRuleDigit     = "digit"
+	passwordRuleSymbol    = "symbol"
+)
+
+// passwordPolicy is what new passwords have to satisfy, and how they are
+// hashed. Lengths are in bytes.
+type passwordPolicy struct {
+	minLength        int
+	cost             int
+	requireUppercase bool
+	requireLowercase bool
+	requireDigit     bool
+	requireSymbol    bool
+}
+
+// PasswordRequirement is one rule a password failed.
+type PasswordRequirement struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type PasswordPolicyResponse struct {
+	ErrorResponse
+	UnmetRequirements []PasswordRequirement `json:"unmet_requirements"`
+}
+
+// passwordPolicyFromEnv reads PASSWORD_MIN_LENGTH (default 8), the
+// PASSWORD_REQUIRE_UPPERCASE, _LOWERCASE, _DIGIT and _SYMBOL switches, which
+// are all off by default, and BCRYPT_COST (default bcrypt's own, 10).
+func passwordPolicyFromEnv() (passwordPolicy, error) {
+	p := passwordPolicy{minLength: 8, cost: bcrypt.DefaultCost}
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 1 || length > maxPasswordLength {
+			return p, fmt.Errorf("PASSWORD_MIN_LENGTH must be between 1 and %d", maxPasswordLength)
+		}
+		p.minLength = length
+	}
+	if value := os.Getenv("BCRYPT_COST"); value != "" {
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return p, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		p.cost = cost
+	}
+
+	for name, field := range map[string]*bool{
+		"PASSWORD_REQUIRE_UPPERCASE": &p.requireUppercase,
+		"PASSWORD_REQUIRE_LOWERCASE": &p.requireLowercase,
+		"PASSWORD_REQUIRE_DIGIT":     &p.requireDigit,
+		"PASSWORD_REQUIRE_SYMBOL":    &p.requireSymbol,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return p, errors.New(name + " must be true or false")
+		}
+		*field = on
+	}
+	return p, nil
+}
+
+// hash hashes a new password at the configured cost.
+func (p passwordPolicy) hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), p.cost)
+}
+
+// needsRehash reports whether a stored hash is cheaper than the configured
+// cost, so it should be replaced the next time the password is known.
+func (p passwordPolicy) needsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < p.cost
+}
+
+// validatePassword returns every rule of the policy the password misses,
+// or nothing if it is acceptable.
+func validatePassword(p passwordPolicy, password string) []PasswordRequirement {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	var unmet []PasswordRequirement
+	if len(password) < p.minLength {
+		unmet = append(unmet, PasswordRequirement{passwordRuleMinLength, fmt.Sprintf("At least %d characters", p.minLength)})
+	}
+	if len(password) > maxPasswordLength {
+		unmet = append(unmet, PasswordRequirement{passwordRuleMaxLength, fmt.Sprintf("At most %d characters", maxPasswordLength)})
+	}
+	if p.requireUppercase && !upper {
+		unmet = append(unmet, PasswordRequirement{passwordRuleUppercase, "An uppercase letter"})
+	}
+	if p.requireLowercase && !lower {
+		unmet = append(unmet, PasswordRequirement{passwordRuleLowercase, "A lowercase letter"})
+	}
+	if p.requireDigit && !digit {
+		unmet = append(unmet, PasswordRequirement{passwordRuleDigit, "A digit"})
+	}
+	if p.requireSymbol && !symbol {
+		unmet = append(unmet, PasswordRequirement{passwordRuleSymbol, "A symbol"})
+	}
+	return unmet
+}
+
+// respondWeakPassword refuses the password in field, listing the rules it
+// missed so the frontend can show which are still outstanding.
+func respondWeakPassword(w http.ResponseWriter, field string, unmet []PasswordRequirement) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(PasswordPolicyResponse{
+		ErrorResponse:     newErrorResponse(w, errCodeWeakPassword, "Password does not meet the requirements", map[string]string{field: "Does not meet the requirements"}),
+		UnmetRequirements: unmet,
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidatePassword(t *testing.T) {
+	strict := passwordPolicy{minLength: 8, requireUppercase: true, requireLowercase: true, requireDigit: true, requireSymbol: true}
+
+	tests := []struct {
+		name     string
+		policy   passwordPolicy
+		password string
+		unmet    []string
+	}{
+		{"one short of the minimum", passwordPolicy{minLength: 8}, "1234567", []string{passwordRuleMinLength}},
+		{"exactly the minimum", passwordPolicy{minLength: 8}, "12345678", nil},
+		{"minimum counts bytes", passwordPolicy{minLength: 8}, "ééé", []string{passwordRuleMinLength}},
+		{"exactly the maximum", passwordPolicy{minLength: 8}, strings.Repeat("a", maxPasswordLength), nil},
+		{"one past the maximum", passwordPolicy{minLength: 8}, strings.Repeat("a", maxPasswordLength+1), []string{passwordRuleMaxLength}},
+		{"rules off by default", passwordPolicy{minLength: 8}, "aaaaaaaa", nil},
+		{"meets every rule", strict, "Abcdef1!", nil},
+		{"no uppercase", strict, "abcdef1!", []string{passwordRuleUppercase}},
+		{"no lowercase", strict, "ABCDEF1!", []string{passwordRuleLowercase}},
+		{"no digit", strict, "Abcdefg!", []string{passwordRuleDigit}},
+		{"no symbol", strict, "Abcdefg1", []string{passwordRuleSymbol}},
+		{"space counts as a symbol", strict, "Abcdef1 ", nil},
+		{"non-ASCII letters count", strict, "Ébcdef1!", nil},
+		{"misses several", strict, "abc", []string{passwordRuleMinLength, passwordRuleUppercase, passwordRuleDigit, passwordRuleSymbol}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unmet []string
+			for _, req := range validatePassword(tt.policy, tt.password) {
+				unmet = append(unmet, req.Rule)
+			}
+			if !slices.Equal(unmet, tt.unmet) {
+				t.Errorf("unmet = %v, want %v", unmet, tt.unmet)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    passwordPolicy
+		wantErr bool
+	}{
+		{"defaults", nil, passwordPolicy{minLength: 8, cost: 10}, false},
+		{"minimum length", map[string]string{"PASSWORD_MIN_LENGTH": "12"}, passwordPolicy{minLength: 12, cost: 10}, false},
+		{"shortest minimum", map[string]string{"PASSWORD_MIN_LENGTH": "1"}, passwordPolicy{minLength: 1, cost: 10}, false},
+		{"longest minimum", map[string]string{"PASSWORD_MIN_LENGTH": "72"}, passwordPolicy{minLength: 72, cost: 10}, false},
+		{"zero minimum", map[string]string{"PASSWORD_MIN_LENGTH": "0"}, passwordPolicy{}, true},
+		{"minimum past bcrypt's limit", map[string]string{"PASSWORD_MIN_LENGTH": "73"}, passwordPolicy{}, true},
+		{"every requirement", map[string]string{
+			"PASSWORD_REQUIRE_UPPERCASE": "true",
+			"PASSWORD_REQUIRE_LOWERCASE": "1",
+			"PASSWORD_REQUIRE_DIGIT":     "true",
+			"PASSWORD_REQUIRE_SYMBOL":    "TRUE",
+		}, passwordPolicy{minLength: 8, cost: 10, requireUppercase: true, requireLowercase: true, requireDigit: true, requireSymbol: true}, false},
+		{"bad switch", map[string]string{"PASSWORD_REQUIRE_DIGIT": "yes please"}, passwordPolicy{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PASSWORD_MIN_LENGTH", "PASSWORD_REQUIRE_UPPERCASE", "PASSWORD_REQUIRE_LOWERCASE", "PASSWORD_REQUIRE_DIGIT", "PASSWORD_REQUIRE_SYMBOL", "BCRYPT_COST"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got, err := passwordPolicyFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("policy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterWeakPassword(t *testing.T) {
+	s := newTestServer(t)
+	s.passwords.requireDigit = true
+	acme := addCompany(t, s, "Acme")
+	addAgent(t, s, acme, "acmeadmin", roleAdmin)
+
+	tests := []struct {
+		name     string
+		password string
+		status   int
+		unmet    []string
+	}{
+		{"strong enough", "password1", http.StatusOK, nil},
+		{"too short", "pass1", http.StatusBadRequest, []string{passwordRuleMinLength}},
+		{"no digit", "password", http.StatusBadRequest, []string{passwordRuleDigit}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]any{
+				"email": fmt.Sprintf("u%d@example.com", i), "password": tt.password,
+				"firstname": "U", "lastname": "Ser", "agent_id": fmt.Sprintf("user%d", i), "company_id": acme,
+			})
+			w := httptest.NewRecorder()
+			s.register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(string(body))))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			var resp PasswordPolicyResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			var unmet []string
+			for _, req := range resp.UnmetRequirements {
+				unmet = append(unmet, req.Rule)
+			}
+			if resp.Code != errCodeWeakPassword || !slices.Equal(unmet, tt.unmet) {
+				t.Errorf("code = %q, unmet = %v, want %q, %v", resp.Code, unmet, errCodeWeakPassword, tt.unmet)
+			}
+		})
+	}
+}
+
+func TestBcryptCostFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		cost    int
+		wantErr bool
+	}{
+		{"", bcrypt.DefaultCost, false},
+		{"4", bcrypt.MinCost, false},
+		{"12", 12, false},
+		{"31", bcrypt.MaxCost, false},
+		{"3", 0, true},
+		{"32", 0, true},
+		{"high", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run("BCRYPT_COST="+tt.value, func(t *testing.T) {
+			t.Setenv("BCRYPT_COST", tt.value)
+			p, err := passwordPolicyFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && p.cost != tt.cost {
+				t.Errorf("cost = %d, want %d", p.cost, tt.cost)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	p := passwordPolicy{cost: bcrypt.MinCost + 1}
+	tests := []struct {
+		name string
+		cost int
+		want bool
+	}{
+		{"cheaper", bcrypt.MinCost, true},
+		{"same", bcrypt.MinCost + 1, false},
+		{"dearer", bcrypt.MinCost + 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), tt.cost)
+			if got := p.needsRehash(string(hash)); got != tt.want {
+				t.Errorf("needsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if p.needsRehash("not a hash") {
+		t.Error("needsRehash of a malformed hash = true, want false")
+	}
+}
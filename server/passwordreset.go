@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"omnicall/db"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long a reset link stays usable.
+const passwordResetTTL = time.Hour
+
+// errResetTokenSpent is a reset token another request used first, or one
+// that expired since it was looked up.
+var errResetTokenSpent = errors.New("reset token already used")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// hashToken is what password_resets and email_verifications store, so a
+// leaked table can't be used to take over anyone's account.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// forgotPassword issues a single-use reset token. The response is the same
+// whether or not the email belongs to anyone, so it can't be used to find
+// out who has an account.
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := s.queries.GetUserByEmail(r.Context(), req.Email)
+	if err == nil && !user.DeletedAt.Valid {
+		token := generateSessionID()
+		if err := s.queries.CreatePasswordReset(r.Context(), db.CreatePasswordResetParams{
+			TokenHash: hashToken(token),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(passwordResetTTL).UTC(),
+		}); err != nil {
+			logErrorf(r.Context(), "Error creating password reset for user %d: %v", user.ID, err)
+		} else {
+			// The token would let anyone who reads the logs take over the
+			// account, so it's only ever sent to the user
+			logInfof(r.Context(), "🔑 Password reset requested for user %d", user.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// resetPassword sets a new password with a reset token. The token is spent
+// in the same transaction, so it works once even when two requests race,
+// and every session the user had is ended.
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if unmet := validatePassword(s.passwords, req.Password); len(unmet) > 0 {
+		respondWeakPassword(w, "password", unmet)
+		return
+	}
+
+	tokenHash := hashToken(req.Token)
+	reset, err := s.queries.GetPasswordReset(r.Context(), tokenHash)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	user, err := s.queries.GetUserByID(r.Context(), reset.UserID)
+	if err != nil || user.DeletedAt.Valid {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	hashedPassword, err := s.passwords.hash(req.Password)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to process password")
+		return
+	}
+
+	// The token is only spent along with the password change, so a failure
+	// part way leaves it usable rather than the account half reset
+	err = s.withTx(r.Context(), func(q *db.Queries) error {
+		consumed, err := q.ConsumePasswordReset(r.Context(), db.ConsumePasswordResetParams{
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		if consumed == 0 {
+			return errResetTokenSpent
+		}
+		if err := q.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+			PasswordHash: string(hashedPassword),
+			ID:           user.ID,
+		}); err != nil {
+			return err
+		}
+		if err := q.DeleteSessionsByUser(r.Context(), user.ID); err != nil {
+			return err
+		}
+		// Proving control of the email is enough to lift a lockout
+		return q.ResetFailedLogins(r.Context(), user.ID)
+	})
+	if errors.Is(err, errResetTokenSpent) {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error resetting password for user %d: %v", user.ID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	logInfof(r.Context(), "🔑 Password reset for %s", user.Email)
+	s.audit(r.Context(), &user, auditPasswordReset, user.AgentID, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// changePassword sets a new password for the signed-in user, who has to know
+// the current one. Every other session is ended, so one that was stolen
+// doesn't outlive the change.
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if unmet := validatePassword(s.passwords, req.NewPassword); len(unmet) > 0 {
+		respondWeakPassword(w, "new_password", unmet)
+		return
+	}
+
+	// Wrong guesses count towards a lockout, as they would at login
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		s.recordFailedLogin(r.Context(), user)
+		respondError(w, http.StatusBadRequest, "Current password is incorrect")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		respondError(w, http.StatusBadRequest, "New password must differ from the current one")
+		return
+	}
+
+	hashedPassword, err := s.passwords.hash(req.NewPassword)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to process password")
+		return
+	}
+	if err := s.queries.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+		PasswordHash: string(hashedPassword),
+		ID:           user.ID,
+	}); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	current, _ := s.cookie.read(r)
+	if _, err := s.queries.DeleteOtherSessions(r.Context(), db.DeleteOtherSessionsParams{
+		UserID: user.ID,
+		ID:     current,
+	}); err != nil {
+		logErrorf(r.Context(), "Error ending other sessions for user %d: %v", user.ID, err)
+	}
+
+	logInfof(r.Context(), "🔑 Password changed for %s", user.Email)
+	s.audit(r.Context(), user, auditPasswordChange, user.AgentID, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
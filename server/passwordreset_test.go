@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"omnicall/db"
+)
+
+func TestForgotPasswordKeepsTokenOutOfLogs(t *testing.T) {
+	s := newTestServer(t)
+	user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+	logs := captureLogs(t)
+
+	w := httptest.NewRecorder()
+	s.forgotPassword(w, httptest.NewRequest(http.MethodPost, "/api/auth/forgot-password", strings.NewReader(`{"email":"`+user.Email+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resets int
+	s.db.QueryRow("SELECT COUNT(*) FROM password_resets WHERE user_id = ?", user.ID).Scan(&resets)
+	if resets != 1 {
+		t.Fatalf("%d reset tokens issued, want 1", resets)
+	}
+	for _, secret := range []string{"token=", user.Email} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("logs give away %q:\n%s", secret, logs)
+		}
+	}
+}
+
+func TestResetPasswordIsAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name   string
+		broken bool // ending the user's sessions fails
+		status int
+	}{
+		{"succeeds", false, http.StatusOK},
+		{"fails part way", true, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+			exec(t, s, "UPDATE users SET failed_login_attempts = 3 WHERE id = ?", user.ID)
+			signIn(t, s, user)
+			if err := s.queries.CreatePasswordReset(t.Context(), db.CreatePasswordResetParams{
+				TokenHash: hashToken("reset-token"),
+				UserID:    user.ID,
+				ExpiresAt: time.Now().Add(time.Hour).UTC(),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.broken {
+				exec(t, s, "CREATE TRIGGER fail_session_delete BEFORE DELETE ON sessions BEGIN SELECT RAISE(ABORT, 'sessions unavailable'); END")
+			}
+
+			w := httptest.NewRecorder()
+			s.resetPassword(w, httptest.NewRequest(http.MethodPost, "/api/auth/reset-password", strings.NewReader(`{"token":"reset-token","password":"new password"}`)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			after, err := s.queries.GetUserByID(t.Context(), user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed := bcrypt.CompareHashAndPassword([]byte(after.PasswordHash), []byte("new password")) == nil
+			var sessions, unspent int
+			s.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", user.ID).Scan(&sessions)
+			s.db.QueryRow("SELECT COUNT(*) FROM password_resets WHERE used_at IS NULL").Scan(&unspent)
+
+			// Either every write happened or none did
+			done := !tt.broken
+			if changed != done || (sessions == 0) != done || (after.FailedLoginAttempts == 0) != done || (unspent == 0) != done {
+				t.Errorf("password changed = %v, sessions = %d, failed logins = %d, unspent tokens = %d, want all done = %v",
+					changed, sessions, after.FailedLoginAttempts, unspent, done)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// unknownPhoneRegion is libphonenumber's region for numbers whose country
+// isn't known.
+const unknownPhoneRegion = "ZZ"
+
+// defaultPhoneRegion is the region numbers without a country code are read
+// in, from DEFAULT_PHONE_REGION. Empty means they aren't converted at all.
+func defaultPhoneRegion() string {
+	return strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_PHONE_REGION")))
+}
+
+// validPhoneRegion reports whether DEFAULT_PHONE_REGION may name region, an
+// ISO 3166 code.
+func validPhoneRegion(region string) bool {
+	return phonenumbers.GetSupportedRegions()[region]
+}
+
+// toE164 converts a number as someone might write it to E.164. Numbers that
+// start with + or an international prefix carry their country code; others
+// are read as national numbers of defaultRegion, which may also repeat the
+// country code. It reports false when the number isn't one that can be
+// dialed.
+func toE164(raw, defaultRegion string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if defaultRegion == "" {
+		// Without a region only numbers carrying their country code can
+		// be read
+		defaultRegion = unknownPhoneRegion
+	}
+	number, err := phonenumbers.Parse(raw, defaultRegion)
+	if (err != nil || !phonenumbers.IsValidNumber(number)) && strings.HasPrefix(raw, "00") {
+		// 00 is the international prefix nearly everywhere, even where the
+		// region's own is different
+		number, err = phonenumbers.Parse("+"+raw[2:], defaultRegion)
+	}
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return "", false
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), true
+}
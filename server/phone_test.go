@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestToE164(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		region string
+		want   string
+		ok     bool
+	}{
+		{"international", "+27 71 123 4567", "", "+27711234567", true},
+		{"national", "071 123 4567", "ZA", "+27711234567", true},
+		{"national with the country code", "27 71 123 4567", "ZA", "+27711234567", true},
+		{"region's international prefix", "0027 71 123 4567", "ZA", "+27711234567", true},
+		{"00 without a region", "0027 71 123 4567", "", "+27711234567", true},
+		{"00 where the prefix is 011", "00 44 121 234 5678", "US", "+441212345678", true},
+		{"011 from the US", "011 44 121 234 5678", "US", "+441212345678", true},
+		{"NANP", "(201) 555-0123", "US", "+12015550123", true},
+		{"national without a region", "071 123 4567", "", "", false},
+		{"too short", "12345", "ZA", "", false},
+		{"not a number", "call me", "ZA", "", false},
+		{"empty", "", "ZA", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := toE164(tt.raw, tt.region)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("toE164(%q, %q) = %q, %v; want %q, %v", tt.raw, tt.region, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		region string
+		raw    string
+		want   string
+	}{
+		{"ZA", "071 123 4567", "+27711234567"},
+		{"ZA", "+27 (71) 123-4567", "+27711234567"},
+		// Numbers that can't be read keep their digits
+		{"ZA", "ext. 1234", "1234"},
+		{"", "071 123 4567", "0711234567"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region+" "+tt.raw, func(t *testing.T) {
+			t.Setenv("DEFAULT_PHONE_REGION", tt.region)
+			if got := normalizePhoneNumber(tt.raw); got != tt.want {
+				t.Errorf("normalizePhoneNumber(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
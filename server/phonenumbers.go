@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// How calls to a company number are handled
+const (
+	routingAgents       = "agents"
+	routingAnnouncement = "announcement"
+)
+
+type PhoneNumberRequest struct {
+	Phone        string `json:"phone"`
+	Label        string `json:"label"`
+	RoutingType  string `json:"routing_type"`
+	Announcement string `json:"announcement"`
+}
+
+type PhoneNumbersResponse struct {
+	Success      bool             `json:"success"`
+	PhoneNumbers []db.PhoneNumber `json:"phone_numbers"`
+}
+
+type PhoneNumberResponse struct {
+	Success     bool            `json:"success"`
+	PhoneNumber *db.PhoneNumber `json:"phone_number,omitempty"`
+}
+
+// validatePhoneNumberRouting checks the routing type and fills in the
+// default. Announcement numbers need something to say.
+func validatePhoneNumberRouting(req *PhoneNumberRequest) error {
+	req.Announcement = strings.TrimSpace(req.Announcement)
+	switch req.RoutingType {
+	case "":
+		req.RoutingType = routingAgents
+	case routingAgents:
+	case routingAnnouncement:
+		if req.Announcement == "" {
+			return errors.New("Announcement numbers need a message")
+		}
+	default:
+		return fmt.Errorf("Routing type must be '%s' or '%s'", routingAgents, routingAnnouncement)
+	}
+	return nil
+}
+
+// defaultCompanyFromEnv reads DEFAULT_COMPANY_ID, the company whose calls
+// come in on numbers nobody has registered. Unset, those calls are rejected.
+func defaultCompanyFromEnv(ctx context.Context, queries *db.Queries) (int64, error) {
+	value := os.Getenv("DEFAULT_COMPANY_ID")
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("DEFAULT_COMPANY_ID must be a company id")
+	}
+	company, err := queries.GetCompany(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && company.DeletedAt.Valid) {
+		return 0, fmt.Errorf("DEFAULT_COMPANY_ID: no company %d", id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// numberCompany looks up a dialed number and the company it belongs to.
+// Numbers nobody has registered belong to the default company, with a nil
+// number. Both are nil when there's no default, or the company is gone.
+func (s *Server) numberCompany(ctx context.Context, phone string) (*db.Company, *db.PhoneNumber) {
+	number, err := s.queries.GetPhoneNumberByPhone(ctx, normalizePhoneNumber(phone))
+	if err == sql.ErrNoRows && s.defaultCompanyID != 0 {
+		company, err := s.queries.GetCompany(ctx, s.defaultCompanyID)
+		if err != nil || company.DeletedAt.Valid {
+			return nil, nil
+		}
+		return &company, nil
+	}
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logErrorf(ctx, "Error looking up number %s: %v", phone, err)
+		}
+		return nil, nil
+	}
+	company, err := s.queries.GetCompany(ctx, number.CompanyID)
+	if err != nil || company.DeletedAt.Valid {
+		return nil, nil
+	}
+	return &company, &number
+}
+
+// companyCallerID is the number a company's calls come from: its first
+// number, preferring ones agents answer, or TWILIO_PHONE_NUMBER when it has
+// none. A zero companyID always gets TWILIO_PHONE_NUMBER.
+func (s *Server) companyCallerID(ctx context.Context, companyID int64) string {
+	if companyID != 0 {
+		number, err := s.queries.GetCompanyCallerNumber(ctx, companyID)
+		if err == nil {
+			return number.Phone
+		}
+		if err != sql.ErrNoRows {
+			logErrorf(ctx, "Error getting caller ID for company %d: %v", companyID, err)
+		}
+	}
+	return os.Getenv("TWILIO_PHONE_NUMBER")
+}
+
+// agentCallerID is the number an agent's outbound calls come from, and why:
+// the number they've been given, else their company's caller ID, else
+// TWILIO_PHONE_NUMBER. agent is nil for callers that aren't a known agent.
+func (s *Server) agentCallerID(ctx context.Context, agent *db.User) (number, source string) {
+	if agent == nil {
+		return os.Getenv("TWILIO_PHONE_NUMBER"), "TWILIO_PHONE_NUMBER, unknown agent"
+	}
+	own, err := s.queries.GetAgentCallerNumber(ctx, agent.ID)
+	if err == nil {
+		return own.Phone, "agent's own number"
+	}
+	if err != sql.ErrNoRows {
+		logErrorf(ctx, "Error getting caller number for agent %s: %v", agent.AgentID, err)
+	}
+	company, err := s.queries.GetCompanyCallerNumber(ctx, agent.CompanyID)
+	if err == nil {
+		return company.Phone, "company number"
+	}
+	if err != sql.ErrNoRows {
+		logErrorf(ctx, "Error getting caller ID for company %d: %v", agent.CompanyID, err)
+	}
+	return os.Getenv("TWILIO_PHONE_NUMBER"), "TWILIO_PHONE_NUMBER, company has no numbers"
+}
+
+func (s *Server) getPhoneNumbers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	numbers, err := s.queries.ListPhoneNumbersByCompany(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get phone numbers")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PhoneNumbersResponse{
+		Success:      true,
+		PhoneNumbers: numbers,
+	})
+}
+
+func (s *Server) createPhoneNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req PhoneNumberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Phone) == "" {
+		respondError(w, http.StatusBadRequest, "Phone number is required")
+		return
+	}
+	phone, ok := toE164(req.Phone, defaultPhoneRegion())
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Phone number must be in E.164 format, such as +14155550123")
+		return
+	}
+	if err := validatePhoneNumberRouting(&req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	number, err := s.queries.CreatePhoneNumber(r.Context(), db.CreatePhoneNumberParams{
+		CompanyID:    user.CompanyID,
+		Phone:        phone,
+		Label:        sql.NullString{String: req.Label, Valid: req.Label != ""},
+		RoutingType:  req.RoutingType,
+		Announcement: sql.NullString{String: req.Announcement, Valid: req.Announcement != ""},
+	})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Phone number is already registered")
+		return
+	}
+
+	logInfof(r.Context(), "☎️ Added number %s (%s) for company %d", phone, req.RoutingType, user.CompanyID)
+	s.audit(r.Context(), user, auditPhoneNumberAdd, phone, req.RoutingType)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PhoneNumberResponse{
+		Success:     true,
+		PhoneNumber: &number,
+	})
+}
+
+func (s *Server) updatePhoneNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid phone number id")
+		return
+	}
+
+	var req PhoneNumberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validatePhoneNumberRouting(&req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	number, err := s.queries.UpdatePhoneNumber(r.Context(), db.UpdatePhoneNumberParams{
+		Label:        sql.NullString{String: req.Label, Valid: req.Label != ""},
+		RoutingType:  req.RoutingType,
+		Announcement: sql.NullString{String: req.Announcement, Valid: req.Announcement != ""},
+		ID:           id,
+		CompanyID:    user.CompanyID,
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Phone number not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update phone number")
+		return
+	}
+
+	s.audit(r.Context(), user, auditPhoneNumberUpdate, number.Phone, req.RoutingType)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PhoneNumberResponse{
+		Success:     true,
+		PhoneNumber: &number,
+	})
+}
+
+func (s *Server) deletePhoneNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid phone number id")
+		return
+	}
+
+	// Agents dialing out from the number go back to the company's
+	err = s.withTx(r.Context(), func(q *db.Queries) error {
+		rows, err := q.DeletePhoneNumber(r.Context(), db.DeletePhoneNumberParams{
+			ID:        id,
+			CompanyID: user.CompanyID,
+		})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return sql.ErrNoRows
+		}
+		return q.ClearCallerNumber(r.Context(), sql.NullInt64{Int64: id, Valid: true})
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Phone number not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete phone number")
+		return
+	}
+
+	s.audit(r.Context(), user, auditPhoneNumberDel, strconv.FormatInt(id, 10), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// announcementTwiML plays a number's message and hangs up without ever
+// reaching an agent.
+func announcementTwiML(message string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>%s</Say>
+	<Hangup/>
+</Response>`, html.EscapeString(message))
+}
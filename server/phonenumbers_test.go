@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAnnouncementNumbers(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	addNumber(t, s, acme, "+27110000001")
+
+	createNumber := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.createPhoneNumber(w, asUser(httptest.NewRequest(http.MethodPost, "/api/phone-numbers", strings.NewReader(body)), admin))
+		return w
+	}
+	for name, body := range map[string]string{
+		"no message":           `{"phone":"+27110000002","routing_type":"announcement","announcement":"  "}`,
+		"unknown routing type": `{"phone":"+27110000002","routing_type":"ivr"}`,
+	} {
+		if w := createNumber(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := createNumber(`{"phone":"+27110000002","routing_type":"announcement","announcement":"Our hours have changed & so has <this> line."}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	tests := []struct {
+		name  string
+		to    string
+		twiml []string // substrings the TwiML must have
+		agent string
+	}{
+		{"agents number", "+27110000001", []string{"<Dial", "acmeadmin"}, "acmeadmin"},
+		{"announcement number", "+27110000002", []string{"<Say>Our hours have changed &amp; so has &lt;this&gt; line.</Say>\n\t<Hangup/>"}, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callSID := "CA-ann-" + string(rune('a'+i))
+			w := httptest.NewRecorder()
+			s.handleIncomingCall(w, twilioWebhook("/twilio/incoming-call", url.Values{
+				"CallSid": {callSID}, "From": {"+27825550001"}, "To": {tt.to}, "CallStatus": {"ringing"},
+			}))
+			body := w.Body.String()
+			for _, want := range tt.twiml {
+				if !strings.Contains(body, want) {
+					t.Errorf("TwiML lacks %q: %s", want, body)
+				}
+			}
+			if tt.agent == "" && strings.Contains(body, "<Dial") {
+				t.Errorf("announcement dialed someone: %s", body)
+			}
+
+			call, err := s.queries.GetCallBySid(t.Context(), callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.AgentID.String != tt.agent {
+				t.Errorf("call handed to %q, want %q", call.AgentID.String, tt.agent)
+			}
+		})
+	}
+}
@@ -1,54 +1,254 @@
 -- name: GetCompany :one
-SELECT * FROM companies WHERE id = ?;
+SELECT * FROM companies WHERE id = ? AND deleted_at IS NULL;
 
--- name: GetAllCompanies :many
-SELECT * FROM companies ORDER BY created_at DESC;
+-- name: ListCompaniesPaginated :many
+-- search matches anywhere in the name, ignoring case; empty matches all.
+-- include_deleted only brings back the caller's own company.
+SELECT * FROM companies
+WHERE (deleted_at IS NULL OR (CAST(sqlc.arg('include_deleted') AS BOOLEAN) AND id = sqlc.arg('company_id')))
+    AND (sqlc.arg('search') = '' OR instr(lower(name), lower(sqlc.arg('search'))) > 0)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountCompanies :one
+SELECT COUNT(*) FROM companies
+WHERE (deleted_at IS NULL OR (CAST(sqlc.arg('include_deleted') AS BOOLEAN) AND id = sqlc.arg('company_id')))
+    AND (sqlc.arg('search') = '' OR instr(lower(name), lower(sqlc.arg('search'))) > 0);
 
 -- name: CreateCompany :one
 INSERT INTO companies (name) VALUES (?) RETURNING *;
 
+-- name: EnsureCompany :exec
+INSERT OR IGNORE INTO companies (name) VALUES (?);
+
+-- name: SoftDeleteCompany :execrows
+UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL;
+
+-- name: RestoreCompany :execrows
+UPDATE companies SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL;
+
+-- name: UpdateCompany :one
+UPDATE companies SET name = ? WHERE id = ? AND deleted_at IS NULL RETURNING *;
+
+-- name: CountUsersByCompany :one
+SELECT COUNT(*) FROM users
+WHERE company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+    AND (sqlc.arg('search') = ''
+        OR instr(lower(firstname || ' ' || lastname), lower(sqlc.arg('search'))) > 0
+        OR instr(lower(email), lower(sqlc.arg('search'))) > 0);
+
+-- name: ListUsersByCompany :many
+-- Only what admins need to see, so the password hash never leaves the table
+SELECT id, email, firstname, lastname, agent_id, role, caller_number_id, created_at FROM users
+WHERE company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+    AND (sqlc.arg('search') = ''
+        OR instr(lower(firstname || ' ' || lastname), lower(sqlc.arg('search'))) > 0
+        OR instr(lower(email), lower(sqlc.arg('search'))) > 0)
+ORDER BY lastname, firstname, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountOtherUsersByCompany :one
+SELECT COUNT(*) FROM users WHERE company_id = ? AND id != ? AND deleted_at IS NULL;
+
 -- name: GetUserByID :one
-SELECT * FROM users WHERE id = ?;
+-- Users of a deleted company are gone along with it
+SELECT u.* FROM users u
+JOIN companies c ON c.id = u.company_id
+WHERE u.id = ? AND u.deleted_at IS NULL AND c.deleted_at IS NULL;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = ?;
+-- Users of a deleted company are gone along with it
+SELECT u.* FROM users u
+JOIN companies c ON c.id = u.company_id
+WHERE u.email = ? AND u.deleted_at IS NULL AND c.deleted_at IS NULL;
 
 -- name: GetUserByAgentID :one
+-- Includes deleted users: agent ids are never handed out twice
 SELECT * FROM users WHERE agent_id = ?;
 
 -- name: CreateUser :one
-INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id)
-VALUES (?, ?, ?, ?, ?, ?) RETURNING *;
+INSERT INTO users (email, password_hash, firstname, lastname, agent_id, company_id, email_verified, role)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: SoftDeleteUser :execrows
+UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: RestoreUser :execrows
+UPDATE users SET deleted_at = NULL WHERE id = ? AND company_id = ? AND deleted_at IS NOT NULL;
 
 -- name: GetSession :one
 SELECT * FROM sessions WHERE id = ?;
 
 -- name: CreateSession :one
-INSERT INTO sessions (id, user_id, expires_at)
-VALUES (?, ?, ?) RETURNING *;
+INSERT INTO sessions (id, user_id, expires_at, user_agent, ip_address)
+VALUES (?, ?, ?, ?, ?) RETURNING *;
+
+-- name: ListSessionsByUser :many
+SELECT * FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC;
+
+-- name: UpdateSessionExpiry :execrows
+-- Guarded by the expiry the caller read, so a session is extended once
+-- however many requests race to do it
+UPDATE sessions SET expires_at = sqlc.arg('new_expires_at')
+WHERE id = sqlc.arg('id') AND expires_at = sqlc.arg('expires_at');
 
 -- name: DeleteSession :exec
 DELETE FROM sessions WHERE id = ?;
 
+-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions WHERE user_id = ?;
+
+-- name: DeleteUserSession :execrows
+DELETE FROM sessions WHERE id = ? AND user_id = ?;
+
+-- name: DeleteOtherSessions :execrows
+DELETE FROM sessions WHERE user_id = ? AND id != ?;
+
+-- name: MarkUserEmailVerified :exec
+UPDATE users SET email_verified = 1 WHERE id = ?;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = ? WHERE id = ?;
+
+-- name: IncrementFailedLogins :one
+-- Counts a failed password. The failure that reaches max_attempts locks the
+-- account until locked_until and starts the count over.
+UPDATE users SET
+    failed_login_attempts = CASE WHEN failed_login_attempts + 1 >= sqlc.arg('max_attempts') THEN 0 ELSE failed_login_attempts + 1 END,
+    locked_until = CASE WHEN failed_login_attempts + 1 >= sqlc.arg('max_attempts') THEN sqlc.arg('locked_until') ELSE locked_until END
+WHERE id = sqlc.arg('id')
+RETURNING locked_until;
+
+-- name: ResetFailedLogins :exec
+UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = ?;
+
+-- name: SetUserCallerNumber :execrows
+UPDATE users SET caller_number_id = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: SetUserRole :execrows
+UPDATE users SET role = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: ClearCallerNumber :exec
+UPDATE users SET caller_number_id = NULL WHERE caller_number_id = ?;
+
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?);
+
+-- name: GetPasswordReset :one
+SELECT * FROM password_resets WHERE token_hash = ?;
+
+-- name: ConsumePasswordReset :execrows
+UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?;
+
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES (?, ?, ?);
+
+-- name: ConsumeEmailVerification :one
+UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+RETURNING user_id;
+
 -- -----------------------
 -- Customer Queries
 -- -----------------------
 
 -- name: GetCustomerByID :one
-SELECT * FROM customers WHERE id = ?;
+SELECT * FROM customers WHERE id = ? AND deleted_at IS NULL;
 
 -- name: GetCustomerByEmail :one
-SELECT * FROM customers WHERE email = ?;
-
--- name: GetCustomerByPhone :one
-SELECT * FROM customers WHERE phone = ?;
+SELECT * FROM customers WHERE email = ? AND deleted_at IS NULL;
 
 -- name: GetAllCustomers :many
-SELECT * FROM customers ORDER BY created_at DESC;
+SELECT * FROM customers WHERE deleted_at IS NULL ORDER BY created_at DESC;
+
+-- name: GetCustomerByPhoneAndCompany :one
+SELECT * FROM customers WHERE phone = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: GetCustomersByCompany :many
+SELECT * FROM customers WHERE company_id = ? AND deleted_at IS NULL ORDER BY created_at DESC;
+
+-- name: GetCustomerByNormalizedPhone :one
+SELECT * FROM customers
+WHERE phone_normalized = ? AND company_id = ?
+  AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
 
 -- name: CreateCustomer :one
-INSERT INTO customers (company_id, first_name, last_name, email, phone, medical_aid_provider, medical_aid_number, medical_plan)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+INSERT INTO customers (company_id, first_name, last_name, email, phone, phone_normalized, medical_aid_provider, medical_aid_number, medical_plan, notes)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: SetCustomerVip :execrows
+UPDATE customers SET is_vip = ? WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: SoftDeleteCustomer :execrows
+UPDATE customers SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: RestoreCustomer :execrows
+UPDATE customers SET deleted_at = NULL WHERE id = ? AND company_id = ? AND deleted_at IS NOT NULL;
+
+-- name: GetCompanyCustomer :one
+SELECT * FROM customers WHERE id = ? AND company_id = ? AND deleted_at IS NULL;
+
+-- name: GetCompanyCustomerWithDeleted :one
+-- For following old calls and messages to a customer who has since been deleted
+SELECT * FROM customers WHERE id = ? AND company_id = ?;
+
+-- name: CountCustomersByCompany :one
+SELECT COUNT(*) FROM customers
+WHERE company_id = sqlc.arg('company_id') AND (deleted_at IS NULL OR CAST(sqlc.arg('include_deleted') AS BOOLEAN));
+
+-- name: ListCustomersByCompany :many
+SELECT * FROM customers
+WHERE company_id = sqlc.arg('company_id') AND (deleted_at IS NULL OR CAST(sqlc.arg('include_deleted') AS BOOLEAN))
+ORDER BY last_name, first_name, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountCustomerSearch :one
+SELECT COUNT(*) FROM customers
+WHERE company_id = sqlc.arg('company_id') AND (deleted_at IS NULL OR CAST(sqlc.arg('include_deleted') AS BOOLEAN))
+  AND (first_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR last_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR first_name || ' ' || last_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR email LIKE sqlc.arg('contains') ESCAPE '\'
+    OR phone LIKE sqlc.arg('contains') ESCAPE '\'
+    OR phone_normalized = sqlc.arg('phone'));
+
+-- name: SearchCustomers :many
+-- Exact matches come first, then prefixes, then anything containing the term
+SELECT * FROM customers
+WHERE company_id = sqlc.arg('company_id') AND (deleted_at IS NULL OR CAST(sqlc.arg('include_deleted') AS BOOLEAN))
+  AND (first_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR last_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR first_name || ' ' || last_name LIKE sqlc.arg('contains') ESCAPE '\'
+    OR email LIKE sqlc.arg('contains') ESCAPE '\'
+    OR phone LIKE sqlc.arg('contains') ESCAPE '\'
+    OR phone_normalized = sqlc.arg('phone'))
+ORDER BY
+  CASE
+    WHEN first_name = sqlc.arg('term') COLLATE NOCASE
+      OR last_name = sqlc.arg('term') COLLATE NOCASE
+      OR first_name || ' ' || last_name = sqlc.arg('term') COLLATE NOCASE
+      OR email = sqlc.arg('term') COLLATE NOCASE
+      OR phone = sqlc.arg('term')
+      OR phone_normalized = sqlc.arg('phone') THEN 0
+    WHEN first_name LIKE sqlc.arg('prefix') ESCAPE '\'
+      OR last_name LIKE sqlc.arg('prefix') ESCAPE '\'
+      OR email LIKE sqlc.arg('prefix') ESCAPE '\'
+      OR phone LIKE sqlc.arg('prefix') ESCAPE '\' THEN 1
+    ELSE 2
+  END,
+  last_name, first_name, id
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: UpdateCustomer :one
+UPDATE customers
+SET first_name = ?, last_name = ?, email = ?, phone = ?, phone_normalized = ?,
+    medical_aid_provider = ?, medical_aid_number = ?, medical_plan = ?, notes = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND company_id = ? AND deleted_at IS NULL
+RETURNING *;
 
 -- -----------------------
 -- Customer Premium Queries
@@ -60,3 +260,618 @@ SELECT * FROM customer_premiums WHERE customer_id = ? ORDER BY effective_date DE
 -- name: CreateCustomerPremium :one
 INSERT INTO customer_premiums (customer_id, premium_amount, effective_date)
 VALUES (?, ?, ?) RETURNING *;
+
+-- -----------------------
+-- Blocked Number Queries
+-- -----------------------
+
+-- name: GetBlockedNumberByPhone :one
+SELECT * FROM blocked_numbers WHERE company_id = ? AND phone = ?;
+
+-- name: ListBlockedNumbersByCompany :many
+SELECT * FROM blocked_numbers WHERE company_id = ? ORDER BY created_at DESC;
+
+-- name: CreateBlockedNumber :one
+INSERT INTO blocked_numbers (company_id, phone, reason)
+VALUES (?, ?, ?) RETURNING *;
+
+-- name: DeleteBlockedNumber :execrows
+DELETE FROM blocked_numbers WHERE id = ? AND company_id = ?;
+
+-- -----------------------
+-- Call Reference Queries
+-- -----------------------
+
+-- name: GetCallRef :one
+SELECT * FROM call_refs WHERE id = ?;
+
+-- name: GetCompanyCallRef :one
+SELECT * FROM call_refs WHERE id = ? AND company_id = ?;
+
+-- name: GetCompanyCallRefBySid :one
+SELECT * FROM call_refs WHERE call_sid = ? AND company_id = ?;
+
+-- name: UpsertCallRef :one
+-- A call's company is settled by whichever webhook learns it first
+INSERT INTO call_refs (id, call_sid, company_id) VALUES (?, ?, ?)
+ON CONFLICT (call_sid) DO UPDATE SET company_id = COALESCE(call_refs.company_id, excluded.company_id)
+RETURNING *;
+
+-- name: SetCallNameRecording :exec
+UPDATE call_refs SET name_recording_url = ? WHERE id = ?;
+
+-- -----------------------
+-- Call Transcription Queries
+-- -----------------------
+
+-- name: ListCallTranscriptionsBySidAndCompany :many
+SELECT ct.* FROM call_transcriptions ct
+JOIN customers c ON c.id = ct.customer_id
+WHERE ct.call_sid = ? AND c.company_id = ?
+ORDER BY ct.created_at ASC;
+
+-- -----------------------
+-- Company Setting Queries
+-- -----------------------
+
+-- name: GetCompanySetting :one
+SELECT value FROM company_settings WHERE company_id = ? AND key = ?;
+
+-- name: ListCompanySettings :many
+SELECT * FROM company_settings WHERE company_id = ? ORDER BY key;
+
+-- name: UpsertCompanySetting :one
+INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)
+ON CONFLICT (company_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: ListCompanySettingsByKey :many
+SELECT * FROM company_settings WHERE key = ?;
+
+-- -----------------------
+-- App Setting Queries
+-- -----------------------
+
+-- name: GetAppSetting :one
+SELECT value FROM app_settings WHERE key = ?;
+
+-- name: UpsertAppSetting :exec
+INSERT INTO app_settings (key, value) VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP;
+
+-- -----------------------
+-- Routing Queries
+-- -----------------------
+
+-- Only online agents are routed to, and those on do-not-disturb or in
+-- wrap-up are skipped until that runs out
+
+-- name: ListAgentIDsByCompany :many
+SELECT u.agent_id FROM users u
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL AND s.presence = 'online'
+  AND (s.dnd_until IS NULL OR datetime(s.dnd_until) <= datetime('now'))
+  AND (s.wrap_up_until IS NULL OR datetime(s.wrap_up_until) <= datetime('now'))
+ORDER BY u.id;
+
+-- name: ListAgentIDsByIdleTimeAndCompany :many
+SELECT u.agent_id FROM users u
+LEFT JOIN agent_activity a ON a.agent_id = u.agent_id
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL AND s.presence = 'online'
+  AND (s.dnd_until IS NULL OR datetime(s.dnd_until) <= datetime('now'))
+  AND (s.wrap_up_until IS NULL OR datetime(s.wrap_up_until) <= datetime('now'))
+ORDER BY a.last_call_at IS NOT NULL, a.last_call_at, u.id;
+
+-- name: RecordAgentCall :exec
+INSERT INTO agent_activity (agent_id, last_call_at) VALUES (?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET last_call_at = excluded.last_call_at;
+
+-- -----------------------
+-- Voicemail Queries
+-- -----------------------
+
+-- name: GetVoicemail :one
+SELECT * FROM voicemails WHERE id = ? AND company_id = ?;
+
+-- name: GetLatestVoicemailByCustomer :one
+SELECT * FROM voicemails
+WHERE customer_id = ? AND company_id = ?
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
+
+-- name: ListVoicemailsForCleanup :many
+SELECT * FROM voicemails
+WHERE company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+  AND (sqlc.narg('before') IS NULL OR created_at < sqlc.narg('before'))
+  AND (sqlc.narg('customer_id') IS NULL OR customer_id = sqlc.narg('customer_id'))
+  AND (sqlc.narg('from_number') IS NULL OR from_number = sqlc.narg('from_number'))
+ORDER BY created_at, id;
+
+-- name: MarkVoicemailDeleted :exec
+UPDATE voicemails SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND company_id = ?;
+
+-- name: CreateVoicemail :one
+INSERT INTO voicemails (company_id, customer_id, call_sid, from_number, recording_url, duration)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: ListVoicemailsByCompany :many
+SELECT * FROM voicemails
+WHERE company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+  AND (NOT sqlc.arg('unread_only') OR read_at IS NULL)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountVoicemailsByCompany :one
+SELECT COUNT(*) FROM voicemails
+WHERE company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+  AND (NOT sqlc.arg('unread_only') OR read_at IS NULL);
+
+-- name: SetVoicemailRead :one
+-- Marking a voicemail read again keeps when it was first read
+UPDATE voicemails
+SET read_at = CASE WHEN sqlc.arg('read') THEN COALESCE(read_at, CURRENT_TIMESTAMP) END
+WHERE id = sqlc.arg('id') AND company_id = sqlc.arg('company_id') AND deleted_at IS NULL
+RETURNING *;
+
+-- -----------------------
+-- Audit Log Queries
+-- -----------------------
+
+-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (company_id, actor, action, target, details)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: ListAuditEntries :many
+SELECT * FROM audit_log
+WHERE company_id = sqlc.arg('company_id')
+  AND (sqlc.narg('actor') IS NULL OR actor = sqlc.narg('actor'))
+  AND (sqlc.narg('action') IS NULL OR action = sqlc.narg('action'))
+  AND (sqlc.narg('since') IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountAuditEntries :one
+SELECT COUNT(*) FROM audit_log
+WHERE company_id = sqlc.arg('company_id')
+  AND (sqlc.narg('actor') IS NULL OR actor = sqlc.narg('actor'))
+  AND (sqlc.narg('action') IS NULL OR action = sqlc.narg('action'))
+  AND (sqlc.narg('since') IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR created_at < sqlc.narg('until'));
+
+-- -----------------------
+-- Queue Queries
+-- -----------------------
+
+-- name: CreateQueueEntry :exec
+INSERT OR IGNORE INTO queue_entries (company_id, call_sid, phone) VALUES (?, ?, ?);
+
+-- name: GetQueueEntryBySid :one
+SELECT * FROM queue_entries WHERE call_sid = ?;
+
+-- name: RequestQueueCallback :execrows
+UPDATE queue_entries SET status = 'callback', position = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status IN ('waiting', 'declined');
+
+-- name: DeclineQueueCallback :exec
+UPDATE queue_entries SET status = 'declined', updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status = 'waiting';
+
+-- name: LeaveQueue :exec
+UPDATE queue_entries SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status IN ('waiting', 'declined');
+
+-- name: NextQueueEntry :one
+SELECT * FROM queue_entries
+WHERE company_id = ? AND status IN ('waiting', 'declined', 'callback')
+ORDER BY queued_at, id
+LIMIT 1;
+
+-- name: ClaimQueueCallback :execrows
+UPDATE queue_entries SET status = 'connected', updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status = 'callback';
+
+-- name: ReleaseQueueCallback :exec
+-- Puts back a callback that couldn't be placed, keeping its place in line
+UPDATE queue_entries SET status = 'callback', updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status = 'connected';
+
+-- name: ListQueueCallbackCompanies :many
+SELECT DISTINCT company_id FROM queue_entries
+WHERE status = 'callback' AND company_id IS NOT NULL
+ORDER BY company_id;
+
+-- -----------------------
+-- Agent Status Queries
+-- -----------------------
+
+-- name: GetAgentStatus :one
+SELECT * FROM agent_status WHERE agent_id = ?;
+
+-- name: SetAgentDnd :one
+INSERT INTO agent_status (agent_id, dnd_until) VALUES (?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET dnd_until = excluded.dnd_until, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: SetAgentWrapUp :exec
+INSERT INTO agent_status (agent_id, wrap_up_call_sid, wrap_up_until) VALUES (?, ?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET wrap_up_call_sid = excluded.wrap_up_call_sid, wrap_up_until = excluded.wrap_up_until, updated_at = CURRENT_TIMESTAMP;
+
+-- name: ClearAgentWrapUp :execrows
+UPDATE agent_status SET wrap_up_call_sid = NULL, wrap_up_until = NULL
+WHERE agent_id = ? AND wrap_up_until IS NOT NULL;
+
+-- name: ListAgentStatusesByCompany :many
+SELECT u.agent_id, s.dnd_until, s.updated_at, s.wrap_up_call_sid, s.wrap_up_until, s.presence, s.last_seen_at
+FROM users u
+LEFT JOIN agent_status s ON s.agent_id = u.agent_id
+WHERE u.company_id = ? AND u.deleted_at IS NULL
+ORDER BY u.id;
+
+-- name: SetAgentPresence :one
+INSERT INTO agent_status (agent_id, presence, last_seen_at) VALUES (?, ?, ?)
+ON CONFLICT (agent_id) DO UPDATE SET presence = excluded.presence, last_seen_at = excluded.last_seen_at, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: TouchAgentPresence :execrows
+UPDATE agent_status SET last_seen_at = ?
+WHERE agent_id = ? AND presence != 'offline';
+
+-- name: SwitchAgentPresence :execrows
+-- Calls only move agents between online and busy, so someone who went away
+-- mid-call stays away
+UPDATE agent_status SET presence = sqlc.arg(presence), updated_at = CURRENT_TIMESTAMP
+WHERE agent_id = sqlc.arg(agent_id) AND presence = sqlc.arg(current_presence);
+
+-- name: ExpireAgentPresence :execrows
+-- Agents whose browser stopped sending heartbeats are taken offline
+UPDATE agent_status SET presence = 'offline', updated_at = CURRENT_TIMESTAMP
+WHERE presence != 'offline' AND (last_seen_at IS NULL OR last_seen_at < ?);
+
+-- -----------------------
+-- Phone Number Queries
+-- -----------------------
+
+-- name: GetPhoneNumberByPhone :one
+SELECT * FROM phone_numbers WHERE phone = ?;
+
+-- name: GetCompanyCallerNumber :one
+-- Numbers agents answer come first, so calling back reaches someone
+SELECT * FROM phone_numbers WHERE company_id = ?
+ORDER BY routing_type != 'agents', id
+LIMIT 1;
+
+-- name: GetCompanyPhoneNumber :one
+SELECT * FROM phone_numbers WHERE id = ? AND company_id = ?;
+
+-- name: GetAgentCallerNumber :one
+-- An agent's own number, as long as it's still one of their company's
+SELECT phone_numbers.* FROM phone_numbers
+JOIN users ON users.caller_number_id = phone_numbers.id
+WHERE users.id = ? AND phone_numbers.company_id = users.company_id;
+
+-- name: ListPhoneNumbersByCompany :many
+SELECT * FROM phone_numbers WHERE company_id = ? ORDER BY phone;
+
+-- name: CreatePhoneNumber :one
+INSERT INTO phone_numbers (company_id, phone, label, routing_type, announcement)
+VALUES (?, ?, ?, ?, ?) RETURNING *;
+
+-- name: UpdatePhoneNumber :one
+UPDATE phone_numbers SET label = ?, routing_type = ?, announcement = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND company_id = ?
+RETURNING *;
+
+-- name: DeletePhoneNumber :execrows
+DELETE FROM phone_numbers WHERE id = ? AND company_id = ?;
+
+-- -----------------------
+-- Voicemail Drop Queries
+-- -----------------------
+
+-- name: ListVoicemailDropsByAgent :many
+SELECT id, company_id, agent_id, name, token, content_type, created_at FROM voicemail_drops
+WHERE agent_id = ? AND company_id = ?
+ORDER BY name;
+
+-- name: GetVoicemailDrop :one
+SELECT id, company_id, agent_id, name, token, content_type, created_at FROM voicemail_drops
+WHERE id = ? AND agent_id = ? AND company_id = ?;
+
+-- name: GetVoicemailDropAudio :one
+SELECT content_type, audio FROM voicemail_drops WHERE token = ?;
+
+-- name: CreateVoicemailDrop :one
+INSERT INTO voicemail_drops (company_id, agent_id, name, token, content_type, audio)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, company_id, agent_id, name, token, content_type, created_at;
+
+-- name: DeleteVoicemailDrop :execrows
+DELETE FROM voicemail_drops WHERE id = ? AND agent_id = ? AND company_id = ?;
+
+-- -----------------------
+-- Call Disposition Queries
+-- -----------------------
+
+-- name: CreateCallDisposition :one
+INSERT INTO call_dispositions (company_id, agent_id, call_sid, call_ref_id, disposition, notes)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: ListCallDispositions :many
+SELECT d.id, d.agent_id, u.firstname, u.lastname, d.disposition, d.notes, d.created_at
+FROM call_dispositions d
+JOIN users u ON u.agent_id = d.agent_id
+WHERE d.call_sid = ? AND d.company_id = ?
+ORDER BY d.created_at, d.id;
+
+-- -----------------------
+-- Call Queries
+-- -----------------------
+
+-- name: CreateCall :exec
+INSERT INTO calls (call_sid, call_ref_id, direction, from_number, to_number, agent_id, company_id, status, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (call_sid) DO UPDATE SET
+    call_ref_id = COALESCE(call_ref_id, excluded.call_ref_id),
+    agent_id = COALESCE(excluded.agent_id, agent_id),
+    company_id = COALESCE(excluded.company_id, company_id);
+
+-- name: CreateCallLeg :exec
+-- A customer leg an agent dialed, kept under the call it was dialed from.
+-- Lists and reports only count the parent.
+INSERT OR IGNORE INTO calls (call_sid, call_ref_id, parent_call_sid, direction, from_number, to_number, agent_id, company_id, status, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetCallBySid :one
+SELECT * FROM calls WHERE call_sid = ?;
+
+-- name: GetCompanyCallBySid :one
+SELECT * FROM calls WHERE call_sid = ? AND company_id = ?;
+
+-- name: SetCallRecording :execrows
+UPDATE calls SET recording_sid = ?, recording_url = ?, recording_duration = ?
+WHERE call_sid = ?;
+
+-- name: CreateCallTransfer :one
+INSERT INTO call_transfers (call_id, from_agent_id, to_agent_id)
+VALUES (?, ?, ?) RETURNING *;
+
+-- name: StartCallHold :execrows
+UPDATE calls SET hold_started_at = ?, held_by = ?, held_call_sid = ?
+WHERE id = ? AND hold_started_at IS NULL;
+
+-- name: EndCallHold :one
+UPDATE calls
+SET hold_seconds = hold_seconds + ?, hold_started_at = NULL, held_by = NULL, held_call_sid = NULL
+WHERE id = ? AND hold_started_at IS NOT NULL
+RETURNING *;
+
+-- name: UpdateCallStatus :execrows
+-- Guarded by the status the caller read, so callbacks racing each other
+-- can't move a call backwards
+UPDATE calls SET status = sqlc.arg('status'),
+    ended_at = COALESCE(sqlc.narg('ended_at'), ended_at),
+    duration_seconds = COALESCE(sqlc.narg('duration_seconds'), duration_seconds)
+WHERE call_sid = sqlc.arg('call_sid') AND status = sqlc.arg('current_status');
+
+-- name: UpdateCallAmd :execrows
+UPDATE calls SET answered_by = ?, amd_outcome = ? WHERE call_sid = ?;
+
+-- name: MarkCallAnswered :exec
+-- Kept from the first answer, however often the legs report it
+UPDATE calls SET answered_at = COALESCE(answered_at, ?) WHERE call_sid = ?;
+
+-- name: CallVolumeReport :many
+-- One row per bucket and direction. Buckets are UTC days, or weeks starting
+-- on Monday. Missed calls ended without anyone answering.
+SELECT CAST(CASE WHEN CAST(sqlc.arg('group_by') AS TEXT) = 'week'
+            THEN date(started_at, 'weekday 0', '-6 days')
+            ELSE date(started_at) END AS TEXT) AS bucket,
+       direction,
+       COUNT(*) AS total,
+       COUNT(answered_at) AS answered,
+       COUNT(CASE WHEN answered_at IS NULL AND status IN ('completed', 'busy', 'no-answer', 'failed', 'canceled') THEN 1 END) AS missed,
+       CAST(COALESCE(SUM(CASE WHEN answered_at IS NOT NULL THEN duration_seconds END), 0) AS INTEGER) AS answered_seconds,
+       COUNT(CASE WHEN answered_at IS NOT NULL THEN duration_seconds END) AS timed
+FROM calls
+WHERE company_id = sqlc.arg('company_id') AND parent_call_sid IS NULL
+  AND started_at >= sqlc.arg('since') AND started_at < sqlc.arg('until')
+GROUP BY bucket, direction
+ORDER BY bucket, direction;
+
+-- name: AgentPerformanceReport :many
+-- One row per agent of the company. Calls count towards the agent they were
+-- put through to, transfers towards the agent who handed the call on.
+-- Agents with nothing in the range are only included on request, and
+-- deleted agents only when they have something.
+SELECT u.id AS user_id, u.agent_id, u.firstname, u.lastname,
+       COUNT(c.id) AS total_calls,
+       COUNT(c.answered_at) AS handled,
+       CAST(COALESCE(SUM(CASE WHEN c.answered_at IS NOT NULL THEN c.duration_seconds END), 0) AS INTEGER) AS talk_seconds,
+       COUNT(CASE WHEN c.answered_at IS NOT NULL THEN c.duration_seconds END) AS timed,
+       COUNT(CASE WHEN c.answered_at IS NULL AND c.status IN ('completed', 'busy', 'no-answer', 'failed', 'canceled') THEN 1 END) AS missed,
+       (SELECT COUNT(*) FROM call_transfers t JOIN calls tc ON tc.id = t.call_id
+        WHERE t.from_agent_id = u.agent_id AND tc.company_id = u.company_id
+          AND tc.started_at >= sqlc.arg('since') AND tc.started_at < sqlc.arg('until')) AS transferred
+FROM users u
+LEFT JOIN calls c ON c.agent_id = u.agent_id AND c.company_id = u.company_id
+  AND c.parent_call_sid IS NULL
+  AND c.started_at >= sqlc.arg('since') AND c.started_at < sqlc.arg('until')
+WHERE u.company_id = sqlc.arg('company_id')
+GROUP BY u.id
+HAVING (CAST(sqlc.arg('include_inactive') AS BOOLEAN) AND u.deleted_at IS NULL)
+    OR COUNT(c.id) > 0 OR transferred > 0
+ORDER BY u.lastname, u.firstname, u.id;
+
+-- name: ListCallsByCompany :many
+SELECT * FROM calls
+WHERE company_id = sqlc.arg('company_id') AND parent_call_sid IS NULL
+  AND (sqlc.narg('agent_id') IS NULL OR agent_id = sqlc.narg('agent_id'))
+  AND (sqlc.narg('since') IS NULL OR started_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR started_at < sqlc.narg('until'))
+ORDER BY started_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountCallsByCompany :one
+SELECT COUNT(*) FROM calls
+WHERE company_id = sqlc.arg('company_id') AND parent_call_sid IS NULL
+  AND (sqlc.narg('agent_id') IS NULL OR agent_id = sqlc.narg('agent_id'))
+  AND (sqlc.narg('since') IS NULL OR started_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR started_at < sqlc.narg('until'));
+
+-- -----------------------
+-- Parked Call Queries
+-- -----------------------
+
+-- name: CreateParkedCall :one
+INSERT INTO parked_calls (company_id, code, call_sid, phone, parked_by, expires_at)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: ListParkedCalls :many
+SELECT * FROM parked_calls WHERE company_id = ? AND status = 'parked' ORDER BY created_at;
+
+-- name: RetrieveParkedCall :one
+UPDATE parked_calls SET status = 'retrieved', retrieved_by = ?, updated_at = CURRENT_TIMESTAMP
+WHERE company_id = ? AND code = ? AND status = 'parked'
+RETURNING *;
+
+-- name: EndParkedCall :one
+UPDATE parked_calls SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE call_sid = ? AND status = 'parked'
+RETURNING *;
+
+-- name: SetParkedCallStatus :exec
+UPDATE parked_calls SET status = ? WHERE id = ?;
+
+-- -----------------------
+-- Message Queries
+-- -----------------------
+
+-- name: CreateMessage :one
+INSERT INTO messages (message_sid, company_id, customer_id, direction, from_number, to_number, body, status, agent_id, error_message)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: CountMessagesByCustomer :one
+SELECT COUNT(*) FROM messages WHERE company_id = ? AND customer_id = ?;
+
+-- name: ListMessagesByCustomer :many
+SELECT * FROM messages
+WHERE company_id = ? AND customer_id = ?
+ORDER BY created_at, id
+LIMIT ? OFFSET ?;
+
+-- name: ListConversationsByCompany :many
+-- One row per customer who has texted or been texted, newest first, with
+-- the last message of the thread
+SELECT m.customer_id, c.first_name, c.last_name, c.phone,
+       m.id AS last_message_id, m.direction, m.body, m.status, m.created_at,
+       (SELECT COUNT(*) FROM messages t WHERE t.company_id = m.company_id AND t.customer_id = m.customer_id) AS message_count
+FROM messages m
+JOIN customers c ON c.id = m.customer_id
+WHERE m.company_id = ?
+  AND m.id = (SELECT MAX(l.id) FROM messages l WHERE l.company_id = m.company_id AND l.customer_id = m.customer_id)
+ORDER BY m.id DESC;
+
+-- -----------------------
+-- Conference Queries
+-- -----------------------
+
+-- name: CreateConferenceParticipant :one
+INSERT INTO conference_participants (company_id, conference_name, call_sid, agent_id, role, status)
+VALUES (?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: JoinConferenceParticipant :execrows
+UPDATE conference_participants
+SET status = 'joined', conference_sid = ?, joined_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND call_sid = ?;
+
+-- name: LeaveConferenceParticipant :execrows
+UPDATE conference_participants
+SET status = 'left', left_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND call_sid = ? AND status != 'left';
+
+-- name: EndConference :execrows
+-- Anyone still listed when the conference ends left with it
+UPDATE conference_participants
+SET status = 'left', left_at = CURRENT_TIMESTAMP
+WHERE conference_name = ? AND status != 'left';
+
+-- -----------------------
+-- Business Hours Queries
+-- -----------------------
+
+-- name: GetBusinessSchedule :one
+SELECT * FROM business_schedules WHERE company_id = ?;
+
+-- name: UpsertBusinessSchedule :one
+INSERT INTO business_schedules (company_id, timezone) VALUES (?, ?)
+ON CONFLICT (company_id) DO UPDATE SET timezone = excluded.timezone, updated_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: DeleteBusinessSchedule :exec
+DELETE FROM business_schedules WHERE company_id = ?;
+
+-- name: ListBusinessScheduleWindows :many
+SELECT * FROM business_schedule_windows WHERE company_id = ? ORDER BY day, opens;
+
+-- name: CreateBusinessScheduleWindow :exec
+INSERT INTO business_schedule_windows (company_id, day, opens, closes) VALUES (?, ?, ?, ?);
+
+-- name: DeleteBusinessScheduleWindows :exec
+DELETE FROM business_schedule_windows WHERE company_id = ?;
+
+-- name: ListBusinessHolidays :many
+SELECT * FROM business_holidays WHERE company_id = ? ORDER BY date;
+
+-- name: CreateBusinessHoliday :exec
+INSERT INTO business_holidays (company_id, date, name) VALUES (?, ?, ?);
+
+-- name: DeleteBusinessHolidays :exec
+DELETE FROM business_holidays WHERE company_id = ?;
+
+-- -----------------------
+-- Idempotency Key Queries
+-- -----------------------
+
+-- name: CreateIdempotencyKey :exec
+-- Fails on the unique key when another request already holds it
+INSERT INTO idempotency_keys (user_id, idempotency_key, method, path, request_hash, expires_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = ?, content_type = ?, response_body = ?
+WHERE user_id = ? AND idempotency_key = ?;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at <= ?;
+
+-- -----------------------
+-- Call Error Queries
+-- -----------------------
+
+-- name: CreateCallError :exec
+INSERT INTO call_errors (company_id, call_sid, error_code, error_url, from_number, to_number)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: ListCallErrors :many
+SELECT * FROM call_errors
+WHERE company_id = sqlc.arg('company_id')
+  AND (sqlc.narg('error_code') IS NULL OR error_code = sqlc.narg('error_code'))
+  AND (sqlc.narg('since') IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountCallErrors :one
+SELECT COUNT(*) FROM call_errors
+WHERE company_id = sqlc.arg('company_id')
+  AND (sqlc.narg('error_code') IS NULL OR error_code = sqlc.narg('error_code'))
+  AND (sqlc.narg('since') IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until') IS NULL OR created_at < sqlc.narg('until'));
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// callQueueName is the Twilio queue a company's unanswered callers wait in.
+// Agents take the next caller from the browser, and callers who asked to be
+// called back keep their place in line alongside the ones still holding.
+func callQueueName(companyID int64) string {
+	return "omnicall-" + strconv.FormatInt(companyID, 10)
+}
+
+// Queue entry statuses
+const (
+	queueWaiting   = "waiting"
+	queueDeclined  = "declined"
+	queueCallback  = "callback"
+	queueConnected = "connected"
+	queueLeft      = "left"
+)
+
+// handleDialComplete runs once the direct dial to an agent ends. Answered
+// calls are done and may put the agent into wrap-up; everyone else joins the
+// queue.
+func (s *Server) handleDialComplete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+
+	if r.FormValue("DialCallStatus") == "completed" {
+		s.advanceCall(r.Context(), r.FormValue("CallSid"), "completed", r.FormValue("DialCallDuration"), time.Now().UTC())
+		if agentID := r.URL.Query().Get("agent_id"); agentID != "" {
+			// Idle-based routing counts from the last call the agent took,
+			// not the last one that rang them
+			if err := s.queries.RecordAgentCall(r.Context(), db.RecordAgentCallParams{
+				AgentID:    agentID,
+				LastCallAt: time.Now(),
+			}); err != nil {
+				logErrorf(r.Context(), "Error recording agent activity: %v", err)
+			}
+			s.startWrapUp(r.Context(), agentID, r.FormValue("CallSid"))
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Hangup/>
+</Response>`))
+		return
+	}
+
+	logInfof(r.Context(), "Call %s not answered, DialCallStatus=%s", r.FormValue("CallSid"), r.FormValue("DialCallStatus"))
+
+	// An agent who let it ring out is taken to be away from the desk, so the
+	// caller leaves a message rather than waiting for them
+	if r.FormValue("DialCallStatus") == "no-answer" {
+		company, _ := s.numberCompany(r.Context(), r.FormValue("To"))
+		w.Write([]byte(s.voicemailPromptTwiML(r.Context(), company, r.FormValue("From"))))
+		return
+	}
+
+	company, _ := s.numberCompany(r.Context(), r.FormValue("To"))
+	w.Write([]byte(s.queueCallTwiML(r, companyID(company), r.FormValue("CallSid"), r.FormValue("From"))))
+}
+
+// queueCallTwiML records a caller joining companyID's queue and returns the
+// TwiML that puts them there.
+func (s *Server) queueCallTwiML(r *http.Request, companyID int64, callSID, phone string) string {
+	if err := s.queries.CreateQueueEntry(r.Context(), db.CreateQueueEntryParams{
+		CompanyID: sql.NullInt64{Int64: companyID, Valid: companyID != 0},
+		CallSid:   callSID,
+		Phone:     phone,
+	}); err != nil {
+		logErrorf(r.Context(), "Error queueing call %s: %v", callSID, err)
+	}
+
+	logInfof(r.Context(), "⏳ Call %s queued", callSID)
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>All of our agents are busy. Please hold and we will be with you shortly.</Say>
+	<Enqueue waitUrl="/twilio/queue/wait" action="/twilio/queue/leave">%s</Enqueue>
+</Response>`, callQueueName(companyID))
+}
+
+// handleQueueWait is Twilio's waitUrl. Once the wait gets long, callers are
+// offered a callback instead of holding, unless they already said no.
+func (s *Server) handleQueueWait(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	position, _ := strconv.Atoi(r.FormValue("QueuePosition"))
+	queueTime, _ := strconv.Atoi(r.FormValue("QueueTime"))
+	avgQueueTime, _ := strconv.Atoi(r.FormValue("AvgQueueTime"))
+
+	offer := false
+	if queueTime >= callbackOfferAfter() || avgQueueTime >= callbackOfferAfter() {
+		entry, err := s.queries.GetQueueEntryBySid(r.Context(), r.FormValue("CallSid"))
+		offer = err == nil && entry.Status == queueWaiting
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(queueWaitTwiML(position, offer)))
+}
+
+// handleQueueCallback records the caller's answer to the callback offer.
+func (s *Server) handleQueueCallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	w.Header().Set("Content-Type", "application/xml")
+
+	if r.FormValue("Digits") != "1" {
+		if err := s.queries.DeclineQueueCallback(r.Context(), callSID); err != nil {
+			logErrorf(r.Context(), "Error recording declined callback for %s: %v", callSID, err)
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>No problem. Please continue to hold.</Say>
+	<Redirect>/twilio/queue/wait</Redirect>
+</Response>`))
+		return
+	}
+
+	position, _ := strconv.ParseInt(r.FormValue("QueuePosition"), 10, 64)
+	rows, err := s.queries.RequestQueueCallback(r.Context(), db.RequestQueueCallbackParams{
+		Position: sql.NullInt64{Int64: position, Valid: position > 0},
+		CallSid:  callSID,
+	})
+	if err != nil || rows == 0 {
+		logErrorf(r.Context(), "Error requesting callback for %s: %v", callSID, err)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Sorry, we could not schedule a callback. Please continue to hold.</Say>
+	<Redirect>/twilio/queue/wait</Redirect>
+</Response>`))
+		return
+	}
+
+	logInfof(r.Context(), "📲 Callback requested for %s at position %d", callSID, position)
+
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Thank you. We will call you back when it is your turn. Goodbye.</Say>
+	<Hangup/>
+</Response>`))
+}
+
+// handleQueueLeave is the Enqueue action, requested when the caller leaves
+// the queue for any reason.
+func (s *Server) handleQueueLeave(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	status := queueLeft
+	if r.FormValue("QueueResult") == "bridged" {
+		status = queueConnected
+	}
+	// Callers waiting for a callback keep their place
+	if err := s.queries.LeaveQueue(r.Context(), db.LeaveQueueParams{
+		Status:  status,
+		CallSid: r.FormValue("CallSid"),
+	}); err != nil {
+		logErrorf(r.Context(), "Error updating queue entry: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	if status == queueConnected {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Hangup/>
+</Response>`))
+		return
+	}
+
+	// Callers still on the line when the queue gives up on them can leave a
+	// message instead
+	company, _ := s.numberCompany(r.Context(), r.FormValue("To"))
+	w.Write([]byte(s.voicemailPromptTwiML(r.Context(), company, r.FormValue("From"))))
+}
+
+// nextInQueueTwiML connects a free agent to whoever is next in their
+// company's line: a caller still holding, or a callback, which is originated
+// from the agent's leg with the company number as caller ID.
+func (s *Server) nextInQueueTwiML(r *http.Request, companyID int64, fromNumber string) string {
+	for {
+		entry, err := s.queries.NextQueueEntry(r.Context(), sql.NullInt64{Int64: companyID, Valid: true})
+		if err == sql.ErrNoRows {
+			return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>There are no callers waiting.</Say>
+</Response>`
+		}
+		if err != nil {
+			logErrorf(r.Context(), "Error getting next queue entry: %v", err)
+			return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Sorry, the queue is unavailable right now.</Say>
+</Response>`
+		}
+
+		if entry.Status != queueCallback {
+			return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Dial>
+		<Queue>%s</Queue>
+	</Dial>
+</Response>`, callQueueName(companyID))
+		}
+
+		// Another agent may have claimed the same callback first
+		rows, err := s.queries.ClaimQueueCallback(r.Context(), entry.ID)
+		if err != nil {
+			logErrorf(r.Context(), "Error claiming callback %d: %v", entry.ID, err)
+			continue
+		}
+		if rows == 0 {
+			continue
+		}
+
+		logInfof(r.Context(), "📲 Calling back %s for %s", entry.Phone, r.FormValue("From"))
+		agentID := strings.TrimPrefix(r.FormValue("From"), "client:")
+		return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Dial callerId="%s">
+		<Number%s>%s</Number>
+	</Dial>
+</Response>`, html.EscapeString(fromNumber), numberStatusCallback(agentID)+s.amdAttributes(r.Context(), agentID), html.EscapeString(entry.Phone))
+	}
+}
+
+// queueWaitTwiML keeps a caller company while they hold, offering a
+// callback when offer is set.
+func queueWaitTwiML(position int, offer bool) string {
+	if !offer {
+		return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>You are number %d in line.</Say>
+	<Play>http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3</Play>
+</Response>`, position)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Gather numDigits="1" timeout="5" action="/twilio/queue/callback">
+		<Say>You are number %d in line. To hang up and keep your place, press 1 and we will call you back. To keep holding, press 2.</Say>
+	</Gather>
+	<Play>http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3</Play>
+</Response>`, position)
+}
+
+// callbackOfferAfter is how long, in seconds, a wait has to be before callers
+// are offered a callback. Set QUEUE_CALLBACK_AFTER to change it.
+func callbackOfferAfter() int {
+	if seconds, err := strconv.Atoi(os.Getenv("QUEUE_CALLBACK_AFTER")); err == nil && seconds >= 0 {
+		return seconds
+	}
+	return 60
+}
+
+// queueCallbackInterval is how often callbacks are placed for free agents.
+// Set QUEUE_CALLBACK_INTERVAL to a duration such as 30s to change it.
+func queueCallbackInterval() (time.Duration, error) {
+	value := os.Getenv("QUEUE_CALLBACK_INTERVAL")
+	if value == "" {
+		return 30 * time.Second, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		return 0, errors.New("QUEUE_CALLBACK_INTERVAL must be a duration of at least 1s")
+	}
+	return interval, nil
+}
+
+// runQueueCallbacks places callbacks every interval until ctx is done.
+// baseURL is where Twilio reaches this server.
+func (s *Server) runQueueCallbacks(ctx context.Context, baseURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.placeQueueCallbacks(ctx, baseURL)
+		}
+	}
+}
+
+// placeQueueCallbacks calls back, in each company, the caller next in line
+// when they asked for a callback and an agent is free. The agent's client
+// rings first and is bridged to the caller once they answer, as with
+// click-to-call. Callers still holding ahead of them are left to agents
+// taking the next caller from the browser.
+func (s *Server) placeQueueCallbacks(ctx context.Context, baseURL string) {
+	companies, err := s.queries.ListQueueCallbackCompanies(ctx)
+	if err != nil {
+		logErrorf(ctx, "Error listing queued callbacks: %v", err)
+		return
+	}
+	for _, companyID := range companies {
+		if err := s.placeQueueCallback(ctx, baseURL, companyID.Int64); err != nil {
+			logErrorf(ctx, "Error placing callback for company %d: %v", companyID.Int64, err)
+		}
+	}
+}
+
+// placeQueueCallback places at most one callback for companyID.
+func (s *Server) placeQueueCallback(ctx context.Context, baseURL string, companyID int64) error {
+	entry, err := s.queries.NextQueueEntry(ctx, sql.NullInt64{Int64: companyID, Valid: true})
+	if err == sql.ErrNoRows || (err == nil && entry.Status != queueCallback) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	company, err := s.queries.GetCompany(ctx, companyID)
+	if err != nil {
+		return err
+	}
+
+	agentID, err := s.chooseAgent(ctx, &company, CallContext{CallSid: entry.CallSid, From: entry.Phone})
+	if err == errNoAgentAvailable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	agent, err := s.queries.GetUserByAgentID(ctx, agentID)
+	if err != nil {
+		return err
+	}
+	from, _ := s.agentCallerID(ctx, &agent)
+	if from == "" {
+		return errors.New("no phone number to call from")
+	}
+
+	// An agent taking the next caller from the browser may get there first
+	rows, err := s.queries.ClaimQueueCallback(ctx, entry.ID)
+	if err != nil || rows == 0 {
+		return err
+	}
+	call, err := s.placeAgentCall(ctx, baseURL, &agent, from, entry.Phone)
+	if err != nil {
+		if err := s.queries.ReleaseQueueCallback(ctx, entry.ID); err != nil {
+			logErrorf(ctx, "Error releasing callback %d: %v", entry.ID, err)
+		}
+		return err
+	}
+
+	logInfof(ctx, "📲 Calling back %s for %s, CallSID=%s", entry.Phone, agentID, call.CallSid)
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQueueCallTwiML(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	addNumber(t, s, acme, "+27110000001")
+	addNumber(t, s, globex, "+27110000002")
+
+	tests := []struct {
+		name    string
+		to      string
+		company int64
+	}{
+		{"acme's number", "+27110000001", acme},
+		{"globex's number", "+27110000002", globex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callSID := "CA-" + tt.to
+			form := url.Values{"CallSid": {callSID}, "From": {"+27825550000"}, "To": {tt.to}, "DialCallStatus": {"busy"}}
+			r := httptest.NewRequest(http.MethodPost, "/twilio/dial-complete", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.handleDialComplete(w, r)
+
+			if want := "<Enqueue waitUrl=\"/twilio/queue/wait\" action=\"/twilio/queue/leave\">" + callQueueName(tt.company) + "</Enqueue>"; !strings.Contains(w.Body.String(), want) {
+				t.Errorf("TwiML = %s, want %s", w.Body, want)
+			}
+			entry, err := s.queries.GetQueueEntryBySid(t.Context(), callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.CompanyID.Int64 != tt.company {
+				t.Errorf("entry company = %d, want %d", entry.CompanyID.Int64, tt.company)
+			}
+		})
+	}
+}
+
+func TestNextInQueueTwiML(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	empty := addCompany(t, s, "Initech")
+	// Acme's caller has waited longest, so a shared queue would hand them
+	// to Globex's agents first
+	exec(t, s, "INSERT INTO queue_entries (company_id, call_sid, phone, status, queued_at) VALUES (?, 'CA1', '+27825550001', 'callback', datetime('now', '-5 minutes'))", acme)
+	exec(t, s, "INSERT INTO queue_entries (company_id, call_sid, phone, status) VALUES (?, 'CA2', '+27825550002', 'callback')", globex)
+	exec(t, s, "INSERT INTO queue_entries (company_id, call_sid, phone, status) VALUES (?, 'CA3', '+27825550003', 'waiting')", acme)
+
+	tests := []struct {
+		name    string
+		company int64
+		want    string
+	}{
+		{"globex gets its own callback", globex, "<Number statusCallback"},
+		{"acme gets its own callback", acme, "+27825550001</Number>"},
+		{"acme then takes its holding caller", acme, "<Queue>" + callQueueName(acme) + "</Queue>"},
+		{"nobody waiting", empty, "There are no callers waiting."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/twilio/voice", nil)
+			r.Form = url.Values{"From": {"client:agent1"}}
+			got := s.nextInQueueTwiML(r, tt.company, "+27110000001")
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("TwiML = %s, want %s", got, tt.want)
+			}
+			if strings.Contains(got, "+27825550001") && tt.company != acme {
+				t.Errorf("company %d was given another company's caller: %s", tt.company, got)
+			}
+		})
+	}
+}
+
+func TestPlaceQueueCallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		holding bool // a caller still on the line is ahead of the callback
+		online  bool
+		fail    bool
+		dialed  bool
+		status  string
+	}{
+		{"free agent", false, true, false, true, queueConnected},
+		{"caller holding ahead", true, true, false, false, queueCallback},
+		{"nobody online", false, false, false, false, queueCallback},
+		{"twilio fails", false, true, true, true, queueCallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			fake := withFakeTwilio(s)
+			fake.fail = tt.fail
+			acme := addCompany(t, s, "Acme")
+			addNumber(t, s, acme, "+27110000001")
+			addAgent(t, s, acme, "agent1", roleAgent)
+			if !tt.online {
+				exec(t, s, "UPDATE agent_status SET presence = 'offline'")
+			}
+			if tt.holding {
+				exec(t, s, "INSERT INTO queue_entries (company_id, call_sid, phone, status, queued_at) VALUES (?, 'CA0', '+27825550000', 'waiting', datetime('now', '-5 minutes'))", acme)
+			}
+			exec(t, s, "INSERT INTO queue_entries (company_id, call_sid, phone, status) VALUES (?, 'CA1', '+27825550001', 'callback')", acme)
+
+			s.placeQueueCallbacks(t.Context(), "https://omnicall.example.com")
+
+			if got := len(fake.requests) > 0; got != tt.dialed {
+				t.Fatalf("dialed = %v, want %v", got, tt.dialed)
+			}
+			if tt.dialed {
+				req := fake.requests[0]
+				if req.Get("To") != "client:agent1" || req.Get("From") != "+27110000001" {
+					t.Errorf("call To=%s From=%s, want client:agent1 from +27110000001", req.Get("To"), req.Get("From"))
+				}
+				if !strings.Contains(req.Get("Url"), "to=%2B27825550001") {
+					t.Errorf("call Url = %s, want it to bridge to the caller", req.Get("Url"))
+				}
+			}
+			entry, err := s.queries.GetQueueEntryBySid(t.Context(), "CA1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Status != tt.status {
+				t.Errorf("entry status = %s, want %s", entry.Status, tt.status)
+			}
+			_, err = s.queries.GetCallBySid(t.Context(), "CAfake")
+			if recorded := err == nil; recorded != (tt.dialed && !tt.fail) {
+				t.Errorf("call recorded = %v, want %v", recorded, tt.dialed && !tt.fail)
+			}
+			if err != nil && err != sql.ErrNoRows {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAddQueueCompanies(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	exec(t, s, `INSERT INTO calls (call_sid, direction, company_id, status, started_at) VALUES ('CA-known', 'inbound', ?, 'queued', CURRENT_TIMESTAMP)`, acme)
+	exec(t, s, "INSERT INTO queue_entries (call_sid, phone, status) VALUES ('CA-known', '+27825550001', 'waiting')")
+	exec(t, s, "INSERT INTO queue_entries (call_sid, phone, status) VALUES ('CA-lost', '+27825550002', 'callback')")
+	exec(t, s, "INSERT INTO queue_entries (call_sid, phone, status) VALUES ('CA-done', '+27825550003', 'connected')")
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addQueueCompanies(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		callSID string
+		company int64
+		status  string
+	}{
+		{"CA-known", acme, queueWaiting},
+		{"CA-lost", 0, queueLeft},
+		{"CA-done", 0, queueConnected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.callSID, func(t *testing.T) {
+			entry, err := s.queries.GetQueueEntryBySid(t.Context(), tt.callSID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.CompanyID.Int64 != tt.company || entry.Status != tt.status {
+				t.Errorf("entry = %s for company %d, want %s for company %d", entry.Status, entry.CompanyID.Int64, tt.status, tt.company)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// rateLimiter keeps one token bucket per company, so a single busy tenant
+// runs out of requests without slowing anyone else down.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64
+	buckets map[int64]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiterFromEnv reads RATE_LIMIT_PER_MINUTE (default 600) and
+// RATE_LIMIT_BURST (default 100). A zero rate turns limiting off.
+func newRateLimiterFromEnv() *rateLimiter {
+	perMinute, err := strconv.Atoi(os.Getenv("RATE_LIMIT_PER_MINUTE"))
+	if err != nil || perMinute < 0 {
+		perMinute = 600
+	}
+	if perMinute == 0 {
+		return nil
+	}
+	burst, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+	if err != nil || burst < 1 {
+		burst = 100
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[int64]*tokenBucket),
+	}
+}
+
+// take spends a token from key's bucket. It returns the tokens left and, when
+// the bucket is empty, how long until the next one arrives.
+func (l *rateLimiter) take(key int64, now time.Time) (remaining int, retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return 0, wait, false
+	}
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// rateLimit applies the company's rate limit. It's mounted behind
+// requireAuth, so Twilio webhooks, health checks and metrics are never
+// limited, and the session has already been looked up.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := userFromContext(r.Context())
+		if s.limiter == nil || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining, retryAfter, ok := s.limiter.take(user.CompanyID, time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(s.limiter.burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondError(w, http.StatusTooManyRequests, "Rate limit exceeded, please slow down")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// attemptLimiter caps attempts per key within a sliding window. It guards
+// the unauthenticated auth routes, where every attempt costs a bcrypt hash
+// and the caller can't be identified by company.
+type attemptLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+}
+
+// newAttemptLimiterFromEnv reads AUTH_RATE_LIMIT_ATTEMPTS (default 5) and
+// AUTH_RATE_LIMIT_WINDOW (default 15m). Zero attempts turns limiting off.
+func newAttemptLimiterFromEnv() *attemptLimiter {
+	limit, err := strconv.Atoi(os.Getenv("AUTH_RATE_LIMIT_ATTEMPTS"))
+	if err != nil || limit < 0 {
+		limit = 5
+	}
+	if limit == 0 {
+		return nil
+	}
+	window, err := time.ParseDuration(os.Getenv("AUTH_RATE_LIMIT_WINDOW"))
+	if err != nil || window <= 0 {
+		window = 15 * time.Minute
+	}
+	return &attemptLimiter{
+		limit:    limit,
+		window:   window,
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+// allow records an attempt against every key unless one of them is already
+// at the limit, in which case it returns how long until that key frees up.
+func (l *attemptLimiter) allow(keys []string, now time.Time) (retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if retryAfter, ok := l.check(keys, now); !ok {
+		return retryAfter, false
+	}
+	l.add(keys, now)
+	return 0, true
+}
+
+// refund takes back an attempt allow recorded at now, for attempts that
+// turned out not to count.
+func (l *attemptLimiter) refund(keys []string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		attempts := l.attempts[key]
+		if i := slices.IndexFunc(attempts, now.Equal); i >= 0 {
+			l.attempts[key] = slices.Delete(attempts, i, i+1)
+		}
+	}
+}
+
+// check is allow without recording the attempt. l.mu must be held.
+func (l *attemptLimiter) check(keys []string, now time.Time) (retryAfter time.Duration, ok bool) {
+	// Forget keys nobody has tried in a while, so the map doesn't grow
+	// with every address that ever made a request
+	if now.Sub(l.lastSweep) > l.window {
+		for key := range l.attempts {
+			l.prune(key, now)
+		}
+		l.lastSweep = now
+	}
+
+	for _, key := range keys {
+		attempts := l.prune(key, now)
+		if len(attempts) >= l.limit {
+			retryAfter = max(retryAfter, attempts[0].Add(l.window).Sub(now))
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+	return 0, true
+}
+
+// add records an attempt against every key. l.mu must be held.
+func (l *attemptLimiter) add(keys []string, now time.Time) {
+	for _, key := range keys {
+		l.attempts[key] = append(l.attempts[key], now)
+	}
+}
+
+// prune drops key's attempts that have left the window and returns the rest.
+func (l *attemptLimiter) prune(key string, now time.Time) []time.Time {
+	attempts := l.attempts[key]
+	i := 0
+	for i < len(attempts) && now.Sub(attempts[i]) >= l.window {
+		i++
+	}
+	attempts = attempts[i:]
+	if len(attempts) == 0 {
+		delete(l.attempts, key)
+		return nil
+	}
+	l.attempts[key] = attempts
+	return attempts
+}
+
+// authRateLimit limits attempts on a route per client address and per email
+// in the JSON body, so neither many accounts from one address nor one
+// account from many addresses gets unlimited guesses.
+func (s *Server) authRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys, ok := s.authLimitKeys(w, r)
+		if !ok {
+			return
+		}
+		if retryAfter, ok := s.authLimiter.allow(keys, time.Now()); !ok {
+			respondTooManyAttempts(w, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailureLimit is authRateLimit counting only the attempts that fail,
+// so people who sign in successfully several times in a row are never
+// turned away. Each attempt takes its place up front and gets it back on a
+// 2xx response, so attempts running side by side can't all slip in under
+// the limit while their passwords are being checked.
+func (s *Server) authFailureLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys, ok := s.authLimitKeys(w, r)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		if retryAfter, ok := s.authLimiter.allow(keys, now); !ok {
+			respondTooManyAttempts(w, retryAfter)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if status := ww.Status(); status >= 200 && status < 300 {
+			s.authLimiter.refund(keys, now)
+		}
+	})
+}
+
+// authLimitKeys returns the keys an auth attempt counts against: the client
+// address and the email in the JSON body. It responds itself and returns
+// false when the body can't be read, with a 413 when it's over the limit.
+func (s *Server) authLimitKeys(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	keys := []string{r.URL.Path + " ip:" + s.clientIP(r)}
+
+	// Peek at the email and put the body back for the handler
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		if !respondBodyTooLarge(w, err) {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+		}
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var req struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &req) == nil && req.Email != "" {
+		keys = append(keys, r.URL.Path+" email:"+strings.ToLower(strings.TrimSpace(req.Email)))
+	}
+	return keys, true
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondError(w, http.StatusTooManyRequests, "Too many attempts, please try again later")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"omnicall/db"
+)
+
+func TestRateLimitPerCompany(t *testing.T) {
+	s := newTestServer(t)
+	s.limiter = &rateLimiter{rate: 1.0 / 60, burst: 3, buckets: make(map[int64]*tokenBucket)}
+	acme := addAgent(t, s, addCompany(t, s, "Acme"), "acme1", roleAgent)
+	globex := addAgent(t, s, addCompany(t, s, "Globex"), "globex1", roleAgent)
+	handler := s.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	get := func(user *db.User) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, asUser(httptest.NewRequest(http.MethodGet, "/api/customers", nil), user))
+		return w.Code
+	}
+
+	// Acme spends its whole burst
+	for i := range 3 {
+		if got := get(acme); got != http.StatusOK {
+			t.Fatalf("Acme request %d: status = %d, want %d", i+1, got, http.StatusOK)
+		}
+	}
+	if got := get(acme); got != http.StatusTooManyRequests {
+		t.Errorf("Acme past its burst: status = %d, want %d", got, http.StatusTooManyRequests)
+	}
+	if got := get(globex); got != http.StatusOK {
+		t.Errorf("Globex after Acme's burst: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestAuthFailureLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts []string // passwords tried in turn
+		want     int      // status of the last attempt
+	}{
+		{"successes never count", []string{"correct horse", "correct horse", "correct horse", "correct horse"}, http.StatusOK},
+		{"failures count", []string{"wrong", "wrong", "wrong", "correct horse"}, http.StatusTooManyRequests},
+		{"failures below the limit", []string{"wrong", "wrong", "correct horse"}, http.StatusOK},
+		{"successes between failures", []string{"wrong", "correct horse", "wrong", "correct horse", "correct horse"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.authLimiter = &attemptLimiter{limit: 3, window: time.Minute, attempts: make(map[string][]time.Time)}
+			hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+			user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+			exec(t, s, "UPDATE users SET password_hash = ? WHERE id = ?", string(hash), user.ID)
+			handler := s.authFailureLimit(http.HandlerFunc(s.login))
+
+			var status int
+			for _, password := range tt.attempts {
+				body := `{"email":"` + user.Email + `","password":"` + password + `"}`
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+				status = w.Code
+			}
+			if status != tt.want {
+				t.Errorf("last attempt = %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthFailureLimitInParallel(t *testing.T) {
+	s := newTestServer(t)
+	s.authLimiter = &attemptLimiter{limit: 3, window: time.Minute, attempts: make(map[string][]time.Time)}
+	var checked atomic.Int32
+	handler := s.authFailureLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// As long as a password check takes, and always wrong
+		checked.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		respondError(w, http.StatusUnauthorized, "Invalid credentials")
+	}))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"pat@example.com","password":"guess"}`)))
+		}()
+	}
+	wg.Wait()
+	if got := checked.Load(); got != 3 {
+		t.Errorf("%d passwords checked, want 3", got)
+	}
+}
+
+func TestAuthLimitKeysBodyTooLarge(t *testing.T) {
+	s := newTestServer(t)
+	s.limits.maxBody = 64
+	s.authLimiter = &attemptLimiter{limit: 3, window: time.Minute, attempts: make(map[string][]time.Time)}
+	handler := s.limitBody(s.authFailureLimit(http.HandlerFunc(s.login)))
+
+	body := `{"email":"pat@example.com","password":"` + strings.Repeat("x", 100) + `"}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newTestServer(t)
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"forged header from a client", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:443", "198.51.100.1", "198.51.100.1"},
+		{"forged entry ahead of the proxy's", "10.0.0.2:443", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:443", "198.51.100.1, 192.0.2.1, 10.0.0.3", "198.51.100.1"},
+		{"trusted proxy without a header", "10.0.0.2:443", "", "10.0.0.2"},
+		{"garbage from a trusted proxy", "10.0.0.2:443", "not-an-ip", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"", 0, true},
+		{"10.0.0.0/8, 127.0.0.1", 2, true},
+		{"::1", 1, true},
+		{"proxy.internal", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.value)
+			proxies, err := trustedProxiesFromEnv()
+			if (err == nil) != tt.ok || len(proxies) != tt.want {
+				t.Errorf("got %v, %v; want %d proxies, ok %v", proxies, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
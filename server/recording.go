@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dialRecordingAttrs is the <Dial> attribute set that records a bridged call
+// from the moment it's answered and reports the recording to
+// handleRecordingCallback.
+const dialRecordingAttrs = ` record="record-from-answer" recordingStatusCallback="/twilio/recording-callback" recordingStatusCallbackEvent="completed"`
+
+// recordingAnnouncement tells whoever is on the customer's side of a recorded
+// call about the recording before they are connected.
+const recordingAnnouncement = "This call may be recorded for quality and training purposes."
+
+// recordingEnabled reports whether a company's calls are recorded.
+// CALL_RECORDING sets the default for companies that haven't chosen.
+func (s *Server) recordingEnabled(ctx context.Context, companyID int64) bool {
+	enabled := os.Getenv("CALL_RECORDING")
+	if companyID != 0 {
+		enabled = s.companySetting(ctx, companyID, settingCallRecording, enabled)
+	}
+	on, _ := strconv.ParseBool(enabled)
+	return on
+}
+
+// recordingAnnounced reports whether recorded calls start with
+// recordingAnnouncement. Consent rules vary, so it stays on unless the
+// company or RECORDING_ANNOUNCEMENT turns it off.
+func (s *Server) recordingAnnounced(ctx context.Context, companyID int64) bool {
+	enabled := os.Getenv("RECORDING_ANNOUNCEMENT")
+	if companyID != 0 {
+		enabled = s.companySetting(ctx, companyID, settingRecordingAnnouncement, enabled)
+	}
+	if on, err := strconv.ParseBool(enabled); err == nil && !on {
+		return false
+	}
+	return true
+}
+
+// handleRecordingAnnouncement is the <Number> url for recorded outbound
+// calls, played to the customer once they answer and before the agent is
+// bridged in.
+func (s *Server) handleRecordingAnnouncement(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>%s</Say>
+</Response>`, html.EscapeString(recordingAnnouncement))))
+}
+
+// handleRecordingCallback stores a finished recording on the call that
+// dialed it.
+func (s *Server) handleRecordingCallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	recordingSID := r.FormValue("RecordingSid")
+	status := r.FormValue("RecordingStatus")
+	if status != "completed" || recordingSID == "" {
+		logInfof(r.Context(), "Ignoring recording callback: CallSid=%s, RecordingSid=%s, RecordingStatus=%s", callSID, recordingSID, status)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	duration, durationErr := strconv.ParseInt(r.FormValue("RecordingDuration"), 10, 64)
+	rows, err := s.queries.SetCallRecording(r.Context(), db.SetCallRecordingParams{
+		RecordingSid:      nullString(recordingSID),
+		RecordingUrl:      nullString(r.FormValue("RecordingUrl")),
+		RecordingDuration: sql.NullInt64{Int64: duration, Valid: durationErr == nil},
+		CallSid:           callSID,
+	})
+	switch {
+	case err != nil:
+		logErrorf(r.Context(), "Error saving recording %s for call %s: %v", recordingSID, callSID, err)
+	case rows == 0:
+		logInfof(r.Context(), "Recording %s is for unknown call %s", recordingSID, callSID)
+	default:
+		logInfof(r.Context(), "⏺️ Recording %s saved for call %s (%ds)", recordingSID, callSID, duration)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCallRecording streams a call's recording from Twilio, which only hands
+// media to the account, so the recording never leaves the owning company.
+func (s *Server) getCallRecording(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	callSID := chi.URLParam(r, "callSid")
+	call, err := s.queries.GetCompanyCallBySid(r.Context(), db.GetCompanyCallBySidParams{
+		CallSid:   callSID,
+		CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get recording")
+		return
+	}
+	if !call.RecordingUrl.Valid {
+		respondError(w, http.StatusNotFound, "Call has no recording")
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	resp, err := s.twilio.Get(call.RecordingUrl.String+".mp3", nil, nil)
+	if err != nil {
+		logErrorf(r.Context(), "Error fetching recording %s for call %s: %v", call.RecordingSid.String, callSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to fetch recording")
+		return
+	}
+	defer resp.Body.Close()
+
+	logInfof(r.Context(), "⏺️ Recording for %s played by %s (company %d)", callSID, user.AgentID, user.CompanyID)
+	s.audit(r.Context(), user, auditCallRecordingPlay, callSID, call.RecordingSid.String)
+
+	// Long recordings can outlast the write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "audio/mpeg")
+	if resp.ContentLength > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logErrorf(r.Context(), "Error streaming recording for call %s: %v", callSID, err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"omnicall/db"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reports cover at most a year, and the last 30 days unless asked otherwise.
+const (
+	maxReportSpan     = 366 * 24 * time.Hour
+	defaultReportSpan = 30 * 24 * time.Hour
+)
+
+// CallVolumeMetrics counts one bucket's calls. The average duration only
+// covers answered calls.
+type CallVolumeMetrics struct {
+	Total                  int64   `json:"total"`
+	Answered               int64   `json:"answered"`
+	Missed                 int64   `json:"missed"`
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+
+	answeredSeconds int64
+	timed           int64
+}
+
+type CallVolumeBucket struct {
+	Bucket  string            `json:"bucket"`
+	Metrics CallVolumeMetrics `json:"metrics"`
+	// Keyed by direction, inbound or outbound
+	ByDirection map[string]CallVolumeMetrics `json:"by_direction"`
+}
+
+type CallVolumeReportResponse struct {
+	Success bool               `json:"success"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	GroupBy string             `json:"group_by"`
+	Buckets []CallVolumeBucket `json:"buckets"`
+}
+
+func (m *CallVolumeMetrics) add(row db.CallVolumeReportRow) {
+	m.Total += row.Total
+	m.Answered += row.Answered
+	m.Missed += row.Missed
+	m.answeredSeconds += row.AnsweredSeconds
+	m.timed += row.Timed
+	if m.timed > 0 {
+		m.AverageDurationSeconds = float64(m.answeredSeconds) / float64(m.timed)
+	}
+}
+
+// parseReportRange reads a report's from and to, which take the same forms
+// as the call list's, and holds them to maxReportSpan.
+func parseReportRange(r *http.Request) (since, until time.Time, err error) {
+	from, err := parseTimeFilter(r.URL.Query().Get("from"), false)
+	if err != nil {
+		return since, until, errors.New("Invalid from date")
+	}
+	to, err := parseTimeFilter(r.URL.Query().Get("to"), true)
+	if err != nil {
+		return since, until, errors.New("Invalid to date")
+	}
+
+	until = time.Now().UTC()
+	if to.Valid {
+		until = to.Time
+	}
+	since = until.Add(-defaultReportSpan)
+	if from.Valid {
+		since = from.Time
+	}
+	if !since.Before(until) {
+		return since, until, errors.New("from must be before to")
+	}
+	if until.Sub(since) > maxReportSpan {
+		return since, until, errors.New("Reports cover at most 366 days")
+	}
+	return since, until, nil
+}
+
+// getCallVolumeReport counts the company's calls per day or week between
+// from and to, overall and by direction. Buckets without calls are left
+// out.
+func (s *Server) getCallVolumeReport(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	if groupBy != "day" && groupBy != "week" {
+		respondError(w, http.StatusBadRequest, "group_by must be day or week")
+		return
+	}
+	since, until, err := parseReportRange(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.queries.CallVolumeReport(r.Context(), db.CallVolumeReportParams{
+		GroupBy:   groupBy,
+		CompanyID: sql.NullInt64{Int64: user.CompanyID, Valid: true},
+		Since:     since,
+		Until:     until,
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error reporting call volume for company %d: %v", user.CompanyID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get report")
+		return
+	}
+
+	// Rows come ordered by bucket, one per direction
+	buckets := []CallVolumeBucket{}
+	for _, row := range rows {
+		if len(buckets) == 0 || buckets[len(buckets)-1].Bucket != row.Bucket {
+			buckets = append(buckets, CallVolumeBucket{
+				Bucket:      row.Bucket,
+				ByDirection: map[string]CallVolumeMetrics{},
+			})
+		}
+		bucket := &buckets[len(buckets)-1]
+		bucket.Metrics.add(row)
+		direction := bucket.ByDirection[row.Direction]
+		direction.add(row)
+		bucket.ByDirection[row.Direction] = direction
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallVolumeReportResponse{
+		Success: true,
+		From:    since,
+		To:      until,
+		GroupBy: groupBy,
+		Buckets: buckets,
+	})
+}
+
+// AgentStats is one agent's line in the performance report. Handled calls
+// are the ones they answered, and talk time and the average handle time
+// only count those.
+type AgentStats struct {
+	AgentID                  string  `json:"agent_id"`
+	Name                     string  `json:"name"`
+	TotalCalls               int64   `json:"total_calls"`
+	Handled                  int64   `json:"handled"`
+	TalkTimeSeconds          int64   `json:"talk_time_seconds"`
+	AverageHandleTimeSeconds float64 `json:"average_handle_time_seconds"`
+	Missed                   int64   `json:"missed"`
+	Transferred              int64   `json:"transferred"`
+}
+
+type AgentPerformanceResponse struct {
+	Success bool         `json:"success"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Sort    string       `json:"sort"`
+	Order   string       `json:"order"`
+	Agents  []AgentStats `json:"agents"`
+}
+
+// agentStatsSorts are what the performance report can be sorted by, each
+// comparing two agents in ascending order.
+var agentStatsSorts = map[string]func(a, b AgentStats) int{
+	"name":                func(a, b AgentStats) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) },
+	"total_calls":         func(a, b AgentStats) int { return cmp.Compare(a.TotalCalls, b.TotalCalls) },
+	"handled":             func(a, b AgentStats) int { return cmp.Compare(a.Handled, b.Handled) },
+	"talk_time":           func(a, b AgentStats) int { return cmp.Compare(a.TalkTimeSeconds, b.TalkTimeSeconds) },
+	"average_handle_time": func(a, b AgentStats) int { return cmp.Compare(a.AverageHandleTimeSeconds, b.AverageHandleTimeSeconds) },
+	"missed":              func(a, b AgentStats) int { return cmp.Compare(a.Missed, b.Missed) },
+	"transferred":         func(a, b AgentStats) int { return cmp.Compare(a.Transferred, b.Transferred) },
+}
+
+// getAgentPerformanceReport is the agent leaderboard: each agent's calls
+// between from and to, busiest first unless sort and order say otherwise.
+// Agents without any calls are left out unless include_inactive is set.
+func (s *Server) getAgentPerformanceReport(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	query := r.URL.Query()
+
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "total_calls"
+	}
+	compare, ok := agentStatsSorts[sortBy]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Unknown sort "+strconv.Quote(sortBy))
+		return
+	}
+	// Names read A to Z, metrics best first
+	order := query.Get("order")
+	if order == "" {
+		order = "desc"
+		if sortBy == "name" {
+			order = "asc"
+		}
+	}
+	if order != "asc" && order != "desc" {
+		respondError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	includeInactive, _ := strconv.ParseBool(query.Get("include_inactive"))
+
+	since, until, err := parseReportRange(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.queries.AgentPerformanceReport(r.Context(), db.AgentPerformanceReportParams{
+		Since:           since,
+		Until:           until,
+		CompanyID:       user.CompanyID,
+		IncludeInactive: includeInactive,
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error reporting agent performance for company %d: %v", user.CompanyID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get report")
+		return
+	}
+
+	agents := make([]AgentStats, 0, len(rows))
+	for _, row := range rows {
+		stats := AgentStats{
+			AgentID:         row.AgentID,
+			Name:            strings.TrimSpace(row.Firstname + " " + row.Lastname),
+			TotalCalls:      row.TotalCalls,
+			Handled:         row.Handled,
+			TalkTimeSeconds: row.TalkSeconds,
+			Missed:          row.Missed,
+			Transferred:     row.Transferred,
+		}
+		if row.Timed > 0 {
+			stats.AverageHandleTimeSeconds = float64(row.TalkSeconds) / float64(row.Timed)
+		}
+		agents = append(agents, stats)
+	}
+	// Stable, so ties stay in name order
+	slices.SortStableFunc(agents, func(a, b AgentStats) int {
+		if order == "desc" {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentPerformanceResponse{
+		Success: true,
+		From:    since,
+		To:      until,
+		Sort:    sortBy,
+		Order:   order,
+		Agents:  agents,
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// User roles. Agents handle calls; supervisors also review them, and admins
+// manage their company.
+const (
+	roleAgent      = "agent"
+	roleSupervisor = "supervisor"
+	roleAdmin      = "admin"
+)
+
+var userRoles = []string{roleAgent, roleSupervisor, roleAdmin}
+
+// requireRole turns away signed-in users without one of roles. It runs after
+// requireAuth, which puts the user in the context.
+func (s *Server) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromContext(r.Context())
+			if !ok {
+				respondError(w, http.StatusUnauthorized, "Not authenticated")
+				return
+			}
+			if !slices.Contains(roles, user.Role) {
+				respondError(w, http.StatusForbidden, "This action requires the "+strings.Join(roles, " or ")+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"omnicall/db"
+)
+
+func TestRequireRole(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name    string
+		roles   []string
+		user    *db.User
+		allowed bool
+	}{
+		{"agent for supervisors", []string{roleSupervisor, roleAdmin}, &db.User{Role: roleAgent}, false},
+		{"supervisor for supervisors", []string{roleSupervisor, roleAdmin}, &db.User{Role: roleSupervisor}, true},
+		{"admin for supervisors", []string{roleSupervisor, roleAdmin}, &db.User{Role: roleAdmin}, true},
+		{"supervisor for admins", []string{roleAdmin}, &db.User{Role: roleSupervisor}, false},
+		{"nobody", []string{roleAdmin}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != nil {
+				r = asUser(r, tt.user)
+			}
+			ran := false
+			w := httptest.NewRecorder()
+			s.requireRole(tt.roles...)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { ran = true })).ServeHTTP(w, r)
+			if ran != tt.allowed {
+				t.Errorf("handler ran = %v, want %v (status %d)", ran, tt.allowed, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"omnicall/db"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Routing strategy names, as stored in the routing_strategy setting
+const (
+	routingFirstAvailable = "first-available"
+	routingRoundRobin     = "round-robin"
+	routingLongestIdle    = "longest-idle"
+)
+
+var routingStrategies = []string{routingFirstAvailable, routingRoundRobin, routingLongestIdle}
+
+var errNoAgentAvailable = errors.New("no agent available")
+
+// CallContext describes the call a Router is choosing an agent for.
+type CallContext struct {
+	CallID   string
+	CallSid  string
+	From     string
+	To       string
+	Customer *db.Customer // nil when the caller isn't a known customer
+}
+
+// isVip reports whether the caller is a customer flagged as VIP.
+func (c CallContext) isVip() bool {
+	return c.Customer != nil && c.Customer.IsVip
+}
+
+// Router decides which agent an incoming call should ring, from among the
+// agents of the company the dialed number belongs to.
+type Router interface {
+	SelectAgent(ctx context.Context, company *db.Company, call CallContext) (string, error)
+}
+
+// statefulRouter is a Router whose choices depend on the calls it has
+// already routed.
+type statefulRouter interface {
+	Router
+	// snapshot returns a router that starts from the same state but whose
+	// choices leave the original untouched.
+	snapshot() Router
+}
+
+// newRouters builds one instance of every routing strategy, keyed by name.
+func newRouters(queries *db.Queries) map[string]Router {
+	return map[string]Router{
+		routingFirstAvailable: &firstAvailableRouter{queries: queries},
+		routingRoundRobin:     &roundRobinRouter{queries: queries, next: make(map[int64]int)},
+		routingLongestIdle:    &longestIdleRouter{queries: queries},
+	}
+}
+
+// snapshotRouters copies routers so calls can be routed through them without
+// moving on the live ones, e.g. to show what a call would do.
+func snapshotRouters(routers map[string]Router) map[string]Router {
+	copied := make(map[string]Router, len(routers))
+	for name, router := range routers {
+		if stateful, ok := router.(statefulRouter); ok {
+			router = stateful.snapshot()
+		}
+		copied[name] = router
+	}
+	return copied
+}
+
+// routerFor returns the strategy configured for a company, falling back to
+// the server-wide default. VIP callers may be given a strategy of their own.
+func (s *Server) routerFor(ctx context.Context, company *db.Company, call CallContext) Router {
+	strategy := s.defaultRouting
+	if call.isVip() && s.defaultVipRouting != "" {
+		strategy = s.defaultVipRouting
+	}
+	strategy = s.companySetting(ctx, company.ID, settingRoutingStrategy, strategy)
+	if call.isVip() {
+		strategy = s.companySetting(ctx, company.ID, settingVipRoutingStrategy, strategy)
+	}
+	if router, ok := s.routers[strategy]; ok {
+		return router
+	}
+	return s.routers[routingFirstAvailable]
+}
+
+// chooseAgent picks the agent an incoming call rings, using the strategy
+// configured for the company. It returns errNoAgentAvailable when nobody is
+// online, or everyone who is is on do not disturb or in wrap-up.
+func (s *Server) chooseAgent(ctx context.Context, company *db.Company, call CallContext) (string, error) {
+	s.expirePresence(ctx)
+	return s.routerFor(ctx, company, call).SelectAgent(ctx, company, call)
+}
+
+func validateRoutingStrategy(value string) error {
+	if !slices.Contains(routingStrategies, value) {
+		return fmt.Errorf("Routing strategy must be one of: %s", strings.Join(routingStrategies, ", "))
+	}
+	return nil
+}
+
+// candidateAgents lists the agents a call may be routed to, in a stable order.
+func candidateAgents(ctx context.Context, queries *db.Queries, company *db.Company) ([]string, error) {
+	return queries.ListAgentIDsByCompany(ctx, company.ID)
+}
+
+// firstAvailableRouter always picks the first agent.
+type firstAvailableRouter struct {
+	queries *db.Queries
+}
+
+func (fr *firstAvailableRouter) SelectAgent(ctx context.Context, company *db.Company, call CallContext) (string, error) {
+	agents, err := candidateAgents(ctx, fr.queries, company)
+	if err != nil {
+		return "", err
+	}
+	if len(agents) == 0 {
+		return "", errNoAgentAvailable
+	}
+	return agents[0], nil
+}
+
+// roundRobinRouter cycles through a company's agents call by call.
+type roundRobinRouter struct {
+	queries *db.Queries
+
+	mu   sync.Mutex
+	next map[int64]int
+}
+
+func (rr *roundRobinRouter) SelectAgent(ctx context.Context, company *db.Company, call CallContext) (string, error) {
+	agents, err := candidateAgents(ctx, rr.queries, company)
+	if err != nil {
+		return "", err
+	}
+	if len(agents) == 0 {
+		return "", errNoAgentAvailable
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	i := rr.next[company.ID] % len(agents)
+	rr.next[company.ID] = i + 1
+	return agents[i], nil
+}
+
+func (rr *roundRobinRouter) snapshot() Router {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return &roundRobinRouter{queries: rr.queries, next: maps.Clone(rr.next)}
+}
+
+// longestIdleRouter picks the agent whose last call is furthest in the past,
+// preferring agents who haven't taken a call at all.
+type longestIdleRouter struct {
+	queries *db.Queries
+}
+
+func (lr *longestIdleRouter) SelectAgent(ctx context.Context, company *db.Company, call CallContext) (string, error) {
+	agents, err := lr.queries.ListAgentIDsByIdleTimeAndCompany(ctx, company.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(agents) == 0 {
+		return "", errNoAgentAvailable
+	}
+	return agents[0], nil
+}
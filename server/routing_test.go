@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestRouters(t *testing.T) {
+	s := newTestServer(t)
+	acmeID := addCompany(t, s, "Acme")
+	globexID := addCompany(t, s, "Globex")
+	initechID := addCompany(t, s, "Initech")
+	addAgent(t, s, acmeID, "a1", roleAgent)
+	addAgent(t, s, acmeID, "a2", roleAgent)
+	addAgent(t, s, acmeID, "a3", roleAgent)
+	addAgent(t, s, acmeID, "a4", roleAgent)
+	addAgent(t, s, globexID, "g1", roleAgent)
+	exec(t, s, "UPDATE agent_status SET dnd_until = datetime('now', '+1 hour') WHERE agent_id = 'a2'")
+	exec(t, s, "INSERT INTO agent_activity (agent_id, last_call_at) VALUES ('a1', datetime('now', '-1 hour')), ('a4', datetime('now', '-2 hours'))")
+	acme, _ := s.queries.GetCompany(t.Context(), acmeID)
+	initech, _ := s.queries.GetCompany(t.Context(), initechID)
+
+	// a2 is on do not disturb, a3 has never taken a call and g1 works for
+	// another company
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{routingFirstAvailable, []string{"a1", "a1", "a1", "a1"}},
+		{routingRoundRobin, []string{"a1", "a3", "a4", "a1"}},
+		{routingLongestIdle, []string{"a3", "a3", "a3", "a3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			router := newRouters(s.queries)[tt.strategy]
+			var got []string
+			for range tt.want {
+				agent, err := router.SelectAgent(t.Context(), &acme, CallContext{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, agent)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("picked %v, want %v", got, tt.want)
+			}
+
+			if _, err := router.SelectAgent(t.Context(), &initech, CallContext{}); !errors.Is(err, errNoAgentAvailable) {
+				t.Errorf("company without agents: error = %v, want %v", err, errNoAgentAvailable)
+			}
+		})
+	}
+}
+
+func TestLongestIdleAfterAnsweredCall(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	addAgent(t, s, acme, "a1", roleAgent)
+	addAgent(t, s, acme, "a2", roleAgent)
+	addNumber(t, s, acme, "+27110000001")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", acme, settingRoutingStrategy, routingLongestIdle)
+
+	// ring routes a call and returns the agent it rang, then tells the
+	// server how the dial ended
+	ring := func(callSID, dialStatus string) string {
+		w := httptest.NewRecorder()
+		s.handleIncomingCall(w, twilioWebhook("/twilio/incoming-call", url.Values{
+			"CallSid": {callSID}, "From": {"+27825550001"}, "To": {"+27110000001"}, "CallStatus": {"ringing"},
+		}))
+		call, err := s.queries.GetCallBySid(t.Context(), callSID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.handleDialComplete(httptest.NewRecorder(), twilioWebhook("/twilio/dial-complete?agent_id="+url.QueryEscape(call.AgentID.String), url.Values{
+			"CallSid": {callSID}, "From": {"+27825550001"}, "To": {"+27110000001"}, "DialCallStatus": {dialStatus}, "DialCallDuration": {"30"},
+		}))
+		return call.AgentID.String
+	}
+
+	steps := []struct {
+		dialStatus string
+		want       string
+	}{
+		{"no-answer", "a1"},
+		{"completed", "a1"}, // ringing out didn't count as a call
+		{"completed", "a2"}, // a1 took the last one
+		{"completed", "a1"},
+	}
+	for i, step := range steps {
+		if got := ring(fmt.Sprintf("CA-%d", i), step.dialStatus); got != step.want {
+			t.Errorf("call %d rang %q, want %q", i+1, got, step.want)
+		}
+	}
+}
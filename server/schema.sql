@@ -1,18 +1,27 @@
 CREATE TABLE IF NOT EXISTS companies (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL UNIQUE,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    email TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL,
     password_hash TEXT NOT NULL,
     firstname TEXT NOT NULL,
     lastname TEXT NOT NULL,
     agent_id TEXT NOT NULL UNIQUE,
     company_id INTEGER NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    email_verified BOOLEAN NOT NULL DEFAULT 0,
+    failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until DATETIME,
+    role TEXT NOT NULL DEFAULT 'agent',
+    caller_number_id INTEGER REFERENCES phone_numbers(id),
     FOREIGN KEY (company_id) REFERENCES companies(id)
 );
 
@@ -21,9 +30,15 @@ CREATE TABLE IF NOT EXISTS sessions (
     user_id INTEGER NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     expires_at DATETIME NOT NULL,
+    user_agent TEXT,
+    ip_address TEXT,
     FOREIGN KEY (user_id) REFERENCES users(id)
 );
 
+-- Soft-deleted rows don't hold on to their name or email
+CREATE UNIQUE INDEX IF NOT EXISTS companies_name_active ON companies (name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_active ON users (email) WHERE deleted_at IS NULL;
+
 -- -----------------------
 -- New Tables
 -- -----------------------
@@ -39,9 +54,16 @@ CREATE TABLE IF NOT EXISTS customers (
     medical_aid_number TEXT,
     medical_plan TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    is_vip BOOLEAN NOT NULL DEFAULT 0,
+    deleted_at DATETIME,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    phone_normalized TEXT,
+    notes TEXT,
     FOREIGN KEY (company_id) REFERENCES companies(id)
 );
 
+CREATE INDEX IF NOT EXISTS customers_phone_normalized ON customers (phone_normalized, company_id);
+
 CREATE TABLE IF NOT EXISTS customer_premiums (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     customer_id INTEGER NOT NULL,
@@ -61,4 +83,505 @@ CREATE TABLE IF NOT EXISTS call_transcriptions (
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (customer_id) REFERENCES customers(id),
     FOREIGN KEY (agent_id) REFERENCES users(id)
-);
\ No newline at end of file
+);
+CREATE TABLE IF NOT EXISTS blocked_numbers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    phone TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT 'rejected',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    UNIQUE (company_id, phone)
+);
+
+CREATE TABLE IF NOT EXISTS call_refs (
+    id TEXT PRIMARY KEY,
+    call_sid TEXT UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    name_recording_url TEXT,
+    company_id INTEGER,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE TABLE IF NOT EXISTS company_settings (
+    company_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (company_id, key),
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE TABLE IF NOT EXISTS agent_activity (
+    agent_id TEXT PRIMARY KEY,
+    last_call_at DATETIME NOT NULL,
+    FOREIGN KEY (agent_id) REFERENCES users(agent_id)
+);
+
+CREATE TABLE IF NOT EXISTS app_settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS voicemails (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    customer_id INTEGER,
+    call_sid TEXT,
+    from_number TEXT NOT NULL,
+    recording_url TEXT NOT NULL,
+    duration INTEGER NOT NULL DEFAULT 0,
+    deleted_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    read_at DATETIME,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target TEXT,
+    details TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_company_created ON audit_log (company_id, created_at);
+
+CREATE TABLE IF NOT EXISTS queue_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_sid TEXT NOT NULL UNIQUE,
+    phone TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'waiting',
+    position INTEGER,
+    queued_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    company_id INTEGER,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE INDEX IF NOT EXISTS queue_entries_company_status ON queue_entries (company_id, status);
+
+CREATE TABLE IF NOT EXISTS agent_status (
+    agent_id TEXT PRIMARY KEY,
+    dnd_until DATETIME,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    wrap_up_call_sid TEXT,
+    wrap_up_until DATETIME,
+    presence TEXT NOT NULL DEFAULT 'offline',
+    last_seen_at DATETIME,
+    FOREIGN KEY (agent_id) REFERENCES users(agent_id)
+);
+
+CREATE TABLE IF NOT EXISTS phone_numbers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    phone TEXT NOT NULL UNIQUE,
+    label TEXT,
+    routing_type TEXT NOT NULL DEFAULT 'agents',
+    announcement TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE TABLE IF NOT EXISTS voicemail_drops (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    agent_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    content_type TEXT NOT NULL,
+    audio BLOB NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (agent_id) REFERENCES users(agent_id)
+);
+
+CREATE TABLE IF NOT EXISTS call_dispositions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    agent_id TEXT NOT NULL,
+    call_sid TEXT NOT NULL,
+    disposition TEXT NOT NULL,
+    notes TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    call_ref_id TEXT,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (agent_id) REFERENCES users(agent_id),
+    FOREIGN KEY (call_ref_id) REFERENCES call_refs(id)
+);
+
+CREATE INDEX IF NOT EXISTS call_dispositions_call_sid ON call_dispositions (call_sid);
+CREATE INDEX IF NOT EXISTS call_dispositions_call_ref_id ON call_dispositions (call_ref_id);
+
+CREATE TABLE IF NOT EXISTS parked_calls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    code TEXT NOT NULL,
+    call_sid TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    parked_by TEXT NOT NULL,
+    retrieved_by TEXT,
+    status TEXT NOT NULL DEFAULT 'parked',
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (parked_by) REFERENCES users(agent_id)
+);
+
+-- Codes are only reserved while the call is still parked
+CREATE UNIQUE INDEX IF NOT EXISTS parked_calls_code_active ON parked_calls (company_id, code) WHERE status = 'parked';
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS calls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_sid TEXT NOT NULL UNIQUE,
+    direction TEXT NOT NULL,
+    from_number TEXT,
+    to_number TEXT,
+    agent_id TEXT,
+    company_id INTEGER,
+    status TEXT NOT NULL,
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME,
+    duration_seconds INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    recording_sid TEXT,
+    recording_url TEXT,
+    recording_duration INTEGER,
+    hold_started_at DATETIME,
+    hold_seconds INTEGER NOT NULL DEFAULT 0,
+    held_by TEXT,
+    held_call_sid TEXT,
+    answered_at DATETIME,
+    call_ref_id TEXT,
+    parent_call_sid TEXT,
+    answered_by TEXT,
+    amd_outcome TEXT,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (call_ref_id) REFERENCES call_refs(id)
+);
+
+CREATE INDEX IF NOT EXISTS calls_company_started ON calls (company_id, started_at);
+CREATE INDEX IF NOT EXISTS calls_call_ref_id ON calls (call_ref_id);
+CREATE INDEX IF NOT EXISTS calls_parent_call_sid ON calls (parent_call_sid);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_sid TEXT UNIQUE,
+    company_id INTEGER NOT NULL,
+    direction TEXT NOT NULL,
+    from_number TEXT NOT NULL,
+    to_number TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL,
+    agent_id TEXT,
+    error_message TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    customer_id INTEGER,
+    FOREIGN KEY (company_id) REFERENCES companies(id),
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+CREATE INDEX IF NOT EXISTS messages_company_customer ON messages (company_id, customer_id, id);
+
+CREATE TABLE IF NOT EXISTS call_transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    call_id INTEGER NOT NULL,
+    from_agent_id TEXT NOT NULL,
+    to_agent_id TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (call_id) REFERENCES calls(id)
+);
+
+CREATE INDEX IF NOT EXISTS call_transfers_call ON call_transfers (call_id);
+
+CREATE TABLE IF NOT EXISTS conference_participants (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    conference_name TEXT NOT NULL,
+    conference_sid TEXT,
+    call_sid TEXT NOT NULL,
+    agent_id TEXT,
+    role TEXT NOT NULL,
+    status TEXT NOT NULL,
+    joined_at DATETIME,
+    left_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (conference_name, call_sid),
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+-- Opening hours. Companies without a schedule are always open.
+CREATE TABLE IF NOT EXISTS business_schedules (
+    company_id INTEGER PRIMARY KEY,
+    timezone TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+-- A window opens on day (0 is Sunday) and runs past midnight when closes
+-- isn't after opens. Times are HH:MM in the schedule's timezone.
+CREATE TABLE IF NOT EXISTS business_schedule_windows (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER NOT NULL,
+    day INTEGER NOT NULL,
+    opens TEXT NOT NULL,
+    closes TEXT NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE INDEX IF NOT EXISTS business_schedule_windows_company ON business_schedule_windows (company_id);
+
+CREATE TABLE IF NOT EXISTS business_holidays (
+    company_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (company_id, date),
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+-- Responses to requests sent with an Idempotency-Key, replayed to retries.
+-- status_code stays null while the first request is still running.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    response_body BLOB,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    UNIQUE (user_id, idempotency_key),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires ON idempotency_keys (expires_at);
+
+-- Calls Twilio couldn't carry on with, as reported to the fallback URL.
+-- company_id is null when the call couldn't be traced to a company.
+CREATE TABLE IF NOT EXISTS call_errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    company_id INTEGER,
+    call_sid TEXT,
+    error_code TEXT,
+    error_url TEXT,
+    from_number TEXT,
+    to_number TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id)
+);
+
+CREATE INDEX IF NOT EXISTS call_errors_company_created ON call_errors (company_id, created_at);
+
+-- Versions of the migrations applied to this database
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Keep updated_at current on every update. Millisecond precision so two
+-- writes in the same second still tell apart.
+
+CREATE TRIGGER IF NOT EXISTS companies_updated_at AFTER UPDATE ON companies
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE companies SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_updated_at AFTER UPDATE ON users
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS customers_updated_at AFTER UPDATE ON customers
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE customers SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS company_settings_updated_at AFTER UPDATE ON company_settings
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE company_settings SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS app_settings_updated_at AFTER UPDATE ON app_settings
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE app_settings SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS voicemails_updated_at AFTER UPDATE ON voicemails
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE voicemails SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS queue_entries_updated_at AFTER UPDATE ON queue_entries
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE queue_entries SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS agent_status_updated_at AFTER UPDATE ON agent_status
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE agent_status SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS phone_numbers_updated_at AFTER UPDATE ON phone_numbers
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE phone_numbers SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS parked_calls_updated_at AFTER UPDATE ON parked_calls
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE parked_calls SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS calls_updated_at AFTER UPDATE ON calls
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE calls SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_updated_at AFTER UPDATE ON messages
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE messages SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS business_schedules_updated_at AFTER UPDATE ON business_schedules
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE business_schedules SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS conference_participants_updated_at AFTER UPDATE ON conference_participants
+FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE conference_participants SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+-- Fill in updated_at on inserts that leave it NULL. Databases that gained
+-- the column through a migration have no default for it.
+
+CREATE TRIGGER IF NOT EXISTS companies_updated_at_insert AFTER INSERT ON companies
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE companies SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_updated_at_insert AFTER INSERT ON users
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS customers_updated_at_insert AFTER INSERT ON customers
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE customers SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS company_settings_updated_at_insert AFTER INSERT ON company_settings
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE company_settings SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS app_settings_updated_at_insert AFTER INSERT ON app_settings
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE app_settings SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS voicemails_updated_at_insert AFTER INSERT ON voicemails
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE voicemails SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS queue_entries_updated_at_insert AFTER INSERT ON queue_entries
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE queue_entries SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS agent_status_updated_at_insert AFTER INSERT ON agent_status
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE agent_status SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS phone_numbers_updated_at_insert AFTER INSERT ON phone_numbers
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE phone_numbers SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS parked_calls_updated_at_insert AFTER INSERT ON parked_calls
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE parked_calls SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS calls_updated_at_insert AFTER INSERT ON calls
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE calls SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_updated_at_insert AFTER INSERT ON messages
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE messages SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS business_schedules_updated_at_insert AFTER INSERT ON business_schedules
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE business_schedules SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS conference_participants_updated_at_insert AFTER INSERT ON conference_participants
+FOR EACH ROW WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE conference_participants SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE rowid = NEW.rowid;
+END;
+
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"omnicall/db"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionInfo describes one of the user's sessions. The session id is the
+// credential itself, so sessions are told apart by its hash instead.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Current   bool      `json:"current"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SessionsResponse struct {
+	Success  bool          `json:"success"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// trustedProxiesFromEnv reads TRUSTED_PROXIES, the comma-separated
+// addresses or CIDR ranges of the proxies in front of the server. Only they
+// are believed about who the client is.
+func trustedProxiesFromEnv() ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, value := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an address or CIDR range", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// trustedProxy reports whether ip is one of the configured proxies.
+func (s *Server) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address the request came from, without the port.
+// X-Forwarded-For lists the client followed by each proxy it passed
+// through, but anyone can send one, so it's only read when the request
+// comes from a trusted proxy. The client is then the last address that
+// isn't one of ours.
+func (s *Server) clientIP(r *http.Request) string {
+	remote := parseIP(r.RemoteAddr)
+	if remote == "" {
+		return r.RemoteAddr
+	}
+	if !s.trustedProxy(remote) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseIP(hops[i])
+		if ip == "" {
+			break
+		}
+		if !s.trustedProxy(ip) {
+			return ip
+		}
+		remote = ip
+	}
+	return remote
+}
+
+// parseIP returns the IP in an address that may carry a port, or "" if it
+// isn't one.
+func parseIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// getSessions lists the signed-in user's sessions that haven't expired,
+// newest first.
+func (s *Server) getSessions(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	current, _ := s.cookie.read(r)
+
+	sessions, err := s.queries.ListSessionsByUser(r.Context(), db.ListSessionsByUserParams{
+		UserID:    user.ID,
+		ExpiresAt: time.Now(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get sessions")
+		return
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:        hashToken(session.ID),
+			Current:   session.ID == current,
+			UserAgent: session.UserAgent.String,
+			IPAddress: session.IpAddress.String,
+			CreatedAt: session.CreatedAt.Time,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionsResponse{
+		Success:  true,
+		Sessions: infos,
+	})
+}
+
+// revokeSession signs one of the user's sessions out, found by the id
+// getSessions gave it. Revoking the current session also clears its cookie.
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	sessions, err := s.queries.ListSessionsByUser(r.Context(), db.ListSessionsByUserParams{
+		UserID:    user.ID,
+		ExpiresAt: time.Now(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	var sessionID string
+	for _, session := range sessions {
+		if hashToken(session.ID) == id {
+			sessionID = session.ID
+			break
+		}
+	}
+	if sessionID == "" {
+		respondError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	deleted, err := s.queries.DeleteUserSession(r.Context(), db.DeleteUserSessionParams{
+		ID:     sessionID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if deleted == 0 {
+		respondError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if current, _ := s.cookie.read(r); current == sessionID {
+		s.cookie.clear(w)
+	}
+
+	logInfof(r.Context(), "🔐 Session revoked for %s", user.Email)
+	s.audit(r.Context(), user, auditSessionRevoke, id, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// revokeOtherSessions signs the user out everywhere but this session.
+func (s *Server) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	current, _ := s.cookie.read(r)
+
+	deleted, err := s.queries.DeleteOtherSessions(r.Context(), db.DeleteOtherSessionsParams{
+		UserID: user.ID,
+		ID:     current,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	logInfof(r.Context(), "🔐 %d other sessions revoked for %s", deleted, user.Email)
+	s.audit(r.Context(), user, auditSessionRevokeAll, user.AgentID, strconv.FormatInt(deleted, 10))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": true,
+		"revoked": deleted,
+	})
+}
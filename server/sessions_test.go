@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginRecordsClientIP(t *testing.T) {
+	s := newTestServer(t)
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+	exec(t, s, "UPDATE users SET password_hash = ? WHERE id = ?", string(hash), user.ID)
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"spoofed X-Forwarded-For", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"through the trusted proxy", "10.0.0.1:443", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"email":"` + user.Email + `","password":"correct horse"}`
+			r := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			s.login(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var cookie *http.Cookie
+			for _, c := range w.Result().Cookies() {
+				if c.Name == s.cookie.name {
+					cookie = c
+				}
+			}
+			if cookie == nil {
+				t.Fatal("no session cookie set")
+			}
+			session, err := s.queries.GetSession(t.Context(), cookie.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.IpAddress.String != tt.want {
+				t.Errorf("session IP = %s, want %s", session.IpAddress.String, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   int // cost of the stored hash
+		password string
+		status   int
+		cost     int // cost of the hash afterwards
+	}{
+		{"cheaper hash upgraded", bcrypt.MinCost, "correct horse", http.StatusOK, bcrypt.MinCost + 1},
+		{"hash at the cost kept", bcrypt.MinCost + 1, "correct horse", http.StatusOK, bcrypt.MinCost + 1},
+		{"dearer hash kept", bcrypt.MinCost + 2, "correct horse", http.StatusOK, bcrypt.MinCost + 2},
+		{"wrong password leaves it", bcrypt.MinCost, "wrong horse", http.StatusUnauthorized, bcrypt.MinCost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.passwords.cost = bcrypt.MinCost + 1
+			user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+			hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), tt.stored)
+			exec(t, s, "UPDATE users SET password_hash = ? WHERE id = ?", string(hash), user.ID)
+
+			body := `{"email":"` + user.Email + `","password":"` + tt.password + `"}`
+			w := httptest.NewRecorder()
+			s.login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			stored, _ := s.queries.GetUserByID(t.Context(), user.ID)
+			cost, err := bcrypt.Cost([]byte(stored.PasswordHash))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cost != tt.cost {
+				t.Errorf("cost = %d, want %d", cost, tt.cost)
+			}
+			// The upgraded hash still has to match the same password
+			if err := bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("correct horse")); err != nil {
+				t.Errorf("stored hash no longer matches: %v", err)
+			}
+		})
+	}
+}
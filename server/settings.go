@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Company setting keys
+const (
+	settingRoutingStrategy       = "routing_strategy"
+	settingVipRoutingStrategy    = "vip_routing_strategy"
+	settingAllowedOrigins        = "allowed_origins"
+	settingCallerIDMasking       = "caller_id_masking"
+	settingCollectCallerName     = "collect_caller_name"
+	settingRequireDisposition    = "require_disposition"
+	settingDispositionTimeout    = "disposition_timeout"
+	settingProductName           = "product_name"
+	settingAmdVoicemailDrop      = "amd_voicemail_drop"
+	settingAmdMessage            = "amd_voicemail_message"
+	settingCallRecording         = "recording_enabled"
+	settingRecordingAnnouncement = "recording_announcement"
+	settingVoicemailGreeting     = "voicemail_greeting"
+	settingVoicemailGreetingURL  = "voicemail_greeting_url"
+	settingHoldMusicURL          = "hold_music_url"
+	settingOutboundDialPrefixes  = "outbound_dial_prefixes"
+	settingGreetingText          = "greeting_text"
+	settingGreetingVoice         = "greeting_voice"
+	settingGreetingLanguage      = "greeting_language"
+	settingGreetingAudioURL      = "greeting_audio_url"
+	settingAfterHoursMessage     = "after_hours_message"
+)
+
+// settingValidators lists the settings a company may change and how each
+// value is checked before it is stored.
+var settingValidators = map[string]func(string) error{
+	settingRoutingStrategy:       validateRoutingStrategy,
+	settingVipRoutingStrategy:    validateRoutingStrategy,
+	settingAllowedOrigins:        validateAllowedOrigins,
+	settingCallerIDMasking:       validateBoolSetting,
+	settingCollectCallerName:     validateBoolSetting,
+	settingRequireDisposition:    validateBoolSetting,
+	settingDispositionTimeout:    validateDispositionTimeout,
+	settingProductName:           validateProductName,
+	settingAmdVoicemailDrop:      validateBoolSetting,
+	settingAmdMessage:            validateAmdMessage,
+	settingCallRecording:         validateBoolSetting,
+	settingRecordingAnnouncement: validateBoolSetting,
+	settingVoicemailGreeting:     validateVoicemailGreeting,
+	settingVoicemailGreetingURL:  validateAudioURL,
+	settingHoldMusicURL:          validateAudioURL,
+	settingOutboundDialPrefixes:  validateDialPrefixes,
+	settingGreetingText:          validateVoicemailGreeting,
+	settingGreetingVoice:         validateGreetingVoice,
+	settingGreetingLanguage:      validateGreetingLanguage,
+	settingGreetingAudioURL:      validateAudioURL,
+	settingAfterHoursMessage:     validateVoicemailGreeting,
+}
+
+type SettingUpdate struct {
+	Value string `json:"value"`
+}
+
+type SettingsResponse struct {
+	Success  bool                `json:"success"`
+	Settings []db.CompanySetting `json:"settings"`
+}
+
+type SettingResponse struct {
+	Success bool               `json:"success"`
+	Setting *db.CompanySetting `json:"setting,omitempty"`
+}
+
+func (s *Server) getSettings(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	settings, err := s.queries.ListCompanySettings(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SettingsResponse{
+		Success:  true,
+		Settings: settings,
+	})
+}
+
+func (s *Server) updateSetting(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	key := chi.URLParam(r, "key")
+	validate, known := settingValidators[key]
+	if !known {
+		respondError(w, http.StatusNotFound, "Unknown setting")
+		return
+	}
+
+	var req SettingUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validate(req.Value); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	setting, err := s.queries.UpsertCompanySetting(r.Context(), db.UpsertCompanySettingParams{
+		CompanyID: user.CompanyID,
+		Key:       key,
+		Value:     req.Value,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update setting")
+		return
+	}
+
+	s.audit(r.Context(), user, auditSettingUpdate, key, req.Value)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SettingResponse{
+		Success: true,
+		Setting: &setting,
+	})
+}
+
+// companySetting returns a company's value for key, or fallback when the
+// company hasn't set one.
+func (s *Server) companySetting(ctx context.Context, companyID int64, key, fallback string) string {
+	value, err := s.queries.GetCompanySetting(ctx, db.GetCompanySettingParams{
+		CompanyID: companyID,
+		Key:       key,
+	})
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// shutdownTimeoutFromEnv is how long in-flight requests get to finish once
+// the server is asked to stop.
+func shutdownTimeoutFromEnv() (time.Duration, error) {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return 30 * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("SHUTDOWN_TIMEOUT must be a positive duration")
+	}
+	return timeout, nil
+}
+
+// serve runs srv until ctx is cancelled, then stops taking connections and
+// waits up to timeout for the requests already in flight. It only returns
+// once the server is done with, so whatever the handlers use can be closed
+// after it.
+func serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logInfof(ctx, "🛑 Shutting down, giving in-flight requests up to %s", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("🛑 HTTP server stopped")
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr finds a local address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitForServer polls addr until it accepts connections.
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+	for range 100 {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("nothing listening on %s", addr)
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	srv := &http.Server{Addr: freeAddr(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		io.WriteString(w, "done")
+	})}
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, 5*time.Second) }()
+	waitForServer(t, srv.Addr)
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr)
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+	<-entered
+
+	// The signal arrives mid-request: serve waits for it rather than
+	// returning, so the database isn't closed under it
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v with a request still in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.Dial("tcp", srv.Addr); err == nil {
+		t.Error("still taking new connections while shutting down")
+	}
+
+	close(release)
+	if r := <-responses; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request got %q, %v; want it to finish", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v, want nil", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Addr: freeAddr(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})}
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, 50*time.Millisecond) }()
+	waitForServer(t, srv.Addr)
+
+	go http.Get("http://" + srv.Addr)
+	<-entered
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("serve = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve waited past its timeout")
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Nothing to drain when the server never started
+	err = serve(t.Context(), &http.Server{Addr: l.Addr().String()}, time.Second)
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("serve = %v, want the listen error", err)
+	}
+}
+
+func TestShutdownTimeoutFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 30 * time.Second, false},
+		{"45s", 45 * time.Second, false},
+		{"0s", 0, true},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("SHUTDOWN_TIMEOUT", tt.value)
+			got, err := shutdownTimeoutFromEnv()
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("shutdownTimeoutFromEnv = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/twilio/twilio-go/client"
+)
+
+// twilioValidatorFromEnv builds the validator that proves a /twilio request
+// was sent by Twilio, signed with TWILIO_AUTH_TOKEN. Checking is on unless
+// TWILIO_VALIDATE_SIGNATURE is false, which is meant for local testing with
+// curl or the Twilio console; a nil validator means it is off.
+func twilioValidatorFromEnv() (*client.RequestValidator, error) {
+	if value := os.Getenv("TWILIO_VALIDATE_SIGNATURE"); value != "" {
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("TWILIO_VALIDATE_SIGNATURE must be true or false")
+		}
+		if !on {
+			return nil, nil
+		}
+	}
+
+	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	if authToken == "" {
+		return nil, errors.New("TWILIO_AUTH_TOKEN is required, or set TWILIO_VALIDATE_SIGNATURE=false to accept unsigned requests")
+	}
+	validator := client.NewRequestValidator(authToken)
+	return &validator, nil
+}
+
+// verifyTwilioSignature rejects webhook requests whose X-Twilio-Signature
+// doesn't match the URL Twilio called and the parameters it posted, so
+// nobody else can drive calls through the TwiML endpoints.
+func (s *Server) verifyTwilioSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.twilioValidator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid form body")
+			return
+		}
+		params := make(map[string]string, len(r.PostForm))
+		for key := range r.PostForm {
+			params[key] = r.PostForm.Get(key)
+		}
+
+		url := publicBaseURL(r) + r.URL.RequestURI()
+		if !s.twilioValidator.Validate(url, params, r.Header.Get("X-Twilio-Signature")) {
+			logInfof(r.Context(), "🚫 Rejected unsigned Twilio request for %s from %s", url, s.clientIP(r))
+			respondError(w, http.StatusForbidden, "Invalid Twilio signature")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicBaseURL is where Twilio can reach this server: PUBLIC_BASE_URL when
+// set, otherwise the scheme and host the request came in on.
+func publicBaseURL(r *http.Request) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
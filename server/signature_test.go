@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/twilio/twilio-go/client"
+)
+
+// A webhook signed with auth token 12345, worked out independently of
+// twilio-go: base64 of the HMAC-SHA1 of the URL followed by each parameter
+// name and value in name order.
+const (
+	signedURL       = "https://mycompany.com/myapp.php?foo=1&bar=2"
+	signedSignature = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+)
+
+var signedParams = url.Values{
+	"CallSid": {"CA1234567890ABCDE"},
+	"Caller":  {"+12349013030"},
+	"Digits":  {"1234"},
+	"From":    {"+12349013030"},
+	"To":      {"+18005551212"},
+}
+
+func TestVerifyTwilioSignature(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://mycompany.com")
+	validator := client.NewRequestValidator("12345")
+
+	tamperedParams := url.Values{}
+	for key, values := range signedParams {
+		tamperedParams[key] = values
+	}
+	tamperedParams.Set("To", "+18005550000")
+
+	tests := []struct {
+		name      string
+		validator *client.RequestValidator
+		path      string
+		params    url.Values
+		signature string
+		status    int
+	}{
+		{"good signature", &validator, "/myapp.php?foo=1&bar=2", signedParams, signedSignature, http.StatusOK},
+		{"changed parameter", &validator, "/myapp.php?foo=1&bar=2", tamperedParams, signedSignature, http.StatusForbidden},
+		{"changed query", &validator, "/myapp.php?foo=1&bar=3", signedParams, signedSignature, http.StatusForbidden},
+		{"wrong signature", &validator, "/myapp.php?foo=1&bar=2", signedParams, "RSOYDt4T1cUTdK1PDd93/VVr8B8=", http.StatusForbidden},
+		{"no signature", &validator, "/myapp.php?foo=1&bar=2", signedParams, "", http.StatusForbidden},
+		{"checking off", nil, "/myapp.php?foo=1&bar=2", signedParams, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.twilioValidator = tt.validator
+			handler := s.verifyTwilioSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.params.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.signature != "" {
+				r.Header.Set("X-Twilio-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestTwilioValidatorFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		validate  string
+		authToken string
+		on        bool
+		wantErr   bool
+	}{
+		{"on by default", "", "12345", true, false},
+		{"turned on", "true", "12345", true, false},
+		{"turned off", "false", "", false, false},
+		{"no auth token", "", "", false, true},
+		{"not a bool", "sometimes", "12345", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TWILIO_VALIDATE_SIGNATURE", tt.validate)
+			t.Setenv("TWILIO_AUTH_TOKEN", tt.authToken)
+			validator, err := twilioValidatorFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if on := validator != nil; on != tt.on {
+				t.Errorf("checking = %v, want %v", on, tt.on)
+			}
+		})
+	}
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		tls       bool
+		forwarded string
+		want      string
+	}{
+		{"configured", "https://omnicall.example.com/", false, "", "https://omnicall.example.com"},
+		{"plain request", "", false, "", "http://example.com"},
+		{"tls request", "", true, "", "https://example.com"},
+		{"behind a tls proxy", "", false, "https", "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUBLIC_BASE_URL", tt.env)
+			r := httptest.NewRequest(http.MethodPost, "/twilio/voice", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			if got := publicBaseURL(r); got != tt.want {
+				t.Errorf("publicBaseURL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"omnicall/db"
+)
+
+type SimulateIncomingRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type SimulateIncomingResponse struct {
+	Success  bool         `json:"success"`
+	CallID   string       `json:"call_id"`
+	AgentID  string       `json:"agent_id,omitempty"`
+	Blocked  bool         `json:"blocked"`
+	Customer *db.Customer `json:"customer,omitempty"`
+	TwiML    string       `json:"twiml"`
+}
+
+// simulateIncomingCall runs the incoming-call pipeline for a made-up call so
+// routing and customer matching can be checked without a phone. Nothing is
+// dialed and nothing is recorded against the chosen agent. The call has to
+// be to one of the admin's own company's numbers, and defaults to its
+// caller number, so nobody can look into another company's routing.
+func (s *Server) simulateIncomingCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req SimulateIncomingRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.From == "" {
+		respondError(w, http.StatusBadRequest, "From number is required")
+		return
+	}
+
+	if req.To == "" {
+		number, err := s.queries.GetCompanyCallerNumber(r.Context(), user.CompanyID)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Company has no phone number to simulate a call to")
+			return
+		}
+		req.To = number.Phone
+	}
+	company, number := s.numberCompany(r.Context(), req.To)
+	if company == nil || company.ID != user.CompanyID {
+		respondError(w, http.StatusNotFound, "Phone number not found")
+		return
+	}
+
+	call := CallContext{
+		CallID: newCallID(),
+		From:   req.From,
+		To:     req.To,
+	}
+
+	logInfof(r.Context(), "🧪 Simulated incoming call by %s: From=%s, To=%s, CallID=%s", user.AgentID, req.From, req.To, call.CallID)
+
+	// A dry run mustn't move round-robin on for the next real call
+	dryRun := *s
+	dryRun.routers = snapshotRouters(s.routers)
+	route := dryRun.routeIncomingCall(r.Context(), call, company, number, false, false)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SimulateIncomingResponse{
+		Success:  true,
+		CallID:   call.CallID,
+		AgentID:  route.AgentID,
+		Blocked:  route.Blocked,
+		Customer: route.Customer,
+		TwiML:    route.TwiML,
+	})
+}
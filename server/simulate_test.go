@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSimulateIncomingCall(t *testing.T) {
+	s := newTestServer(t)
+	own := addCompany(t, s, "Own")
+	other := addCompany(t, s, "Other")
+	admin := addAgent(t, s, own, "admin1", roleAdmin)
+	addAgent(t, s, own, "agent1", roleAgent)
+	addAgent(t, s, other, "agent2", roleAgent)
+	addNumber(t, s, own, "+27110000001")
+	addNumber(t, s, other, "+27110000002")
+	exec(t, s, "INSERT INTO company_settings (company_id, key, value) VALUES (?, ?, ?)", own, settingRoutingStrategy, routingRoundRobin)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		agent  string
+	}{
+		{"own number", `{"from":"+27825550000","to":"+27110000001"}`, http.StatusOK, "admin1"},
+		{"defaults to own number", `{"from":"+27825550000"}`, http.StatusOK, "admin1"},
+		{"other company's number", `{"from":"+27825550000","to":"+27110000002"}`, http.StatusNotFound, ""},
+		{"unregistered number", `{"from":"+27825550000","to":"+27110000009"}`, http.StatusNotFound, ""},
+		{"no caller", `{"to":"+27110000001"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/twilio/simulate-incoming", strings.NewReader(tt.body)), admin)
+			w := httptest.NewRecorder()
+			s.simulateIncomingCall(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp SimulateIncomingResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			// Round-robin would have moved on to agent1 had the first run
+			// touched the live router
+			if resp.AgentID != tt.agent {
+				t.Errorf("agent = %q, want %q", resp.AgentID, tt.agent)
+			}
+		})
+	}
+}
+
+func TestSnapshotRoutersLeavesLiveStateAlone(t *testing.T) {
+	s := newTestServer(t)
+	company := addCompany(t, s, "Acme")
+	addAgent(t, s, company, "a1", roleAgent)
+	addAgent(t, s, company, "a2", roleAgent)
+	acme, _ := s.queries.GetCompany(t.Context(), company)
+
+	live := s.routers[routingRoundRobin]
+	copied := snapshotRouters(s.routers)[routingRoundRobin]
+	for range 3 {
+		copied.SelectAgent(t.Context(), &acme, CallContext{})
+	}
+	if agent, _ := live.SelectAgent(t.Context(), &acme, CallContext{}); agent != "a1" {
+		t.Errorf("live router picked %q after a snapshot was used, want a1", agent)
+	}
+}
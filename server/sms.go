@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// maxSMSSegments caps how many parts a message may be split into, which
+// keeps a stray paste from costing dozens of messages.
+const maxSMSSegments = 10
+
+// messagePreviewLength is how much of a thread's last message the
+// conversation list shows.
+const messagePreviewLength = 80
+
+// The GSM 03.38 alphabet. Extension characters take two septets each, and
+// anything outside both sends the whole message as UCS-2.
+const (
+	gsm7Chars    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7ExtChars = "^{}\\[~]|€\f"
+)
+
+type SMSSendRequest struct {
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+type SMSSendResponse struct {
+	Success    bool        `json:"success"`
+	MessageSid string      `json:"message_sid"`
+	Status     string      `json:"status"`
+	Message    *db.Message `json:"message,omitempty"`
+}
+
+type MessagesResponse struct {
+	Success    bool         `json:"success"`
+	Messages   []db.Message `json:"messages"`
+	Pagination Pagination   `json:"pagination"`
+}
+
+type ConversationsResponse struct {
+	Success       bool                               `json:"success"`
+	Conversations []db.ListConversationsByCompanyRow `json:"conversations"`
+}
+
+// smsSegments counts the parts body goes out as. One part holds 160 GSM-7
+// septets or 70 UCS-2 characters; longer messages lose a little of each
+// part to the header that joins them back up.
+func smsSegments(body string) int {
+	septets := 0
+	gsm := true
+	for _, char := range body {
+		switch {
+		case strings.ContainsRune(gsm7Chars, char):
+			septets++
+		case strings.ContainsRune(gsm7ExtChars, char):
+			septets += 2
+		default:
+			gsm = false
+		}
+	}
+
+	if gsm {
+		if septets <= 160 {
+			return 1
+		}
+		return (septets + 152) / 153
+	}
+	units := len(utf16.Encode([]rune(body)))
+	if units <= 70 {
+		return 1
+	}
+	return (units + 66) / 67
+}
+
+// sendSMS texts a customer from the company's number and keeps a copy of
+// the message, failed sends included.
+func (s *Server) sendSMS(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req SMSSendRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	to, ok := toE164(req.To, defaultPhoneRegion())
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Recipient must be a valid phone number")
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		respondError(w, http.StatusBadRequest, "Message body is required")
+		return
+	}
+	if segments := smsSegments(req.Body); segments > maxSMSSegments {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Message is too long: %d segments, at most %d allowed", segments, maxSMSSegments))
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+	from := s.companyCallerID(r.Context(), user.CompanyID)
+	if from == "" {
+		respondError(w, http.StatusInternalServerError, "No phone number to send from")
+		return
+	}
+
+	params := &openapi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(from)
+	params.SetBody(req.Body)
+	sent, sendErr := s.twilio.Api.CreateMessage(params)
+
+	record := db.CreateMessageParams{
+		CompanyID:  user.CompanyID,
+		CustomerID: s.messageCustomer(r.Context(), user.CompanyID, to),
+		Direction:  "outbound",
+		FromNumber: from,
+		ToNumber:   to,
+		Body:       req.Body,
+		Status:     "failed",
+		AgentID:    nullString(user.AgentID),
+	}
+	if sendErr != nil {
+		record.ErrorMessage = nullString(sendErr.Error())
+	} else {
+		if sent.Sid != nil {
+			record.MessageSid = nullString(*sent.Sid)
+		}
+		record.Status = "queued"
+		if sent.Status != nil {
+			record.Status = *sent.Status
+		}
+	}
+
+	message, err := s.queries.CreateMessage(r.Context(), record)
+	if err != nil {
+		logErrorf(r.Context(), "Error recording message to %s: %v", to, err)
+	}
+
+	if sendErr != nil {
+		logErrorf(r.Context(), "Error sending SMS to %s for %s: %v", to, user.AgentID, sendErr)
+		respondError(w, http.StatusBadGateway, "Failed to send message")
+		return
+	}
+
+	logInfof(r.Context(), "💬 SMS %s sent by %s to %s", record.MessageSid.String, user.AgentID, to)
+	s.audit(r.Context(), user, auditSMSSend, to, record.MessageSid.String)
+
+	resp := SMSSendResponse{
+		Success:    true,
+		MessageSid: record.MessageSid.String,
+		Status:     record.Status,
+	}
+	if err == nil {
+		resp.Message = &message
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleIncomingSMS keeps a text sent to one of a company's numbers, filed
+// under the customer who sent it, and tells the company's agents about it.
+func (s *Server) handleIncomingSMS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	from := r.FormValue("From")
+	to := r.FormValue("To")
+	messageSID := r.FormValue("MessageSid")
+
+	company, _ := s.numberCompany(r.Context(), to)
+	if company == nil {
+		logInfof(r.Context(), "Ignoring SMS %s to %s, which isn't registered to a company", messageSID, to)
+	} else {
+		message, err := s.queries.CreateMessage(r.Context(), db.CreateMessageParams{
+			MessageSid: nullString(messageSID),
+			CompanyID:  company.ID,
+			CustomerID: s.messageCustomer(r.Context(), company.ID, from),
+			Direction:  "inbound",
+			FromNumber: from,
+			ToNumber:   to,
+			Body:       r.FormValue("Body"),
+			Status:     "received",
+		})
+		if err != nil {
+			logErrorf(r.Context(), "Error storing SMS %s: %v", messageSID, err)
+		} else {
+			logInfof(r.Context(), "💬 SMS %s received from %s for company %d", messageSID, from, company.ID)
+			s.notify(realtimeEvent{
+				Type:      "sms_received",
+				CompanyID: company.ID,
+				Data:      message,
+			})
+		}
+	}
+
+	// Nothing is sent back automatically
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response></Response>`))
+}
+
+// messageCustomer is the company's customer with the given number, if any,
+// for filing a message under.
+func (s *Server) messageCustomer(ctx context.Context, companyID int64, phone string) sql.NullInt64 {
+	customer, err := s.findCustomerByPhone(ctx, companyID, phone)
+	if err != nil {
+		logErrorf(ctx, "Error looking up customer for %s: %v", phone, err)
+	}
+	if customer == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: customer.ID, Valid: true}
+}
+
+// getMessages returns the text thread with one customer, oldest first.
+func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	customerID, err := strconv.ParseInt(r.URL.Query().Get("customer_id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "customer_id is required")
+		return
+	}
+	if _, err := s.queries.GetCompanyCustomer(r.Context(), db.GetCompanyCustomerParams{
+		ID:        customerID,
+		CompanyID: user.CompanyID,
+	}); err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get messages")
+		return
+	}
+
+	customer := sql.NullInt64{Int64: customerID, Valid: true}
+	page := parsePagination(r)
+
+	total, err := s.queries.CountMessagesByCustomer(r.Context(), db.CountMessagesByCustomerParams{
+		CompanyID:  user.CompanyID,
+		CustomerID: customer,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get messages")
+		return
+	}
+	page.Total = total
+
+	messages, err := s.queries.ListMessagesByCustomer(r.Context(), db.ListMessagesByCustomerParams{
+		CompanyID:  user.CompanyID,
+		CustomerID: customer,
+		Limit:      page.PageSize,
+		Offset:     page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get messages")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MessagesResponse{
+		Success:    true,
+		Messages:   messages,
+		Pagination: page,
+	})
+}
+
+// getConversations lists the company's text threads, most recent first,
+// each with the start of its last message.
+func (s *Server) getConversations(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	conversations, err := s.queries.ListConversationsByCompany(r.Context(), user.CompanyID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get conversations")
+		return
+	}
+	for i := range conversations {
+		if preview := []rune(conversations[i].Body); len(preview) > messagePreviewLength {
+			conversations[i].Body = string(preview[:messagePreviewLength]) + "…"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConversationsResponse{
+		Success:       true,
+		Conversations: conversations,
+	})
+}
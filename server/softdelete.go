@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Soft delete convention: deletable tables carry a nullable deleted_at, reads
+// filter on "deleted_at IS NULL", and deleting or restoring only flips that
+// column so history that references the row stays intact.
+
+var errDeleteSelf = errors.New("cannot delete yourself")
+
+// errCompanyInUse keeps a company from being deleted out from under the
+// people and records still in it.
+var errCompanyInUse = errors.New("company still has users or customers")
+
+// includeDeleted reads ?include_deleted=1, which adds deleted rows to a list
+// so they can be found and restored. Only admins may ask for it; anyone else
+// is refused, and ok comes back false.
+func includeDeleted(w http.ResponseWriter, r *http.Request) (include, ok bool) {
+	include, _ = strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+	if !include {
+		return false, true
+	}
+	if user, _ := userFromContext(r.Context()); user == nil || user.Role != roleAdmin {
+		respondError(w, http.StatusForbidden, "include_deleted requires the "+roleAdmin+" role")
+		return false, false
+	}
+	return true, true
+}
+
+// softDeleteFunc deletes or restores one row on behalf of user and reports
+// how many rows it changed.
+type softDeleteFunc func(ctx context.Context, id int64, user *db.User) (int64, error)
+
+type SoftDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// softDeleteHandler wraps a delete or restore in the id parsing, error
+// mapping and logging the entities share. action reads "deleted" or "restored".
+func (s *Server) softDeleteHandler(entity, action string, apply softDeleteFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := userFromContext(r.Context())
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid "+strings.ToLower(entity)+" id")
+			return
+		}
+
+		rows, err := apply(r.Context(), id, user)
+		if errors.Is(err, errDeleteSelf) {
+			respondError(w, http.StatusBadRequest, "You cannot delete your own account")
+			return
+		}
+		if errors.Is(err, errCompanyInUse) {
+			respondError(w, http.StatusConflict, "Company still has users or customers")
+			return
+		}
+		if isUniqueViolation(err) {
+			// Another live row took the name or email in the meantime
+			respondError(w, http.StatusConflict, entity+" conflicts with an existing record")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to update "+strings.ToLower(entity))
+			return
+		}
+		if rows == 0 {
+			respondError(w, http.StatusNotFound, entity+" not found")
+			return
+		}
+
+		logInfof(r.Context(), "🗑️ %s %d %s by %s", entity, id, action, user.AgentID)
+		s.audit(r.Context(), user, strings.ToLower(entity)+"."+action, strconv.FormatInt(id, 10), "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SoftDeleteResponse{
+			Success: true,
+			Message: entity + " " + action,
+		})
+	}
+}
+
+// deleteCompany deletes the user's own company once it is down to just
+// them: no other users and no customers.
+func (s *Server) deleteCompany(ctx context.Context, id int64, user *db.User) (int64, error) {
+	if id != user.CompanyID {
+		return 0, nil
+	}
+	users, err := s.queries.CountOtherUsersByCompany(ctx, db.CountOtherUsersByCompanyParams{CompanyID: id, ID: user.ID})
+	if err != nil {
+		return 0, err
+	}
+	customers, err := s.queries.CountCustomersByCompany(ctx, db.CountCustomersByCompanyParams{CompanyID: id})
+	if err != nil {
+		return 0, err
+	}
+	if users > 0 || customers > 0 {
+		return 0, errCompanyInUse
+	}
+	return s.queries.SoftDeleteCompany(ctx, id)
+}
+
+func (s *Server) restoreCompany(ctx context.Context, id int64, user *db.User) (int64, error) {
+	if id != user.CompanyID {
+		return 0, nil
+	}
+	return s.queries.RestoreCompany(ctx, id)
+}
+
+func (s *Server) deleteUser(ctx context.Context, id int64, user *db.User) (int64, error) {
+	if id == user.ID {
+		return 0, errDeleteSelf
+	}
+	rows, err := s.queries.SoftDeleteUser(ctx, db.SoftDeleteUserParams{ID: id, CompanyID: user.CompanyID})
+	if err != nil || rows == 0 {
+		return rows, err
+	}
+	// A deleted user is signed out everywhere
+	return rows, s.queries.DeleteSessionsByUser(ctx, id)
+}
+
+func (s *Server) restoreUser(ctx context.Context, id int64, user *db.User) (int64, error) {
+	return s.queries.RestoreUser(ctx, db.RestoreUserParams{ID: id, CompanyID: user.CompanyID})
+}
+
+func (s *Server) deleteCustomer(ctx context.Context, id int64, user *db.User) (int64, error) {
+	return s.queries.SoftDeleteCustomer(ctx, db.SoftDeleteCustomerParams{ID: id, CompanyID: user.CompanyID})
+}
+
+func (s *Server) restoreCustomer(ctx context.Context, id int64, user *db.User) (int64, error) {
+	return s.queries.RestoreCustomer(ctx, db.RestoreCustomerParams{ID: id, CompanyID: user.CompanyID})
+}
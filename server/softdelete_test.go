@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"omnicall/db"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeletedCompanyUsersAreSignedOut(t *testing.T) {
+	s := newTestServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	live := addAgent(t, s, acme, "acme1", roleAgent)
+	gone := addAgent(t, s, globex, "globex1", roleAgent)
+	exec(t, s, "UPDATE users SET password_hash = ?", string(hash))
+	cookies := map[int64]*http.Cookie{live.ID: signIn(t, s, live), gone.ID: signIn(t, s, gone)}
+	exec(t, s, "UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", globex)
+
+	protected := s.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		email   string
+		userID  int64
+		login   int
+		session int
+	}{
+		{"live company", live.Email, live.ID, http.StatusOK, http.StatusOK},
+		{"deleted company", gone.Email, gone.ID, http.StatusUnauthorized, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"`+tt.email+`","password":"correct horse"}`)))
+			if w.Code != tt.login {
+				t.Errorf("login: status = %d, want %d: %s", w.Code, tt.login, w.Body)
+			}
+
+			// Sessions from before the company was deleted stop working too
+			r := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+			r.AddCookie(cookies[tt.userID])
+			w = httptest.NewRecorder()
+			protected.ServeHTTP(w, r)
+			if w.Code != tt.session {
+				t.Errorf("session: status = %d, want %d: %s", w.Code, tt.session, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetCompaniesIncludeDeleted(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	addCompany(t, s, "Globex")
+	initech := addCompany(t, s, "Initech")
+	admin := addAgent(t, s, acme, "acmeadmin", roleAdmin)
+	exec(t, s, "UPDATE companies SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", initech)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"live only", "", []string{"Acme", "Globex"}},
+		{"another company's deleted record stays hidden", "?include_deleted=1", []string{"Acme", "Globex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.getCompanies(w, asUser(httptest.NewRequest(http.MethodGet, "/api/companies"+tt.query, nil), admin))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var resp CompaniesResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			var names []string
+			for _, c := range resp.Companies {
+				names = append(names, c.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.want) || resp.Pagination.Total != int64(len(tt.want)) {
+				t.Errorf("listed %v of %d, want %v", names, resp.Pagination.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeletedCustomers(t *testing.T) {
+	t.Setenv("DEFAULT_PHONE_REGION", "ZA")
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	users := map[string]*db.User{
+		"admin":   addAgent(t, s, acme, "acmeadmin", roleAdmin),
+		"agent":   addAgent(t, s, acme, "acme1", roleAgent),
+		"globex1": addAgent(t, s, globex, "globex1", roleAdmin),
+	}
+	pat, err := exec(t, s, "INSERT INTO customers (company_id, first_name, last_name, phone, phone_normalized) VALUES (?, 'Pat', 'Doe', '071 123 4567', '+27711234567')", acme).LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec(t, s, "INSERT INTO customers (company_id, first_name, last_name) VALUES (?, 'Sam', 'Roe')", acme)
+	voicemail := addVoicemail(t, s, acme, pat, "+27711234567", "https://api.twilio.com/RE1", "2026-01-01 09:00:00")
+	id := strconv.FormatInt(pat, 10)
+
+	call := func(handler http.HandlerFunc, method, target, user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, withURLParams(asUser(httptest.NewRequest(method, target, nil), users[user]), map[string]string{"id": id}))
+		return w
+	}
+	found := func(handler http.HandlerFunc, target, user string) []string {
+		t.Helper()
+		w := call(handler, http.MethodGet, target, user)
+		var resp CustomersResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		var names []string
+		for _, c := range resp.Customers {
+			names = append(names, c.FirstName)
+		}
+		return names
+	}
+	del := s.softDeleteHandler("Customer", "deleted", s.deleteCustomer)
+	restore := s.softDeleteHandler("Customer", "restored", s.restoreCustomer)
+
+	if w := call(del, http.MethodDelete, "/api/customers/"+id, "globex1"); w.Code != http.StatusNotFound {
+		t.Errorf("another company deleting: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := call(del, http.MethodDelete, "/api/customers/"+id, "agent"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w := call(del, http.MethodDelete, "/api/customers/"+id, "agent"); w.Code != http.StatusNotFound {
+		t.Errorf("deleting twice: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Lookups, the list and search no longer find them
+	for _, phone := range []string{"071 123 4567", "+27711234567"} {
+		if w := call(s.getCustomerByPhone, http.MethodGet, "/api/customers/phone?phone="+url.QueryEscape(phone), "agent"); w.Code != http.StatusNotFound {
+			t.Errorf("lookup by %s: status = %d, want %d", phone, w.Code, http.StatusNotFound)
+		}
+	}
+	if customer, err := s.findCustomerByPhone(t.Context(), acme, "+27711234567"); err != nil || customer != nil {
+		t.Errorf("incoming calls find %+v, %v; want nobody", customer, err)
+	}
+	if names := found(s.getCustomers, "/api/customers", "agent"); !slices.Equal(names, []string{"Sam"}) {
+		t.Errorf("listed %v, want [Sam]", names)
+	}
+	if names := found(s.searchCustomers, "/api/customers/search?q=Pat", "agent"); len(names) != 0 {
+		t.Errorf("search found %v, want nobody", names)
+	}
+	if w := call(s.getCustomer, http.MethodGet, "/api/customers/"+id, "agent"); w.Code != http.StatusNotFound {
+		t.Errorf("get: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Old voicemails still point at them, and admins can follow the link
+	var linked int64
+	s.db.QueryRow("SELECT customer_id FROM voicemails WHERE id = ?", voicemail).Scan(&linked)
+	if linked != pat {
+		t.Fatalf("voicemail linked to customer %d, want %d", linked, pat)
+	}
+	if w := call(s.getCustomer, http.MethodGet, "/api/customers/"+id+"?include_deleted=1", "agent"); w.Code != http.StatusForbidden {
+		t.Errorf("agent asking for deleted customers: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := call(s.getCustomer, http.MethodGet, "/api/customers/"+id+"?include_deleted=1", "admin")
+	var resp CustomerResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Customer == nil || resp.Customer.FirstName != "Pat" || !resp.Customer.DeletedAt.Valid {
+		t.Errorf("status = %d, customer %+v; want Pat, marked deleted", w.Code, resp.Customer)
+	}
+	if names := found(s.getCustomers, "/api/customers?include_deleted=1", "admin"); !slices.Equal(names, []string{"Pat", "Sam"}) {
+		t.Errorf("admin listed %v, want [Pat Sam]", names)
+	}
+	if names := found(s.searchCustomers, "/api/customers/search?q=Pat&include_deleted=1", "globex1"); len(names) != 0 {
+		t.Errorf("another company's admin found %v", names)
+	}
+
+	// Restoring brings them back everywhere
+	if w := call(restore, http.MethodPost, "/api/customers/"+id+"/restore", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w := call(s.getCustomerByPhone, http.MethodGet, "/api/customers/phone?phone=071+123+4567", "agent"); w.Code != http.StatusOK {
+		t.Errorf("lookup after restoring: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if names := found(s.searchCustomers, "/api/customers/search?q=Pat", "agent"); !slices.Equal(names, []string{"Pat"}) {
+		t.Errorf("search after restoring found %v, want [Pat]", names)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+type CallTransferRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+type CallTransferResponse struct {
+	Success  bool             `json:"success"`
+	CallSid  string           `json:"call_sid"`
+	Transfer *db.CallTransfer `json:"transfer,omitempty"`
+}
+
+// transferCall hands the customer on the agent's call to another agent in
+// the company. callSid is the agent's (browser) leg; the customer's leg is
+// redirected to ring the new agent and the first agent drops off.
+func (s *Server) transferCall(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req CallTransferRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	target, ok := s.availableAgent(w, r, user, req.AgentID)
+	if !ok {
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	customer, ok := s.liveCustomerLeg(w, r, user, chi.URLParam(r, "callSid"), "Failed to transfer call")
+	if !ok {
+		return
+	}
+
+	query := url.Values{}
+	query.Set("to_agent", target.AgentID)
+	update := &openapi.UpdateCallParams{}
+	update.SetUrl(publicBaseURL(r) + "/twilio/transfer-voice?" + query.Encode())
+	update.SetMethod(http.MethodGet)
+	if _, err := s.twilio.Api.UpdateCall(*customer.Sid, update); err != nil {
+		logErrorf(r.Context(), "Error transferring %s to %s: %v", *customer.Sid, target.AgentID, err)
+		respondError(w, http.StatusBadGateway, "Failed to transfer call")
+		return
+	}
+
+	logInfof(r.Context(), "🔀 Call %s transferred by %s to %s", *customer.Sid, user.AgentID, target.AgentID)
+	s.audit(r.Context(), user, auditCallTransfer, *customer.Sid, target.AgentID)
+
+	resp := CallTransferResponse{Success: true, CallSid: *customer.Sid}
+	if transfer, err := s.recordTransfer(r, []string{*customer.Sid, chi.URLParam(r, "callSid")}, user.AgentID, target.AgentID); err != nil {
+		logErrorf(r.Context(), "Error recording transfer of %s: %v", *customer.Sid, err)
+	} else {
+		resp.Transfer = &transfer
+	}
+	s.notify(realtimeEvent{
+		Type:      "call_transferred",
+		CompanyID: user.CompanyID,
+		AgentID:   target.AgentID,
+		Data:      resp,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// availableAgent looks up the agent a call is being handed to, who must be
+// someone else in user's company and free to take it.
+func (s *Server) availableAgent(w http.ResponseWriter, r *http.Request, user *db.User, agentID string) (*db.User, bool) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		respondError(w, http.StatusBadRequest, "agent_id is required")
+		return nil, false
+	}
+	if agentID == user.AgentID {
+		respondError(w, http.StatusBadRequest, "Cannot hand a call to yourself")
+		return nil, false
+	}
+
+	agent, err := s.queries.GetUserByAgentID(r.Context(), agentID)
+	if err != nil || agent.DeletedAt.Valid || agent.CompanyID != user.CompanyID {
+		respondError(w, http.StatusNotFound, "Agent not found")
+		return nil, false
+	}
+	s.expirePresence(r.Context())
+	status, err := s.queries.GetAgentStatus(r.Context(), agent.AgentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusInternalServerError, "Failed to get agent status")
+		return nil, false
+	}
+	if agentStatusInfo(agent.AgentID, status, time.Now()).Status != "available" {
+		respondError(w, http.StatusConflict, "Agent is not available")
+		return nil, false
+	}
+	return &agent, true
+}
+
+// liveCustomerLeg finds the customer's side of user's call, which must still
+// be in progress. callSID is the agent's own leg, which tells an unknown call
+// from one that has ended.
+func (s *Server) liveCustomerLeg(w http.ResponseWriter, r *http.Request, user *db.User, callSID, failure string) (*openapi.ApiV2010Call, bool) {
+	agentLeg, err := fetchCall(s.twilio.Api, callSID)
+	if errors.Is(err, errCallNotFound) || (err == nil && !isAgentLeg(agentLeg, user.AgentID)) {
+		respondError(w, http.StatusNotFound, "Call not found")
+		return nil, false
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error fetching call %s: %v", callSID, err)
+		respondError(w, http.StatusBadGateway, failure)
+		return nil, false
+	}
+
+	customer, err := customerLeg(s.twilio.Api, callSID, user.AgentID)
+	if errors.Is(err, errCallNotFound) || (err == nil && (customer.Status == nil || *customer.Status != "in-progress")) {
+		respondError(w, http.StatusConflict, "Call is no longer in progress")
+		return nil, false
+	}
+	if err != nil {
+		logErrorf(r.Context(), "Error finding customer leg of %s: %v", callSID, err)
+		respondError(w, http.StatusBadGateway, failure)
+		return nil, false
+	}
+	return customer, true
+}
+
+// recordTransfer adds the transfer to the call's history.
+func (s *Server) recordTransfer(r *http.Request, callSIDs []string, from, to string) (db.CallTransfer, error) {
+	call, err := s.callRecord(r.Context(), callSIDs...)
+	if err != nil {
+		return db.CallTransfer{}, err
+	}
+	return s.queries.CreateCallTransfer(r.Context(), db.CreateCallTransferParams{
+		CallID:      call.ID,
+		FromAgentID: from,
+		ToAgentID:   to,
+	})
+}
+
+// callRecord finds the history row for a call. Inbound calls are kept under
+// the customer's leg and outbound ones under the agent's, so the first of
+// callSIDs with a row wins.
+func (s *Server) callRecord(ctx context.Context, callSIDs ...string) (db.Call, error) {
+	for _, callSID := range callSIDs {
+		call, err := s.queries.GetCallBySid(ctx, callSID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		return call, err
+	}
+	return db.Call{}, sql.ErrNoRows
+}
+
+// isAgentLeg reports whether call is agentID's browser leg, whichever way
+// it was placed.
+func isAgentLeg(call *openapi.ApiV2010Call, agentID string) bool {
+	identity := "client:" + agentID
+	return (call.From != nil && *call.From == identity) || (call.To != nil && *call.To == identity)
+}
+
+// handleTransferVoice rings the agent a call is being transferred to. If
+// they don't pick up, the dial action treats it like any other missed call.
+func (s *Server) handleTransferVoice(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("to_agent")
+
+	dialAttrs := ""
+	if agent, err := s.queries.GetUserByAgentID(r.Context(), agentID); err == nil && s.recordingEnabled(r.Context(), agent.CompanyID) {
+		dialAttrs = dialRecordingAttrs
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Please hold while we transfer your call.</Say>
+	<Dial action="/twilio/dial-complete?agent_id=%s"%s>
+		<Client%s>%s</Client>
+	</Dial>
+</Response>`, url.QueryEscape(agentID), dialAttrs, clientStatusCallback, html.EscapeString(agentID))))
+}
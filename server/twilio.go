@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twilio/twilio-go"
+)
+
+// Twilio refuses access tokens meant to last longer than a day
+const maxTwilioTokenTTL = 24 * time.Hour
+
+// twilioConfig is the Twilio account the server works through, read once at
+// startup.
+type twilioConfig struct {
+	accountSID   string
+	apiKeySID    string
+	apiKeySecret string
+	twimlAppSID  string
+	tokenTTL     time.Duration
+}
+
+// twilioConfigFromEnv reads the Twilio account settings and TWILIO_TOKEN_TTL,
+// how long an agent's access token lasts (default 1h, at most 24h).
+func twilioConfigFromEnv() (twilioConfig, error) {
+	cfg := twilioConfig{
+		accountSID:   os.Getenv("TWILIO_ACCOUNT_SID"),
+		apiKeySID:    os.Getenv("TWILIO_API_KEY_SID"),
+		apiKeySecret: os.Getenv("TWILIO_API_KEY_SECRET"),
+		twimlAppSID:  os.Getenv("TWILIO_TWIML_APP_SID"),
+		tokenTTL:     time.Hour,
+	}
+	if value := os.Getenv("TWILIO_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < time.Minute || ttl > maxTwilioTokenTTL {
+			return cfg, errors.New("TWILIO_TOKEN_TTL must be a duration between 1m and 24h")
+		}
+		cfg.tokenTTL = ttl
+	}
+	return cfg, nil
+}
+
+// hasCredentials reports whether the REST API and access tokens can be
+// used at all.
+func (c twilioConfig) hasCredentials() bool {
+	return c.accountSID != "" && c.apiKeySID != "" && c.apiKeySecret != ""
+}
+
+// missing names the settings left unset.
+func (c twilioConfig) missing() []string {
+	var missing []string
+	for _, setting := range [][2]string{
+		{"TWILIO_ACCOUNT_SID", c.accountSID},
+		{"TWILIO_API_KEY_SID", c.apiKeySID},
+		{"TWILIO_API_KEY_SECRET", c.apiKeySecret},
+		{"TWILIO_TWIML_APP_SID", c.twimlAppSID},
+	} {
+		if setting[1] == "" {
+			missing = append(missing, setting[0])
+		}
+	}
+	return missing
+}
+
+// runTwilioStartupCheck verifies the Twilio settings once at boot so a
+// missing variable or bad key shows up in the deploy logs rather than on an
+// agent's first token request. TWILIO_STARTUP_CHECK selects "warn"
+// (default), "fail" or "off"; only "fail" returns an error. client is nil
+// when the credentials aren't set.
+func runTwilioStartupCheck(cfg twilioConfig, client *twilio.RestClient) error {
+	mode := os.Getenv("TWILIO_STARTUP_CHECK")
+	if mode == "" {
+		mode = "warn"
+	}
+	if mode == "off" {
+		return nil
+	}
+
+	// Local development often runs without Twilio at all
+	missing := cfg.missing()
+	if len(missing) == 4 {
+		log.Println("Twilio credentials not set, skipping startup check")
+		return nil
+	}
+	if len(missing) > 0 {
+		if mode == "fail" {
+			return fmt.Errorf("settings incomplete, missing %s", strings.Join(missing, ", "))
+		}
+		logWarnf(context.Background(), "Twilio settings incomplete, calls will not work: missing %s", strings.Join(missing, ", "))
+		if client == nil {
+			return nil
+		}
+	}
+
+	if err := checkTwilioCredentials(client, cfg.accountSID); err != nil {
+		if mode == "fail" {
+			return fmt.Errorf("credential check failed: %w", err)
+		}
+		logWarnf(context.Background(), "Twilio credential check failed, calls will not work: %v", err)
+		return nil
+	}
+
+	log.Println("✅ Twilio credentials verified")
+	return nil
+}
+
+// checkTwilioCredentials fetches the account, which is about the cheapest
+// authenticated request the REST API offers.
+func checkTwilioCredentials(client *twilio.RestClient, accountSID string) error {
+	_, err := client.Api.FetchAccount(accountSID)
+	return err
+}
+
+var errTwilioNotConfigured = errors.New("twilio credentials not set")
+
+// newTwilioClient builds the REST client from the configuration. The server
+// makes one at startup and shares it between requests.
+func newTwilioClient(cfg twilioConfig) (*twilio.RestClient, error) {
+	if !cfg.hasCredentials() {
+		return nil, errTwilioNotConfigured
+	}
+
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username:   cfg.apiKeySID,
+		Password:   cfg.apiKeySecret,
+		AccountSid: cfg.accountSID,
+	})
+	client.SetTimeout(10 * time.Second)
+	return client, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/twilio/twilio-go"
+)
+
+func TestRunTwilioStartupCheck(t *testing.T) {
+	full := twilioConfig{accountSID: "ACtest", apiKeySID: "SKtest", apiKeySecret: "secret", twimlAppSID: "APtest"}
+	noApp := twilioConfig{accountSID: "ACtest", apiKeySID: "SKtest", apiKeySecret: "secret"}
+
+	tests := []struct {
+		name    string
+		mode    string
+		cfg     twilioConfig
+		client  bool // whether the credentials were enough for a client
+		invalid bool // Twilio turns the credentials down
+		wantErr bool
+		checked bool   // whether Twilio was asked
+		logged  string // empty when nothing is warned about
+	}{
+		{"valid", "", full, true, false, false, true, ""},
+		{"invalid, warning", "warn", full, true, true, false, true, "credential check failed"},
+		{"invalid, failing", "fail", full, true, true, true, true, ""},
+		{"valid, failing", "fail", full, true, false, false, true, ""},
+		{"turned off", "off", full, true, true, false, false, ""},
+		{"nothing set in development", "fail", twilioConfig{}, false, false, false, false, ""},
+		{"incomplete, warning", "warn", noApp, true, false, false, true, "TWILIO_TWIML_APP_SID"},
+		{"incomplete without credentials", "warn", twilioConfig{accountSID: "ACtest"}, false, false, false, false, "TWILIO_API_KEY_SID"},
+		{"incomplete, failing", "fail", noApp, true, false, true, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TWILIO_STARTUP_CHECK", tt.mode)
+			logs := captureLogs(t)
+			fake := &fakeTwilio{fail: tt.invalid}
+			var client *twilio.RestClient
+			if tt.client {
+				client = twilio.NewRestClientWithParams(twilio.ClientParams{Client: fake})
+			}
+
+			err := runTwilioStartupCheck(tt.cfg, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error = %v", err, tt.wantErr)
+			}
+			if checked := len(fake.requests) > 0; checked != tt.checked {
+				t.Errorf("asked Twilio = %v, want %v", checked, tt.checked)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != (tt.logged != "") || !strings.Contains(logs.String(), tt.logged) {
+				t.Errorf("logs = %q, want a warning about %q", logs, tt.logged)
+			}
+		})
+	}
+}
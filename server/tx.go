@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"omnicall/db"
+)
+
+// withTx runs fn against queries in a single transaction, committing when
+// it returns nil and rolling back otherwise. Handlers that write more than
+// once use it so a failure part way doesn't leave half the change behind.
+func (s *Server) withTx(ctx context.Context, fn func(q *db.Queries) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(s.queries.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"omnicall/db"
+)
+
+func TestWithTx(t *testing.T) {
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name string
+		fail error
+		kept bool
+	}{
+		{"commits", nil, true},
+		{"rolls back", errBroken, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			err := s.withTx(t.Context(), func(q *db.Queries) error {
+				if _, err := q.CreateCompany(t.Context(), "Acme"); err != nil {
+					return err
+				}
+				return tt.fail
+			})
+			if !errors.Is(err, tt.fail) {
+				t.Fatalf("error = %v, want %v", err, tt.fail)
+			}
+			var companies int
+			s.db.QueryRow("SELECT COUNT(*) FROM companies").Scan(&companies)
+			if kept := companies == 1; kept != tt.kept {
+				t.Errorf("company kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+// failSessions makes every new session fail to insert, so whatever was
+// written before it in the same transaction has to be undone.
+func failSessions(t *testing.T, s *Server) {
+	t.Helper()
+	exec(t, s, "CREATE TRIGGER fail_sessions BEFORE INSERT ON sessions BEGIN SELECT RAISE(ABORT, 'sessions unavailable'); END")
+}
+
+func TestRegisterWithoutSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		company string
+	}{
+		{"joining a company", `"company_id":1`},
+		{"starting a company", `"company_name":"Initech"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			addCompany(t, s, "Acme")
+			failSessions(t, s)
+
+			body := `{"email":"pat@example.com","password":"password1","firstname":"Pat","lastname":"Doe","agent_id":"pat",` + tt.company + `}`
+			w := httptest.NewRecorder()
+			s.register(w, httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body)))
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+			}
+
+			var users, companies int
+			s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users)
+			s.db.QueryRow("SELECT COUNT(*) FROM companies").Scan(&companies)
+			if users != 0 || companies != 1 {
+				t.Errorf("left %d users and %d companies, want 0 and 1", users, companies)
+			}
+		})
+	}
+}
+
+func TestLoginWithoutSession(t *testing.T) {
+	s := newTestServer(t)
+	user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	exec(t, s, "UPDATE users SET password_hash = ?, failed_login_attempts = 2 WHERE id = ?", string(hash), user.ID)
+	failSessions(t, s)
+
+	w := httptest.NewRecorder()
+	s.login(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"agent1@example.com","password":"correct horse"}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+	// The failures are only forgiven along with a session
+	stored, _ := s.queries.GetUserByID(t.Context(), user.ID)
+	if stored.FailedLoginAttempts != 2 {
+		t.Errorf("failed login attempts = %d, want 2", stored.FailedLoginAttempts)
+	}
+}
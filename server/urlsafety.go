@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Hostnames that only mean something inside a cloud provider's network
+var blockedHostnames = map[string]bool{
+	"localhost":                true,
+	"metadata":                 true,
+	"metadata.google.internal": true,
+	"instance-data":            true,
+}
+
+var errUnsafeAddress = errors.New("URL points at an internal address")
+
+// safeExternalURL checks that a configured URL points somewhere on the public
+// internet, so admin-provided URLs (hold music, greetings, webhooks, export
+// targets) can't be used to reach this server's own network. Plain http is
+// only accepted when requireHTTPS is false.
+func safeExternalURL(ctx context.Context, raw string, requireHTTPS bool) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("URL must be absolute")
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && !requireHTTPS:
+	default:
+		if requireHTTPS {
+			return nil, errors.New("URL must use https")
+		}
+		return nil, errors.New("URL must use http or https")
+	}
+	if u.User != nil {
+		return nil, errors.New("URL must not contain credentials")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if blockedHostnames[host] || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return nil, errUnsafeAddress
+	}
+
+	// Resolve now so names that point inside the network are caught too.
+	// Delivery still goes through safeHTTPClient, since DNS can change.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("URL host %s can't be resolved", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return nil, errUnsafeAddress
+		}
+	}
+	return u, nil
+}
+
+// isPublicIP reports whether ip is routable on the public internet. Loopback,
+// private, link-local (which covers the 169.254.169.254 metadata service),
+// carrier-grade NAT and unspecified addresses are not.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		// 0.0.0.0/8 and 100.64.0.0/10
+		if ip4[0] == 0 || ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+			return false
+		}
+	}
+	return true
+}
+
+// safeHTTPClient is for requests to configured URLs. It checks the address
+// it actually connects to, so a name that resolved to a public address at
+// registration can't be re-pointed inside the network later, and it
+// re-validates every redirect.
+var safeHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return errUnsafeAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		_, err := safeExternalURL(req.Context(), req.URL.String(), via[0].URL.Scheme == "https")
+		return err
+	},
+}
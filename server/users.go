@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"omnicall/db"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Twilio rejects client identities longer than this
+const maxAgentIDLength = 121
+
+type UsersResponse struct {
+	Success    bool                       `json:"success"`
+	Users      []db.ListUsersByCompanyRow `json:"users"`
+	Pagination Pagination                 `json:"pagination"`
+}
+
+// UserCallerNumberRequest gives an agent one of the company's numbers to
+// dial out from. A null phone_number_id puts them back on the company's.
+type UserCallerNumberRequest struct {
+	PhoneNumberID *int64 `json:"phone_number_id"`
+}
+
+type UserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// getUsers lists the users of the admin's own company a page at a time,
+// optionally only those whose name or email contains search.
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	page := parsePagination(r)
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	total, err := s.queries.CountUsersByCompany(r.Context(), db.CountUsersByCompanyParams{
+		CompanyID: user.CompanyID,
+		Search:    search,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+	page.Total = total
+
+	users, err := s.queries.ListUsersByCompany(r.Context(), db.ListUsersByCompanyParams{
+		CompanyID: user.CompanyID,
+		Search:    search,
+		Limit:     page.PageSize,
+		Offset:    page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsersResponse{
+		Success:    true,
+		Users:      users,
+		Pagination: page,
+	})
+}
+
+// setUserCallerNumber chooses the number a user's outbound calls show.
+func (s *Server) setUserCallerNumber(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req UserCallerNumberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	details := "company number"
+	var numberID sql.NullInt64
+	if req.PhoneNumberID != nil {
+		number, err := s.queries.GetCompanyPhoneNumber(r.Context(), db.GetCompanyPhoneNumberParams{
+			ID:        *req.PhoneNumberID,
+			CompanyID: user.CompanyID,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondValidationError(w, errCodeInvalidNumber, "Phone number not found", map[string]string{"phone_number_id": "Not one of your company's numbers"})
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to update caller number")
+			return
+		}
+		numberID = sql.NullInt64{Int64: number.ID, Valid: true}
+		details = number.Phone
+	}
+
+	rows, err := s.queries.SetUserCallerNumber(r.Context(), db.SetUserCallerNumberParams{
+		CallerNumberID: numberID,
+		ID:             id,
+		CompanyID:      user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update caller number")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	s.audit(r.Context(), user, auditUserCallerNumber, strconv.FormatInt(id, 10), details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// setUserRole changes what a user of the admin's company may do. Admins
+// can't change their own role, so a company is never left without one.
+func (s *Server) setUserRole(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+	if id == user.ID {
+		respondError(w, http.StatusBadRequest, "You can't change your own role")
+		return
+	}
+
+	var req UserRoleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !slices.Contains(userRoles, req.Role) {
+		respondValidationError(w, errCodeInvalidBody, "Role must be one of: "+strings.Join(userRoles, ", "), map[string]string{"role": "Unknown role"})
+		return
+	}
+
+	rows, err := s.queries.SetUserRole(r.Context(), db.SetUserRoleParams{
+		Role:      req.Role,
+		ID:        id,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	s.audit(r.Context(), user, auditUserRole, strconv.FormatInt(id, 10), req.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// validateAgentID ensures an agent ID is usable as a Twilio client identity,
+// which is what <Client> dials route on.
+func validateAgentID(agentID string) error {
+	if agentID == "" {
+		return errors.New("Agent ID is required")
+	}
+	if len(agentID) > maxAgentIDLength {
+		return fmt.Errorf("Agent ID must be at most %d characters", maxAgentIDLength)
+	}
+	for _, char := range agentID {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '_' || char == '-' || char == '.') {
+			return errors.New("Agent ID may only contain letters, numbers, underscores, hyphens and periods")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSetUserRole(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	globex := addCompany(t, s, "Globex")
+	admin := addAgent(t, s, acme, "admin1", roleAdmin)
+	agent := addAgent(t, s, acme, "agent1", roleAgent)
+	outsider := addAgent(t, s, globex, "agent2", roleAgent)
+
+	tests := []struct {
+		name   string
+		id     int64
+		role   string
+		status int
+	}{
+		{"promote to supervisor", agent.ID, roleSupervisor, http.StatusOK},
+		{"back to agent", agent.ID, roleAgent, http.StatusOK},
+		{"unknown role", agent.ID, "owner", http.StatusBadRequest},
+		{"own role", admin.ID, roleAgent, http.StatusBadRequest},
+		{"another company's user", outsider.ID, roleAdmin, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := strconv.FormatInt(tt.id, 10)
+			r := asUser(httptest.NewRequest(http.MethodPut, "/api/users/"+id+"/role", strings.NewReader(`{"role":"`+tt.role+`"}`)), admin)
+			r = withURLParams(r, map[string]string{"id": id})
+			w := httptest.NewRecorder()
+			s.setUserRole(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			user, _ := s.queries.GetUserByID(t.Context(), tt.id)
+			if user.Role != tt.role {
+				t.Errorf("role = %q, want %q", user.Role, tt.role)
+			}
+		})
+	}
+}
+
+func TestUserResponsesOmitPasswordHash(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	admin := addAgent(t, s, acme, "admin1", roleAdmin)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	exec(t, s, "UPDATE users SET password_hash = ? WHERE id = ?", string(hash), admin.ID)
+	// Loaded as requireAuth loads it, hash and all
+	withHash, _ := s.queries.GetUserByID(t.Context(), admin.ID)
+	admin = &withHash
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		handler http.HandlerFunc
+	}{
+		{"register", httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(
+			`{"email":"new@example.com","password":"password1","firstname":"New","lastname":"User","agent_id":"new1","company_id":`+strconv.FormatInt(acme, 10)+`}`)), s.register},
+		{"login", httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"admin1@example.com","password":"correct horse"}`)), s.login},
+		{"current user", asUser(httptest.NewRequest(http.MethodGet, "/api/auth/me", nil), admin), s.getCurrentUser},
+		{"user list", asUser(httptest.NewRequest(http.MethodGet, "/api/users", nil), admin), s.getUsers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.request)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			body := w.Body.String()
+			if strings.Contains(body, "password") {
+				t.Errorf("response mentions a password: %s", body)
+			}
+			rows, err := s.db.Query("SELECT password_hash FROM users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var stored string
+				rows.Scan(&stored)
+				if strings.Contains(body, stored) {
+					t.Errorf("response contains a password hash: %s", body)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAgentID(t *testing.T) {
+	tests := []struct {
+		name    string
+		agentID string
+		wantErr bool
+	}{
+		{"letters and digits", "agent1", false},
+		{"every allowed symbol", "pat.doe_2-b", false},
+		{"exactly the maximum", strings.Repeat("a", maxAgentIDLength), false},
+		{"one past the maximum", strings.Repeat("a", maxAgentIDLength+1), true},
+		{"empty", "", true},
+		{"space", "pat doe", true},
+		{"client prefix", "client:pat", true},
+		{"non-ASCII letter", "josé", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAgentID(tt.agentID); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
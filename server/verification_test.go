@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendVerificationKeepsTokenOutOfLogs(t *testing.T) {
+	s := newTestServer(t)
+	user := addAgent(t, s, addCompany(t, s, "Acme"), "agent1", roleAgent)
+	logs := captureLogs(t)
+
+	s.sendVerification(httptest.NewRequest(http.MethodPost, "/api/auth/resend-verification", nil), user)
+
+	var issued int
+	s.db.QueryRow("SELECT COUNT(*) FROM email_verifications WHERE user_id = ?", user.ID).Scan(&issued)
+	if issued != 1 {
+		t.Fatalf("%d verification tokens issued, want 1", issued)
+	}
+	for _, secret := range []string{"token=", user.Email} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("logs give away %q:\n%s", secret, logs)
+		}
+	}
+}
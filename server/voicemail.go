@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"omnicall/db"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+var (
+	errVoicemailNotFound = errors.New("voicemail not found")
+	errVoicemailDeleted  = errors.New("voicemail recording deleted")
+)
+
+type VoicemailReplayRequest struct {
+	VoicemailID int64 `json:"voicemail_id"`
+}
+
+type VoicemailReplayResponse struct {
+	Success   bool          `json:"success"`
+	CallSid   string        `json:"call_sid"`
+	Voicemail *db.Voicemail `json:"voicemail"`
+}
+
+type VoicemailUpdate struct {
+	Read *bool `json:"read"`
+}
+
+type VoicemailListResponse struct {
+	Success    bool           `json:"success"`
+	Voicemails []db.Voicemail `json:"voicemails"`
+	Unread     int64          `json:"unread"`
+	Pagination Pagination     `json:"pagination"`
+}
+
+type VoicemailResponse struct {
+	Success   bool          `json:"success"`
+	Voicemail *db.Voicemail `json:"voicemail"`
+}
+
+// getVoicemails lists the company's voicemails, newest first, with how many
+// nobody has listened to yet. ?unread=true leaves out the ones already read.
+func (s *Server) getVoicemails(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	unreadOnly, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	page := parsePagination(r)
+
+	total, err := s.queries.CountVoicemailsByCompany(r.Context(), db.CountVoicemailsByCompanyParams{
+		CompanyID:  user.CompanyID,
+		UnreadOnly: unreadOnly,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemails")
+		return
+	}
+	page.Total = total
+
+	unread, err := s.queries.CountVoicemailsByCompany(r.Context(), db.CountVoicemailsByCompanyParams{
+		CompanyID:  user.CompanyID,
+		UnreadOnly: true,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemails")
+		return
+	}
+
+	voicemails, err := s.queries.ListVoicemailsByCompany(r.Context(), db.ListVoicemailsByCompanyParams{
+		CompanyID:  user.CompanyID,
+		UnreadOnly: unreadOnly,
+		Limit:      page.PageSize,
+		Offset:     page.Offset(),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemails")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailListResponse{
+		Success:    true,
+		Voicemails: voicemails,
+		Unread:     unread,
+		Pagination: page,
+	})
+}
+
+// updateVoicemail marks a voicemail read or, with read set to false, unread
+// again.
+func (s *Server) updateVoicemail(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid voicemail id")
+		return
+	}
+
+	var req VoicemailUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Read == nil {
+		respondError(w, http.StatusBadRequest, "read is required")
+		return
+	}
+
+	voicemail, err := s.queries.SetVoicemailRead(r.Context(), db.SetVoicemailReadParams{
+		Read:      *req.Read,
+		ID:        id,
+		CompanyID: user.CompanyID,
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Voicemail not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update voicemail")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailResponse{
+		Success:   true,
+		Voicemail: &voicemail,
+	})
+}
+
+// replayVoicemail rings the agent's browser client and plays a customer's
+// voicemail down the line, so callbacks can start with what the customer
+// said. Without a voicemail_id the most recent voicemail is played.
+func (s *Server) replayVoicemail(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	customerID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid customer id")
+		return
+	}
+
+	// The body is optional
+	var req VoicemailReplayRequest
+	if r.ContentLength != 0 {
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+	}
+
+	voicemail, err := s.findVoicemail(r.Context(), user.CompanyID, customerID, req.VoicemailID)
+	switch {
+	case errors.Is(err, errVoicemailNotFound):
+		respondError(w, http.StatusNotFound, "No voicemail found for this customer")
+		return
+	case errors.Is(err, errVoicemailDeleted):
+		respondError(w, http.StatusGone, "Voicemail recording has been deleted")
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemail")
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	from, _ := s.agentCallerID(r.Context(), user)
+	if from == "" {
+		respondError(w, http.StatusInternalServerError, "No phone number to call from")
+		return
+	}
+
+	params := &openapi.CreateCallParams{}
+	params.SetTo("client:" + user.AgentID)
+	params.SetFrom(from)
+	params.SetTwiml(voicemailPlaybackTwiML(&voicemail))
+
+	call, err := s.twilio.Api.CreateCall(params)
+	if err != nil {
+		logErrorf(r.Context(), "Error starting voicemail replay: %v", err)
+		respondError(w, http.StatusBadGateway, "Failed to start voicemail replay")
+		return
+	}
+
+	callSID := ""
+	if call.Sid != nil {
+		callSID = *call.Sid
+	}
+
+	logInfof(r.Context(), "🎧 Voicemail %d for customer %d replayed to %s (company %d), CallSID=%s", voicemail.ID, customerID, user.AgentID, user.CompanyID, callSID)
+	s.audit(r.Context(), user, auditVoicemailReplay, strconv.FormatInt(voicemail.ID, 10), callSID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailReplayResponse{
+		Success:   true,
+		CallSid:   callSID,
+		Voicemail: &voicemail,
+	})
+}
+
+// handleVoicemailPlayback answers an in-browser call the agent placed with a
+// CustomerId (and optionally VoicemailId) parameter instead of a number.
+func (s *Server) handleVoicemailPlayback(w http.ResponseWriter, r *http.Request) {
+	agentID := strings.TrimPrefix(r.FormValue("From"), "client:")
+	customerID, _ := strconv.ParseInt(r.FormValue("CustomerId"), 10, 64)
+	voicemailID, _ := strconv.ParseInt(r.FormValue("VoicemailId"), 10, 64)
+
+	var voicemail *db.Voicemail
+	user, err := s.queries.GetUserByAgentID(r.Context(), agentID)
+	if err != nil || user.DeletedAt.Valid {
+		logInfof(r.Context(), "Voicemail playback requested by unknown client %q", agentID)
+	} else if vm, err := s.findVoicemail(r.Context(), user.CompanyID, customerID, voicemailID); err != nil {
+		logInfof(r.Context(), "Voicemail playback for customer %d by %s unavailable: %v", customerID, agentID, err)
+	} else {
+		voicemail = &vm
+		logInfof(r.Context(), "🎧 Voicemail %d for customer %d played to %s (company %d)", vm.ID, customerID, agentID, user.CompanyID)
+		s.audit(r.Context(), &user, auditVoicemailPlay, strconv.FormatInt(vm.ID, 10), "")
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(voicemailPlaybackTwiML(voicemail)))
+}
+
+// findVoicemail returns a customer's voicemail within a company, or the most
+// recent one when voicemailID is zero.
+func (s *Server) findVoicemail(ctx context.Context, companyID, customerID, voicemailID int64) (db.Voicemail, error) {
+	var voicemail db.Voicemail
+	var err error
+	if voicemailID != 0 {
+		voicemail, err = s.queries.GetVoicemail(ctx, db.GetVoicemailParams{
+			ID:        voicemailID,
+			CompanyID: companyID,
+		})
+		if err == nil && voicemail.CustomerID.Int64 != customerID {
+			err = sql.ErrNoRows
+		}
+	} else {
+		voicemail, err = s.queries.GetLatestVoicemailByCustomer(ctx, db.GetLatestVoicemailByCustomerParams{
+			CustomerID: sql.NullInt64{Int64: customerID, Valid: true},
+			CompanyID:  companyID,
+		})
+	}
+	if err == sql.ErrNoRows {
+		return voicemail, errVoicemailNotFound
+	}
+	if err != nil {
+		return voicemail, err
+	}
+	if voicemail.DeletedAt.Valid || voicemail.RecordingUrl == "" {
+		return voicemail, errVoicemailDeleted
+	}
+	return voicemail, nil
+}
+
+// voicemailPromptTwiML asks a caller nobody can take to leave a message,
+// with the company's recorded greeting when it has one and its greeting text
+// otherwise. Twilio skips the action when nothing was recorded, so the call
+// ends on the goodbye instead. The finished recording is reported to
+// handleVoicemailCallback, which files it under the company.
+func (s *Server) voicemailPromptTwiML(ctx context.Context, company *db.Company, from string) string {
+	id := companyID(company)
+
+	greeting := fmt.Sprintf("<Say>Thank you for calling %s. Nobody is available to take your call right now. Please leave a message after the tone.</Say>", html.EscapeString(s.productName(ctx, id)))
+	callback := ""
+	if company != nil {
+		if audioURL := s.companySetting(ctx, id, settingVoicemailGreetingURL, ""); audioURL != "" {
+			greeting = fmt.Sprintf("<Play>%s</Play>", html.EscapeString(audioURL))
+		} else if text := s.companySetting(ctx, id, settingVoicemailGreeting, ""); text != "" {
+			greeting = fmt.Sprintf("<Say>%s</Say>", html.EscapeString(text))
+		}
+
+		query := url.Values{}
+		query.Set("company_id", strconv.FormatInt(id, 10))
+		query.Set("from", from)
+		callback = fmt.Sprintf(` recordingStatusCallback="%s" recordingStatusCallbackEvent="completed"`, html.EscapeString("/twilio/voicemail-callback?"+query.Encode()))
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	%s
+	<Record action="/twilio/voicemail" maxLength="120" playBeep="true" trim="trim-silence"%s/>
+	<Say>We did not receive a message. Goodbye.</Say>
+	<Hangup/>
+</Response>`, greeting, callback)
+}
+
+// handleVoicemailCallback stores a caller's message once Twilio has the
+// recording. The company and caller travel in the callback URL, which the
+// webhook signature covers.
+func (s *Server) handleVoicemailCallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	callSID := r.FormValue("CallSid")
+	recordingURL := r.FormValue("RecordingUrl")
+	status := r.FormValue("RecordingStatus")
+	companyID, _ := strconv.ParseInt(r.URL.Query().Get("company_id"), 10, 64)
+	from := r.URL.Query().Get("from")
+	if status != "completed" || recordingURL == "" || companyID == 0 {
+		logInfof(r.Context(), "Ignoring voicemail callback: CallSid=%s, RecordingStatus=%s, company_id=%d", callSID, status, companyID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	duration, _ := strconv.ParseInt(r.FormValue("RecordingDuration"), 10, 64)
+	voicemail, err := s.queries.CreateVoicemail(r.Context(), db.CreateVoicemailParams{
+		CompanyID:    companyID,
+		CustomerID:   s.messageCustomer(r.Context(), companyID, from),
+		CallSid:      nullString(callSID),
+		FromNumber:   from,
+		RecordingUrl: recordingURL,
+		Duration:     duration,
+	})
+	if err != nil {
+		logErrorf(r.Context(), "Error storing voicemail for call %s: %v", callSID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	logInfof(r.Context(), "📼 Voicemail %d saved for company %d from %s (%ds)", voicemail.ID, companyID, from, duration)
+	s.notify(realtimeEvent{
+		Type:      "voicemail_received",
+		CompanyID: companyID,
+		Data:      voicemail,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateVoicemailGreeting accepts the text read to callers before they
+// leave a message. An empty value goes back to the standard greeting.
+func validateVoicemailGreeting(value string) error {
+	if len(strings.TrimSpace(value)) > 500 {
+		return errors.New("Greeting must be at most 500 characters")
+	}
+	return nil
+}
+
+// validateAudioURL accepts a recording Twilio can fetch and play, such as a
+// voicemail greeting or hold music. It must be a public https URL, and is
+// fetched once through safeHTTPClient to check it serves audio. An empty
+// value removes it.
+func validateAudioURL(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	ctx := context.Background()
+	u, err := safeExternalURL(ctx, value, true)
+	if err != nil {
+		return fmt.Errorf("Invalid audio URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.New("Audio URL must be an https URL")
+	}
+	resp, err := safeHTTPClient.Do(req)
+	if err != nil {
+		return errors.New("Audio URL can't be fetched")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Audio URL returned %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !strings.HasPrefix(mediaType, "audio/") {
+		return errors.New("Audio URL must point at an audio file")
+	}
+	return nil
+}
+
+// handleVoicemailRecorded ends a call once the caller has left a message.
+func (s *Server) handleVoicemailRecorded(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		logErrorf(r.Context(), "Error parsing form: %v", err)
+	}
+
+	logInfof(r.Context(), "📼 Voicemail left: From=%s, CallSID=%s, Duration=%ss, Recording=%s", r.FormValue("From"), r.FormValue("CallSid"), r.FormValue("RecordingDuration"), r.FormValue("RecordingUrl"))
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Thank you for your message. Goodbye.</Say>
+	<Hangup/>
+</Response>`))
+}
+
+// voicemailPlaybackTwiML plays a voicemail, or apologises when there is
+// nothing (left) to play.
+func voicemailPlaybackTwiML(voicemail *db.Voicemail) string {
+	if voicemail == nil || voicemail.DeletedAt.Valid || voicemail.RecordingUrl == "" {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Sorry, this voicemail is no longer available.</Say>
+	<Hangup/>
+</Response>`
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Say>Voicemail from %s.</Say>
+	<Play>%s</Play>
+	<Hangup/>
+</Response>`, html.EscapeString(voicemail.FromNumber), html.EscapeString(voicemail.RecordingUrl))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestReplayVoicemailCallerID(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers []string // the company's numbers
+		own     string   // the agent's own number, one of numbers
+		from    string
+	}{
+		{"agent's own number", []string{"+27110000001", "+27110000002"}, "+27110000002", "+27110000002"},
+		{"company number", []string{"+27110000001"}, "", "+27110000001"},
+		{"no numbers", nil, "", "+15005550006"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TWILIO_PHONE_NUMBER", "+15005550006")
+			s := newTestServer(t)
+			fake := withFakeTwilio(s)
+			acme := addCompany(t, s, "Acme")
+			for _, phone := range tt.numbers {
+				addNumber(t, s, acme, phone)
+			}
+			agent := addAgent(t, s, acme, "agent1", roleAgent)
+			if tt.own != "" {
+				exec(t, s, "UPDATE users SET caller_number_id = (SELECT id FROM phone_numbers WHERE phone = ?) WHERE id = ?", tt.own, agent.ID)
+			}
+			customer, _ := exec(t, s, "INSERT INTO customers (company_id, first_name, last_name) VALUES (?, 'Pat', 'Doe')", acme).LastInsertId()
+			exec(t, s, "INSERT INTO voicemails (company_id, customer_id, from_number, recording_url) VALUES (?, ?, '+27825550001', 'https://api.twilio.com/Recordings/RE1')", acme, customer)
+
+			id := strconv.FormatInt(customer, 10)
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/customers/"+id+"/voicemail/replay", nil), agent)
+			r = withURLParams(r, map[string]string{"id": id})
+			w := httptest.NewRecorder()
+			s.replayVoicemail(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			if len(fake.requests) != 1 {
+				t.Fatalf("placed %d calls, want 1", len(fake.requests))
+			}
+			if got := fake.requests[0].Get("From"); got != tt.from {
+				t.Errorf("From = %s, want %s", got, tt.from)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"omnicall/db"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	twilioClient "github.com/twilio/twilio-go/client"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+var errCallNotFound = errors.New("call not found")
+
+// maxVoicemailDropSize keeps uploaded drops to a sensible length of audio.
+const maxVoicemailDropSize = 5 << 20
+
+// Audio formats Twilio's <Play> understands
+var voicemailDropTypes = map[string]bool{
+	"audio/mpeg":  true,
+	"audio/wav":   true,
+	"audio/wave":  true,
+	"audio/x-wav": true,
+}
+
+// callController is the slice of the Twilio API that voicemail drops need,
+// so a fake can stand in for it.
+type callController interface {
+	FetchCall(sid string, params *openapi.FetchCallParams) (*openapi.ApiV2010Call, error)
+	ListCall(params *openapi.ListCallParams) ([]openapi.ApiV2010Call, error)
+	UpdateCall(sid string, params *openapi.UpdateCallParams) (*openapi.ApiV2010Call, error)
+}
+
+type VoicemailDropsResponse struct {
+	Success bool                              `json:"success"`
+	Drops   []db.ListVoicemailDropsByAgentRow `json:"drops"`
+}
+
+type VoicemailDropResponse struct {
+	Success bool                       `json:"success"`
+	Drop    *db.CreateVoicemailDropRow `json:"drop,omitempty"`
+}
+
+type VoicemailDropPlayRequest struct {
+	DropID int64 `json:"drop_id"`
+}
+
+type VoicemailDropPlayResponse struct {
+	Success bool   `json:"success"`
+	CallSid string `json:"call_sid"`
+}
+
+func (s *Server) getVoicemailDrops(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	drops, err := s.queries.ListVoicemailDropsByAgent(r.Context(), db.ListVoicemailDropsByAgentParams{
+		AgentID:   user.AgentID,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemail drops")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailDropsResponse{
+		Success: true,
+		Drops:   drops,
+	})
+}
+
+// uploadVoicemailDrop stores a recorded message from a multipart form with a
+// "name" field and an "audio" file (MP3 or WAV).
+func (s *Server) uploadVoicemailDrop(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxVoicemailDropSize+1<<20)
+	if err := r.ParseMultipartForm(maxVoicemailDropSize); err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		respondError(w, status, "Invalid upload, audio must be under 5 MB")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		respondError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	file, _, err := r.FormFile("audio")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Audio file is required")
+		return
+	}
+	defer file.Close()
+
+	audio, err := io.ReadAll(io.LimitReader(file, maxVoicemailDropSize+1))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read audio file")
+		return
+	}
+	if len(audio) > maxVoicemailDropSize {
+		respondError(w, http.StatusBadRequest, "Audio must be under 5 MB")
+		return
+	}
+
+	// Trust the bytes, not the browser's idea of the file type
+	contentType := http.DetectContentType(audio)
+	if !voicemailDropTypes[contentType] {
+		respondError(w, http.StatusBadRequest, "Audio must be an MP3 or WAV file")
+		return
+	}
+
+	drop, err := s.queries.CreateVoicemailDrop(r.Context(), db.CreateVoicemailDropParams{
+		CompanyID:   user.CompanyID,
+		AgentID:     user.AgentID,
+		Name:        name,
+		Token:       rand.Text(),
+		ContentType: contentType,
+		Audio:       audio,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save voicemail drop")
+		return
+	}
+
+	logInfof(r.Context(), "📼 Voicemail drop %q uploaded by %s (%d bytes)", name, user.AgentID, len(audio))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(VoicemailDropResponse{
+		Success: true,
+		Drop:    &drop,
+	})
+}
+
+func (s *Server) deleteVoicemailDrop(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid voicemail drop id")
+		return
+	}
+
+	rows, err := s.queries.DeleteVoicemailDrop(r.Context(), db.DeleteVoicemailDropParams{
+		ID:        id,
+		AgentID:   user.AgentID,
+		CompanyID: user.CompanyID,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete voicemail drop")
+		return
+	}
+	if rows == 0 {
+		respondError(w, http.StatusNotFound, "Voicemail drop not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// playVoicemailDrop leaves one of the agent's recorded messages on the other
+// end of their outbound call and hangs up. callSid is the agent's own
+// (browser) leg; the message is played on the leg it dialed, so the agent is
+// freed up straight away.
+func (s *Server) playVoicemailDrop(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	var req VoicemailDropPlayRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	drop, err := s.queries.GetVoicemailDrop(r.Context(), db.GetVoicemailDropParams{
+		ID:        req.DropID,
+		AgentID:   user.AgentID,
+		CompanyID: user.CompanyID,
+	})
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Voicemail drop not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get voicemail drop")
+		return
+	}
+
+	if s.twilio == nil {
+		respondError(w, http.StatusInternalServerError, "Twilio credentials not configured")
+		return
+	}
+
+	callSID := chi.URLParam(r, "callSid")
+	audioURL := publicBaseURL(r) + "/twilio/voicemail-drops/" + drop.Token
+	dialedSID, err := dropVoicemail(s.twilio.Api, callSID, user.AgentID, audioURL)
+	switch {
+	case errors.Is(err, errCallNotFound):
+		respondError(w, http.StatusNotFound, "No active outbound call found")
+		return
+	case err != nil:
+		logErrorf(r.Context(), "Error playing voicemail drop %d on %s: %v", drop.ID, callSID, err)
+		respondError(w, http.StatusBadGateway, "Failed to play voicemail drop")
+		return
+	}
+
+	logInfof(r.Context(), "📼 Voicemail drop %d played by %s on %s", drop.ID, user.AgentID, dialedSID)
+	s.audit(r.Context(), user, auditVoicemailDrop, dialedSID, strconv.FormatInt(drop.ID, 10))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VoicemailDropPlayResponse{
+		Success: true,
+		CallSid: dialedSID,
+	})
+}
+
+// dropVoicemail checks that callSID is agentID's call, finds the leg it
+// dialed and redirects that leg to play audioURL and hang up. It returns the
+// SID of the redirected leg.
+func dropVoicemail(api callController, callSID, agentID, audioURL string) (string, error) {
+	call, err := fetchCall(api, callSID)
+	if err != nil {
+		return "", err
+	}
+	if call.From == nil || *call.From != "client:"+agentID {
+		return "", errCallNotFound
+	}
+
+	dialed, err := dialedLeg(api, callSID)
+	if err != nil {
+		return "", err
+	}
+
+	update := &openapi.UpdateCallParams{}
+	update.SetTwiml(voicemailDropTwiML(audioURL))
+	if _, err := api.UpdateCall(*dialed.Sid, update); err != nil {
+		return "", err
+	}
+	return *dialed.Sid, nil
+}
+
+// fetchCall looks up a call, turning Twilio's 404 into errCallNotFound.
+func fetchCall(api callController, callSID string) (*openapi.ApiV2010Call, error) {
+	call, err := api.FetchCall(callSID, nil)
+	var restErr *twilioClient.TwilioRestError
+	if errors.As(err, &restErr) && restErr.Status == http.StatusNotFound {
+		return nil, errCallNotFound
+	}
+	return call, err
+}
+
+// dialedLeg finds the in-progress leg that callSID dialed.
+func dialedLeg(api callController, callSID string) (*openapi.ApiV2010Call, error) {
+	params := &openapi.ListCallParams{}
+	params.SetParentCallSid(callSID)
+	params.SetStatus("in-progress")
+	params.SetLimit(1)
+	children, err := api.ListCall(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 || children[0].Sid == nil {
+		return nil, errCallNotFound
+	}
+	return &children[0], nil
+}
+
+// serveVoicemailDrop hands Twilio the audio for a drop. The token in the URL
+// is the only thing that grants access.
+func (s *Server) serveVoicemailDrop(w http.ResponseWriter, r *http.Request) {
+	drop, err := s.queries.GetVoicemailDropAudio(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", drop.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(drop.Audio)))
+	w.Write(drop.Audio)
+}
+
+func voicemailDropTwiML(audioURL string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Response>
+	<Play>%s</Play>
+	<Hangup/>
+</Response>`, html.EscapeString(audioURL))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"omnicall/db"
+	"strconv"
+	"strings"
+	"testing"
+
+	twilioClient "github.com/twilio/twilio-go/client"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// fakeCalls stands in for Twilio's calls API. from maps call SIDs to who
+// placed them and dialed maps each to the in-progress leg it dialed.
+type fakeCalls struct {
+	from    map[string]string
+	dialed  map[string]string
+	updated map[string]string // call SID to the TwiML it was redirected to
+}
+
+func (f *fakeCalls) FetchCall(sid string, params *openapi.FetchCallParams) (*openapi.ApiV2010Call, error) {
+	from, ok := f.from[sid]
+	if !ok {
+		return nil, &twilioClient.TwilioRestError{Status: http.StatusNotFound}
+	}
+	return &openapi.ApiV2010Call{Sid: &sid, From: &from}, nil
+}
+
+func (f *fakeCalls) ListCall(params *openapi.ListCallParams) ([]openapi.ApiV2010Call, error) {
+	sid, ok := f.dialed[*params.ParentCallSid]
+	if !ok {
+		return nil, nil
+	}
+	return []openapi.ApiV2010Call{{Sid: &sid}}, nil
+}
+
+func (f *fakeCalls) UpdateCall(sid string, params *openapi.UpdateCallParams) (*openapi.ApiV2010Call, error) {
+	f.updated[sid] = *params.Twiml
+	return &openapi.ApiV2010Call{Sid: &sid}, nil
+}
+
+func TestDropVoicemail(t *testing.T) {
+	const audioURL = "https://omnicall.example/twilio/voicemail-drops/token"
+	tests := []struct {
+		name    string
+		callSID string
+		agentID string
+		err     error
+		dialed  string // the leg redirected
+	}{
+		{"agent's own call", "CA-agent", "acme1", nil, "CA-customer"},
+		{"someone else's call", "CA-agent", "acme2", errCallNotFound, ""},
+		{"no such call", "CA-missing", "acme1", errCallNotFound, ""},
+		{"nobody answered yet", "CA-ringing", "acme1", errCallNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeCalls{
+				from:    map[string]string{"CA-agent": "client:acme1", "CA-ringing": "client:acme1"},
+				dialed:  map[string]string{"CA-agent": "CA-customer"},
+				updated: map[string]string{},
+			}
+			sid, err := dropVoicemail(api, tt.callSID, tt.agentID, audioURL)
+			if !errors.Is(err, tt.err) || sid != tt.dialed {
+				t.Fatalf("dropVoicemail = %q, %v; want %q, %v", sid, err, tt.dialed, tt.err)
+			}
+			if tt.dialed == "" {
+				if len(api.updated) != 0 {
+					t.Errorf("redirected %v", api.updated)
+				}
+				return
+			}
+			// Only the customer's leg plays the message; the agent is freed
+			if twiml := api.updated[tt.dialed]; twiml != voicemailDropTwiML(audioURL) || len(api.updated) != 1 {
+				t.Errorf("redirected %v, want only %s to play the drop", api.updated, tt.dialed)
+			}
+		})
+	}
+}
+
+func TestVoicemailDrops(t *testing.T) {
+	s := newTestServer(t)
+	acme := addCompany(t, s, "Acme")
+	owner := addAgent(t, s, acme, "acme1", roleAgent)
+	colleague := addAgent(t, s, acme, "acme2", roleAgent)
+	mp3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0}, 64)...)
+
+	upload := func(name string, audio []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("name", name)
+		file, _ := form.CreateFormFile("audio", "drop.mp3")
+		file.Write(audio)
+		form.Close()
+		r := httptest.NewRequest(http.MethodPost, "/api/voicemail-drops", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		s.uploadVoicemailDrop(w, asUser(r, owner))
+		return w
+	}
+
+	if w := upload("", mp3); w.Code != http.StatusBadRequest {
+		t.Errorf("no name: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := upload("Not audio", []byte("<html>hello</html>")); w.Code != http.StatusBadRequest {
+		t.Errorf("not audio: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := upload("Follow-up", mp3)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var created VoicemailDropResponse
+	json.NewDecoder(w.Body).Decode(&created)
+	id := strconv.FormatInt(created.Drop.ID, 10)
+
+	// Each agent sees only their own drops
+	for agent, want := range map[*db.User]int{owner: 1, colleague: 0} {
+		w := httptest.NewRecorder()
+		s.getVoicemailDrops(w, asUser(httptest.NewRequest(http.MethodGet, "/api/voicemail-drops", nil), agent))
+		var resp VoicemailDropsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Drops) != want {
+			t.Errorf("%s lists %d drops, want %d", agent.AgentID, len(resp.Drops), want)
+		}
+	}
+
+	// Twilio fetches the audio by the drop's token alone
+	var token string
+	s.db.QueryRow("SELECT token FROM voicemail_drops WHERE id = ?", created.Drop.ID).Scan(&token)
+	w = httptest.NewRecorder()
+	s.serveVoicemailDrop(w, withURLParams(httptest.NewRequest(http.MethodGet, "/twilio/voicemail-drops/"+token, nil), map[string]string{"token": token}))
+	if audio, _ := io.ReadAll(w.Body); !bytes.Equal(audio, mp3) || w.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("served %d bytes of %s, want the upload as audio/mpeg", len(audio), w.Header().Get("Content-Type"))
+	}
+
+	// A colleague can neither play nor delete someone else's drop
+	w = httptest.NewRecorder()
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/calls/CA-agent/voicemail-drop", strings.NewReader(`{"drop_id":`+id+`}`)), colleague)
+	s.playVoicemailDrop(w, withURLParams(r, map[string]string{"callSid": "CA-agent"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("colleague playing: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	deleteDrop := func(agent *db.User) int {
+		w := httptest.NewRecorder()
+		s.deleteVoicemailDrop(w, withURLParams(asUser(httptest.NewRequest(http.MethodDelete, "/api/voicemail-drops/"+id, nil), agent), map[string]string{"id": id}))
+		return w.Code
+	}
+	if code := deleteDrop(colleague); code != http.StatusNotFound {
+		t.Errorf("colleague deleting: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := deleteDrop(owner); code != http.StatusOK {
+		t.Errorf("owner deleting: status = %d, want %d", code, http.StatusOK)
+	}
+}
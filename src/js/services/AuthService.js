@@ -64,7 +64,12 @@ class AuthService {
         console.log('✅ Registration successful');
         return { success: true, user: data.user };
       } else {
-        return { success: false, error: data.detail || 'Failed to register' };
+        return {
+          success: false,
+          error: data.message || data.detail || 'Failed to register',
+          code: data.code,
+          fields: data.fields || {}
+        };
       }
     } catch (error) {
       console.error('Network error during registration:', error);
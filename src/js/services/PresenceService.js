@@ -0,0 +1,84 @@
+/**
+ * Presence Service
+ * Tells the server whether this agent can take calls, and keeps that
+ * presence alive with heartbeats while the softphone is open
+ */
+
+const API_BASE_URL = 'http://localhost:3000';
+
+// Well inside the server's AGENT_HEARTBEAT_TIMEOUT (two minutes by default)
+const HEARTBEAT_INTERVAL_MS = 30 * 1000;
+
+class PresenceService {
+  constructor() {
+    this.heartbeatTimer = null;
+  }
+
+  /**
+   * Set this agent's presence
+   * @param {string} status - online, busy, away or offline
+   * @returns {Promise<Object|null>} The agent's status or null on failure
+   */
+  async setStatus(status) {
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/agents/status`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ status }),
+      });
+
+      if (!response.ok) {
+        console.error('Failed to set presence:', response.statusText);
+        return null;
+      }
+
+      return await response.json();
+    } catch (error) {
+      console.error('Error setting presence:', error);
+      return null;
+    }
+  }
+
+  /**
+   * Go online and keep sending heartbeats
+   */
+  async start() {
+    await this.setStatus('online');
+
+    if (this.heartbeatTimer) return;
+    this.heartbeatTimer = setInterval(() => this.heartbeat(), HEARTBEAT_INTERVAL_MS);
+  }
+
+  /**
+   * Stop heartbeats and go offline
+   */
+  async stop() {
+    if (this.heartbeatTimer) {
+      clearInterval(this.heartbeatTimer);
+      this.heartbeatTimer = null;
+    }
+    await this.setStatus('offline');
+  }
+
+  /**
+   * Tell the server this agent is still here
+   */
+  async heartbeat() {
+    try {
+      await fetch(`${API_BASE_URL}/api/agents/heartbeat`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+      });
+    } catch (error) {
+      console.error('Error sending heartbeat:', error);
+    }
+  }
+}
+
+export const presenceService = new PresenceService();
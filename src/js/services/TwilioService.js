@@ -8,6 +8,7 @@ export class TwilioService {
     this.device = null;
     this.currentConnection = null;
     this.isInitialized = false;
+    this.fetchToken = null;
     this.listeners = {
       onIncoming: null,
       onConnected: null,
@@ -19,8 +20,10 @@ export class TwilioService {
   /**
    * Initialize Twilio Device with access token
    * @param {string} accessToken - Twilio access token from backend
+   * @param {Function} fetchToken - Resolves to a fresh token when this one is about to expire
    */
-  async initialize(accessToken) {
+  async initialize(accessToken, fetchToken = null) {
+    this.fetchToken = fetchToken;
     try {
       // Check if Twilio SDK is loaded
       if (typeof Twilio === 'undefined' || !Twilio.Device) {
@@ -64,10 +67,11 @@ export class TwilioService {
       // Set up connection handlers
       this.setupConnectionHandlers(connection);
 
-      // Notify listener
+      // Notify listener. When caller ID is masked, From is the company
+      // number and the real caller arrives as a custom parameter.
       if (this.listeners.onIncoming) {
         this.listeners.onIncoming({
-          from: connection.parameters.From,
+          from: connection.customParameters.get('caller') || connection.parameters.From,
           customParameters: connection.customParameters
         });
       }
@@ -90,6 +94,18 @@ export class TwilioService {
     this.device.on('unregistered', () => {
       console.log('Twilio Device unregistered');
     });
+
+    // Swap in a fresh token before this one lapses, so long shifts
+    // don't drop off
+    this.device.on('tokenWillExpire', async () => {
+      if (!this.fetchToken) return;
+      try {
+        this.device.updateToken(await this.fetchToken());
+        console.log('Twilio token refreshed');
+      } catch (error) {
+        console.error('Failed to refresh Twilio token:', error);
+      }
+    });
   }
 
   /**
@@ -155,6 +171,43 @@ export class TwilioService {
     }
   }
 
+  /**
+   * Play a customer's voicemail through the browser
+   * @param {number} customerId - Customer whose voicemail to play
+   * @param {number} voicemailId - Optional voicemail, defaults to the most recent
+   */
+  async playVoicemail(customerId, voicemailId) {
+    if (!this.isInitialized || !this.device) {
+      throw new Error('Twilio Device not initialized');
+    }
+
+    const callParams = { CustomerId: String(customerId) };
+    if (voicemailId) {
+      callParams.VoicemailId = String(voicemailId);
+    }
+
+    this.currentConnection = await this.device.connect({ params: callParams });
+    this.setupConnectionHandlers(this.currentConnection);
+
+    console.log('Voicemail playback started for customer:', customerId);
+    return this.currentConnection;
+  }
+
+  /**
+   * Take the next caller waiting in the queue, or the next callback due
+   */
+  async takeNextInQueue() {
+    if (!this.isInitialized || !this.device) {
+      throw new Error('Twilio Device not initialized');
+    }
+
+    this.currentConnection = await this.device.connect({ params: { Queue: 'next' } });
+    this.setupConnectionHandlers(this.currentConnection);
+
+    console.log('Taking next caller from the queue');
+    return this.currentConnection;
+  }
+
   /**
    * Accept an incoming call
    */
@@ -255,6 +308,7 @@ export class TwilioService {
     }
 
     this.isInitialized = false;
+    this.fetchToken = null;
     this.currentConnection = null;
     console.log('Twilio Device destroyed');
   }
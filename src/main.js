@@ -8,6 +8,7 @@ import { ScreenController } from './js/ui/ScreenController.js';
 import { authService } from './js/services/AuthService.js';
 import { twilioService } from './js/services/TwilioService.js';
 import { customerService } from './js/services/CustomerService.js';
+import { presenceService } from './js/services/PresenceService.js';
 
 // Check authentication before initializing
 authService.init().then(() => {
@@ -473,7 +474,8 @@ function updateDialButtonState() {
 /**
  * Handle logout
  */
-function handleLogout() {
+async function handleLogout() {
+  await presenceService.stop();
   authService.logout();
   window.location.href = '/login.html';
 }
@@ -498,6 +500,32 @@ function handleCloseProfile() {
   }
 }
 
+/**
+ * Fetch a Twilio access token from the backend
+ */
+async function fetchTwilioToken() {
+  const response = await fetch('http://localhost:3000/api/twilio/token', {
+    method: 'GET',
+    credentials: 'include',
+    headers: {
+      'Content-Type': 'application/json',
+    }
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to fetch Twilio token from backend');
+  }
+
+  const data = await response.json();
+
+  if (!data.token) {
+    throw new Error('No token received from backend');
+  }
+
+  console.log('Twilio token expires at', data.expires_at);
+  return data.token;
+}
+
 /**
  * Handle Twilio connection initialization
  */
@@ -522,27 +550,12 @@ async function handleInitTwilio() {
       statusText.textContent = 'Connecting...';
     }
 
-    // Fetch Twilio access token from backend
-    const response = await fetch('http://localhost:3000/api/twilio/token', {
-      method: 'GET',
-      credentials: 'include',
-      headers: {
-        'Content-Type': 'application/json',
-      }
-    });
-
-    if (!response.ok) {
-      throw new Error('Failed to fetch Twilio token from backend');
-    }
-
-    const data = await response.json();
-
-    if (!data.token) {
-      throw new Error('No token received from backend');
-    }
+    // Initialize Twilio with an access token, fetching fresh ones as each
+    // nears its expiry
+    await twilioService.initialize(await fetchTwilioToken(), fetchTwilioToken);
 
-    // Initialize Twilio with the access token
-    await twilioService.initialize(data.token);
+    // Calls are only routed to agents who are online
+    await presenceService.start();
 
     // Set up Twilio event listeners
     twilioService.setListeners({
@@ -71,6 +71,19 @@ async function loadCompanies() {
 
       // Enable the select
       companySelect.disabled = false;
+    } else if (response.status === 401) {
+      // The company list is only shown to signed-in users, so new agents
+      // enter the company ID their administrator gave them
+      console.log('Company list requires sign-in, asking for a company ID');
+      const companyInput = document.createElement('input');
+      companyInput.type = 'number';
+      companyInput.min = '1';
+      companyInput.required = true;
+      companyInput.id = companySelect.id;
+      companyInput.name = companySelect.name;
+      companyInput.placeholder = 'Company ID from your administrator';
+      companyInput.className = companySelect.className;
+      companySelect.replaceWith(companyInput);
     } else {
       console.error('Failed to load companies, status:', response.status);
       const errorText = await response.text();
@@ -94,6 +107,24 @@ async function loadCompanies() {
 console.log('Register page loaded, loading companies...');
 loadCompanies();
 
+// Outline the inputs a registration error was about
+function showFieldErrors(fields) {
+  Object.entries(fields || {}).forEach(([name, message]) => {
+    const input = registerForm.elements.namedItem(name);
+    if (input) {
+      input.classList.add('border-red-400');
+      input.title = message;
+    }
+  });
+}
+
+function clearFieldErrors() {
+  Array.from(registerForm.elements).forEach((input) => {
+    input.classList.remove('border-red-400');
+    input.removeAttribute('title');
+  });
+}
+
 // Handle form submission
 registerForm.addEventListener('submit', async (e) => {
   e.preventDefault();
@@ -118,6 +149,7 @@ registerForm.addEventListener('submit', async (e) => {
   registerBtn.disabled = true;
   registerBtn.textContent = 'Creating account...';
   errorMessage.classList.add('hidden');
+  clearFieldErrors();
 
   try {
     const result = await authService.register(
@@ -133,9 +165,10 @@ registerForm.addEventListener('submit', async (e) => {
       // Redirect to main app
       window.location.href = '/';
     } else {
-      // Show error
+      // Show error, and mark the fields the server named
       errorMessage.textContent = result.error || 'Failed to register';
       errorMessage.classList.remove('hidden');
+      showFieldErrors(result.fields);
     }
   } catch (error) {
     console.error('Registration error:', error);